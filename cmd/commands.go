@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"cfpurge/internal/util"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var commandsJSON bool
+
+// commandInfo describes a command for tools that wrap the CLI
+type commandInfo struct {
+	Path    string     `json:"path"`
+	Short   string     `json:"short,omitempty"`
+	Long    string     `json:"long,omitempty"`
+	Example string     `json:"example,omitempty"`
+	Aliases []string   `json:"aliases,omitempty"`
+	Flags   []flagInfo `json:"flags"`
+}
+
+// flagInfo describes a flag. The default of a flag read from an environment
+// variable is left out, since it may be a credential, and the variable is
+// named instead.
+type flagInfo struct {
+	Name        string `json:"name"`
+	Shorthand   string `json:"shorthand,omitempty"`
+	Type        string `json:"type"`
+	Default     string `json:"default,omitempty"`
+	Env         string `json:"env,omitempty"`
+	Description string `json:"description"`
+
+	// Persistent flags also apply to every subcommand
+	Persistent bool `json:"persistent,omitempty"`
+}
+
+// commandsCmd lists every command and its flags, for wrappers and
+// documentation generators rather than people
+var commandsCmd = &cobra.Command{
+	Use:    "__commands",
+	Short:  "List every command and its flags",
+	Hidden: true,
+	Args:   cobra.NoArgs,
+	Long: `List the path of every available command. With --json, also describe
+each command's help text and flags (name, type, default and description), so
+that tools wrapping cfpurge can follow its commands as they change. Flags
+marked persistent apply to the command's subcommands too.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		commands := describeCommands(cmd.Root())
+
+		if commandsJSON {
+			return util.WriteOutput(os.Stdout, util.OutputJSON, commands)
+		}
+
+		for _, command := range commands {
+			fmt.Println(command.Path)
+		}
+		return nil
+	},
+}
+
+// describeCommands walks the command tree from root, skipping hidden and
+// deprecated commands and flags
+func describeCommands(root *cobra.Command) []commandInfo {
+	var commands []commandInfo
+
+	var walk func(cmd *cobra.Command)
+	walk = func(cmd *cobra.Command) {
+		info := commandInfo{
+			Path:    cmd.CommandPath(),
+			Short:   cmd.Short,
+			Long:    cmd.Long,
+			Example: cmd.Example,
+			Aliases: cmd.Aliases,
+			Flags:   []flagInfo{},
+		}
+
+		persistent := cmd.PersistentFlags()
+		cmd.LocalFlags().VisitAll(func(flag *pflag.Flag) {
+			if flag.Hidden || flag.Name == "help" {
+				return
+			}
+			info.Flags = append(info.Flags, describeFlag(flag, persistent.Lookup(flag.Name) != nil))
+		})
+		commands = append(commands, info)
+
+		for _, child := range cmd.Commands() {
+			if child.IsAvailableCommand() {
+				walk(child)
+			}
+		}
+	}
+	walk(root)
+
+	return commands
+}
+
+// describeFlag describes a single flag
+func describeFlag(flag *pflag.Flag, persistent bool) flagInfo {
+	info := flagInfo{
+		Name:        flag.Name,
+		Shorthand:   flag.Shorthand,
+		Type:        flag.Value.Type(),
+		Default:     flag.DefValue,
+		Description: flag.Usage,
+		Persistent:  persistent,
+	}
+	if env := flag.Annotations[envAnnotation]; len(env) > 0 {
+		info.Default, info.Env = "", env[0]
+	}
+	return info
+}
+
+func init() {
+	commandsCmd.Flags().BoolVar(&commandsJSON, "json", false, "Describe each command and its flags as JSON")
+}
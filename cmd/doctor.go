@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"cfpurge/internal/api"
+	"cfpurge/internal/util"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/spf13/cobra"
+)
+
+// doctorCmd represents the doctor command
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Diagnose configuration issues",
+	Long: `Check that credentials are present and valid, that the account ID is usable,
+and that the credentials can list zones, purge cache and manage Workers KV.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		criticalFailures := 0
+
+		util.Header("Configuration checks")
+
+		// Credentials present
+		if err := api.ValidateAuth(); err != nil {
+			util.Error("Credentials: %v", err)
+			return fmt.Errorf("critical checks failed")
+		}
+		if api.UsesSplitTokens() {
+			util.Success("Credentials: separate read and write API tokens configured")
+		} else if api.UsesAPIToken() {
+			util.Success("Credentials: API token configured")
+		} else {
+			util.Success("Credentials: API key and email configured")
+		}
+
+		client, err := api.GetClient(api.ReadAccess)
+		if err != nil {
+			util.Error("Client: %v", err)
+			return fmt.Errorf("critical checks failed")
+		}
+
+		// Tokens valid
+		if api.UsesSplitTokens() {
+			if !verifyToken(ctx, "Read token", client) {
+				criticalFailures++
+			}
+			if writeClient, err := api.GetClient(api.WriteAccess); err != nil {
+				util.Error("Write token: %v", err)
+				criticalFailures++
+			} else if writeClient != client && !verifyToken(ctx, "Write token", writeClient) {
+				criticalFailures++
+			}
+		} else if api.UsesAPIToken() && !verifyToken(ctx, "Token", client) {
+			criticalFailures++
+		}
+
+		// Account ID valid
+		if err := api.ValidateAccountID(); err != nil {
+			util.Warning("Account: no account ID configured (required for KV commands)")
+		} else if account, _, err := client.Account(ctx, api.GetAccountID()); err != nil {
+			util.Error("Account: cannot access account %s: %v", api.GetAccountID(), err)
+			criticalFailures++
+		} else {
+			util.Success("Account: %s (%s)", account.Name, account.ID)
+		}
+
+		// Zones listable
+		zones, err := api.ListZones(ctx)
+		if err != nil {
+			util.Error("Zones: cannot list zones: %v", err)
+			criticalFailures++
+		} else if len(zones) == 0 {
+			util.Warning("Zones: credentials can list zones but none are visible")
+		} else {
+			util.Success("Zones: %d zones visible", len(zones))
+
+			// Cache purge permission
+			purgeable := 0
+			for _, zone := range zones {
+				if zoneHasPermission(zone, "#cache_purge:edit") {
+					purgeable++
+				}
+			}
+			if purgeable > 0 {
+				util.Success("Cache purge: permitted on %d of %d zones", purgeable, len(zones))
+			} else {
+				util.Warning("Cache purge: permission not reported on any zone; purges may fail")
+			}
+		}
+
+		// KV permission
+		if api.GetAccountID() != "" {
+			_, _, err := client.ListWorkersKVNamespaces(ctx, cloudflare.AccountIdentifier(api.GetAccountID()), cloudflare.ListWorkersKVNamespacesParams{})
+			if err != nil {
+				util.Warning("Workers KV: cannot list namespaces: %v", err)
+			} else {
+				util.Success("Workers KV: namespaces can be listed")
+			}
+		}
+
+		if criticalFailures > 0 {
+			return fmt.Errorf("%d critical checks failed", criticalFailures)
+		}
+
+		util.Success("All critical checks passed")
+		return nil
+	},
+}
+
+// verifyToken checks that a client's API token is valid and active, reporting
+// the result under label
+func verifyToken(ctx context.Context, label string, client *cloudflare.API) bool {
+	token, err := client.VerifyAPIToken(ctx)
+	if err != nil {
+		util.Error("%s: verification failed: %v", label, err)
+		return false
+	}
+	if token.Status != "active" {
+		util.Error("%s: status is '%s', expected 'active'", label, token.Status)
+		return false
+	}
+	util.Success("%s: valid and active", label)
+	return true
+}
+
+// zoneHasPermission checks whether the zone reports the given permission for the current credentials
+func zoneHasPermission(zone cloudflare.Zone, permission string) bool {
+	return util.ContainsString(zone.Permissions, permission)
+}
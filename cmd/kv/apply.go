@@ -0,0 +1,319 @@
+package kv
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"cfpurge/internal/api"
+	"cfpurge/internal/util"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/spf13/cobra"
+)
+
+// maxApplyOperations bounds a batch, since every key is read before the batch
+// is applied and rolling back a large batch would widen the race window
+const maxApplyOperations = 100
+
+// Operations accepted by kv apply
+const (
+	applyOpPut    = "put"
+	applyOpDelete = "delete"
+)
+
+// applyRecord is a single line of JSONL input for kv apply
+type applyRecord struct {
+	Op       string                 `json:"op"`
+	Key      string                 `json:"key"`
+	Value    json.RawMessage        `json:"value"`
+	Metadata map[string]interface{} `json:"metadata"`
+	TTL      int                    `json:"ttl"`
+}
+
+// savedEntry is the state of a key before the batch touched it
+type savedEntry struct {
+	exists bool
+	key    cloudflare.StorageKey
+	value  []byte
+}
+
+func newApplyCmd() *cobra.Command {
+	var (
+		namespace string
+		file      string
+		dryRun    bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "apply",
+		Short: "Apply a batch of KV puts and deletes, rolling back on failure",
+		Long: `Apply a small batch of put and delete operations read from a JSONL file, in
+order. Each line is an object of the form
+{"op": "put", "key": ..., "value": ..., "metadata": {...}, "ttl": ...} or
+{"op": "delete", "key": ...}.
+
+Before anything is written, the current value, metadata and expiration of every
+key in the batch are read. If an operation fails, the keys touched so far are
+restored to that state: re-written, or deleted if they did not exist.
+
+This is best effort, not a transaction. Workers KV has no locking, so a write
+made by someone else between the read and the rollback is overwritten, and
+because KV is eventually consistent the state read may be up to a minute old.
+Readers can also see a partially applied batch until it completes or is rolled
+back. A batch holds at most 100 operations.`,
+		Example: `  # Deploy a set of related config keys together
+  cfpurge kv apply --namespace=<namespace-id> --file=release.jsonl
+
+  # Validate the batch and show what would be applied
+  cfpurge kv apply --namespace=<namespace-id> --file=release.jsonl --dry-run`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := api.ValidateAuth(); err != nil {
+				return err
+			}
+
+			if err := api.ValidateAccountID(); err != nil {
+				return err
+			}
+
+			records, err := readApplyFile(file)
+			if err != nil {
+				return err
+			}
+
+			if dryRun {
+				util.Info("Dry run mode - would apply %d operations to namespace %s:", len(records), namespace)
+				for _, record := range records {
+					fmt.Printf("  %s %s\n", record.Op, record.Key)
+				}
+				return nil
+			}
+
+			client, err := api.GetClient(api.WriteAccess)
+			if err != nil {
+				return err
+			}
+
+			ctx := cmd.Context()
+			saved, err := saveEntries(ctx, client, namespace, records)
+			if err != nil {
+				return err
+			}
+			util.Info("Read the current state of %d keys; applying %d operations", len(saved), len(records))
+
+			var touched []string
+			for i, record := range records {
+				if !util.ContainsString(touched, record.Key) {
+					touched = append(touched, record.Key)
+				}
+
+				err := applyOperation(ctx, client, namespace, record)
+				util.Audit(namespace, []string{record.Key}, err)
+				if err == nil {
+					util.Success("Applied %s %s", record.Op, record.Key)
+					continue
+				}
+
+				util.Error("Error applying %s %s: %v", record.Op, record.Key, err)
+				util.Warning("Rolling back %d keys", len(touched))
+
+				// A cancelled command still rolls back what it applied
+				failed := rollback(context.WithoutCancel(ctx), client, namespace, touched, saved)
+				if len(failed) > 0 {
+					return fmt.Errorf("operation %d (%s %s) failed and %d keys could not be rolled back (%s): %w", i+1, record.Op, record.Key, len(failed), strings.Join(failed, ", "), err)
+				}
+				return fmt.Errorf("operation %d (%s %s) failed; rolled back %d keys: %w", i+1, record.Op, record.Key, len(touched), err)
+			}
+
+			util.Success("Applied all %d operations to namespace %s", len(records), namespace)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&namespace, "namespace", "", "KV namespace ID")
+	cmd.Flags().StringVar(&file, "file", "", "JSONL file with one put or delete operation per line")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Validate the batch and show the operations without applying them")
+
+	cmd.MarkFlagRequired("namespace")
+	cmd.MarkFlagRequired("file")
+
+	return cmd
+}
+
+// readApplyFile reads and validates every operation in a batch file, so that
+// a mistake on any line is reported before anything is written
+func readApplyFile(path string) ([]applyRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening batch file: %w", err)
+	}
+	defer f.Close()
+
+	var records []applyRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxBulkLineSize)
+
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		record, err := parseApplyRecord(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading batch file after line %d: %w", lineNum, err)
+	}
+
+	if len(records) == 0 {
+		return nil, fmt.Errorf("batch file %s holds no operations", path)
+	}
+	if len(records) > maxApplyOperations {
+		return nil, fmt.Errorf("batch file %s holds %d operations, the maximum is %d", path, len(records), maxApplyOperations)
+	}
+
+	return records, nil
+}
+
+// parseApplyRecord parses and validates a single operation
+func parseApplyRecord(line string) (applyRecord, error) {
+	var record applyRecord
+	if err := json.Unmarshal([]byte(line), &record); err != nil {
+		return record, fmt.Errorf("malformed record: %w", err)
+	}
+
+	if err := util.ValidateKVKey(record.Key); err != nil {
+		return record, err
+	}
+
+	switch record.Op {
+	case applyOpPut:
+		if len(record.Value) == 0 || string(record.Value) == "null" {
+			return record, fmt.Errorf("put of key '%s' is missing a value", record.Key)
+		}
+		if record.TTL != 0 && record.TTL < kvMinExpirationTTL {
+			return record, fmt.Errorf("put of key '%s' has a ttl below %d seconds", record.Key, kvMinExpirationTTL)
+		}
+	case applyOpDelete:
+		if len(record.Value) > 0 || record.Metadata != nil || record.TTL != 0 {
+			return record, fmt.Errorf("delete of key '%s' cannot have a value, metadata or ttl", record.Key)
+		}
+	default:
+		return record, fmt.Errorf("unknown op '%s' for key '%s', expected put or delete", record.Op, record.Key)
+	}
+
+	return record, nil
+}
+
+// saveEntries reads the current state of every key in the batch
+func saveEntries(ctx context.Context, client *cloudflare.API, namespace string, records []applyRecord) (map[string]savedEntry, error) {
+	saved := make(map[string]savedEntry)
+	for _, record := range records {
+		if _, ok := saved[record.Key]; ok {
+			continue
+		}
+
+		// Listing provides the metadata and expiration, which reading a value does not
+		keys, err := listAllKeys(ctx, client, namespace, record.Key)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the current state of key %s: %w", record.Key, err)
+		}
+
+		entry := savedEntry{}
+		for _, key := range keys {
+			if key.Name == record.Key {
+				entry.exists, entry.key = true, key
+				break
+			}
+		}
+
+		if entry.exists {
+			err := api.WithRetry(ctx, func(ctx context.Context) error {
+				var err error
+				entry.value, err = api.ReadKV(ctx, client, namespace, record.Key)
+				return err
+			})
+			if err != nil {
+				return nil, fmt.Errorf("error reading the current value of key %s: %w", record.Key, err)
+			}
+		}
+
+		saved[record.Key] = entry
+	}
+	return saved, nil
+}
+
+// applyOperation performs a single put or delete
+func applyOperation(ctx context.Context, client *cloudflare.API, namespace string, record applyRecord) error {
+	if record.Op == applyOpDelete {
+		return api.WithRetry(ctx, func(ctx context.Context) error {
+			return api.DeleteKV(ctx, client, namespace, record.Key)
+		})
+	}
+
+	// String values are stored as-is, anything else is stored as its JSON text
+	var value string
+	if err := json.Unmarshal(record.Value, &value); err != nil {
+		value = string(record.Value)
+	}
+
+	entry := api.KVWrite{
+		Key:   record.Key,
+		Value: []byte(value),
+	}
+	if record.Metadata != nil {
+		entry.Metadata = record.Metadata
+	}
+	if record.TTL > 0 {
+		entry.ExpirationTTL = record.TTL
+	}
+
+	return api.WithRetry(ctx, func(ctx context.Context) error {
+		return api.WriteKV(ctx, client, namespace, entry)
+	})
+}
+
+// rollback restores each touched key to its saved state and returns the keys
+// that could not be restored
+func rollback(ctx context.Context, client *cloudflare.API, namespace string, keys []string, saved map[string]savedEntry) []string {
+	var failed []string
+	for i := len(keys) - 1; i >= 0; i-- {
+		key := keys[i]
+		entry := saved[key]
+
+		var err error
+		if entry.exists {
+			restore := api.KVWrite{
+				Key:        key,
+				Value:      entry.value,
+				Metadata:   entry.key.Metadata,
+				Expiration: int64(entry.key.Expiration),
+			}
+			err = api.WithRetry(ctx, func(ctx context.Context) error {
+				return api.WriteKV(ctx, client, namespace, restore)
+			})
+		} else {
+			err = api.WithRetry(ctx, func(ctx context.Context) error {
+				return api.DeleteKV(ctx, client, namespace, key)
+			})
+		}
+		util.Audit(namespace, []string{key}, err)
+
+		if err != nil {
+			util.Error("Error rolling back key %s: %v", key, err)
+			failed = append(failed, key)
+		} else {
+			util.Success("Rolled back key %s", key)
+		}
+	}
+	return failed
+}
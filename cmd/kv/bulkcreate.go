@@ -0,0 +1,229 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"cfpurge/internal/api"
+	"cfpurge/internal/util"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/spf13/cobra"
+)
+
+// newNamespaceCmd creates the namespace command group for operations on many namespaces
+func newNamespaceCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "namespace",
+		Short: "Manage KV namespaces in bulk",
+		Long:  `Operations that work on many Workers KV namespaces at once.`,
+	}
+
+	cmd.AddCommand(newBulkCreateCmd())
+
+	return cmd
+}
+
+// namespaceCreateResult is the outcome of creating one namespace
+type namespaceCreateResult struct {
+	Title   string `json:"title" yaml:"title"`
+	ID      string `json:"id,omitempty" yaml:"id,omitempty"`
+	Skipped bool   `json:"skipped,omitempty" yaml:"skipped,omitempty"`
+	Error   string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// namespaceCreateSummary is the structured output of kv namespace bulk-create
+type namespaceCreateSummary struct {
+	util.ResultSummary `yaml:",inline"`
+	Skipped            int                     `json:"skipped" yaml:"skipped"`
+	Namespaces         []namespaceCreateResult `json:"namespaces" yaml:"namespaces"`
+}
+
+func newBulkCreateCmd() *cobra.Command {
+	var (
+		titlesFile      string
+		prefix          string
+		count           int
+		start           int
+		concurrency     int
+		allowDuplicates bool
+		out             output
+	)
+
+	cmd := &cobra.Command{
+		Use:   "bulk-create",
+		Short: "Create many KV namespaces",
+		Long: `Create Workers KV namespaces from a file of titles, or from a prefix and a
+count. Titles that already exist in the account are skipped unless
+--allow-duplicates is given. Prints the title and ID of each namespace.`,
+		Example: `  # Create the namespaces listed in a file, one title per line
+  cfpurge kv namespace bulk-create --titles-file=namespaces.txt
+
+  # Create staging-1 to staging-5 and print the IDs as JSON
+  cfpurge kv namespace bulk-create --prefix=staging- --count=5 --output=json`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := api.ValidateAuth(); err != nil {
+				return err
+			}
+
+			if err := api.ValidateAccountID(); err != nil {
+				return err
+			}
+
+			if err := out.start(); err != nil {
+				return err
+			}
+
+			if (titlesFile == "") == (prefix == "") {
+				return fmt.Errorf("exactly one of --titles-file or --prefix is required")
+			}
+
+			if concurrency < 1 {
+				return fmt.Errorf("concurrency must be at least 1")
+			}
+
+			var titles []string
+			if titlesFile != "" {
+				lines, err := util.ReadLines(titlesFile)
+				if err != nil {
+					return fmt.Errorf("error reading titles file: %w", err)
+				}
+				titles = lines
+			} else {
+				if count < 1 {
+					return fmt.Errorf("--count must be at least 1 with --prefix")
+				}
+				for i := start; i < start+count; i++ {
+					titles = append(titles, fmt.Sprintf("%s%d", prefix, i))
+				}
+			}
+
+			titles = util.FilterDuplicates(titles)
+			if len(titles) == 0 {
+				return fmt.Errorf("no namespace titles given")
+			}
+
+			client, err := api.GetClient(api.WriteAccess)
+			if err != nil {
+				return err
+			}
+
+			ctx := cmd.Context()
+
+			// Look up existing titles once so that re-running is safe
+			existing := make(map[string]string)
+			if !allowDuplicates {
+				namespaces, err := listAllNamespaces(ctx, client)
+				if err != nil {
+					return fmt.Errorf("error listing KV namespaces: %w", err)
+				}
+				for _, ns := range namespaces {
+					existing[ns.Title] = ns.ID
+				}
+			}
+
+			created := bulkCreateNamespaces(ctx, client, titles, existing, concurrency, &out)
+			return printNamespaceCreateResults(created, &out)
+		},
+	}
+
+	cmd.Flags().StringVar(&titlesFile, "titles-file", "", "File with one namespace title per line")
+	cmd.Flags().StringVar(&prefix, "prefix", "", "Create titles from this prefix followed by a number")
+	cmd.Flags().IntVar(&count, "count", 0, "Number of namespaces to create with --prefix")
+	cmd.Flags().IntVar(&start, "start", 1, "First number appended to --prefix")
+	cmd.Flags().IntVar(&concurrency, "concurrency", 5, "Maximum number of namespaces created concurrently")
+	cmd.Flags().BoolVar(&allowDuplicates, "allow-duplicates", false, "Create titles even if a namespace with the same title already exists")
+	out.addFlags(cmd)
+
+	return cmd
+}
+
+// bulkCreateNamespaces creates each title that is not already in existing, with
+// bounded concurrency. Results keep the order of titles.
+func bulkCreateNamespaces(ctx context.Context, client *cloudflare.API, titles []string, existing map[string]string, concurrency int, out *output) []namespaceCreateResult {
+	results := make([]namespaceCreateResult, len(titles))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+
+	for i, title := range titles {
+		if id, ok := existing[title]; ok {
+			util.Warning("Skipping '%s': a namespace with this title already exists (%s)", title, id)
+			results[i] = namespaceCreateResult{Title: title, ID: id, Skipped: true}
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(result *namespaceCreateResult, title string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			result.Title = title
+
+			var res cloudflare.WorkersKVNamespaceResponse
+			err := api.WithRetry(ctx, func(ctx context.Context) error {
+				var err error
+				res, err = client.CreateWorkersKVNamespace(
+					ctx,
+					cloudflare.AccountIdentifier(api.GetAccountID()),
+					cloudflare.CreateWorkersKVNamespaceParams{
+						Title: title,
+					},
+				)
+				return err
+			})
+			if err != nil {
+				util.Error("Error creating KV namespace %s: %v", title, err)
+				result.Error = err.Error()
+				return
+			}
+
+			result.ID = res.Result.ID
+			out.success("Created KV namespace %s: %s", title, res.Result.ID)
+		}(&results[i], title)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// printNamespaceCreateResults prints the title to ID mapping and a summary
+func printNamespaceCreateResults(results []namespaceCreateResult, out *output) error {
+	summary := namespaceCreateSummary{Namespaces: results}
+	for _, result := range results {
+		switch {
+		case result.Skipped:
+			summary.Skipped++
+		case result.Error != "":
+			summary.Failed++
+		default:
+			summary.Successful++
+		}
+	}
+
+	if out.structured() {
+		return out.write(summary)
+	}
+
+	fmt.Println()
+	table := util.NewTable("Title", "Namespace ID", "Status")
+	for _, result := range results {
+		status := "created"
+		if result.Skipped {
+			status = "exists"
+		} else if result.Error != "" {
+			status = "failed"
+		}
+		table.AddRow(result.Title, result.ID, status)
+	}
+	table.Print()
+
+	if summary.Skipped > 0 {
+		util.Info("Skipped %d titles that already exist", summary.Skipped)
+	}
+	util.PrettyPrintResults(summary.Successful, summary.Failed)
+	return nil
+}
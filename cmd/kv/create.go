@@ -33,26 +33,31 @@ func newCreateCmd() *cobra.Command {
 				return fmt.Errorf("namespace title is required")
 			}
 
-			client, err := api.GetClient()
+			client, err := api.GetClient(api.WriteAccess)
 			if err != nil {
 				return err
 			}
 
 			// Create KV namespace
-			res, err := client.CreateWorkersKVNamespace(
-				context.Background(),
-				api.GetAccountID(),
-				cloudflare.CreateWorkersKVNamespaceParams{
-					Title: title,
-				},
-			)
+			var res cloudflare.WorkersKVNamespaceResponse
+			err = api.WithRetry(cmd.Context(), func(ctx context.Context) error {
+				var err error
+				res, err = client.CreateWorkersKVNamespace(
+					ctx,
+					cloudflare.AccountIdentifier(api.GetAccountID()),
+					cloudflare.CreateWorkersKVNamespaceParams{
+						Title: title,
+					},
+				)
+				return err
+			})
 
 			if err != nil {
 				return fmt.Errorf("error creating KV namespace: %w", err)
 			}
 
 			util.Success("Successfully created KV namespace: %s", title)
-			fmt.Printf("   Namespace ID: %s\n", res.ID)
+			fmt.Printf("   Namespace ID: %s\n", res.Result.ID)
 
 			return nil
 		},
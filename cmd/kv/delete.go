@@ -3,8 +3,8 @@ package kv
 import (
 	"context"
 	"fmt"
-	"strings"
 	"sync"
+	"time"
 
 	"cfpurge/internal/api"
 	"cfpurge/internal/util"
@@ -16,10 +16,27 @@ import (
 func newDeleteCmd() *cobra.Command {
 	var (
 		deleteByTag   string
+		metaFilter    string
 		namespace     string
 		allNamespaces bool
 		key           string
 		dryRun        bool
+		failFast      bool
+		preserveOrder bool
+		base64Key     bool
+		dryRunOutput  string
+		fromPlan      string
+		retryFailed   string
+		failuresOut   string
+		errorOnEmpty  bool
+		sample        int
+		tagList       bool
+		keysFile      string
+		keysFormat    string
+		measureSize   bool
+		batchSize     int
+		concurrency   = util.Concurrency{N: 10}
+		out           output
 	)
 
 	cmd := &cobra.Command{
@@ -32,14 +49,36 @@ func newDeleteCmd() *cobra.Command {
   # Delete entries with matching tag
   cfpurge kv delete --namespace=<namespace-id> --tag=product-123
   
+  # Delete entries by any metadata field
+  cfpurge kv delete --namespace=<namespace-id> --metadata-filter="env=staging"
+  
   # Delete entries across multiple namespaces
   cfpurge kv delete --namespace=<namespace-id1>,<namespace-id2> --tag=product-123
   
   # Delete entries in all namespaces
   cfpurge kv delete --all-namespaces --tag=product-123
   
+  # Delete exactly the keys listed in a file, one per line
+  cfpurge kv delete --namespace=<namespace-id> --keys-file=keys.txt
+  
+  # Read every line as a key, including ones starting with # or made of spaces
+  cfpurge kv delete --namespace=<namespace-id> --keys-file=keys.txt --keys-file-format=raw
+  
   # Preview what would be deleted (dry run)
-  cfpurge kv delete --namespace=<namespace-id> --tag=product-123 --dry-run`,
+  cfpurge kv delete --namespace=<namespace-id> --tag=product-123 --dry-run
+  
+  # Preview a large match by listing only the first 20 keys
+  cfpurge kv delete --all-namespaces --metadata-filter="env=staging" --dry-run --sample=20
+  
+  # Report the deleted keys as JSON
+  cfpurge kv delete --namespace=<namespace-id> --tag=product-123 --output=json
+  
+  # Report how much storage the deletion frees and the largest keys
+  cfpurge kv delete --namespace=<namespace-id> --metadata-filter="env=staging" --measure-size
+  
+  # Record failed keys, then retry only those
+  cfpurge kv delete --all-namespaces --tag=product-123 --failures-output=failed.json
+  cfpurge kv delete --retry-failed=failed.json`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if err := api.ValidateAuth(); err != nil {
 				return err
@@ -49,21 +88,98 @@ func newDeleteCmd() *cobra.Command {
 				return err
 			}
 
-			if namespace == "" && !allNamespaces {
+			if err := out.start(); err != nil {
+				return err
+			}
+
+			if retryFailed != "" {
+				if fromPlan != "" {
+					return fmt.Errorf("--retry-failed cannot be combined with --from-plan")
+				}
+				// A failures file is a plan holding only the keys that failed last time
+				fromPlan = retryFailed
+			}
+
+			if fromPlan != "" && dryRun {
+				return fmt.Errorf("--from-plan cannot be combined with --dry-run")
+			}
+
+			if fromPlan != "" && key != "" {
+				return fmt.Errorf("--from-plan cannot be combined with --key")
+			}
+
+			if keysFile != "" && (key != "" || deleteByTag != "" || metaFilter != "" || fromPlan != "" || allNamespaces) {
+				return fmt.Errorf("--keys-file cannot be combined with --key, --tag, --metadata-filter, --from-plan or --all-namespaces")
+			}
+
+			if cmd.Flags().Changed("keys-file-format") && keysFile == "" {
+				return fmt.Errorf("--keys-file-format requires --keys-file")
+			}
+
+			if fromPlan == "" && namespace == "" && !allNamespaces {
 				return fmt.Errorf("either namespace ID or --all-namespaces flag is required")
 			}
 
-			if deleteByTag == "" && key == "" {
-				return fmt.Errorf("either tag or key is required for deletion")
+			if fromPlan == "" && deleteByTag == "" && metaFilter == "" && key == "" && keysFile == "" {
+				return fmt.Errorf("either tag, metadata filter, key or keys file is required for deletion")
 			}
 
-			client, err := api.GetClient()
+			if err := concurrency.Validate(); err != nil {
+				return err
+			}
+
+			if err := util.ValidateBatchSize(batchSize, kvBulkDeleteBatchSize); err != nil {
+				return err
+			}
+
+			// Other deletions remove keys one at a time, so there is no batch to size
+			if cmd.Flags().Changed("batch-size") && keysFile == "" {
+				return fmt.Errorf("--batch-size requires --keys-file")
+			}
+
+			if measureSize && key != "" {
+				return fmt.Errorf("--measure-size cannot be combined with --key")
+			}
+
+			var selector *keySelector
+			if fromPlan == "" && key == "" && keysFile == "" {
+				var err error
+				selector, err = newKeySelector(deleteByTag, metaFilter, tagList)
+				if err != nil {
+					return err
+				}
+			}
+
+			// Writing a plan file implies a dry run
+			if dryRunOutput != "" {
+				dryRun = true
+			}
+
+			if sample < 0 {
+				return fmt.Errorf("--sample must not be negative")
+			}
+			if sample > 0 && !dryRun {
+				return fmt.Errorf("--sample requires --dry-run")
+			}
+
+			client, err := api.GetClient(api.WriteAccess)
 			if err != nil {
 				return err
 			}
 
+			ctx, cancel := context.WithCancel(cmd.Context())
+			defer cancel()
+
 			// If deleting a specific key, handle it directly
 			if key != "" {
+				if base64Key {
+					decoded, err := decodeBase64Key(key)
+					if err != nil {
+						return err
+					}
+					key = decoded
+				}
+
 				if allNamespaces {
 					return fmt.Errorf("cannot use --all-namespaces with --key; specify a single namespace")
 				}
@@ -76,29 +192,65 @@ func newDeleteCmd() *cobra.Command {
 
 				if dryRun {
 					util.Info("Dry run mode - would delete key '%s' from namespace %s", key, namespaces[0])
+					plan := util.NewPlan("kv delete")
+					plan.Namespaces = []util.PlanNamespace{{ID: namespaces[0], Keys: []string{key}}}
+					if err := writePlanIfRequested(dryRunOutput, plan); err != nil {
+						return err
+					}
+					result := newKVResult(true)
+					result.plan(namespaces[0], key)
+					if err := out.writeReport(result); err != nil {
+						return err
+					}
+					if out.structured() {
+						return out.write(result.document())
+					}
 					return nil
 				}
 
-				params := cloudflare.DeleteWorkersKVEntryParams{
-					NamespaceID: namespaces[0],
-					Key:         key,
+				err := api.WithRetry(ctx, func(ctx context.Context) error {
+					return api.DeleteKV(ctx, client, namespaces[0], key)
+				})
+				util.Audit(namespaces[0], []string{key}, err)
+
+				result := newKVResult(false)
+				result.Record(namespaces[0], key, err)
+				if err := out.writeReport(result); err != nil {
+					return err
 				}
-				err := client.DeleteWorkersKVEntry(context.Background(), api.GetAccountID(), params)
 
 				if err != nil {
 					return fmt.Errorf("error deleting KV key: %w", err)
 				}
 
-				util.Success("Successfully deleted key: %s", key)
+				out.success("Successfully deleted key: %s", key)
+				if out.structured() {
+					return out.write(result.document())
+				}
 				return nil
 			}
 
+			if keysFile != "" {
+				namespaces := util.SplitCommaList(namespace)
+				if len(namespaces) > 1 {
+					return fmt.Errorf("cannot use multiple namespaces with --keys-file; specify a single namespace")
+				}
+				return deleteKeysFromFile(ctx, client, namespaces[0], keysFile, keysFormat, concurrency.Semaphore(api.GetRateLimit()), batchSize, measureSize, dryRun, dryRunOutput, failuresOut, sample, errorOnEmpty, failFast, &out)
+			}
+
 			// Get list of namespaces to process
 			var namespaceIDs []string
+			var plannedKeys map[string][]string
 
-			if allNamespaces {
+			if fromPlan != "" {
+				// Execute exactly what was reviewed, without re-discovering keys
+				_, namespaceIDs, plannedKeys, err = loadNamespacePlan(fromPlan, "kv delete")
+				if err != nil {
+					return err
+				}
+			} else if allNamespaces {
 				// Get all namespaces
-				resp, _, err := client.ListWorkersKVNamespaces(context.Background(), api.GetAccountID(), cloudflare.ListWorkersKVNamespacesParams{})
+				resp, err := listAllNamespaces(ctx, client)
 				if err != nil {
 					return fmt.Errorf("error listing KV namespaces: %w", err)
 				}
@@ -116,113 +268,341 @@ func newDeleteCmd() *cobra.Command {
 				namespaceIDs = util.SplitCommaList(namespace)
 			}
 
-			totalSuccessCount := 0
-			totalFailureCount := 0
+			result := newKVResult(dryRun)
+			matched := 0
+			plan := util.NewPlan("kv delete")
 
-			// Process each namespace
-			for _, nsID := range namespaceIDs {
-				fmt.Printf("\nProcessing namespace: %s\n", nsID)
+			var sizeSem *util.Semaphore
+			if measureSize {
+				sizeSem = concurrency.Semaphore(api.GetRateLimit())
+			}
 
-				// Get all keys in the namespace
-				keys, _, err := client.ListWorkersKVKeys(context.Background(), api.GetAccountID(), cloudflare.ListWorkersKVKeysParams{
-					NamespaceID: nsID,
-				})
-				if err != nil {
-					util.Error("Error listing KV keys in namespace %s: %v", nsID, err)
-					totalFailureCount++
-					continue
+			// finish records any failures and prints the results, reporting an
+			// interruption only once the partial results are out
+			finish := func() error {
+				if err := writeFailuresIfRequested(failuresOut, "kv delete", result, nil); err != nil {
+					return err
+				}
+				if err := out.summary(result); err != nil {
+					return err
+				}
+				return util.Interrupted(cmd.Context())
+			}
+
+			// Process each namespace
+			for i, nsID := range namespaceIDs {
+				if cmd.Context().Err() != nil {
+					util.Warning("Interrupted; skipping the remaining %d namespaces", len(namespaceIDs)-i)
+					return finish()
 				}
 
-				// Find keys with matching cache tags
+				util.Printf("\nProcessing namespace: %s\n", nsID)
+
+				// Find keys with matching cache tags, or take them from the plan
 				var keysToDelete []string
 
-				for _, key := range keys {
-					if key.Metadata != nil {
-						// Use type assertion to access the metadata map
-						if metadata, ok := key.Metadata.(map[string]interface{}); ok {
-							if cacheTag, exists := metadata["cache-tag"]; exists {
-								// Check if the cache tag contains our search tag
-								if cacheTagStr, ok := cacheTag.(string); ok && strings.Contains(cacheTagStr, deleteByTag) {
-									keysToDelete = append(keysToDelete, key.Name)
-								}
+				if fromPlan != "" {
+					keysToDelete = plannedKeys[nsID]
+				} else {
+					keysToDelete, _, err = findKeys(ctx, client, nsID, selector)
+					if err != nil {
+						util.Error("Error listing KV keys in namespace %s: %v", nsID, err)
+						result.Record(nsID, "", err)
+						if failFast {
+							if err := finish(); err != nil {
+								return err
 							}
+							return fmt.Errorf("aborting due to --fail-fast: error listing KV keys in namespace %s: %w", nsID, err)
 						}
+						continue
 					}
-				}
 
-				if len(keysToDelete) == 0 {
-					util.Info("No KV keys found with cache-tag containing '%s' in namespace %s", deleteByTag, nsID)
-					continue
+					if len(keysToDelete) == 0 {
+						util.Info("No KV keys found %s in namespace %s", selector.description, nsID)
+						continue
+					}
+
+					util.Info("Found %d KV keys %s in namespace %s", len(keysToDelete), selector.description, nsID)
 				}
 
-				util.Info("Found %d KV keys with matching cache tag '%s' in namespace %s", len(keysToDelete), deleteByTag, nsID)
+				matched += len(keysToDelete)
+
+				if measureSize {
+					util.Info("Measuring the values of %d keys in namespace %s", len(keysToDelete), nsID)
+					sizes, unmeasured := measureValueSizes(ctx, client, nsID, keysToDelete, sizeSem)
+					result.measure(nsID, sizes, unmeasured)
+				}
 
 				if dryRun {
-					fmt.Printf("Dry run mode - would delete the following keys from namespace %s:\n", nsID)
+					printDryRunKeys(nsID, keysToDelete, nil, sample)
 					for _, key := range keysToDelete {
-						fmt.Printf("  %s\n", key)
+						result.plan(nsID, key)
 					}
+					plan.Namespaces = append(plan.Namespaces, util.PlanNamespace{ID: nsID, Keys: keysToDelete})
 					continue
 				}
 
 				// Delete the KV entries
+				nsCtx, nsSpan := startNamespaceSpan(ctx, "kv_delete", nsID, len(keysToDelete))
 				var wg sync.WaitGroup
-				var deleteMutex sync.Mutex
-				successCount := 0
-				failureCount := 0
-
-				// Process in batches of 30 for better performance
-				batchSize := 30
-				for i := 0; i < len(keysToDelete); i += batchSize {
-					end := i + batchSize
-					if end > len(keysToDelete) {
-						end = len(keysToDelete)
-					}
+				var failMutex sync.Mutex
+				var firstErr error
+				nsResult := util.NewResults()
 
-					batch := keysToDelete[i:end]
+				// With --preserve-order, results are buffered per key and printed once all batches finish
+				keyResults := make([]keyResult, len(keysToDelete))
+
+				// Process in batches for better performance
+				for i, batch := range util.Chunk(keysToDelete, kvKeyBatchSize) {
 					wg.Add(1)
 
-					go func(keys []string, nsID string) {
+					go func(start int, keys []string, nsID string) {
 						defer wg.Done()
 
-						for _, key := range keys {
-							params := cloudflare.DeleteWorkersKVEntryParams{
-								NamespaceID: nsID,
-								Key:         key,
+						for j, key := range keys {
+							// Stop picking up new keys after a fail-fast abort or Ctrl-C
+							if nsCtx.Err() != nil {
+								return
 							}
-							err := client.DeleteWorkersKVEntry(context.Background(), api.GetAccountID(), params)
 
-							deleteMutex.Lock()
+							err := api.WithRetry(nsCtx, func(ctx context.Context) error {
+								return api.DeleteKV(ctx, client, nsID, key)
+							})
+
+							keyResults[start+j] = keyResult{attempted: true, err: err}
+							nsResult.Record(nsID, key, err)
+							util.Audit(nsID, []string{key}, err)
 							if err != nil {
-								util.Error("Error deleting KV key %s in namespace %s: %v", key, nsID, err)
-								failureCount++
-							} else {
-								util.Success("Successfully deleted KV key: %s from namespace %s", key, nsID)
-								successCount++
+								if !preserveOrder {
+									util.Error("Error deleting KV key %s in namespace %s: %v", key, nsID, err)
+								}
+								if failFast {
+									failMutex.Lock()
+									if firstErr == nil {
+										firstErr = fmt.Errorf("error deleting KV key %s in namespace %s: %w", key, nsID, err)
+										cancel()
+									}
+									failMutex.Unlock()
+								}
+							} else if !preserveOrder {
+								out.success("Successfully deleted KV key: %s from namespace %s", key, nsID)
 							}
-							deleteMutex.Unlock()
 						}
-					}(batch, nsID)
+					}(i*kvKeyBatchSize, batch, nsID)
 				}
 
 				// Wait for all KV deletions to complete
 				wg.Wait()
+				api.EndSpan(nsSpan, nsResult.Err())
+				result.Merge(nsResult)
+
+				if preserveOrder {
+					for i, result := range keyResults {
+						if !result.attempted {
+							continue
+						}
+						if result.err != nil {
+							util.Error("Error deleting KV key %s in namespace %s: %v", keysToDelete[i], nsID, result.err)
+						} else {
+							out.success("Successfully deleted KV key: %s from namespace %s", keysToDelete[i], nsID)
+						}
+					}
+				}
+
+				nsSummary := nsResult.Summary()
+				util.Printf("Summary for namespace %s: %d successful, %d failed\n", nsID, nsSummary.Successful, nsSummary.Failed)
 
-				fmt.Printf("Summary for namespace %s: %d successful, %d failed\n", nsID, successCount, failureCount)
-				totalSuccessCount += successCount
-				totalFailureCount += failureCount
+				if firstErr != nil {
+					if err := finish(); err != nil {
+						return err
+					}
+					return fmt.Errorf("aborting due to --fail-fast: %w", firstErr)
+				}
 			}
 
-			util.PrettyPrintResults(totalSuccessCount, totalFailureCount)
-			return nil
+			if matched == 0 && result.Summary().Failed == 0 {
+				if out.structured() {
+					if err := out.write(result.document()); err != nil {
+						return err
+					}
+				}
+				return util.NothingMatched("KV keys", errorOnEmpty)
+			}
+
+			if dryRun {
+				if err := writePlanIfRequested(dryRunOutput, plan); err != nil {
+					return err
+				}
+				if err := out.writeReport(result); err != nil {
+					return err
+				}
+				if out.structured() {
+					return out.write(result.document())
+				}
+				result.printSize()
+				return nil
+			}
+
+			return finish()
 		},
 	}
 
 	cmd.Flags().StringVar(&deleteByTag, "tag", "", "Delete KV entries with matching cache-tag metadata")
+	cmd.Flags().BoolVar(&tagList, "tag-list", false, "Treat cache-tag metadata as a comma or space separated list and match --tag exactly against its elements")
+	cmd.Flags().StringVar(&metaFilter, "metadata-filter", "", "Delete KV entries whose metadata matches field=value, field~=substring or field (exists); nested fields use dots")
 	cmd.Flags().StringVar(&namespace, "namespace", "", "Comma-separated list of KV namespace IDs")
 	cmd.Flags().BoolVar(&allNamespaces, "all-namespaces", false, "Apply to all KV namespaces")
 	cmd.Flags().StringVar(&key, "key", "", "Specific key to delete")
+	cmd.Flags().IntVar(&batchSize, "batch-size", kvBulkDeleteBatchSize, fmt.Sprintf("Number of keys sent per bulk delete request; requires --keys-file (at most %d)", kvBulkDeleteBatchSize))
+	cmd.Flags().StringVar(&keysFile, "keys-file", "", "File with one key to delete per line, exactly as stored")
+	cmd.Flags().StringVar(&keysFormat, "keys-file-format", util.KeysFileLines, "Format of --keys-file: lines (blank lines and # comments are ignored) or raw (every non-empty line is a key)")
+	cmd.Flags().Var(&concurrency, "concurrency", "Maximum number of concurrent requests when using --keys-file or --measure-size, or auto to adapt to --rate-limit and rate limiting")
+	cmd.Flags().BoolVar(&measureSize, "measure-size", false, "Read each matched value before deleting to report the storage freed and the largest keys; doubles the API calls")
+	cmd.Flags().BoolVar(&base64Key, "base64-key", false, "Treat --key as base64 and decode it before use")
 	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what would be deleted without actually deleting")
+	cmd.Flags().StringVar(&fromPlan, "from-plan", "", "Delete exactly the keys listed in a plan written with --dry-run-output")
+	cmd.Flags().StringVar(&retryFailed, "retry-failed", "", "Delete only the keys recorded in a file written with --failures-output")
+	cmd.Flags().StringVar(&failuresOut, "failures-output", "", "Write the keys that failed to delete, with their errors, to this file for --retry-failed")
+	cmd.Flags().IntVar(&sample, "sample", 0, "With --dry-run, list only the first N matched keys per namespace and count the rest")
+	cmd.Flags().StringVar(&dryRunOutput, "dry-run-output", "", "Write the dry-run plan as JSON to this file (implies --dry-run)")
+	cmd.Flags().BoolVar(&preserveOrder, "preserve-order", false, "Print per-key results in the original key order once processing completes")
+	cmd.Flags().BoolVar(&errorOnEmpty, "error-on-empty", false, fmt.Sprintf("Exit with status %d when no keys match", util.ExitNothingMatched))
+	cmd.Flags().BoolVar(&failFast, "fail-fast", false, "Stop on the first error; work already completed is not rolled back")
+	out.addFlags(cmd)
+	out.addReportFlag(cmd, "KV delete")
 
 	return cmd
 }
+
+// deleteKeysFromFile deletes exactly the keys listed in a file from one namespace
+// using the bulk delete API. Listed keys that do not exist are reported and skipped.
+// With failFast, no further batches are started once one fails.
+func deleteKeysFromFile(ctx context.Context, client *cloudflare.API, nsID, path, format string, sem *util.Semaphore, batchSize int, measureSize, dryRun bool, dryRunOutput, failuresOut string, sample int, errorOnEmpty, failFast bool, out *output) error {
+	keys, err := util.ReadKeysFile(path, format)
+	if err != nil {
+		return fmt.Errorf("error reading keys file: %w", err)
+	}
+
+	keys = util.FilterDuplicates(keys)
+	if len(keys) == 0 {
+		return fmt.Errorf("no keys found in %s", path)
+	}
+
+	util.Info("Checking %d listed keys in namespace %s", len(keys), nsID)
+	existing, missing, err := splitExistingKeys(ctx, client, nsID, keys, sem)
+	if err != nil {
+		return err
+	}
+
+	if len(missing) > 0 {
+		util.Warning("%d listed keys do not exist in namespace %s:", len(missing), nsID)
+		for _, key := range missing {
+			util.Printf("  %s\n", key)
+		}
+	}
+
+	result := newKVResult(dryRun)
+	if measureSize && len(existing) > 0 {
+		util.Info("Measuring the values of %d keys in namespace %s", len(existing), nsID)
+		sizes, unmeasured := measureValueSizes(ctx, client, nsID, existing, sem)
+		result.measure(nsID, sizes, unmeasured)
+	}
+
+	if len(existing) == 0 {
+		if out.structured() {
+			if err := out.write(result.document()); err != nil {
+				return err
+			}
+		}
+		return util.NothingMatched("listed keys", errorOnEmpty)
+	}
+
+	if dryRun {
+		printDryRunKeys(nsID, existing, nil, sample)
+		for _, key := range existing {
+			result.plan(nsID, key)
+		}
+		plan := util.NewPlan("kv delete")
+		plan.Namespaces = []util.PlanNamespace{{ID: nsID, Keys: existing}}
+		if err := writePlanIfRequested(dryRunOutput, plan); err != nil {
+			return err
+		}
+		if err := out.writeReport(result); err != nil {
+			return err
+		}
+		if out.structured() {
+			return out.write(result.document())
+		}
+		result.printSize()
+		return nil
+	}
+
+	batchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	spanCtx, span := startNamespaceSpan(batchCtx, "kv_bulk_delete", nsID, len(existing))
+	spanCtx = api.WithSemaphore(spanCtx, sem)
+	var wg sync.WaitGroup
+	var failMutex sync.Mutex
+	var firstErr error
+
+	for _, batch := range util.Chunk(existing, batchSize) {
+		sem.Acquire()
+		if batchCtx.Err() != nil {
+			sem.Release(time.Now(), nil)
+			if ctx.Err() != nil {
+				util.Warning("Interrupted; not starting the remaining batches")
+			} else {
+				util.Warning("Not starting the remaining batches after a failed bulk delete")
+			}
+			break
+		}
+		wg.Add(1)
+
+		go func(batch []string) {
+			defer wg.Done()
+
+			start := time.Now()
+			params := cloudflare.DeleteWorkersKVEntriesParams{
+				NamespaceID: nsID,
+				Keys:        batch,
+			}
+			err := api.WithRetry(spanCtx, func(ctx context.Context) error {
+				_, err := client.DeleteWorkersKVEntries(ctx, cloudflare.AccountIdentifier(api.GetAccountID()), params)
+				return err
+			})
+			sem.Release(start, err)
+
+			for _, key := range batch {
+				result.Record(nsID, key, err)
+			}
+			util.Audit(nsID, batch, err)
+			if err != nil {
+				util.Error("Error deleting %d keys from namespace %s: %v", len(batch), nsID, err)
+				if failFast {
+					failMutex.Lock()
+					if firstErr == nil {
+						firstErr = fmt.Errorf("error deleting %d keys from namespace %s: %w", len(batch), nsID, err)
+						cancel()
+					}
+					failMutex.Unlock()
+				}
+				return
+			}
+			out.success("Deleted %d keys from namespace %s", len(batch), nsID)
+		}(batch)
+	}
+
+	wg.Wait()
+	api.EndSpan(span, result.Err())
+
+	if err := writeFailuresIfRequested(failuresOut, "kv delete", result, nil); err != nil {
+		return err
+	}
+	if err := out.summary(result); err != nil {
+		return err
+	}
+	if firstErr != nil {
+		return fmt.Errorf("aborting due to --fail-fast: %w", firstErr)
+	}
+	return util.Interrupted(ctx)
+}
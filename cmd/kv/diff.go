@@ -0,0 +1,225 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"cfpurge/internal/api"
+	"cfpurge/internal/util"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/spf13/cobra"
+)
+
+// diffResult is the structured output of kv diff
+type diffResult struct {
+	Source  string            `json:"source" yaml:"source"`
+	Dest    string            `json:"dest" yaml:"dest"`
+	Summary util.DiffSummary  `json:"summary" yaml:"summary"`
+	Keys    []util.KeyDiff    `json:"keys" yaml:"keys"`
+	Errors  map[string]string `json:"errors,omitempty" yaml:"errors,omitempty"`
+}
+
+func newDiffCmd() *cobra.Command {
+	var (
+		source        string
+		dest          string
+		prefix        string
+		compareValues bool
+		concurrency   = util.Concurrency{N: valueFetchConcurrency}
+		out           output
+	)
+
+	cmd := &cobra.Command{
+		Use:   "diff",
+		Short: "Compare the keys of two KV namespaces",
+		Long: `List the keys of two namespaces, with their metadata, and report the keys
+only in the source, only in the destination, and in both but with different
+metadata. With --compare-values the value of every key in both namespaces is
+also read and compared, which costs two reads per key.`,
+		Example: `  # See how a namespace differs from its copy before a migration
+  cfpurge kv diff --source=<namespace-id1> --dest=<namespace-id2>
+
+  # Also compare values, as JSON
+  cfpurge kv diff --source=<namespace-id1> --dest=<namespace-id2> --compare-values --format=json`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := api.ValidateAuth(); err != nil {
+				return err
+			}
+
+			if err := api.ValidateAccountID(); err != nil {
+				return err
+			}
+
+			if err := out.start(); err != nil {
+				return err
+			}
+
+			if source == dest {
+				return fmt.Errorf("--source and --dest must be different namespaces")
+			}
+
+			if err := concurrency.Validate(); err != nil {
+				return err
+			}
+
+			client, err := api.GetClient(api.ReadAccess)
+			if err != nil {
+				return err
+			}
+
+			ctx := cmd.Context()
+			sourceKeys, err := listAllKeys(ctx, client, source, prefix)
+			if err != nil {
+				return fmt.Errorf("error listing KV keys in namespace %s: %w", source, err)
+			}
+			destKeys, err := listAllKeys(ctx, client, dest, prefix)
+			if err != nil {
+				return fmt.Errorf("error listing KV keys in namespace %s: %w", dest, err)
+			}
+
+			sourceEntries := diffEntries(sourceKeys)
+			destEntries := diffEntries(destKeys)
+
+			var valueErrors map[string]string
+			if compareValues {
+				var common []string
+				for key := range sourceEntries {
+					if _, ok := destEntries[key]; ok {
+						common = append(common, key)
+					}
+				}
+				util.Info("Comparing the values of %d keys present in both namespaces", len(common))
+				valueErrors = fetchDiffValues(ctx, client, source, dest, common, sourceEntries, destEntries, concurrency.Semaphore(api.GetRateLimit()))
+				if err := util.Interrupted(ctx); err != nil {
+					return err
+				}
+			}
+
+			keys, summary := util.DiffKeys(sourceEntries, destEntries)
+			summary.SourceKeys, summary.DestKeys = len(sourceKeys), len(destKeys)
+			if keys == nil {
+				keys = []util.KeyDiff{}
+			}
+			result := diffResult{Source: source, Dest: dest, Summary: summary, Keys: keys, Errors: valueErrors}
+
+			if out.structured() {
+				return out.write(result)
+			}
+			printDiffResult(result)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&source, "source", "", "Source KV namespace ID")
+	cmd.Flags().StringVar(&dest, "dest", "", "Destination KV namespace ID")
+	cmd.Flags().StringVar(&prefix, "prefix", "", "Only compare keys with this prefix")
+	cmd.Flags().BoolVar(&compareValues, "compare-values", false, "Also read and compare the value of every key present in both namespaces")
+	cmd.Flags().Var(&concurrency, "concurrency", "Maximum number of values read concurrently with --compare-values, or auto to adapt to --rate-limit and rate limiting")
+	out.addFlags(cmd)
+	cmd.Flags().StringVar(&out.format, "format", util.OutputTable, "Alias for --output")
+
+	cmd.MarkFlagRequired("source")
+	cmd.MarkFlagRequired("dest")
+
+	return cmd
+}
+
+// diffEntries indexes listed keys by name for comparison
+func diffEntries(keys []cloudflare.StorageKey) map[string]util.DiffEntry {
+	entries := make(map[string]util.DiffEntry, len(keys))
+	for _, key := range keys {
+		entries[key.Name] = util.DiffEntry{Metadata: key.Metadata}
+	}
+	return entries
+}
+
+// fetchDiffValues reads the value of each key from both namespaces, holding
+// sem while reading each key, and stores it in the entries. Keys whose value cannot be
+// read are removed from the comparison and returned with their error.
+func fetchDiffValues(ctx context.Context, client *cloudflare.API, source, dest string, keys []string, sourceEntries, destEntries map[string]util.DiffEntry, sem *util.Semaphore) map[string]string {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	errs := make(map[string]string)
+	ctx = api.WithSemaphore(ctx, sem)
+
+	read := func(namespace, key string) ([]byte, error) {
+		var value []byte
+		err := api.WithRetry(ctx, func(ctx context.Context) error {
+			var err error
+			value, err = api.ReadKV(ctx, client, namespace, key)
+			return err
+		})
+		return value, err
+	}
+
+	for _, key := range keys {
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		sem.Acquire()
+
+		go func(key string) {
+			defer wg.Done()
+
+			start := time.Now()
+			sourceValue, err := read(source, key)
+			var destValue []byte
+			if err == nil {
+				destValue, err = read(dest, key)
+			}
+			sem.Release(start, err)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[key] = err.Error()
+				delete(sourceEntries, key)
+				delete(destEntries, key)
+				return
+			}
+			// A nil value means "not compared", so keep empty values non-nil
+			sourceEntries[key] = util.DiffEntry{Metadata: sourceEntries[key].Metadata, Value: append([]byte{}, sourceValue...)}
+			destEntries[key] = util.DiffEntry{Metadata: destEntries[key].Metadata, Value: append([]byte{}, destValue...)}
+		}(key)
+	}
+	wg.Wait()
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// printDiffResult prints the differing keys as a table with a summary line
+func printDiffResult(result diffResult) {
+	summary := result.Summary
+	if len(result.Keys) > 0 {
+		labels := map[string]string{
+			util.DiffOnlySource: "only in source",
+			util.DiffOnlyDest:   "only in dest",
+		}
+
+		table := util.NewTable("Key", "Difference")
+		for _, diff := range result.Keys {
+			label, ok := labels[diff.Status]
+			if !ok {
+				label = "changed " + strings.Join(diff.Fields, ", ")
+			}
+			table.AddRow(diff.Key, label)
+		}
+		fmt.Println()
+		table.Print()
+	}
+
+	for key, err := range result.Errors {
+		util.Error("Could not compare the value of %s: %s", key, err)
+	}
+
+	fmt.Printf("\n%s: %d keys, %s: %d keys\n", result.Source, summary.SourceKeys, result.Dest, summary.DestKeys)
+	fmt.Printf("%d only in source, %d only in dest, %d differ, %d identical\n", summary.OnlySource, summary.OnlyDest, summary.Changed, summary.Identical)
+}
@@ -1,12 +1,14 @@
 package kv
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"strings"
+	"os"
 
 	"cfpurge/internal/api"
+	"cfpurge/internal/util"
 
 	"github.com/cloudflare/cloudflare-go"
 	"github.com/spf13/cobra"
@@ -17,17 +19,82 @@ func newGetCmd() *cobra.Command {
 		namespace string
 		key       string
 		metadata  bool
+		base64Key bool
+		field     string
+		raw       bool
+		jqExpr    string
+		detect    bool
+		chunked   bool
+		ifVersion int64
+		out       output
+
+		maxDisplayBytes int
+		outputFile      string
+
+		namespaceTitle  string
+		cacheNamespaces bool
+		refresh         bool
 	)
 
 	cmd := &cobra.Command{
 		Use:   "get",
 		Short: "Get a KV entry",
-		Long:  `Retrieve a Workers KV entry value or metadata from a namespace.`,
+		Long: `Retrieve a Workers KV entry value or metadata from a namespace.
+
+By default a value that starts with '{' or '[' and parses as JSON is
+pretty-printed, which changes its whitespace, and long values are truncated.
+The guess is only a heuristic: a compact JSON value will not print as stored,
+and a value that starts with a brace but is not JSON is printed unchanged.
+Use --raw in scripts or when comparing values byte for byte.
+
+With --jq, a JSON value is filtered through a jq expression and only the
+results are printed: strings as plain text, anything else as JSON.
+
+With --detect-type, the value's MIME type is guessed from its first bytes and
+printed to stderr before the value, and binary values such as images or
+archives are not printed to a terminal; save them with --output-file instead.
+
+With --chunked, a value stored by kv put --chunk is reassembled from its
+chunks. Values that were not chunked are returned as they are.
+
+With --if-metadata-version, only the key's metadata is read first, and the
+value is read only when the "version" field of the metadata, a whole number,
+is greater than the one given. Otherwise "not modified" is printed and the
+command exits with status 5, which suits polling a large value that rarely
+changes. Writers must raise the version with every change, for example with
+kv put --metadata='{"version": 8}'. A key without a version is always read.`,
 		Example: `  # Get the value of a key
   cfpurge kv get --namespace=<namespace-id> --key=my-key
   
   # Get only the metadata of a key
-  cfpurge kv get --namespace=<namespace-id> --key=my-key --metadata`,
+  cfpurge kv get --namespace=<namespace-id> --key=my-key --metadata
+  
+  # Get a single (possibly nested) metadata field
+  TAG=$(cfpurge kv get --namespace=<namespace-id> --key=my-key --metadata --field=cache-tag)
+  
+  # Look the namespace up by title, caching the namespace list between runs
+  cfpurge kv get --namespace-title=SESSIONS --key=my-key --cache-namespaces
+  
+  # Print the value exactly as stored, e.g. to compare it with a file
+  cfpurge kv get --namespace=<namespace-id> --key=my-key --raw | cmp - expected.json
+  
+  # Print one field of a stored JSON config
+  cfpurge kv get --namespace=<namespace-id> --key=config --jq=.features.checkout
+  
+  # Show what kind of data a value holds before printing it
+  cfpurge kv get --namespace=<namespace-id> --key=my-key --detect-type
+  
+  # Reassemble a value stored with kv put --chunk
+  cfpurge kv get --namespace=<namespace-id> --key=dataset --chunked --output-file=dataset.bin
+  
+  # Poll a value, reading it only when its metadata version is above 7
+  cfpurge kv get --namespace=<namespace-id> --key=config --if-metadata-version=7 --raw
+  
+  # Save a large value to a file instead of printing it
+  cfpurge kv get --namespace=<namespace-id> --key=my-blob --output-file=blob.bin
+  
+  # Get the value and metadata together as JSON
+  cfpurge kv get --namespace=<namespace-id> --key=my-key --output=json`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if err := api.ValidateAuth(); err != nil {
 				return err
@@ -37,26 +104,124 @@ func newGetCmd() *cobra.Command {
 				return err
 			}
 
-			if namespace == "" {
-				return fmt.Errorf("namespace ID is required")
+			if err := out.start(); err != nil {
+				return err
+			}
+
+			if namespace == "" && namespaceTitle == "" {
+				return fmt.Errorf("either namespace ID or --namespace-title is required")
+			}
+
+			if namespace != "" && namespaceTitle != "" {
+				return fmt.Errorf("--namespace cannot be combined with --namespace-title")
 			}
 
 			if key == "" {
 				return fmt.Errorf("key is required")
 			}
 
-			client, err := api.GetClient()
+			if field != "" && !metadata {
+				return fmt.Errorf("--field can only be used with --metadata")
+			}
+
+			if outputFile != "" && (metadata || out.structured()) {
+				return fmt.Errorf("--output-file writes the raw value and cannot be combined with --metadata or --output")
+			}
+
+			if raw && (metadata || out.structured() || outputFile != "") {
+				return fmt.Errorf("--raw cannot be combined with --metadata, --output or --output-file")
+			}
+
+			if detect && (metadata || out.structured()) {
+				return fmt.Errorf("--detect-type applies to values and cannot be combined with --metadata or --output")
+			}
+
+			if chunked && metadata {
+				return fmt.Errorf("--chunked applies to values and cannot be combined with --metadata")
+			}
+
+			conditional := cmd.Flags().Changed("if-metadata-version")
+			if conditional && metadata {
+				return fmt.Errorf("--if-metadata-version applies to values and cannot be combined with --metadata")
+			}
+
+			var jq *util.JQ
+			if jqExpr != "" {
+				if metadata || raw || out.structured() || outputFile != "" {
+					return fmt.Errorf("--jq cannot be combined with --metadata, --raw, --output or --output-file")
+				}
+				var err error
+				jq, err = util.ParseJQ(jqExpr)
+				if err != nil {
+					return err
+				}
+			}
+
+			if base64Key {
+				decoded, err := decodeBase64Key(key)
+				if err != nil {
+					return err
+				}
+				key = decoded
+			}
+
+			client, err := api.GetClient(api.ReadAccess)
 			if err != nil {
 				return err
 			}
 
+			if namespaceTitle != "" {
+				resolver := &namespaceResolver{client: client, diskCache: cacheNamespaces, refresh: refresh}
+				namespace, err = resolver.resolve(cmd.Context(), namespaceTitle)
+				if err != nil {
+					return err
+				}
+			}
+
+			if conditional {
+				modified, err := newerThanVersion(cmd.Context(), client, namespace, key, ifVersion)
+				if err != nil {
+					return err
+				}
+				if !modified {
+					if !out.structured() {
+						fmt.Println("not modified")
+					}
+					return fmt.Errorf("key %s is not newer than version %d: %w", key, ifVersion, util.ErrNotModified)
+				}
+			}
+
+			if out.structured() {
+				return getStructured(cmd.Context(), client, namespace, key, metadata, chunked, field, &out)
+			}
+
 			if metadata {
 				// Get metadata only
-				meta, err := client.GetWorkersKVEntryMetadata(context.Background(), api.GetAccountID(), namespace, key)
+				var meta interface{}
+				err := api.WithRetry(cmd.Context(), func(ctx context.Context) error {
+					listed, err := api.ReadKVKey(ctx, client, namespace, key)
+					meta = listed.Metadata
+					return err
+				})
 				if err != nil {
 					return fmt.Errorf("error getting KV metadata: %w", err)
 				}
 
+				// Print just the requested field so it can be captured by scripts
+				if field != "" {
+					fieldValue, ok := util.LookupPath(meta, field)
+					if !ok {
+						return fmt.Errorf("metadata field '%s' not found", field)
+					}
+					if str, ok := fieldValue.(string); ok {
+						fmt.Println(str)
+					} else {
+						fieldJSON, _ := json.Marshal(fieldValue)
+						fmt.Println(string(fieldJSON))
+					}
+					return nil
+				}
+
 				fmt.Println("KV Entry Metadata:")
 				if metaData, ok := meta.(map[string]interface{}); ok {
 					for k, v := range metaData {
@@ -70,24 +235,84 @@ func newGetCmd() *cobra.Command {
 				}
 			} else {
 				// Get value
-				value, err := client.GetWorkersKV(context.Background(), api.GetAccountID(), namespace, key)
+				var value []byte
+				err := api.WithRetry(cmd.Context(), func(ctx context.Context) error {
+					var err error
+					value, err = api.ReadKV(ctx, client, namespace, key)
+					return err
+				})
 				if err != nil {
 					return fmt.Errorf("error getting KV value: %w", err)
 				}
 
+				if chunked {
+					var meta interface{}
+					err := api.WithRetry(cmd.Context(), func(ctx context.Context) error {
+						listed, err := api.ReadKVKey(ctx, client, namespace, key)
+						meta = listed.Metadata
+						return err
+					})
+					if err != nil {
+						return fmt.Errorf("error getting KV metadata: %w", err)
+					}
+					value, err = readChunks(cmd.Context(), client, namespace, key, meta, value)
+					if err != nil {
+						return err
+					}
+				}
+
+				if detect {
+					mimeType, binary := util.DetectContentType(value)
+					fmt.Fprintf(os.Stderr, "Content-Type: %s (%s)\n", mimeType, util.FormatBytes(int64(len(value))))
+					if binary && outputFile == "" && util.IsTerminal(os.Stdout) {
+						return fmt.Errorf("value of key %s is binary (%s) and was not printed to the terminal; save it with --output-file or pipe --raw output", key, mimeType)
+					}
+				}
+
+				// Files get the value exactly as stored, however large
+				if outputFile != "" {
+					if err := os.WriteFile(outputFile, value, 0o644); err != nil {
+						return fmt.Errorf("error writing value to %s: %w", outputFile, err)
+					}
+					util.Success("Wrote %s bytes to %s", util.FormatCount(len(value)), outputFile)
+					return nil
+				}
+
+				// Scripts get the value byte for byte, with no trailing newline
+				if raw {
+					if _, err := os.Stdout.Write(value); err != nil {
+						return fmt.Errorf("error writing value: %w", err)
+					}
+					return nil
+				}
+
+				if jq != nil {
+					results, err := jq.Apply(value)
+					if err != nil {
+						return fmt.Errorf("error applying --jq to key %s: %w", key, err)
+					}
+					for _, result := range results {
+						fmt.Println(util.FormatJQResult(result))
+					}
+					return nil
+				}
+
 				// Try to print as string first
-				valueStr := string(value)
-				if strings.HasPrefix(valueStr, "{") || strings.HasPrefix(valueStr, "[") {
+				display := value
+				if bytes.HasPrefix(value, []byte("{")) || bytes.HasPrefix(value, []byte("[")) {
 					// If it looks like JSON, pretty print it
 					var jsonValue interface{}
 					if err := json.Unmarshal(value, &jsonValue); err == nil {
-						prettyJSON, _ := json.MarshalIndent(jsonValue, "", "  ")
-						fmt.Println(string(prettyJSON))
-					} else {
-						fmt.Println(valueStr)
+						display, _ = json.MarshalIndent(jsonValue, "", "  ")
 					}
-				} else {
-					fmt.Println(valueStr)
+				}
+
+				// Avoid flooding the terminal with a huge value
+				total := len(display)
+				display, truncated := util.TruncateBytes(display, maxDisplayBytes)
+				fmt.Println(string(display))
+				if truncated {
+					fmt.Printf("[truncated after %s of %s bytes, use --output-file for full value]\n", util.FormatCount(len(display)), util.FormatCount(total))
 				}
 			}
 
@@ -96,11 +321,130 @@ func newGetCmd() *cobra.Command {
 	}
 
 	cmd.Flags().StringVar(&namespace, "namespace", "", "KV namespace ID")
+	cmd.Flags().StringVar(&namespaceTitle, "namespace-title", "", "KV namespace title, resolved to its ID")
+	cmd.Flags().BoolVar(&cacheNamespaces, "cache-namespaces", false, "Cache the namespace list on disk for a few minutes when resolving --namespace-title")
+	cmd.Flags().BoolVar(&refresh, "refresh", false, "Ignore and replace the cached namespace list")
 	cmd.Flags().StringVar(&key, "key", "", "Key to retrieve")
 	cmd.Flags().BoolVar(&metadata, "metadata", false, "Show metadata only (not value)")
+	cmd.Flags().StringVar(&field, "field", "", "Print only this metadata field (dotted paths for nested values)")
+	cmd.Flags().BoolVar(&base64Key, "base64-key", false, "Treat --key as base64 and decode it before use")
+	cmd.Flags().BoolVar(&raw, "raw", false, "Print the value exactly as stored, without JSON pretty-printing, truncation or a trailing newline")
+	cmd.Flags().StringVar(&jqExpr, "jq", "", "Print the results of this jq expression applied to the JSON value instead of the whole value")
+	cmd.Flags().IntVar(&maxDisplayBytes, "max-display-bytes", 1<<20, "Truncate values printed to the terminal after this many bytes (0 for no limit)")
+	cmd.Flags().BoolVar(&detect, "detect-type", false, "Print the value's detected MIME type to stderr and refuse to print binary values to a terminal")
+	cmd.Flags().BoolVar(&chunked, "chunked", false, "Reassemble a value stored across several keys with kv put --chunk")
+	cmd.Flags().Int64Var(&ifVersion, "if-metadata-version", 0, fmt.Sprintf("Read the value only if its %q metadata field is greater than this, otherwise exit with status %d", util.VersionMetadata, util.ExitNotModified))
+	cmd.Flags().StringVar(&outputFile, "output-file", "", "Write the raw value to this file instead of printing it; never truncated")
+
+	out.addFlags(cmd)
 
-	cmd.MarkFlagRequired("namespace")
 	cmd.MarkFlagRequired("key")
 
 	return cmd
 }
+
+// kvEntry is the structured output of kv get
+type kvEntry struct {
+	Namespace string      `json:"namespace" yaml:"namespace"`
+	Key       string      `json:"key" yaml:"key"`
+	Value     interface{} `json:"value,omitempty" yaml:"value,omitempty"`
+	Metadata  interface{} `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+}
+
+// getStructured writes a KV entry as a structured result. The value is
+// included unless only metadata was requested, and is decoded when it is JSON.
+// With chunked, a chunked value is reassembled.
+func getStructured(ctx context.Context, client *cloudflare.API, namespace, key string, metadataOnly, chunked bool, field string, out *output) error {
+	entry := kvEntry{Namespace: namespace, Key: key}
+
+	err := api.WithRetry(ctx, func(ctx context.Context) error {
+		listed, err := api.ReadKVKey(ctx, client, namespace, key)
+		entry.Metadata = listed.Metadata
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error getting KV metadata: %w", err)
+	}
+
+	if field != "" {
+		fieldValue, ok := util.LookupPath(entry.Metadata, field)
+		if !ok {
+			return fmt.Errorf("metadata field '%s' not found", field)
+		}
+		return out.write(map[string]interface{}{field: fieldValue})
+	}
+
+	if !metadataOnly {
+		var value []byte
+		err := api.WithRetry(ctx, func(ctx context.Context) error {
+			var err error
+			value, err = api.ReadKV(ctx, client, namespace, key)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("error getting KV value: %w", err)
+		}
+
+		if chunked {
+			value, err = readChunks(ctx, client, namespace, key, entry.Metadata, value)
+			if err != nil {
+				return err
+			}
+		}
+
+		var jsonValue interface{}
+		if err := json.Unmarshal(value, &jsonValue); err == nil {
+			entry.Value = jsonValue
+		} else {
+			entry.Value = string(value)
+		}
+	}
+
+	return out.write(entry)
+}
+
+// readChunks appends the remaining chunks of a value stored with kv put
+// --chunk to its first chunk, value. A value whose metadata records no chunk
+// count is returned unchanged.
+func readChunks(ctx context.Context, client *cloudflare.API, namespace, key string, metadata interface{}, value []byte) ([]byte, error) {
+	count, ok := util.ChunkCount(metadata)
+	if !ok {
+		return value, nil
+	}
+
+	for i := 1; i < count; i++ {
+		var chunk []byte
+		err := api.WithRetry(ctx, func(ctx context.Context) error {
+			var err error
+			chunk, err = api.ReadKV(ctx, client, namespace, util.ChunkKey(key, i))
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error getting chunk %d of %d of KV value: %w", i+1, count, err)
+		}
+		value = append(value, chunk...)
+	}
+	return value, nil
+}
+
+// newerThanVersion reads a key's metadata and reports whether its version is
+// greater than version. A key without a version counts as newer, since
+// whether it changed cannot be told.
+func newerThanVersion(ctx context.Context, client *cloudflare.API, namespace, key string, version int64) (bool, error) {
+	var meta interface{}
+	err := api.WithRetry(ctx, func(ctx context.Context) error {
+		listed, err := api.ReadKVKey(ctx, client, namespace, key)
+		meta = listed.Metadata
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("error getting KV metadata: %w", err)
+	}
+
+	current, ok := util.MetadataVersion(meta)
+	if !ok {
+		util.Warning("Key %s has no whole-number %q metadata field; reading its value", key, util.VersionMetadata)
+		return true, nil
+	}
+	return current > version, nil
+}
@@ -0,0 +1,395 @@
+package kv
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"cfpurge/internal/api"
+	"cfpurge/internal/util"
+
+	"github.com/cloudflare/cloudflare-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Batch sizes for KV requests
+const (
+	// kvKeyBatchSize is the number of keys each worker deletes one at a time
+	kvKeyBatchSize = 30
+
+	// kvBulkDeleteBatchSize is the maximum number of keys accepted by one bulk delete request
+	kvBulkDeleteBatchSize = 10000
+
+	// bulkWriteBatchSize is the default number of entries sent per bulk write request
+	bulkWriteBatchSize = 1000
+
+	// kvBulkWriteMaxBatchSize is the maximum number of entries accepted by one bulk write request
+	kvBulkWriteMaxBatchSize = 10000
+)
+
+// keyResult is the outcome of an operation on a single key
+type keyResult struct {
+	attempted bool
+	err       error
+}
+
+// listAllKeys lists every key in a namespace matching the prefix, following pagination cursors
+func listAllKeys(ctx context.Context, client *cloudflare.API, namespaceID, prefix string) ([]cloudflare.StorageKey, error) {
+	var allKeys []cloudflare.StorageKey
+	var cursor string
+
+	for {
+		var keys []cloudflare.StorageKey
+		err := api.WithRetry(ctx, func(ctx context.Context) error {
+			var err error
+			keys, cursor, err = api.ListKVKeys(ctx, client, namespaceID, prefix, 0, cursor)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		allKeys = append(allKeys, keys...)
+
+		if cursor == "" {
+			break
+		}
+	}
+
+	return allKeys, nil
+}
+
+// listAllNamespaces lists every KV namespace in the account
+func listAllNamespaces(ctx context.Context, client *cloudflare.API) ([]cloudflare.WorkersKVNamespace, error) {
+	var namespaces []cloudflare.WorkersKVNamespace
+	err := api.WithRetry(ctx, func(ctx context.Context) error {
+		var err error
+		namespaces, _, err = client.ListWorkersKVNamespaces(ctx, cloudflare.AccountIdentifier(api.GetAccountID()), cloudflare.ListWorkersKVNamespacesParams{})
+		return err
+	})
+	return namespaces, err
+}
+
+// decodeBase64Key decodes a key passed with --base64-key into the actual key
+func decodeBase64Key(encoded string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("invalid base64 key '%s': %w", encoded, err)
+	}
+
+	if len(decoded) == 0 {
+		return "", fmt.Errorf("base64 key decodes to an empty key")
+	}
+
+	if err := util.ValidateKVKey(string(decoded)); err != nil {
+		return "", fmt.Errorf("invalid --key: %w", err)
+	}
+
+	return string(decoded), nil
+}
+
+// startNamespaceSpan starts a tracing span for a bulk operation on one namespace
+func startNamespaceSpan(ctx context.Context, operation, nsID string, count int) (context.Context, trace.Span) {
+	return api.StartSpan(ctx, strings.ReplaceAll(operation, "_", " ")+" namespace",
+		attribute.String("cloudflare.kv.namespace_id", nsID),
+		attribute.String("cfpurge.operation", operation),
+		attribute.Int("cfpurge.count", count),
+	)
+}
+
+// writePlanIfRequested saves a dry-run plan when an output file was given
+func writePlanIfRequested(path string, plan *util.Plan) error {
+	if path == "" {
+		return nil
+	}
+
+	if err := util.WritePlan(path, plan); err != nil {
+		return err
+	}
+
+	util.Success("Plan written to %s", path)
+	return nil
+}
+
+// printDryRunKeys lists the keys a dry run would delete from a namespace, with
+// their cache tags when known. A positive sample limits the listing to the first
+// sample keys followed by a count of the rest.
+func printDryRunKeys(nsID string, keys, cacheTags []string, sample int) {
+	util.Printf("Dry run mode - would delete the following keys from namespace %s:\n", nsID)
+
+	shown := keys
+	if sample > 0 && len(keys) > sample {
+		shown = keys[:sample]
+	}
+
+	for i, key := range shown {
+		if i < len(cacheTags) && cacheTags[i] != "" {
+			util.Printf("  %s (cache-tag: %s)\n", key, cacheTags[i])
+		} else {
+			util.Printf("  %s\n", key)
+		}
+	}
+
+	if len(shown) < len(keys) {
+		util.Printf("  ... and %s more (%s in total)\n", util.FormatCount(len(keys)-len(shown)), util.FormatCount(len(keys)))
+	}
+}
+
+// writeFailuresIfRequested saves the keys that failed, with their errors, as a
+// plan that --retry-failed can execute. Namespaces that failed before their keys
+// were listed cannot be expressed in a plan and are only reported.
+func writeFailuresIfRequested(path, command string, result *kvResult, cacheTags []string) error {
+	if path == "" {
+		return nil
+	}
+
+	failures := util.NewPlan(command)
+	recorded := 0
+	for _, item := range result.Failures() {
+		if item.Item == "" {
+			util.Warning("Namespace %s failed before its keys were listed and is not recorded in %s", item.Scope, path)
+			continue
+		}
+		failures.AddFailedKey(item.Scope, item.Item, item.Error)
+		recorded++
+	}
+	failures.CacheTags = util.FilterDuplicates(cacheTags)
+
+	if err := util.WritePlan(path, failures); err != nil {
+		return err
+	}
+
+	util.Info("Recorded %d failed keys in %s", recorded, path)
+	return nil
+}
+
+// keySelector picks keys by their metadata, for --tag and --metadata-filter
+type keySelector struct {
+	description string
+	match       func(metadata map[string]interface{}) bool
+}
+
+// newKeySelector builds a selector from either a cache tag or a metadata filter
+// expression. The tag matches as a substring of the cache-tag metadata, or with
+// tagList as an exact element of a comma or space separated list.
+func newKeySelector(tag, filterExpr string, tagList bool) (*keySelector, error) {
+	if tag != "" && filterExpr != "" {
+		return nil, fmt.Errorf("--tag cannot be combined with --metadata-filter")
+	}
+
+	if tagList && tag == "" {
+		return nil, fmt.Errorf("--tag-list requires --tag")
+	}
+
+	if filterExpr != "" {
+		filter, err := util.ParseMetadataFilter(filterExpr)
+		if err != nil {
+			return nil, err
+		}
+		return &keySelector{
+			description: fmt.Sprintf("with metadata matching '%s'", filter),
+			match: func(metadata map[string]interface{}) bool {
+				return filter.Match(metadata)
+			},
+		}, nil
+	}
+
+	if tagList {
+		return &keySelector{
+			description: fmt.Sprintf("with cache-tag list including '%s'", tag),
+			match: func(metadata map[string]interface{}) bool {
+				cacheTag, ok := metadata["cache-tag"].(string)
+				return ok && util.TagListContains(cacheTag, tag)
+			},
+		}, nil
+	}
+
+	return &keySelector{
+		description: fmt.Sprintf("with cache-tag containing '%s'", tag),
+		match: func(metadata map[string]interface{}) bool {
+			cacheTag, ok := metadata["cache-tag"].(string)
+			return ok && strings.Contains(cacheTag, tag)
+		},
+	}, nil
+}
+
+// purgeTagsFor returns the cache tags to purge for matched keys, skipping keys
+// without one. List-style metadata is split into its individual tags, since a
+// tag containing a comma is rejected by the purge API.
+func purgeTagsFor(cacheTags []string, tagList bool) []string {
+	tags := util.FilterString(cacheTags, "")
+	if !tagList {
+		return tags
+	}
+
+	var split []string
+	for _, tag := range tags {
+		split = append(split, util.SplitTagList(tag)...)
+	}
+	return split
+}
+
+// cacheTagTargets labels cache tags as audit log targets
+func cacheTagTargets(tags []string) []string {
+	targets := make([]string, len(tags))
+	for i, tag := range tags {
+		targets[i] = "tag:" + tag
+	}
+	return targets
+}
+
+// findKeys returns the keys in a namespace whose metadata is selected, along
+// with the cache tag of each matching key (empty when a key has none)
+func findKeys(ctx context.Context, client *cloudflare.API, namespaceID string, selector *keySelector) ([]string, []string, error) {
+	keys, err := listAllKeys(ctx, client, namespaceID, "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var matchingKeys []string
+	var cacheTags []string
+
+	for _, key := range keys {
+		// Use type assertion to access the metadata map
+		metadata, ok := key.Metadata.(map[string]interface{})
+		if !ok || !selector.match(metadata) {
+			continue
+		}
+
+		cacheTag, _ := metadata["cache-tag"].(string)
+		matchingKeys = append(matchingKeys, key.Name)
+		cacheTags = append(cacheTags, cacheTag)
+	}
+
+	return matchingKeys, cacheTags, nil
+}
+
+// loadNamespacePlan reads a plan for a KV command and returns its namespace IDs
+// along with the keys planned for each namespace
+func loadNamespacePlan(path, command string) (*util.Plan, []string, map[string][]string, error) {
+	plan, err := util.ReadPlan(path, command)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var namespaceIDs []string
+	plannedKeys := make(map[string][]string)
+	for _, ns := range plan.Namespaces {
+		if _, seen := plannedKeys[ns.ID]; !seen {
+			namespaceIDs = append(namespaceIDs, ns.ID)
+		}
+		plannedKeys[ns.ID] = append(plannedKeys[ns.ID], ns.Keys...)
+	}
+
+	util.Info("Loaded plan from %s covering %d KV namespaces", path, len(namespaceIDs))
+	return plan, namespaceIDs, plannedKeys, nil
+}
+
+// measureValueSizes reads the value of each key while holding sem to learn its
+// size in bytes. Keys that no longer exist or cannot be read are counted as
+// unmeasured rather than failing the deletion.
+func measureValueSizes(ctx context.Context, client *cloudflare.API, nsID string, keys []string, sem *util.Semaphore) (map[string]int64, int) {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	sizes := make(map[string]int64, len(keys))
+	unmeasured := 0
+	ctx = api.WithSemaphore(ctx, sem)
+
+	for _, key := range keys {
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		sem.Acquire()
+
+		go func(key string) {
+			defer wg.Done()
+
+			start := time.Now()
+			var value []byte
+			err := api.WithRetry(ctx, func(ctx context.Context) error {
+				var err error
+				value, err = api.ReadKV(ctx, client, nsID, key)
+				return err
+			})
+			sem.Release(start, err)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				unmeasured++
+				return
+			}
+			sizes[key] = int64(len(value))
+		}(key)
+	}
+	wg.Wait()
+
+	return sizes, unmeasured
+}
+
+// splitExistingKeys checks which of the given keys exist in a namespace, looking
+// each one up while holding sem. Both returned slices keep the input order.
+func splitExistingKeys(ctx context.Context, client *cloudflare.API, nsID string, keys []string, sem *util.Semaphore) ([]string, []string, error) {
+	var wg sync.WaitGroup
+	var errMutex sync.Mutex
+	var firstErr error
+
+	exists := make([]bool, len(keys))
+	ctx = api.WithSemaphore(ctx, sem)
+
+	for i, key := range keys {
+		wg.Add(1)
+		sem.Acquire()
+
+		go func(i int, key string) {
+			defer wg.Done()
+
+			start := time.Now()
+			err := api.WithRetry(ctx, func(ctx context.Context) error {
+				_, err := api.ReadKVKey(ctx, client, nsID, key)
+				return err
+			})
+
+			var notFound *cloudflare.NotFoundError
+			if errors.As(err, &notFound) {
+				sem.Release(start, nil)
+			} else {
+				sem.Release(start, err)
+			}
+			switch {
+			case err == nil:
+				exists[i] = true
+			case errors.As(err, &notFound):
+				// Missing keys are reported by the caller, not treated as failures
+			default:
+				errMutex.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("error checking KV key %s in namespace %s: %w", key, nsID, err)
+				}
+				errMutex.Unlock()
+			}
+		}(i, key)
+	}
+
+	wg.Wait()
+	if firstErr != nil {
+		return nil, nil, firstErr
+	}
+
+	var existing, missing []string
+	for i, key := range keys {
+		if exists[i] {
+			existing = append(existing, key)
+		} else {
+			missing = append(missing, key)
+		}
+	}
+
+	return existing, missing, nil
+}
@@ -4,10 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"strings"
+	"sync"
 	"time"
 
 	"cfpurge/internal/api"
+	"cfpurge/internal/util"
 
 	"github.com/cloudflare/cloudflare-go"
 	"github.com/spf13/cobra"
@@ -18,21 +19,58 @@ func newListCmd() *cobra.Command {
 	var verbose bool
 	var filter string
 	var limit int
+	var all bool
 	var cursor string
+	var saveCursor string
+	var resumeCursor string
+	var withCounts bool
+	var withValues bool
+	var maxValueLen int
+	var allNamespaces bool
+	var parallelScan bool
+	var out output
 
 	cmd := &cobra.Command{
 		Use:   "list",
 		Short: "List KV namespaces or keys in a namespace",
 		Long: `List all KV namespaces in your Cloudflare account,
-or list keys in a specific namespace.`,
+or list keys in a specific namespace or across all namespaces.
+
+A namespace is normally listed one page after another, following a single
+cursor. --parallel-scan instead splits the keys by the character that follows
+--filter, with one shard per printable ASCII character and one per UTF-8 lead
+byte, and lists these shards concurrently, which is much faster for namespaces
+with millions of keys. The key equal to --filter and keys continuing with a
+control character are listed first. Keys are then not listed in order.`,
 		Example: `  # List all namespaces
   cfpurge kv list
   
+  # List namespaces as JSON with approximate key counts
+  cfpurge kv list --output=json --with-counts
+  
+  # List namespaces as YAML
+  cfpurge kv list --output=yaml
+  
   # List keys in a namespace
   cfpurge kv list --namespace=<namespace-id>
   
   # List keys with metadata and filtering
-  cfpurge kv list --namespace=<namespace-id> --verbose --filter=user- --limit=50`,
+  cfpurge kv list --namespace=<namespace-id> --verbose --filter=user- --limit=50
+  
+  # Follow pagination to list up to 5000 keys
+  cfpurge kv list --namespace=<namespace-id> --all --limit=5000
+  
+  # Page through a large namespace across sessions, one page per run
+  cfpurge kv list --namespace=<namespace-id> --resume-cursor=page.json --save-cursor=page.json
+  
+  # List every key of a very large namespace quickly, in no particular order
+  cfpurge kv list --namespace=<namespace-id> --parallel-scan --limit=0
+  
+  # Find which namespaces hold keys starting with user-123
+  cfpurge kv list --all-namespaces --filter=user-123
+  
+  # Show the values of a small set of keys inline
+  cfpurge kv list --namespace=<namespace-id> --filter=config- --values`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if err := api.ValidateAuth(); err != nil {
 				return err
@@ -42,81 +80,286 @@ or list keys in a specific namespace.`,
 				return err
 			}
 
-			client, err := api.GetClient()
+			if err := out.start(); err != nil {
+				return err
+			}
+
+			if limit < 0 {
+				return fmt.Errorf("--limit must not be negative")
+			}
+
+			if all && namespace == "" {
+				return fmt.Errorf("--all requires --namespace")
+			}
+
+			if (saveCursor != "" || resumeCursor != "") && namespace == "" {
+				return fmt.Errorf("--save-cursor and --resume-cursor require --namespace")
+			}
+
+			if resumeCursor != "" {
+				if cursor != "" {
+					return fmt.Errorf("--resume-cursor cannot be combined with --cursor")
+				}
+				saved, err := util.ReadCursor(resumeCursor, namespace, filter)
+				if err != nil {
+					return err
+				}
+				if saved != nil {
+					cursor = saved.Cursor
+				}
+			}
+
+			if parallelScan {
+				if namespace == "" {
+					return fmt.Errorf("--parallel-scan requires --namespace")
+				}
+				if cursor != "" || saveCursor != "" || resumeCursor != "" {
+					return fmt.Errorf("--parallel-scan cannot be combined with --cursor, --save-cursor or --resume-cursor")
+				}
+				// Shards are walked to the end, so there is no single cursor to resume from
+				all = true
+			}
+
+			if allNamespaces {
+				if namespace != "" {
+					return fmt.Errorf("--all-namespaces cannot be combined with --namespace")
+				}
+				if withValues || withCounts || cursor != "" {
+					return fmt.Errorf("--all-namespaces cannot be combined with --values, --with-counts or --cursor")
+				}
+			}
+
+			if withValues {
+				if namespace == "" {
+					return fmt.Errorf("--values requires --namespace")
+				}
+				// Guard against pulling every value of a large namespace by accident
+				if filter == "" && !cmd.Flags().Changed("limit") {
+					return fmt.Errorf("--values requires --filter or an explicit --limit")
+				}
+				util.Warning("--values fetches every listed value with a separate request; this is slow and costly for many keys")
+			}
+
+			client, err := api.GetClient(api.ReadAccess)
 			if err != nil {
 				return err
 			}
 
+			if allNamespaces {
+				return listKeysAllNamespaces(cmd.Context(), client, &out, verbose, filter, limit)
+			}
+
 			// If no namespace provided, list all namespaces
 			if namespace == "" {
-				return listNamespaces(client)
+				return listNamespaces(cmd.Context(), client, &out, withCounts)
 			}
 
 			// List keys in the namespace
-			return listKeys(client, namespace, verbose, filter, limit, cursor)
+			return listKeys(cmd.Context(), client, &out, namespace, verbose, filter, limit, all, parallelScan, cursor, saveCursor, withValues, maxValueLen)
 		},
 	}
 
 	cmd.Flags().StringVar(&namespace, "namespace", "", "KV namespace ID to list keys from")
 	cmd.Flags().BoolVar(&verbose, "verbose", false, "Display key metadata")
 	cmd.Flags().StringVar(&filter, "filter", "", "Filter keys by prefix")
-	cmd.Flags().IntVar(&limit, "limit", 1000, "Maximum number of keys to return")
+	cmd.Flags().IntVar(&limit, "limit", 1000, "Maximum number of keys to return in total, 0 for no limit (per namespace with --all-namespaces)")
+	cmd.Flags().BoolVar(&all, "all", false, "Follow pagination until --limit keys are listed or the namespace is exhausted")
 	cmd.Flags().StringVar(&cursor, "cursor", "", "Cursor for pagination")
+	cmd.Flags().StringVar(&saveCursor, "save-cursor", "", "Write the cursor of the next page to this file, removing it once the last page is listed")
+	cmd.Flags().StringVar(&resumeCursor, "resume-cursor", "", "Start from the cursor saved in this file by --save-cursor, or from the beginning if it does not exist")
+	cmd.Flags().BoolVar(&withValues, "values", false, "Fetch and show each key's value (requires --filter or --limit)")
+	cmd.Flags().IntVar(&maxValueLen, "max-value-len", 80, "Truncate values shown in the table to this many characters; json and yaml show them in full")
+	cmd.Flags().BoolVar(&allNamespaces, "all-namespaces", false, "List matching keys in every namespace, showing which namespace each belongs to")
+	cmd.Flags().BoolVar(&parallelScan, "parallel-scan", false, "List the keys of --namespace in concurrent shards by first character; faster for huge namespaces, but keys are not in order")
+	cmd.Flags().BoolVar(&withCounts, "with-counts", false, "Include an approximate key count for each namespace")
+	out.addFlags(cmd)
+
+	// --format predates the shared --output flag and is kept as an alias
+	cmd.Flags().StringVar(&out.format, "format", util.OutputTable, "Output format (table, json, yaml)")
+	cmd.Flags().MarkDeprecated("format", "use --output instead")
 
 	return cmd
 }
 
-func listNamespaces(client *cloudflare.API) error {
-	namespaces, _, err := client.ListWorkersKVNamespaces(context.Background(), api.GetAccountID(), cloudflare.ListWorkersKVNamespacesParams{})
+// namespaceConcurrency bounds how many namespaces are counted or listed at once
+const namespaceConcurrency = 5
+
+// parallelScanConcurrency bounds how many shards kv list --parallel-scan lists at once
+const parallelScanConcurrency = 10
+
+// valueFetchConcurrency bounds how many values kv list --values fetches at once
+const valueFetchConcurrency = 10
+
+// namespaceInfo is a namespace as shown by kv list, optionally with its key count
+type namespaceInfo struct {
+	Title      string `json:"title" yaml:"title"`
+	ID         string `json:"id" yaml:"id"`
+	KeyCount   *int   `json:"key_count,omitempty" yaml:"key_count,omitempty"`
+	CountError string `json:"count_error,omitempty" yaml:"count_error,omitempty"`
+}
+
+func listNamespaces(ctx context.Context, client *cloudflare.API, out *output, withCounts bool) error {
+	namespaces, err := listAllNamespaces(ctx, client)
 	if err != nil {
 		return fmt.Errorf("error listing KV namespaces: %w", err)
 	}
 
+	infos := make([]namespaceInfo, len(namespaces))
+	for i, ns := range namespaces {
+		infos[i] = namespaceInfo{Title: ns.Title, ID: ns.ID}
+	}
+
+	if withCounts {
+		countNamespaceKeys(ctx, client, infos)
+	}
+
+	if out.structured() {
+		return out.write(infos)
+	}
+
 	fmt.Println("\nAvailable KV namespaces:")
-	fmt.Printf("%-40s %-30s\n", "Title", "Namespace ID")
-	fmt.Println(strings.Repeat("-", 80))
-	for _, ns := range namespaces {
-		fmt.Printf("%-40s %-30s\n", ns.Title, ns.ID)
+	table := util.NewTable("Title", "Namespace ID")
+	if withCounts {
+		table = util.NewTable("Title", "Namespace ID", "Keys")
+	}
+	for _, info := range infos {
+		count := "error"
+		if info.KeyCount != nil {
+			count = fmt.Sprintf("~%d", *info.KeyCount)
+		}
+		table.AddRow(info.Title, info.ID, count)
 	}
+	table.Print()
 	return nil
 }
 
-func listKeys(client *cloudflare.API, namespace string, verbose bool, filter string, limit int, cursor string) error {
-	params := cloudflare.ListWorkersKVKeysParams{
-		NamespaceID: namespace,
-		Limit:       limit,
-	}
+// countNamespaceKeys fills in an approximate key count for each namespace using
+// the first page of results. A namespace that fails to count is reported but
+// does not stop the others from being counted.
+func countNamespaceKeys(ctx context.Context, client *cloudflare.API, infos []namespaceInfo) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, namespaceConcurrency)
 
-	if filter != "" {
-		params.Prefix = filter
+	for i := range infos {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(info *namespaceInfo) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			var count int
+			err := api.WithRetry(ctx, func(ctx context.Context) error {
+				keys, _, err := api.ListKVKeys(ctx, client, info.ID, "", 0, "")
+				count = len(keys)
+				return err
+			})
+			if err != nil {
+				info.CountError = err.Error()
+				return
+			}
+			info.KeyCount = &count
+		}(&infos[i])
 	}
 
-	if cursor != "" {
-		params.Cursor = cursor
+	wg.Wait()
+}
+
+// kvKeyInfo is a key as shown by kv list
+type kvKeyInfo struct {
+	Name       string      `json:"name" yaml:"name"`
+	Expiration int         `json:"expiration,omitempty" yaml:"expiration,omitempty"`
+	Metadata   interface{} `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+	Value      interface{} `json:"value,omitempty" yaml:"value,omitempty"`
+	ValueError string      `json:"value_error,omitempty" yaml:"value_error,omitempty"`
+}
+
+// kvKeyList is the structured output of listing the keys in a namespace
+type kvKeyList struct {
+	Namespace string      `json:"namespace" yaml:"namespace"`
+	Title     string      `json:"title,omitempty" yaml:"title,omitempty"`
+	Error     string      `json:"error,omitempty" yaml:"error,omitempty"`
+	Keys      []kvKeyInfo `json:"keys" yaml:"keys"`
+	Cursor    string      `json:"cursor,omitempty" yaml:"cursor,omitempty"`
+	Count     int         `json:"count" yaml:"count"`
+}
+
+// kvListPageSize is the most keys the API returns in one page
+const kvListPageSize = 1000
+
+// listKeys lists up to limit keys in a namespace. Without all only one page is
+// fetched; with all, pagination is followed until limit keys are collected.
+// With saveCursor, the next page's cursor is written to that file, which is
+// removed once there are no more pages. With parallelScan, the keys are
+// listed in shards by the character after filter, concurrently.
+func listKeys(ctx context.Context, client *cloudflare.API, out *output, namespace string, verbose bool, filter string, limit int, all, parallelScan bool, cursor, saveCursor string, withValues bool, maxValueLen int) error {
+	fetchPrefix := func(prefix, cursor string, pageLimit int) ([]cloudflare.StorageKey, string, error) {
+		var page []cloudflare.StorageKey
+		var next string
+		err := api.WithRetry(ctx, func(ctx context.Context) error {
+			var err error
+			page, next, err = api.ListKVKeys(ctx, client, namespace, prefix, pageLimit, cursor)
+			return err
+		})
+		return page, next, err
 	}
 
-	keys, listResult, err := client.ListWorkersKVKeys(context.Background(), api.GetAccountID(), params)
+	var keys []cloudflare.StorageKey
+	var nextCursor string
+	var err error
+	if parallelScan {
+		keyName := func(key cloudflare.StorageKey) string { return key.Name }
+		keys, err = util.ScanPrefix(filter, limit, kvListPageSize, parallelScanConcurrency, keyName, fetchPrefix)
+	} else {
+		maxPages := 1
+		if all {
+			maxPages = 0
+		}
+		fetch := func(cursor string, pageLimit int) ([]cloudflare.StorageKey, string, error) {
+			return fetchPrefix(filter, cursor, pageLimit)
+		}
+		keys, nextCursor, err = util.Paginate(limit, kvListPageSize, maxPages, cursor, fetch)
+	}
 	if err != nil {
 		return fmt.Errorf("error listing KV keys: %w", err)
 	}
+	infos := make([]kvKeyInfo, len(keys))
+	for i, key := range keys {
+		infos[i] = kvKeyInfo{Name: key.Name, Expiration: key.Expiration, Metadata: key.Metadata}
+	}
+	if withValues {
+		fetchKeyValues(ctx, client, namespace, infos)
+	}
+
+	if saveCursor != "" {
+		if nextCursor != "" {
+			err = util.WriteCursor(saveCursor, util.SavedCursor{Namespace: namespace, Prefix: filter, Cursor: nextCursor})
+		} else {
+			err = util.ClearCursor(saveCursor)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if out.structured() {
+		return out.write(kvKeyList{Namespace: namespace, Keys: infos, Count: len(keys), Cursor: nextCursor})
+	}
 
 	fmt.Printf("\nKeys in namespace %s:\n", namespace)
-	if verbose {
-		fmt.Printf("%-40s %-20s %s\n", "Key", "Expiration", "Metadata")
-		fmt.Println(strings.Repeat("-", 80))
+	if withValues {
+		table := util.NewTable("Key", "Value")
+		table.MaxWidths = []int{0, maxValueLen}
+		for _, info := range infos {
+			table.AddRow(info.Name, displayValue(info))
+		}
+		table.Print()
+	} else if verbose {
+		table := util.NewTable("Key", "Expiration", "Metadata")
 		for _, key := range keys {
-			expiration := "Never"
-			if key.Expiration > 0 {
-				expTime := time.Unix(int64(key.Expiration), 0)
-				expiration = expTime.Format("2006-01-02 15:04:05")
-			}
-			metadataStr := "None"
-			if key.Metadata != nil {
-				metadataBytes, _ := json.MarshalIndent(key.Metadata, "", "  ")
-				metadataStr = string(metadataBytes)
-			}
-			fmt.Printf("%-40s %-20s %s\n", key.Name, expiration, metadataStr)
+			table.AddRow(key.Name, displayExpiration(key.Expiration), displayMetadata(key.Metadata))
 		}
+		table.Print()
 	} else {
 		for _, key := range keys {
 			fmt.Println(key.Name)
@@ -124,11 +367,157 @@ func listKeys(client *cloudflare.API, namespace string, verbose bool, filter str
 	}
 
 	// Show pagination information if cursor is available
-	if listResult.Cursor != "" && listResult.Cursor != "null" {
+	if nextCursor != "" && saveCursor != "" {
+		fmt.Printf("\nMore keys available. The cursor for the next page was saved to %s\n", saveCursor)
+	} else if nextCursor != "" {
 		fmt.Printf("\nMore keys available. Use this cursor for the next page:\n")
-		fmt.Printf("  --cursor=%s\n", listResult.Cursor)
+		fmt.Printf("  --cursor=%s\n", nextCursor)
+	}
+
+	if nextCursor == "" && saveCursor != "" {
+		fmt.Printf("\nReached the last page; removed %s\n", saveCursor)
 	}
 
-	fmt.Printf("\nShowing %d/%d keys\n", len(keys), listResult.Count)
+	fmt.Printf("\nShowing %d keys\n", len(keys))
 	return nil
 }
+
+// fetchKeyValues fills in the value of each key with bounded concurrency. JSON
+// values are decoded so that structured output nests them. A key that fails to
+// fetch is reported in its ValueError without stopping the others.
+func fetchKeyValues(ctx context.Context, client *cloudflare.API, namespace string, infos []kvKeyInfo) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, valueFetchConcurrency)
+
+	for i := range infos {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(info *kvKeyInfo) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			var value []byte
+			err := api.WithRetry(ctx, func(ctx context.Context) error {
+				var err error
+				value, err = api.ReadKV(ctx, client, namespace, info.Name)
+				return err
+			})
+			if err != nil {
+				info.ValueError = err.Error()
+				return
+			}
+
+			var jsonValue interface{}
+			if err := json.Unmarshal(value, &jsonValue); err == nil {
+				info.Value = jsonValue
+			} else {
+				info.Value = string(value)
+			}
+		}(&infos[i])
+	}
+
+	wg.Wait()
+}
+
+// listKeysAllNamespaces lists the keys matching filter in every namespace, up
+// to limit keys per namespace. A namespace that fails to list is reported
+// without stopping the others.
+func listKeysAllNamespaces(ctx context.Context, client *cloudflare.API, out *output, verbose bool, filter string, limit int) error {
+	namespaces, err := listAllNamespaces(ctx, client)
+	if err != nil {
+		return fmt.Errorf("error listing KV namespaces: %w", err)
+	}
+
+	lists := make([]kvKeyList, len(namespaces))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, namespaceConcurrency)
+
+	for i, ns := range namespaces {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(list *kvKeyList, ns cloudflare.WorkersKVNamespace) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			list.Namespace, list.Title = ns.ID, ns.Title
+			err := api.WithRetry(ctx, func(ctx context.Context) error {
+				page, next, err := api.ListKVKeys(ctx, client, ns.ID, filter, limit, "")
+				if err != nil {
+					return err
+				}
+				list.Keys = make([]kvKeyInfo, len(page))
+				for j, key := range page {
+					list.Keys[j] = kvKeyInfo{Name: key.Name, Expiration: key.Expiration, Metadata: key.Metadata}
+				}
+				list.Count, list.Cursor = len(page), next
+				return nil
+			})
+			if err != nil {
+				util.Error("Error listing keys in namespace %s: %v", ns.Title, err)
+				list.Error = err.Error()
+			}
+		}(&lists[i], ns)
+	}
+	wg.Wait()
+
+	if out.structured() {
+		return out.write(lists)
+	}
+
+	table := util.NewTable("Namespace", "Namespace ID", "Key")
+	if verbose {
+		table = util.NewTable("Namespace", "Namespace ID", "Key", "Expiration", "Metadata")
+	}
+
+	total, matched := 0, 0
+	for _, list := range lists {
+		if len(list.Keys) > 0 {
+			matched++
+		}
+		for _, key := range list.Keys {
+			table.AddRow(list.Title, list.Namespace, key.Name, displayExpiration(key.Expiration), displayMetadata(key.Metadata))
+			total++
+		}
+		if list.Cursor != "" {
+			util.Warning("Namespace %s has more than %d matching keys; use --namespace=%s --cursor to see the rest", list.Title, limit, list.Namespace)
+		}
+	}
+
+	fmt.Println("\nKeys across all KV namespaces:")
+	table.Print()
+	fmt.Printf("\nFound %d keys in %d of %d namespaces\n", total, matched, len(namespaces))
+	return nil
+}
+
+// displayExpiration renders a key's expiration as a local time
+func displayExpiration(expiration int) string {
+	if expiration <= 0 {
+		return "Never"
+	}
+	return time.Unix(int64(expiration), 0).Format("2006-01-02 15:04:05")
+}
+
+// displayMetadata renders a key's metadata as compact JSON
+func displayMetadata(metadata interface{}) string {
+	if metadata == nil {
+		return "None"
+	}
+	data, _ := json.Marshal(metadata)
+	return string(data)
+}
+
+// displayValue renders a fetched value as text for the table, which keeps it
+// on one line and truncates it to --max-value-len
+func displayValue(info kvKeyInfo) string {
+	if info.ValueError != "" {
+		return "error: " + info.ValueError
+	}
+
+	if str, ok := info.Value.(string); ok {
+		return str
+	}
+	data, _ := json.Marshal(info.Value)
+	return string(data)
+}
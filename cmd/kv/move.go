@@ -0,0 +1,198 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"cfpurge/internal/api"
+	"cfpurge/internal/util"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/spf13/cobra"
+)
+
+func newMoveCmd() *cobra.Command {
+	var (
+		source       string
+		dest         string
+		filter       string
+		tag          string
+		concurrency  = util.Concurrency{N: 10}
+		dryRun       bool
+		errorOnEmpty bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "move",
+		Short: "Move KV entries between namespaces",
+		Long: `Copy Workers KV entries matching a filter from one namespace to another,
+then delete them from the source. A source key is only deleted once its copy
+has been written successfully.`,
+		Example: `  # Move all keys with a prefix
+  cfpurge kv move --source=<namespace-id> --dest=<namespace-id> --filter=user-
+
+  # Move entries with matching cache tag
+  cfpurge kv move --source=<namespace-id> --dest=<namespace-id> --tag=product-123
+
+  # Preview what would be moved (dry run)
+  cfpurge kv move --source=<namespace-id> --dest=<namespace-id> --filter=user- --dry-run`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := api.ValidateAuth(); err != nil {
+				return err
+			}
+
+			if err := api.ValidateAccountID(); err != nil {
+				return err
+			}
+
+			if source == "" || dest == "" {
+				return fmt.Errorf("both source and destination namespace IDs are required")
+			}
+
+			if source == dest {
+				return fmt.Errorf("source and destination namespaces must be different")
+			}
+
+			if err := concurrency.Validate(); err != nil {
+				return err
+			}
+
+			client, err := api.GetClient(api.WriteAccess)
+			if err != nil {
+				return err
+			}
+
+			// Get all keys in the source namespace
+			keys, err := listAllKeys(cmd.Context(), client, source, filter)
+			if err != nil {
+				return fmt.Errorf("error listing KV keys in namespace %s: %w", source, err)
+			}
+
+			// Narrow down by cache tag if requested
+			var keysToMove []cloudflare.StorageKey
+			for _, key := range keys {
+				if tag != "" {
+					metadata, ok := key.Metadata.(map[string]interface{})
+					if !ok {
+						continue
+					}
+					cacheTagStr, ok := metadata["cache-tag"].(string)
+					if !ok || !strings.Contains(cacheTagStr, tag) {
+						continue
+					}
+				}
+				keysToMove = append(keysToMove, key)
+			}
+
+			if len(keysToMove) == 0 {
+				return util.NothingMatched(fmt.Sprintf("KV keys in namespace %s", source), errorOnEmpty)
+			}
+
+			util.Info("Found %d KV keys to move from namespace %s to %s", len(keysToMove), source, dest)
+
+			if dryRun {
+				fmt.Printf("Dry run mode - would move the following keys from namespace %s to %s:\n", source, dest)
+				for _, key := range keysToMove {
+					fmt.Printf("  %s\n", key.Name)
+				}
+				return nil
+			}
+
+			var wg sync.WaitGroup
+			var moveMutex sync.Mutex
+			successCount := 0
+			failureCount := 0
+
+			// Bound the number of keys being moved at once
+			sem := concurrency.Semaphore(api.GetRateLimit())
+			ctx := api.WithSemaphore(cmd.Context(), sem)
+
+			for i, key := range keysToMove {
+				if ctx.Err() != nil {
+					util.Warning("Interrupted; not moving the remaining %d keys", len(keysToMove)-i)
+					break
+				}
+				wg.Add(1)
+				sem.Acquire()
+
+				go func(key cloudflare.StorageKey) {
+					defer wg.Done()
+
+					start := time.Now()
+					err := moveKey(ctx, client, source, dest, key)
+					sem.Release(start, err)
+					util.Audit(source+" -> "+dest, []string{key.Name}, err)
+
+					moveMutex.Lock()
+					if err != nil {
+						util.Error("Error moving KV key %s: %v", key.Name, err)
+						failureCount++
+					} else {
+						util.Success("Successfully moved KV key: %s", key.Name)
+						successCount++
+					}
+					moveMutex.Unlock()
+				}(key)
+			}
+
+			// Wait for all KV moves to complete
+			wg.Wait()
+
+			util.PrettyPrintResults(successCount, failureCount)
+			return util.Interrupted(cmd.Context())
+		},
+	}
+
+	cmd.Flags().StringVar(&source, "source", "", "Source KV namespace ID")
+	cmd.Flags().StringVar(&dest, "dest", "", "Destination KV namespace ID")
+	cmd.Flags().StringVar(&filter, "filter", "", "Only move keys with this prefix")
+	cmd.Flags().StringVar(&tag, "tag", "", "Only move KV entries with matching cache-tag metadata")
+	cmd.Flags().Var(&concurrency, "concurrency", "Maximum number of keys to move concurrently, or auto to adapt to --rate-limit and rate limiting")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what would be moved without actually moving")
+	cmd.Flags().BoolVar(&errorOnEmpty, "error-on-empty", false, fmt.Sprintf("Exit with status %d when no keys match", util.ExitNothingMatched))
+
+	cmd.MarkFlagRequired("source")
+	cmd.MarkFlagRequired("dest")
+
+	return cmd
+}
+
+// moveKey copies a single entry to the destination namespace and only deletes
+// it from the source once the copy has been written
+func moveKey(ctx context.Context, client *cloudflare.API, source, dest string, key cloudflare.StorageKey) error {
+	var value []byte
+	err := api.WithRetry(ctx, func(ctx context.Context) error {
+		var err error
+		value, err = api.ReadKV(ctx, client, source, key.Name)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error reading value: %w", err)
+	}
+
+	entry := api.KVWrite{
+		Key:        key.Name,
+		Value:      value,
+		Metadata:   key.Metadata,
+		Expiration: int64(key.Expiration),
+	}
+
+	err = api.WithRetry(ctx, func(ctx context.Context) error {
+		return api.WriteKV(ctx, client, dest, entry)
+	})
+	if err != nil {
+		return fmt.Errorf("error copying to destination, source left intact: %w", err)
+	}
+
+	err = api.WithRetry(ctx, func(ctx context.Context) error {
+		return api.DeleteKV(ctx, client, source, key.Name)
+	})
+	if err != nil {
+		return fmt.Errorf("copied to destination but error deleting from source: %w", err)
+	}
+
+	return nil
+}
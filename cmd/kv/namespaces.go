@@ -0,0 +1,137 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"cfpurge/internal/api"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// namespaceCacheTTL is how long an on-disk namespace listing stays valid
+const namespaceCacheTTL = 5 * time.Minute
+
+// namespaceCache is the on-disk form of a namespace listing for one account
+type namespaceCache struct {
+	AccountID  string                          `json:"account_id"`
+	FetchedAt  time.Time                       `json:"fetched_at"`
+	Namespaces []cloudflare.WorkersKVNamespace `json:"namespaces"`
+}
+
+// namespaceResolver maps namespace titles to IDs. The namespace list is fetched
+// at most once per command, and optionally shared between invocations through
+// a short-lived cache file keyed by account ID.
+type namespaceResolver struct {
+	client     *cloudflare.API
+	diskCache  bool
+	refresh    bool
+	namespaces []cloudflare.WorkersKVNamespace
+}
+
+// resolve returns the ID of the namespace with the given title
+func (r *namespaceResolver) resolve(ctx context.Context, title string) (string, error) {
+	namespaces, err := r.list(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	for _, ns := range namespaces {
+		if ns.Title == title {
+			return ns.ID, nil
+		}
+	}
+
+	hint := ""
+	if r.diskCache && !r.refresh {
+		hint = "; if it was created recently, retry with --refresh"
+	}
+	return "", fmt.Errorf("no KV namespace titled '%s'%s", title, hint)
+}
+
+// list returns the account's namespaces from memory, the cache file or the API
+func (r *namespaceResolver) list(ctx context.Context) ([]cloudflare.WorkersKVNamespace, error) {
+	if r.namespaces != nil {
+		return r.namespaces, nil
+	}
+
+	if r.diskCache && !r.refresh {
+		if cached, ok := readNamespaceCache(); ok {
+			r.namespaces = cached
+			return cached, nil
+		}
+	}
+
+	namespaces, err := listAllNamespaces(ctx, r.client)
+	if err != nil {
+		return nil, fmt.Errorf("error listing KV namespaces: %w", err)
+	}
+	r.namespaces = namespaces
+
+	if r.diskCache {
+		// A failed cache write only costs a re-list next time
+		_ = writeNamespaceCache(namespaces)
+	}
+
+	return namespaces, nil
+}
+
+// namespaceCachePath returns the cache file for the configured account
+func namespaceCachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "cfpurge", fmt.Sprintf("namespaces-%s.json", api.GetAccountID())), nil
+}
+
+// readNamespaceCache loads the cached namespace listing if it is still fresh
+func readNamespaceCache() ([]cloudflare.WorkersKVNamespace, bool) {
+	path, err := namespaceCachePath()
+	if err != nil {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+
+	var cache namespaceCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, false
+	}
+
+	if cache.AccountID != api.GetAccountID() || time.Since(cache.FetchedAt) > namespaceCacheTTL {
+		return nil, false
+	}
+
+	return cache.Namespaces, true
+}
+
+// writeNamespaceCache saves a namespace listing for later invocations
+func writeNamespaceCache(namespaces []cloudflare.WorkersKVNamespace) error {
+	path, err := namespaceCachePath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(namespaceCache{
+		AccountID:  api.GetAccountID(),
+		FetchedAt:  time.Now().UTC(),
+		Namespaces: namespaces,
+	})
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0o600)
+}
@@ -0,0 +1,249 @@
+package kv
+
+import (
+	"os"
+	"sort"
+	"time"
+
+	"cfpurge/internal/util"
+
+	"github.com/spf13/cobra"
+)
+
+// output carries the --quiet and --output settings shared by the KV commands,
+// and --report for those that delete keys
+type output struct {
+	quiet  bool
+	format string
+
+	report      string
+	reportTitle string
+	started     time.Time
+}
+
+// kvKeyResult is the structured outcome of an operation on a single key
+type kvKeyResult struct {
+	Namespace string `json:"namespace" yaml:"namespace"`
+	Key       string `json:"key,omitempty" yaml:"key,omitempty"`
+	Error     string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// kvResult collects the outcome of the KV commands that delete keys. Key
+// outcomes are recorded through the embedded util.Results, which is safe to
+// use from the deletion goroutines.
+type kvResult struct {
+	*util.Results
+	dryRun    bool
+	planned   []kvKeyResult
+	cacheTags []string
+
+	// sizes holds the value sizes measured with --measure-size, by namespace
+	// and key; nil when sizes were not measured
+	sizes      map[kvKeyID]int64
+	unmeasured int
+}
+
+// kvKeyID identifies a key within a namespace
+type kvKeyID struct {
+	namespace string
+	key       string
+}
+
+// kvLargestKeys is how many of the largest measured keys are reported
+const kvLargestKeys = 5
+
+// kvSizeSummary is the storage taken by the matched keys, measured with
+// --measure-size. Freed only counts keys whose deletion succeeded.
+type kvSizeSummary struct {
+	MatchedBytes int64       `json:"matched_bytes" yaml:"matched_bytes"`
+	FreedBytes   int64       `json:"freed_bytes" yaml:"freed_bytes"`
+	Unmeasured   int         `json:"unmeasured,omitempty" yaml:"unmeasured,omitempty"`
+	Largest      []kvKeySize `json:"largest" yaml:"largest"`
+}
+
+// kvKeySize is the measured value size of one key
+type kvKeySize struct {
+	Namespace string `json:"namespace" yaml:"namespace"`
+	Key       string `json:"key" yaml:"key"`
+	Bytes     int64  `json:"bytes" yaml:"bytes"`
+}
+
+// kvResultDocument is the structured output of the KV commands that delete keys
+type kvResultDocument struct {
+	util.ResultSummary `yaml:",inline"`
+	DryRun             bool           `json:"dry_run,omitempty" yaml:"dry_run,omitempty"`
+	Keys               []kvKeyResult  `json:"keys" yaml:"keys"`
+	CacheTags          []string       `json:"cache_tags,omitempty" yaml:"cache_tags,omitempty"`
+	Size               *kvSizeSummary `json:"size,omitempty" yaml:"size,omitempty"`
+}
+
+// newKVResult returns an empty result for a real or dry run
+func newKVResult(dryRun bool) *kvResult {
+	return &kvResult{Results: util.NewResults(), dryRun: dryRun}
+}
+
+// addFlags registers --quiet and --output on a command
+func (o *output) addFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&o.quiet, "quiet", false, "Suppress per-item success messages")
+	cmd.Flags().StringVar(&o.format, "output", util.OutputTable, "Output format (table, json, yaml)")
+}
+
+// addReportFlag registers --report on a command whose summary can be written
+// as an HTML report with the given title
+func (o *output) addReportFlag(cmd *cobra.Command, title string) {
+	o.reportTitle = title
+	cmd.Flags().StringVar(&o.report, "report", "", "Also write the outcome as a self-contained HTML report to this file")
+}
+
+// start validates the output format. With structured output, human-readable
+// progress messages move to stderr so that stdout holds only the result.
+func (o *output) start() error {
+	if err := util.ValidateOutputFormat(o.format); err != nil {
+		return err
+	}
+	o.started = time.Now()
+	util.SetOutputFor(o.format)
+	return nil
+}
+
+// structured reports whether a JSON or YAML result is written instead of a table
+func (o *output) structured() bool {
+	return util.IsStructuredOutput(o.format)
+}
+
+// success prints a per-item success message unless --quiet was given
+func (o *output) success(message string, args ...interface{}) {
+	if !o.quiet {
+		util.Success(message, args...)
+	}
+}
+
+// write prints the structured result to stdout
+func (o *output) write(data interface{}) error {
+	return util.WriteOutput(os.Stdout, o.format, data)
+}
+
+// summary ends a run with either the structured result or the usual summary
+// line, and writes the --report if one was requested
+func (o *output) summary(result *kvResult) error {
+	if err := o.writeReport(result); err != nil {
+		return err
+	}
+
+	if o.structured() {
+		return o.write(result.document())
+	}
+	result.Print()
+	result.printSize()
+	return nil
+}
+
+// writeReport writes the result as an HTML report if --report was given
+func (o *output) writeReport(result *kvResult) error {
+	if o.report == "" {
+		return nil
+	}
+	if err := util.WriteHTMLReport(o.report, result.report(o.reportTitle, o.started)); err != nil {
+		return err
+	}
+	util.Info("Wrote report to %s", o.report)
+	return nil
+}
+
+// plan lists a key that a dry run would delete
+func (r *kvResult) plan(namespace, key string) {
+	r.planned = append(r.planned, kvKeyResult{Namespace: namespace, Key: key})
+}
+
+// document converts the result into its structured form
+func (r *kvResult) document() kvResultDocument {
+	doc := kvResultDocument{
+		ResultSummary: r.Summary(),
+		DryRun:        r.dryRun,
+		Keys:          r.planned,
+		CacheTags:     r.cacheTags,
+	}
+	for _, item := range r.Items() {
+		doc.Keys = append(doc.Keys, kvKeyResult{Namespace: item.Scope, Key: item.Item, Error: item.Error})
+	}
+	doc.Size = r.sizeSummary()
+	return doc
+}
+
+// report converts the result into an HTML report, listing the keys a dry run
+// would delete as its items
+func (r *kvResult) report(title string, started time.Time) util.Report {
+	report := util.NewReport(title, started, r.Results)
+	report.DryRun = r.dryRun
+	for _, key := range r.planned {
+		report.Items = append(report.Items, util.ItemResult{Scope: key.Namespace, Item: key.Key})
+	}
+	return report
+}
+
+// measure records the value sizes measured in a namespace, along with how
+// many keys could not be measured
+func (r *kvResult) measure(namespace string, sizes map[string]int64, unmeasured int) {
+	if r.sizes == nil {
+		r.sizes = make(map[kvKeyID]int64)
+	}
+	for key, size := range sizes {
+		r.sizes[kvKeyID{namespace, key}] = size
+	}
+	r.unmeasured += unmeasured
+}
+
+// sizeSummary totals the measured sizes, or returns nil when sizes were not
+// measured
+func (r *kvResult) sizeSummary() *kvSizeSummary {
+	if r.sizes == nil {
+		return nil
+	}
+
+	summary := &kvSizeSummary{Unmeasured: r.unmeasured, Largest: []kvKeySize{}}
+	for id, size := range r.sizes {
+		summary.MatchedBytes += size
+		summary.Largest = append(summary.Largest, kvKeySize{Namespace: id.namespace, Key: id.key, Bytes: size})
+	}
+	for _, item := range r.Items() {
+		if item.Error == "" {
+			summary.FreedBytes += r.sizes[kvKeyID{item.Scope, item.Item}]
+		}
+	}
+
+	sort.Slice(summary.Largest, func(i, j int) bool {
+		if summary.Largest[i].Bytes != summary.Largest[j].Bytes {
+			return summary.Largest[i].Bytes > summary.Largest[j].Bytes
+		}
+		return summary.Largest[i].Key < summary.Largest[j].Key
+	})
+	if len(summary.Largest) > kvLargestKeys {
+		summary.Largest = summary.Largest[:kvLargestKeys]
+	}
+	return summary
+}
+
+// printSize prints the storage freed, or that a dry run would free, along
+// with the largest keys
+func (r *kvResult) printSize() {
+	summary := r.sizeSummary()
+	if summary == nil {
+		return
+	}
+
+	if r.dryRun {
+		util.Info("Deleting would free approximately %s", util.FormatBytes(summary.MatchedBytes))
+	} else {
+		util.Info("Freed approximately %s of %s matched", util.FormatBytes(summary.FreedBytes), util.FormatBytes(summary.MatchedBytes))
+	}
+	if summary.Unmeasured > 0 {
+		util.Warning("%d keys could not be measured and are not counted", summary.Unmeasured)
+	}
+
+	if len(summary.Largest) > 0 {
+		util.Printf("Largest keys:\n")
+		for _, size := range summary.Largest {
+			util.Printf("  %10s  %s (namespace %s)\n", util.FormatBytes(size.Bytes), size.Key, size.Namespace)
+		}
+	}
+}
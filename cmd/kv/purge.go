@@ -11,14 +11,28 @@ import (
 
 	"github.com/cloudflare/cloudflare-go"
 	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func newPurgeCmd() *cobra.Command {
 	var (
 		deleteByTag   string
+		metaFilter    string
 		namespace     string
 		allNamespaces bool
 		dryRun        bool
+		failFast      bool
+		preserveOrder bool
+		dryRunOutput  string
+		fromPlan      string
+		retryFailed   string
+		failuresOut   string
+		errorOnEmpty  bool
+		sample        int
+		tagList       bool
+		purgeZones    string
+		batchSize     int
+		out           output
 	)
 
 	cmd := &cobra.Command{
@@ -28,11 +42,21 @@ func newPurgeCmd() *cobra.Command {
 		Example: `  # Delete entries and purge cache
   cfpurge kv purge --namespace=<namespace-id> --tag=product-123
   
+  # Select entries by any metadata field; their cache tags are still purged
+  cfpurge kv purge --namespace=<namespace-id> --metadata-filter="owner.team~=web"
+  
   # Across multiple namespaces
   cfpurge kv purge --namespace=<namespace-id1>,<namespace-id2> --tag=product-123
   
   # Preview what would be deleted (dry run)
-  cfpurge kv purge --all-namespaces --tag=product-123 --dry-run`,
+  cfpurge kv purge --all-namespaces --tag=product-123 --dry-run
+  
+  # Only purge the cache tags from the zones that use them
+  cfpurge kv purge --namespace=<namespace-id> --tag=product-123 --purge-zones=example.com,shop.example.com
+  
+  # Record failed keys and cache tags, then retry only those
+  cfpurge kv purge --all-namespaces --tag=product-123 --failures-output=failed.json
+  cfpurge kv purge --retry-failed=failed.json`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if err := api.ValidateAuth(); err != nil {
 				return err
@@ -42,25 +66,77 @@ func newPurgeCmd() *cobra.Command {
 				return err
 			}
 
-			if namespace == "" && !allNamespaces {
+			if err := out.start(); err != nil {
+				return err
+			}
+
+			if retryFailed != "" {
+				if fromPlan != "" {
+					return fmt.Errorf("--retry-failed cannot be combined with --from-plan")
+				}
+				// A failures file is a plan holding only the keys and tags that failed last time
+				fromPlan = retryFailed
+			}
+
+			if fromPlan != "" && dryRun {
+				return fmt.Errorf("--from-plan cannot be combined with --dry-run")
+			}
+
+			if fromPlan == "" && namespace == "" && !allNamespaces {
 				return fmt.Errorf("either namespace ID or --all-namespaces flag is required")
 			}
 
-			if deleteByTag == "" {
-				return fmt.Errorf("tag is required for deletion")
+			if fromPlan == "" && deleteByTag == "" && metaFilter == "" {
+				return fmt.Errorf("either tag or metadata filter is required for deletion")
+			}
+
+			var selector *keySelector
+			if fromPlan == "" {
+				var err error
+				selector, err = newKeySelector(deleteByTag, metaFilter, tagList)
+				if err != nil {
+					return err
+				}
+			}
+
+			// Writing a plan file implies a dry run
+			if dryRunOutput != "" {
+				dryRun = true
+			}
+
+			if err := util.ValidateBatchSize(batchSize, api.PurgeBatchSize); err != nil {
+				return err
+			}
+
+			if sample < 0 {
+				return fmt.Errorf("--sample must not be negative")
+			}
+			if sample > 0 && !dryRun {
+				return fmt.Errorf("--sample requires --dry-run")
 			}
 
-			client, err := api.GetClient()
+			client, err := api.GetClient(api.WriteAccess)
 			if err != nil {
 				return err
 			}
 
+			ctx, cancel := context.WithCancel(cmd.Context())
+			defer cancel()
+
 			// Get list of namespaces to process
 			var namespaceIDs []string
+			var sourcePlan *util.Plan
+			var plannedKeys map[string][]string
 
-			if allNamespaces {
+			if fromPlan != "" {
+				// Execute exactly what was reviewed, without re-discovering keys
+				sourcePlan, namespaceIDs, plannedKeys, err = loadNamespacePlan(fromPlan, "kv purge")
+				if err != nil {
+					return err
+				}
+			} else if allNamespaces {
 				// Get all namespaces
-				namespaces, _, err := client.ListWorkersKVNamespaces(context.Background(), api.GetAccountID(), cloudflare.ListWorkersKVNamespacesParams{})
+				namespaces, err := listAllNamespaces(ctx, client)
 				if err != nil {
 					return fmt.Errorf("error listing KV namespaces: %w", err)
 				}
@@ -78,105 +154,178 @@ func newPurgeCmd() *cobra.Command {
 				namespaceIDs = util.SplitCommaList(namespace)
 			}
 
-			totalSuccessCount := 0
-			totalFailureCount := 0
+			result := newKVResult(dryRun)
+			matched := 0
 			var allCacheTags []string
+			plan := util.NewPlan("kv purge")
 
-			// Process each namespace
-			for _, nsID := range namespaceIDs {
-				fmt.Printf("\nProcessing namespace: %s\n", nsID)
+			// Cache tags of keys that failed to delete, or whose purge failed,
+			// are recorded with the failed keys so that a retry purges them
+			var failedTags []string
+			recordFailures := func() error {
+				return writeFailuresIfRequested(failuresOut, "kv purge", result, failedTags)
+			}
 
-				// Get all keys in the namespace
-				keys, _, err := client.ListWorkersKVKeys(context.Background(), api.GetAccountID(), cloudflare.ListWorkersKVKeysParams{
-					NamespaceID: nsID,
-				})
-				if err != nil {
-					util.Error("Error listing KV keys in namespace %s: %v", nsID, err)
-					totalFailureCount++
-					continue
+			// Process each namespace
+			for i, nsID := range namespaceIDs {
+				if cmd.Context().Err() != nil {
+					util.Warning("Interrupted; skipping the remaining %d namespaces", len(namespaceIDs)-i)
+					break
 				}
 
-				// Find keys with matching cache tags
+				util.Printf("\nProcessing namespace: %s\n", nsID)
+
+				// Find keys with matching cache tags, or take them from the plan
 				var keysToDelete []string
 				var cacheTags []string
 
-				for _, key := range keys {
-					if key.Metadata != nil {
-						// Use type assertion to access the metadata map
-						if metadata, ok := key.Metadata.(map[string]interface{}); ok {
-							if cacheTag, exists := metadata["cache-tag"]; exists {
-								// Check if the cache tag contains our search tag
-								if cacheTagStr, ok := cacheTag.(string); ok && strings.Contains(cacheTagStr, deleteByTag) {
-									keysToDelete = append(keysToDelete, key.Name)
-									cacheTags = append(cacheTags, cacheTagStr)
-								}
+				if fromPlan != "" {
+					keysToDelete = plannedKeys[nsID]
+				} else {
+					keysToDelete, cacheTags, err = findKeys(ctx, client, nsID, selector)
+					if err != nil {
+						util.Error("Error listing KV keys in namespace %s: %v", nsID, err)
+						result.Record(nsID, "", err)
+						if failFast {
+							if err := recordFailures(); err != nil {
+								return err
+							}
+							if err := out.summary(result); err != nil {
+								return err
 							}
+							return fmt.Errorf("aborting due to --fail-fast: error listing KV keys in namespace %s: %w", nsID, err)
 						}
+						continue
 					}
-				}
 
-				if len(keysToDelete) == 0 {
-					util.Info("No KV keys found with cache-tag containing '%s' in namespace %s", deleteByTag, nsID)
-					continue
+					if len(keysToDelete) == 0 {
+						util.Info("No KV keys found %s in namespace %s", selector.description, nsID)
+						continue
+					}
+
+					util.Info("Found %d KV keys %s in namespace %s", len(keysToDelete), selector.description, nsID)
 				}
 
-				util.Info("Found %d KV keys with matching cache tag '%s' in namespace %s", len(keysToDelete), deleteByTag, nsID)
+				matched += len(keysToDelete)
 
 				if dryRun {
-					fmt.Printf("Dry run mode - would delete the following keys from namespace %s:\n", nsID)
-					for i, key := range keysToDelete {
-						fmt.Printf("  %s (cache-tag: %s)\n", key, cacheTags[i])
+					printDryRunKeys(nsID, keysToDelete, cacheTags, sample)
+					for _, key := range keysToDelete {
+						result.plan(nsID, key)
 					}
+					plan.Namespaces = append(plan.Namespaces, util.PlanNamespace{ID: nsID, Keys: keysToDelete})
+					plan.CacheTags = append(plan.CacheTags, purgeTagsFor(cacheTags, tagList)...)
 					continue
 				}
 
 				// Delete the KV entries
+				nsCtx, nsSpan := startNamespaceSpan(ctx, "kv_purge", nsID, len(keysToDelete))
 				var wg sync.WaitGroup
-				var deleteMutex sync.Mutex
-				successCount := 0
-				failureCount := 0
-
-				// Process in batches of 30 for better performance
-				batchSize := 30
-				for i := 0; i < len(keysToDelete); i += batchSize {
-					end := i + batchSize
-					if end > len(keysToDelete) {
-						end = len(keysToDelete)
-					}
+				var failMutex sync.Mutex
+				var firstErr error
+				nsResult := util.NewResults()
+
+				// With --preserve-order, results are buffered per key and printed once all batches finish
+				keyResults := make([]keyResult, len(keysToDelete))
 
-					batch := keysToDelete[i:end]
+				// Process in batches for better performance
+				for i, batch := range util.Chunk(keysToDelete, kvKeyBatchSize) {
 					wg.Add(1)
 
-					go func(keys []string, nsID string) {
+					go func(start int, keys []string, nsID string) {
 						defer wg.Done()
 
-						for _, key := range keys {
-							params := cloudflare.DeleteWorkersKVEntryParams{
-								NamespaceID: nsID,
-								Key:         key,
+						for j, key := range keys {
+							// Stop picking up new keys after a fail-fast abort or Ctrl-C
+							if nsCtx.Err() != nil {
+								return
 							}
-							err := client.DeleteWorkersKVEntry(context.Background(), api.GetAccountID(), params)
 
-							deleteMutex.Lock()
+							err := api.WithRetry(nsCtx, func(ctx context.Context) error {
+								return api.DeleteKV(ctx, client, nsID, key)
+							})
+
+							keyResults[start+j] = keyResult{attempted: true, err: err}
+							nsResult.Record(nsID, key, err)
+							util.Audit(nsID, []string{key}, err)
 							if err != nil {
-								util.Error("Error deleting KV key %s in namespace %s: %v", key, nsID, err)
-								failureCount++
-							} else {
-								util.Success("Successfully deleted KV key: %s from namespace %s", key, nsID)
-								successCount++
+								if !preserveOrder {
+									util.Error("Error deleting KV key %s in namespace %s: %v", key, nsID, err)
+								}
+								if failFast {
+									failMutex.Lock()
+									if firstErr == nil {
+										firstErr = fmt.Errorf("error deleting KV key %s in namespace %s: %w", key, nsID, err)
+										cancel()
+									}
+									failMutex.Unlock()
+								}
+							} else if !preserveOrder {
+								out.success("Successfully deleted KV key: %s from namespace %s", key, nsID)
 							}
-							deleteMutex.Unlock()
 						}
-					}(batch, nsID)
+					}(i*kvKeyBatchSize, batch, nsID)
 				}
 
 				// Wait for all KV deletions to complete
 				wg.Wait()
+				api.EndSpan(nsSpan, nsResult.Err())
+				result.Merge(nsResult)
 
-				fmt.Printf("Summary for namespace %s: %d successful, %d failed\n", nsID, successCount, failureCount)
-				totalSuccessCount += successCount
-				totalFailureCount += failureCount
-				allCacheTags = append(allCacheTags, cacheTags...)
+				for i, keyResult := range keyResults {
+					if keyResult.err != nil && i < len(cacheTags) {
+						failedTags = append(failedTags, purgeTagsFor(cacheTags[i:i+1], tagList)...)
+					}
+				}
+
+				if preserveOrder {
+					for i, result := range keyResults {
+						if !result.attempted {
+							continue
+						}
+						if result.err != nil {
+							util.Error("Error deleting KV key %s in namespace %s: %v", keysToDelete[i], nsID, result.err)
+						} else {
+							out.success("Successfully deleted KV key: %s from namespace %s", keysToDelete[i], nsID)
+						}
+					}
+				}
+
+				nsSummary := nsResult.Summary()
+				util.Printf("Summary for namespace %s: %d successful, %d failed\n", nsID, nsSummary.Successful, nsSummary.Failed)
+
+				if firstErr != nil {
+					if err := recordFailures(); err != nil {
+						return err
+					}
+					if err := out.summary(result); err != nil {
+						return err
+					}
+					return fmt.Errorf("aborting due to --fail-fast: %w", firstErr)
+				}
+				allCacheTags = append(allCacheTags, purgeTagsFor(cacheTags, tagList)...)
+			}
+
+			// A plan carries the cache tags recorded when it was generated
+			if sourcePlan != nil {
+				allCacheTags = sourcePlan.CacheTags
+			}
+
+			if matched == 0 && len(allCacheTags) == 0 && result.Summary().Failed == 0 && cmd.Context().Err() == nil {
+				if out.structured() {
+					if err := out.write(result.document()); err != nil {
+						return err
+					}
+				}
+				return util.NothingMatched("KV keys", errorOnEmpty)
+			}
+
+			// The deleted keys' cache tags are kept as failures so that a
+			// --retry-failed run purges them
+			if cmd.Context().Err() != nil && len(allCacheTags) > 0 && !dryRun {
+				util.Warning("Interrupted; the cache was not purged for %d cache tags of deleted keys", len(allCacheTags))
+				failedTags = append(failedTags, allCacheTags...)
+				allCacheTags = nil
 			}
 
 			// Purge the cache with matching cache tags
@@ -184,7 +333,7 @@ func newPurgeCmd() *cobra.Command {
 				util.Header("Purging Cloudflare cache with matching cache tags")
 
 				// Get all zones to purge from
-				zones, err := client.ListZones(context.Background())
+				zones, err := tagPurgeZones(ctx, util.SplitCommaList(purgeZones))
 				if err != nil {
 					util.Error("Error getting zones for cache purge: %v", err)
 				} else {
@@ -198,49 +347,143 @@ func newPurgeCmd() *cobra.Command {
 
 					util.Info("Found %d unique cache tags to purge", len(tagsList))
 
-					// Purge cache in batches of 30 tags per request
-					purgeSuccessCount := 0
-					purgeFailureCount := 0
-
-					for i := 0; i < len(tagsList); i += 30 {
-						end := i + 30
-						if end > len(tagsList) {
-							end = len(tagsList)
-						}
+					// Skip malformed tags individually rather than letting a whole batch fail
+					tagsList, invalidTags := util.SplitValidCacheTags(tagsList)
+					util.ReportInvalidCacheTags(invalidTags)
+					result.cacheTags = tagsList
 
-						batchTags := tagsList[i:end]
+					// Purge cache in batches of --batch-size tags per request
+					purgeResult := util.NewResults()
 
+					for _, batchTags := range util.Chunk(tagsList, batchSize) {
 						for _, zone := range zones {
 							purgeReq := cloudflare.PurgeCacheRequest{
 								Tags: batchTags,
 							}
-							_, err := client.PurgeCache(context.Background(), zone.ID, purgeReq)
-
+							zoneCtx, span := api.StartSpan(ctx, "purge zone",
+								attribute.String("cloudflare.zone.id", zone.ID),
+								attribute.String("cloudflare.zone.name", zone.Name),
+								attribute.String("cfpurge.operation", "purge_tags"),
+								attribute.Int("cfpurge.count", len(batchTags)),
+							)
+							err := api.WithRetry(zoneCtx, func(ctx context.Context) error {
+								_, err := client.PurgeCache(ctx, zone.ID, purgeReq)
+								return err
+							})
+							api.EndSpan(span, err)
+
+							purgeResult.Record(zone.Name, "", err)
+							util.Audit(zone.Name, cacheTagTargets(batchTags), err)
 							if err != nil {
 								util.Error("Error purging cache for zone %s:%v", zone.Name, err)
-								purgeFailureCount++
+								failedTags = append(failedTags, batchTags...)
+								if failFast {
+									if err := recordFailures(); err != nil {
+										return err
+									}
+									purgeResult.Print()
+									if out.structured() {
+										if err := out.write(result.document()); err != nil {
+											return err
+										}
+									}
+									return fmt.Errorf("aborting due to --fail-fast: error purging cache for zone %s: %w", zone.Name, err)
+								}
 							} else {
-								util.Success("Successfully purged cache tags from zone %s", zone.Name)
-								purgeSuccessCount++
+								out.success("Successfully purged cache tags from zone %s", zone.Name)
 							}
 						}
 					}
 
-					util.PrettyPrintResults(purgeSuccessCount, purgeFailureCount)
+					purgeResult.Print()
 				}
 			}
 
-			fmt.Printf("\nOverall KV deletion summary: %d successful, %d failed\n", totalSuccessCount, totalFailureCount)
-			return nil
+			if dryRun {
+				plan.CacheTags = util.FilterDuplicates(plan.CacheTags)
+				if err := writePlanIfRequested(dryRunOutput, plan); err != nil {
+					return err
+				}
+				if err := out.writeReport(result); err != nil {
+					return err
+				}
+				if out.structured() {
+					result.cacheTags = plan.CacheTags
+					return out.write(result.document())
+				}
+				return nil
+			}
+
+			if err := recordFailures(); err != nil {
+				return err
+			}
+			if err := out.writeReport(result); err != nil {
+				return err
+			}
+			if out.structured() {
+				if err := out.write(result.document()); err != nil {
+					return err
+				}
+				return util.Interrupted(cmd.Context())
+			}
+			summary := result.Summary()
+			util.Printf("\nOverall KV deletion summary: %d successful, %d failed\n", summary.Successful, summary.Failed)
+			return util.Interrupted(cmd.Context())
 		},
 	}
 
 	cmd.Flags().StringVar(&deleteByTag, "tag", "", "Delete KV entries with matching cache-tag metadata")
+	cmd.Flags().BoolVar(&tagList, "tag-list", false, "Treat cache-tag metadata as a comma or space separated list and match --tag exactly against its elements")
+	cmd.Flags().StringVar(&metaFilter, "metadata-filter", "", "Delete KV entries whose metadata matches field=value, field~=substring or field (exists); nested fields use dots")
 	cmd.Flags().StringVar(&namespace, "namespace", "", "Comma-separated list of KV namespace IDs")
 	cmd.Flags().BoolVar(&allNamespaces, "all-namespaces", false, "Apply to all KV namespaces")
 	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what would be deleted without actually deleting")
-
-	cmd.MarkFlagRequired("tag")
+	cmd.Flags().StringVar(&fromPlan, "from-plan", "", "Delete exactly the keys listed in a plan written with --dry-run-output")
+	cmd.Flags().StringVar(&retryFailed, "retry-failed", "", "Delete and purge only the keys and cache tags recorded in a file written with --failures-output")
+	cmd.Flags().StringVar(&failuresOut, "failures-output", "", "Write the keys and cache tags that failed, with key errors, to this file for --retry-failed")
+	cmd.Flags().IntVar(&sample, "sample", 0, "With --dry-run, list only the first N matched keys per namespace and count the rest")
+	cmd.Flags().StringVar(&dryRunOutput, "dry-run-output", "", "Write the dry-run plan as JSON to this file (implies --dry-run)")
+	cmd.Flags().BoolVar(&preserveOrder, "preserve-order", false, "Print per-key results in the original key order once processing completes")
+	cmd.Flags().BoolVar(&errorOnEmpty, "error-on-empty", false, fmt.Sprintf("Exit with status %d when no keys match", util.ExitNothingMatched))
+	cmd.Flags().BoolVar(&failFast, "fail-fast", false, "Stop on the first error; work already completed is not rolled back")
+	cmd.Flags().IntVar(&batchSize, "batch-size", api.PurgeBatchSize, fmt.Sprintf("Number of cache tags sent per purge request (at most %d)", api.PurgeBatchSize))
+	cmd.Flags().StringVar(&purgeZones, "purge-zones", "", "Comma-separated zone names or IDs to purge the cache tags from, instead of every zone")
+	out.addFlags(cmd)
+	out.addReportFlag(cmd, "KV purge")
 
 	return cmd
 }
+
+// tagPurgeZones lists the zones to purge cache tags from: the named zones, or
+// every zone when none are named, leaving out zones whose plan cannot purge by
+// tag so that each tag batch is not sent to them only to fail
+func tagPurgeZones(ctx context.Context, names []string) ([]cloudflare.Zone, error) {
+	zones, err := api.ListZones(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(names) > 0 {
+		var missing []string
+		zones, missing = api.SelectZones(zones, names)
+		for _, name := range missing {
+			util.Warning("Zone '%s' not found among the zones visible to the current credentials", name)
+		}
+	}
+
+	zones, unsupported := api.FilterTagPurgeZones(zones)
+	if len(unsupported) > 0 {
+		skipped := make([]string, len(unsupported))
+		for i, zone := range unsupported {
+			skipped[i] = zone.Name
+		}
+		util.Info("Skipping %d zones whose plan cannot purge by cache tag: %s", len(unsupported), strings.Join(skipped, ", "))
+	}
+
+	if len(zones) == 0 {
+		return nil, fmt.Errorf("no zones left that can purge by cache tag")
+	}
+
+	util.Info("Purging cache tags from %d zones", len(zones))
+	return zones, nil
+}
@@ -10,10 +10,24 @@ import (
 	"cfpurge/internal/api"
 	"cfpurge/internal/util"
 
-	"github.com/cloudflare/cloudflare-go"
 	"github.com/spf13/cobra"
 )
 
+// kvWriteResult is the structured output of kv put. Expiration is also set
+// for a TTL write, to the time the entry was computed to expire.
+type kvWriteResult struct {
+	Namespace     string                 `json:"namespace" yaml:"namespace"`
+	Key           string                 `json:"key" yaml:"key"`
+	Bytes         int                    `json:"bytes" yaml:"bytes"`
+	Chunks        int                    `json:"chunks,omitempty" yaml:"chunks,omitempty"`
+	Metadata      map[string]interface{} `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+	ExpirationTTL int                    `json:"expiration_ttl,omitempty" yaml:"expiration_ttl,omitempty"`
+	Expiration    string                 `json:"expiration,omitempty" yaml:"expiration,omitempty"`
+}
+
+// kvMinExpirationTTL is the shortest expiration TTL, in seconds, that KV accepts
+const kvMinExpirationTTL = 60
+
 func newPutCmd() *cobra.Command {
 	var (
 		namespace      string
@@ -22,14 +36,24 @@ func newPutCmd() *cobra.Command {
 		valueFile      string
 		expirationTTL  int
 		expirationDate string
+		expiresIn      string
 		cacheTag       string
 		metadata       string
+		base64Key      bool
+		chunk          bool
+		out            output
 	)
 
 	cmd := &cobra.Command{
 		Use:   "put",
 		Short: "Put a KV entry",
-		Long:  `Create or update a Workers KV entry in a namespace.`,
+		Long: `Create or update a Workers KV entry in a namespace.
+
+Values are limited to 25 MiB. With --chunk, a larger value is split into 25 MiB
+chunks: the first is stored under the key with the chunk count in its
+"cfpurge-chunks" metadata field, and the rest under <key>#chunk-1,
+<key>#chunk-2 and so on. Read it back with kv get --chunked. Chunk keys are not
+removed when the key is later overwritten or deleted.`,
 		Example: `  # Store a simple value
   cfpurge kv put --namespace=<namespace-id> --key=my-key --value="my value"
   
@@ -37,7 +61,19 @@ func newPutCmd() *cobra.Command {
   cfpurge kv put --namespace=<namespace-id> --key=my-key --file=data.json --cache-tag=product-123
   
   # With expiration
-  cfpurge kv put --namespace=<namespace-id> --key=my-key --value="temp" --ttl=3600`,
+  cfpurge kv put --namespace=<namespace-id> --key=my-key --value="temp" --ttl=3600
+  
+  # Expire a week from now
+  cfpurge kv put --namespace=<namespace-id> --key=my-key --value="temp" --expires-in=7d
+  
+  # Print what was written, including the computed expiration, as JSON
+  cfpurge kv put --namespace=<namespace-id> --key=my-key --value="temp" --ttl=3600 --output=json
+  
+  # Store a value larger than 25 MiB across several keys
+  cfpurge kv put --namespace=<namespace-id> --key=dataset --file=dataset.bin --chunk
+  
+  # Key with special characters, passed as base64
+  cfpurge kv put --namespace=<namespace-id> --key=cGF0aC90by9rZXk= --base64-key --value="v"`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if err := api.ValidateAuth(); err != nil {
 				return err
@@ -47,6 +83,10 @@ func newPutCmd() *cobra.Command {
 				return err
 			}
 
+			if err := out.start(); err != nil {
+				return err
+			}
+
 			if namespace == "" {
 				return fmt.Errorf("namespace ID is required")
 			}
@@ -55,10 +95,39 @@ func newPutCmd() *cobra.Command {
 				return fmt.Errorf("key is required")
 			}
 
+			if base64Key {
+				decoded, err := decodeBase64Key(key)
+				if err != nil {
+					return err
+				}
+				key = decoded
+			}
+
 			if value == "" && valueFile == "" {
 				return fmt.Errorf("either value or file is required")
 			}
 
+			expirationFlags := 0
+			for _, set := range []bool{expirationTTL > 0, expirationDate != "", expiresIn != ""} {
+				if set {
+					expirationFlags++
+				}
+			}
+			if expirationFlags > 1 {
+				return fmt.Errorf("only one of --ttl, --expiration and --expires-in may be given")
+			}
+
+			if expiresIn != "" {
+				ttl, err := util.ParseDuration(expiresIn)
+				if err != nil {
+					return err
+				}
+				if ttl < kvMinExpirationTTL*time.Second {
+					return fmt.Errorf("--expires-in must be at least %d seconds, got %s", kvMinExpirationTTL, ttl)
+				}
+				expirationTTL = int(ttl / time.Second)
+			}
+
 			// Parse metadata if provided
 			var metadataMap map[string]interface{}
 			if metadata != "" {
@@ -75,7 +144,18 @@ func newPutCmd() *cobra.Command {
 				metadataMap["cache-tag"] = cacheTag
 			}
 
-			client, err := api.GetClient()
+			// Reject tags that could never be purged before storing them
+			if tag, ok := metadataMap["cache-tag"]; ok {
+				tagStr, isString := tag.(string)
+				if !isString {
+					return fmt.Errorf("cache-tag metadata must be a string")
+				}
+				if err := util.ValidateCacheTagList(tagStr); err != nil {
+					return err
+				}
+			}
+
+			client, err := api.GetClient(api.WriteAccess)
 			if err != nil {
 				return err
 			}
@@ -92,6 +172,21 @@ func newPutCmd() *cobra.Command {
 				valueData = []byte(value)
 			}
 
+			chunks := [][]byte{valueData}
+			if err := util.ValidateKVValueSize(len(valueData)); err != nil {
+				if !chunk {
+					return fmt.Errorf("%w; use --chunk to split it across several keys", err)
+				}
+				chunks = util.SplitChunks(valueData, util.MaxKVValueSize)
+				if err := util.ValidateKVKey(util.ChunkKey(key, len(chunks)-1)); err != nil {
+					return fmt.Errorf("key is too long to add chunk suffixes: %w", err)
+				}
+				if metadataMap == nil {
+					metadataMap = make(map[string]interface{})
+				}
+				metadataMap[util.ChunkCountMetadata] = len(chunks)
+			}
+
 			// Prepare expiration
 			var expiration *time.Time
 			if expirationDate != "" {
@@ -102,30 +197,59 @@ func newPutCmd() *cobra.Command {
 				expiration = &parsedTime
 			}
 
-			// Create params for write
-			params := cloudflare.WriteWorkersKVEntryParams{
-				NamespaceID: namespace,
-				Key:         key,
-				Value:       valueData,
-				Metadata:    metadataMap,
+			// The key itself is written last, so that its chunk count is only
+			// visible once every other chunk is stored
+			writtenAt := time.Now()
+			for i := len(chunks) - 1; i >= 0; i-- {
+				entry := api.KVWrite{
+					Key:   util.ChunkKey(key, i),
+					Value: chunks[i],
+				}
+				if i == 0 && metadataMap != nil {
+					entry.Metadata = metadataMap
+				}
+
+				if expirationTTL > 0 {
+					entry.ExpirationTTL = expirationTTL
+				} else if expiration != nil {
+					entry.Expiration = expiration.Unix()
+				}
+
+				// Write the KV entry
+				err = api.WithRetry(cmd.Context(), func(ctx context.Context) error {
+					return api.WriteKV(ctx, client, namespace, entry)
+				})
+				if err != nil {
+					if len(chunks) > 1 {
+						return fmt.Errorf("error writing chunk %d of %d of KV entry: %w", i+1, len(chunks), err)
+					}
+					return fmt.Errorf("error writing KV entry: %w", err)
+				}
 			}
 
-			if expirationTTL > 0 {
-				ttl := uint(expirationTTL)
-				params.ExpirationTTL = &ttl
-			} else if expiration != nil {
-				// Convert to seconds since epoch
-				expSeconds := uint(expiration.Unix())
-				params.Expiration = &expSeconds
+			if out.structured() {
+				written := kvWriteResult{Namespace: namespace, Key: key, Bytes: len(valueData), Metadata: metadataMap}
+				if len(chunks) > 1 {
+					written.Chunks = len(chunks)
+				}
+				if expirationTTL > 0 {
+					written.ExpirationTTL = expirationTTL
+					written.Expiration = writtenAt.Add(time.Duration(expirationTTL) * time.Second).Format(time.RFC3339)
+				} else if expiration != nil {
+					written.Expiration = expiration.Format(time.RFC3339)
+				}
+				return out.write(written)
 			}
 
-			// Write the KV entry
-			err = client.WriteWorkersKVEntry(context.Background(), api.GetAccountID(), params)
-			if err != nil {
-				return fmt.Errorf("error writing KV entry: %w", err)
+			if out.quiet {
+				return nil
 			}
 
-			util.Success("Successfully stored value for key: %s", key)
+			if len(chunks) > 1 {
+				util.Success("Successfully stored %s for key: %s in %d chunks", util.FormatBytes(int64(len(valueData))), key, len(chunks))
+			} else {
+				util.Success("Successfully stored %s for key: %s", util.FormatBytes(int64(len(valueData))), key)
+			}
 
 			// Print details about the entry
 			if metadataMap != nil {
@@ -153,10 +277,16 @@ func newPutCmd() *cobra.Command {
 	cmd.Flags().StringVar(&valueFile, "file", "", "Read value from file")
 	cmd.Flags().IntVar(&expirationTTL, "ttl", 0, "Expiration TTL in seconds (0 = no expiration)")
 	cmd.Flags().StringVar(&expirationDate, "expiration", "", "Expiration date/time (RFC3339 format)")
+	cmd.Flags().StringVar(&expiresIn, "expires-in", "", "Expire after this long from now, e.g. 90m, 24h or 7d (minimum 60s)")
 	cmd.Flags().StringVar(&cacheTag, "cache-tag", "", "Cache tag for the entry")
 	cmd.Flags().StringVar(&metadata, "metadata", "", "Custom metadata JSON (e.g., '{\"key\":\"value\"}')")
+	cmd.Flags().BoolVar(&base64Key, "base64-key", false, "Treat --key as base64 and decode it before use")
+	cmd.Flags().BoolVar(&chunk, "chunk", false, "Split a value larger than 25 MiB across several keys, to be read with kv get --chunked")
+
+	out.addFlags(cmd)
 
 	cmd.MarkFlagRequired("namespace")
 	cmd.MarkFlagRequired("key")
 
 	return cmd
+}
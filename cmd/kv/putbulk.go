@@ -0,0 +1,197 @@
+package kv
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"cfpurge/internal/api"
+	"cfpurge/internal/util"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/spf13/cobra"
+)
+
+// maxBulkLineSize bounds a single JSONL record (KV values are limited to 25 MiB)
+const maxBulkLineSize = 32 * 1024 * 1024
+
+// bulkRecord is a single line of JSONL input for put-bulk
+type bulkRecord struct {
+	Key      string                 `json:"key"`
+	Value    json.RawMessage        `json:"value"`
+	Metadata map[string]interface{} `json:"metadata"`
+	TTL      int                    `json:"ttl"`
+}
+
+func newPutBulkCmd() *cobra.Command {
+	var (
+		namespace string
+		dryRun    bool
+		failFast  bool
+		batchSize int
+	)
+
+	cmd := &cobra.Command{
+		Use:   "put-bulk",
+		Short: "Bulk put KV entries from JSONL on stdin",
+		Long: `Read JSONL records from stdin and write them to a Workers KV namespace using the bulk API.
+Each line must be an object of the form {"key": ..., "value": ..., "metadata": {...}, "ttl": ...}.
+Input is streamed in batches so memory use stays bounded.`,
+		Example: `  # Seed a namespace from a generator
+  generate-config | cfpurge kv put-bulk --namespace=<namespace-id>
+
+  # Count and validate records without writing
+  cfpurge kv put-bulk --namespace=<namespace-id> --dry-run < entries.jsonl`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := api.ValidateAuth(); err != nil {
+				return err
+			}
+
+			if err := api.ValidateAccountID(); err != nil {
+				return err
+			}
+
+			if namespace == "" {
+				return fmt.Errorf("namespace ID is required")
+			}
+
+			if err := util.ValidateBatchSize(batchSize, kvBulkWriteMaxBatchSize); err != nil {
+				return err
+			}
+
+			client, err := api.GetClient(api.WriteAccess)
+			if err != nil {
+				return err
+			}
+
+			successCount := 0
+			failureCount := 0
+			var batch []*cloudflare.WorkersKVPair
+
+			flush := func() error {
+				if len(batch) == 0 {
+					return nil
+				}
+				if dryRun {
+					successCount += len(batch)
+				} else {
+					params := cloudflare.WriteWorkersKVEntriesParams{
+						NamespaceID: namespace,
+						KVs:         batch,
+					}
+					err := api.WithRetry(cmd.Context(), func(ctx context.Context) error {
+						_, err := client.WriteWorkersKVEntries(ctx, cloudflare.AccountIdentifier(api.GetAccountID()), params)
+						return err
+					})
+					if err != nil {
+						util.Error("Error writing batch of %d KV entries: %v", len(batch), err)
+						failureCount += len(batch)
+						if failFast {
+							return fmt.Errorf("aborting due to --fail-fast: error writing batch of %d KV entries: %w", len(batch), err)
+						}
+					} else {
+						util.Success("Successfully wrote batch of %d KV entries", len(batch))
+						successCount += len(batch)
+					}
+				}
+				batch = nil
+				return nil
+			}
+
+			scanner := bufio.NewScanner(cmd.InOrStdin())
+			scanner.Buffer(make([]byte, 64*1024), maxBulkLineSize)
+
+			lineNum := 0
+			for scanner.Scan() {
+				lineNum++
+				line := strings.TrimSpace(scanner.Text())
+				if line == "" {
+					continue
+				}
+
+				pair, err := parseBulkRecord(line)
+				if err != nil {
+					util.Error("Line %d: %v", lineNum, err)
+					failureCount++
+					if failFast {
+						util.PrettyPrintResults(successCount, failureCount)
+						return fmt.Errorf("aborting due to --fail-fast: line %d: %w", lineNum, err)
+					}
+					continue
+				}
+
+				batch = append(batch, pair)
+				if len(batch) >= batchSize {
+					if err := flush(); err != nil {
+						util.PrettyPrintResults(successCount, failureCount)
+						return err
+					}
+				}
+			}
+			if err := scanner.Err(); err != nil {
+				flush()
+				util.PrettyPrintResults(successCount, failureCount)
+				return fmt.Errorf("error reading input after line %d: %w", lineNum, err)
+			}
+			if err := flush(); err != nil {
+				util.PrettyPrintResults(successCount, failureCount)
+				return err
+			}
+
+			if dryRun {
+				util.Info("Dry run mode - would write %d KV entries to namespace %s", successCount, namespace)
+			}
+
+			util.PrettyPrintResults(successCount, failureCount)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&namespace, "namespace", "", "KV namespace ID")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Validate and count records without writing")
+	cmd.Flags().BoolVar(&failFast, "fail-fast", false, "Stop on the first error; work already completed is not rolled back")
+	cmd.Flags().IntVar(&batchSize, "batch-size", bulkWriteBatchSize, fmt.Sprintf("Number of entries sent per bulk write request (at most %d)", kvBulkWriteMaxBatchSize))
+
+	cmd.MarkFlagRequired("namespace")
+
+	return cmd
+}
+
+// parseBulkRecord converts a JSONL line into a KV pair for the bulk API
+func parseBulkRecord(line string) (*cloudflare.WorkersKVPair, error) {
+	var record bulkRecord
+	if err := json.Unmarshal([]byte(line), &record); err != nil {
+		return nil, fmt.Errorf("malformed record: %w", err)
+	}
+
+	if record.Key == "" {
+		return nil, fmt.Errorf("record is missing a key")
+	}
+
+	if len(record.Value) == 0 || string(record.Value) == "null" {
+		return nil, fmt.Errorf("record for key '%s' is missing a value", record.Key)
+	}
+
+	if record.TTL < 0 {
+		return nil, fmt.Errorf("record for key '%s' has a negative ttl", record.Key)
+	}
+
+	// String values are stored as-is, anything else is stored as its JSON text
+	var value string
+	if err := json.Unmarshal(record.Value, &value); err != nil {
+		value = string(record.Value)
+	}
+
+	pair := &cloudflare.WorkersKVPair{
+		Key:           record.Key,
+		Value:         value,
+		ExpirationTTL: record.TTL,
+	}
+	if record.Metadata != nil {
+		pair.Metadata = record.Metadata
+	}
+
+	return pair, nil
+}
@@ -40,7 +40,7 @@ func newRenameCmd() *cobra.Command {
 				return fmt.Errorf("new title is required")
 			}
 
-			client, err := api.GetClient()
+			client, err := api.GetClient(api.WriteAccess)
 			if err != nil {
 				return err
 			}
@@ -50,11 +50,14 @@ func newRenameCmd() *cobra.Command {
 				NamespaceID: namespaceID,
 				Title:       title,
 			}
-			_, err = client.UpdateWorkersKVNamespace(
-				context.Background(),
-				api.GetAccountID(),
-				params,
-			)
+			err = api.WithRetry(cmd.Context(), func(ctx context.Context) error {
+				_, err := client.UpdateWorkersKVNamespace(
+					ctx,
+					cloudflare.AccountIdentifier(api.GetAccountID()),
+					params,
+				)
+				return err
+			})
 
 			if err != nil {
 				return fmt.Errorf("error renaming KV namespace: %w", err)
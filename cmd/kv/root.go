@@ -1,6 +1,10 @@
 package kv
 
 import (
+	"fmt"
+
+	"cfpurge/internal/util"
+
 	"github.com/spf13/cobra"
 )
 
@@ -19,7 +23,61 @@ func NewKVCmd() *cobra.Command {
 	kvCmd.AddCommand(newPurgeCmd())
 	kvCmd.AddCommand(newGetCmd())
 	kvCmd.AddCommand(newPutCmd())
+	kvCmd.AddCommand(newPutBulkCmd())
+	kvCmd.AddCommand(newApplyCmd())
 	kvCmd.AddCommand(newRenameCmd())
+	kvCmd.AddCommand(newMoveCmd())
+	kvCmd.AddCommand(newTouchCmd())
+	kvCmd.AddCommand(newNamespaceCmd())
+	kvCmd.AddCommand(newSearchCmd())
+	kvCmd.AddCommand(newDiffCmd())
+
+	validateFlagsBeforeRun(kvCmd)
 
 	return kvCmd
 }
+
+// validateFlagsBeforeRun makes every KV subcommand check its namespace ID and
+// key flags once they are parsed, before any API call is made
+func validateFlagsBeforeRun(cmd *cobra.Command) {
+	for _, sub := range cmd.Commands() {
+		sub.PreRunE = func(cmd *cobra.Command, args []string) error {
+			return validateKVFlags(cmd)
+		}
+		validateFlagsBeforeRun(sub)
+	}
+}
+
+// validateKVFlags checks the namespace ID flags (--namespace may hold a comma
+// separated list) and --key of a command. Only the command's own flags are
+// checked, since the global --key is an API key rather than a KV key.
+func validateKVFlags(cmd *cobra.Command) error {
+	flags := cmd.LocalFlags()
+
+	for _, name := range []string{"namespace", "source", "dest"} {
+		flag := flags.Lookup(name)
+		if flag == nil || flag.Value.String() == "" {
+			continue
+		}
+		for _, id := range util.SplitCommaList(flag.Value.String()) {
+			if err := util.ValidateNamespaceID(id); err != nil {
+				return fmt.Errorf("invalid --%s: %w", name, err)
+			}
+		}
+	}
+
+	// A base64 key is checked once decoded
+	if flag := flags.Lookup("key"); flag != nil && flag.Changed && !base64KeyFlag(cmd) {
+		if err := util.ValidateKVKey(flag.Value.String()); err != nil {
+			return fmt.Errorf("invalid --key: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// base64KeyFlag reports whether --base64-key was given to a command that has it
+func base64KeyFlag(cmd *cobra.Command) bool {
+	flag := cmd.LocalFlags().Lookup("base64-key")
+	return flag != nil && flag.Value.String() == "true"
+}
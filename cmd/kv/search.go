@@ -0,0 +1,236 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"cfpurge/internal/api"
+	"cfpurge/internal/util"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/spf13/cobra"
+)
+
+// searchMatch is a key found by kv search
+type searchMatch struct {
+	Namespace   string `json:"namespace" yaml:"namespace"`
+	NamespaceID string `json:"namespace_id" yaml:"namespace_id"`
+	Key         string `json:"key" yaml:"key"`
+	CacheTag    string `json:"cache_tag,omitempty" yaml:"cache_tag,omitempty"`
+}
+
+// searchResult is the structured output of kv search
+type searchResult struct {
+	Matches []searchMatch     `json:"matches" yaml:"matches"`
+	Errors  map[string]string `json:"errors,omitempty" yaml:"errors,omitempty"`
+}
+
+func newSearchCmd() *cobra.Command {
+	var (
+		pattern     string
+		tag         string
+		tagList     bool
+		namespace   string
+		concurrency int
+		out         output
+	)
+
+	cmd := &cobra.Command{
+		Use:   "search",
+		Short: "Find keys across KV namespaces",
+		Long: `Scan KV namespaces for keys whose name matches a glob pattern and/or whose
+cache-tag metadata contains a tag, and report which namespace each is in.
+In patterns, '*' matches any characters including '/', '?' matches one
+character and '[...]' matches a character class.`,
+		Example: `  # Which namespace holds the key for product-123?
+  cfpurge kv search --pattern="product-123*"
+
+  # Find keys tagged with a cache tag in two namespaces
+  cfpurge kv search --tag=product-123 --namespace=<namespace-id1>,<namespace-id2>
+
+  # Combine a pattern and a tag, as JSON
+  cfpurge kv search --pattern="users/*/profile" --tag=team-a --format=json`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := api.ValidateAuth(); err != nil {
+				return err
+			}
+
+			if err := api.ValidateAccountID(); err != nil {
+				return err
+			}
+
+			if err := out.start(); err != nil {
+				return err
+			}
+
+			if pattern == "" && tag == "" {
+				return fmt.Errorf("either --pattern or --tag is required")
+			}
+
+			if concurrency < 1 {
+				return fmt.Errorf("concurrency must be at least 1")
+			}
+
+			var glob *util.Glob
+			if pattern != "" {
+				var err error
+				glob, err = util.CompileGlob(pattern)
+				if err != nil {
+					return err
+				}
+			}
+
+			var selector *keySelector
+			if tag != "" {
+				var err error
+				selector, err = newKeySelector(tag, "", tagList)
+				if err != nil {
+					return err
+				}
+			}
+
+			client, err := api.GetClient(api.ReadAccess)
+			if err != nil {
+				return err
+			}
+
+			ctx := cmd.Context()
+			namespaces, err := listAllNamespaces(ctx, client)
+			if err != nil {
+				return fmt.Errorf("error listing KV namespaces: %w", err)
+			}
+
+			// Restrict the scan to the requested namespaces, keeping their titles
+			if namespace != "" {
+				wanted := util.StringSliceToSet(util.SplitCommaList(namespace))
+				var subset []cloudflare.WorkersKVNamespace
+				for _, ns := range namespaces {
+					if wanted[ns.ID] {
+						subset = append(subset, ns)
+						delete(wanted, ns.ID)
+					}
+				}
+				for id := range wanted {
+					util.Warning("Namespace %s not found in the account", id)
+				}
+				namespaces = subset
+			}
+
+			if len(namespaces) == 0 {
+				return fmt.Errorf("no KV namespaces to search")
+			}
+
+			util.Info("Searching %d KV namespaces", len(namespaces))
+			result := searchNamespaces(ctx, client, namespaces, glob, selector, concurrency)
+
+			if out.structured() {
+				return out.write(result)
+			}
+			printSearchResult(result, len(namespaces))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&pattern, "pattern", "", "Glob pattern the key name must match")
+	cmd.Flags().StringVar(&tag, "tag", "", "Only keys whose cache-tag metadata contains this tag")
+	cmd.Flags().BoolVar(&tagList, "tag-list", false, "Treat cache-tag metadata as a comma or space separated list and match --tag exactly against its elements")
+	cmd.Flags().StringVar(&namespace, "namespace", "", "Comma-separated namespace IDs to search instead of all namespaces")
+	cmd.Flags().IntVar(&concurrency, "concurrency", namespaceConcurrency, "Maximum number of namespaces scanned concurrently")
+	out.addFlags(cmd)
+	cmd.Flags().StringVar(&out.format, "format", util.OutputTable, "Alias for --output")
+
+	return cmd
+}
+
+// searchNamespaces lists the keys of each namespace with bounded concurrency
+// and collects those matching the glob and selector. A namespace that fails to
+// list is reported without stopping the others.
+func searchNamespaces(ctx context.Context, client *cloudflare.API, namespaces []cloudflare.WorkersKVNamespace, glob *util.Glob, selector *keySelector, concurrency int) searchResult {
+	var result searchResult
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+
+	// Listing only the keys that share the pattern's literal prefix saves pages
+	var prefix string
+	if glob != nil {
+		prefix = glob.Prefix()
+	}
+
+	for _, ns := range namespaces {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(ns cloudflare.WorkersKVNamespace) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			keys, err := listAllKeys(ctx, client, ns.ID, prefix)
+			if err != nil {
+				util.Error("Error listing keys in namespace %s: %v", ns.Title, err)
+				mu.Lock()
+				if result.Errors == nil {
+					result.Errors = make(map[string]string)
+				}
+				result.Errors[ns.ID] = err.Error()
+				mu.Unlock()
+				return
+			}
+
+			var matches []searchMatch
+			for _, key := range keys {
+				if glob != nil && !glob.Match(key.Name) {
+					continue
+				}
+
+				metadata, _ := key.Metadata.(map[string]interface{})
+				if selector != nil && (metadata == nil || !selector.match(metadata)) {
+					continue
+				}
+
+				cacheTag, _ := metadata["cache-tag"].(string)
+				matches = append(matches, searchMatch{Namespace: ns.Title, NamespaceID: ns.ID, Key: key.Name, CacheTag: cacheTag})
+			}
+
+			mu.Lock()
+			result.Matches = append(result.Matches, matches...)
+			mu.Unlock()
+		}(ns)
+	}
+	wg.Wait()
+
+	// Namespaces finish in any order, so sort for stable output
+	sort.Slice(result.Matches, func(i, j int) bool {
+		a, b := result.Matches[i], result.Matches[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Key < b.Key
+	})
+	if result.Matches == nil {
+		result.Matches = []searchMatch{}
+	}
+
+	return result
+}
+
+// printSearchResult prints the matching keys as a table with a summary line
+func printSearchResult(result searchResult, searched int) {
+	if len(result.Matches) == 0 {
+		util.Warning("No matching keys found in %d namespaces", searched)
+		return
+	}
+
+	namespaces := make(map[string]bool)
+	table := util.NewTable("Namespace", "Namespace ID", "Key", "Cache Tag")
+	for _, match := range result.Matches {
+		namespaces[match.NamespaceID] = true
+		table.AddRow(match.Namespace, match.NamespaceID, match.Key, match.CacheTag)
+	}
+
+	fmt.Println()
+	table.Print()
+	fmt.Printf("\nFound %d keys in %d of %d namespaces\n", len(result.Matches), len(namespaces), searched)
+}
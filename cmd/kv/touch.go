@@ -0,0 +1,204 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"cfpurge/internal/api"
+	"cfpurge/internal/util"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/spf13/cobra"
+)
+
+func newTouchCmd() *cobra.Command {
+	var (
+		namespace    string
+		key          string
+		prefix       string
+		tag          string
+		ttl          int
+		concurrency  = util.Concurrency{N: 10}
+		dryRun       bool
+		errorOnEmpty bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "touch",
+		Short: "Extend the TTL of KV entries without changing their value",
+		Long: `Give Workers KV entries a new expiration TTL while keeping their value and
+metadata. KV cannot change an entry's expiration on its own, so each entry is
+read and written back: every key touched costs one read and one write.
+
+Select a single entry with --key, or several with --prefix and/or --tag.`,
+		Example: `  # Keep an entry for another day
+  cfpurge kv touch --namespace=<namespace-id> --key=session-123 --ttl=86400
+
+  # Extend every entry with a cache tag
+  cfpurge kv touch --namespace=<namespace-id> --tag=product-123 --ttl=3600
+
+  # Preview which entries would be touched (dry run)
+  cfpurge kv touch --namespace=<namespace-id> --prefix=user- --ttl=3600 --dry-run`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := api.ValidateAuth(); err != nil {
+				return err
+			}
+
+			if err := api.ValidateAccountID(); err != nil {
+				return err
+			}
+
+			if key != "" && (prefix != "" || tag != "") {
+				return fmt.Errorf("--key cannot be combined with --prefix or --tag")
+			}
+
+			if key == "" && prefix == "" && tag == "" {
+				return fmt.Errorf("one of --key, --prefix or --tag is required")
+			}
+
+			if ttl < kvMinExpirationTTL {
+				return fmt.Errorf("--ttl must be at least %d seconds", kvMinExpirationTTL)
+			}
+
+			if err := concurrency.Validate(); err != nil {
+				return err
+			}
+
+			client, err := api.GetClient(api.WriteAccess)
+			if err != nil {
+				return err
+			}
+
+			// Listing provides the metadata to preserve, which reading a value does not
+			listPrefix := prefix
+			if key != "" {
+				listPrefix = key
+			}
+			keys, err := listAllKeys(cmd.Context(), client, namespace, listPrefix)
+			if err != nil {
+				return fmt.Errorf("error listing KV keys in namespace %s: %w", namespace, err)
+			}
+
+			var keysToTouch []cloudflare.StorageKey
+			for _, k := range keys {
+				if key != "" && k.Name != key {
+					continue
+				}
+				if tag != "" {
+					metadata, ok := k.Metadata.(map[string]interface{})
+					if !ok {
+						continue
+					}
+					cacheTagStr, ok := metadata["cache-tag"].(string)
+					if !ok || !strings.Contains(cacheTagStr, tag) {
+						continue
+					}
+				}
+				keysToTouch = append(keysToTouch, k)
+			}
+
+			if len(keysToTouch) == 0 {
+				return util.NothingMatched(fmt.Sprintf("KV keys in namespace %s", namespace), errorOnEmpty)
+			}
+
+			util.Info("Found %d KV keys to touch in namespace %s", len(keysToTouch), namespace)
+
+			if dryRun {
+				fmt.Printf("Dry run mode - would set a TTL of %ds on the following keys in namespace %s:\n", ttl, namespace)
+				for _, k := range keysToTouch {
+					fmt.Printf("  %s\n", k.Name)
+				}
+				return nil
+			}
+
+			util.Warning("Touching re-writes each value; %d keys will cost %d reads and %d writes", len(keysToTouch), len(keysToTouch), len(keysToTouch))
+
+			var wg sync.WaitGroup
+			var touchMutex sync.Mutex
+			successCount := 0
+			failureCount := 0
+
+			sem := concurrency.Semaphore(api.GetRateLimit())
+			ctx := api.WithSemaphore(cmd.Context(), sem)
+
+			for i, k := range keysToTouch {
+				if ctx.Err() != nil {
+					util.Warning("Interrupted; not touching the remaining %d keys", len(keysToTouch)-i)
+					break
+				}
+				wg.Add(1)
+				sem.Acquire()
+
+				go func(k cloudflare.StorageKey) {
+					defer wg.Done()
+
+					start := time.Now()
+					err := touchKey(ctx, client, namespace, k, ttl)
+					sem.Release(start, err)
+					util.Audit(namespace, []string{k.Name}, err)
+
+					touchMutex.Lock()
+					if err != nil {
+						util.Error("Error touching KV key %s: %v", k.Name, err)
+						failureCount++
+					} else {
+						util.Success("Successfully touched KV key: %s", k.Name)
+						successCount++
+					}
+					touchMutex.Unlock()
+				}(k)
+			}
+
+			wg.Wait()
+
+			util.PrettyPrintResults(successCount, failureCount)
+			return util.Interrupted(cmd.Context())
+		},
+	}
+
+	cmd.Flags().StringVar(&namespace, "namespace", "", "KV namespace ID")
+	cmd.Flags().StringVar(&key, "key", "", "Touch a single key")
+	cmd.Flags().StringVar(&prefix, "prefix", "", "Touch keys with this prefix")
+	cmd.Flags().StringVar(&tag, "tag", "", "Touch KV entries with matching cache-tag metadata")
+	cmd.Flags().IntVar(&ttl, "ttl", 0, fmt.Sprintf("New expiration TTL in seconds (at least %d)", kvMinExpirationTTL))
+	cmd.Flags().Var(&concurrency, "concurrency", "Maximum number of keys to touch concurrently, or auto to adapt to --rate-limit and rate limiting")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what would be touched without writing")
+	cmd.Flags().BoolVar(&errorOnEmpty, "error-on-empty", false, fmt.Sprintf("Exit with status %d when no keys match", util.ExitNothingMatched))
+
+	cmd.MarkFlagRequired("namespace")
+	cmd.MarkFlagRequired("ttl")
+
+	return cmd
+}
+
+// touchKey reads an entry and writes it back unchanged apart from its new TTL
+func touchKey(ctx context.Context, client *cloudflare.API, namespace string, key cloudflare.StorageKey, ttl int) error {
+	var value []byte
+	err := api.WithRetry(ctx, func(ctx context.Context) error {
+		var err error
+		value, err = api.ReadKV(ctx, client, namespace, key.Name)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error reading value: %w", err)
+	}
+
+	entry := api.KVWrite{
+		Key:           key.Name,
+		Value:         value,
+		Metadata:      key.Metadata,
+		ExpirationTTL: ttl,
+	}
+
+	err = api.WithRetry(ctx, func(ctx context.Context) error {
+		return api.WriteKV(ctx, client, namespace, entry)
+	})
+	if err != nil {
+		return fmt.Errorf("error writing value: %w", err)
+	}
+
+	return nil
+}
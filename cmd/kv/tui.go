@@ -0,0 +1,497 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"cfpurge/internal/api"
+	"cfpurge/internal/util"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/spf13/cobra"
+)
+
+// NewTUICmd creates the tui command, an interactive browser for KV namespaces
+// and keys. It sits at the top level, apart from the scriptable kv commands.
+func NewTUICmd() *cobra.Command {
+	var readOnly bool
+
+	cmd := &cobra.Command{
+		Use:   "tui",
+		Short: "Browse KV namespaces and keys interactively",
+		Long: `Open a terminal UI to browse Workers KV: pick a namespace, page through its
+keys, and view each key's value, metadata and expiration. Keys can be deleted
+after confirming, unless --read-only is given, which also only needs the
+read token.
+
+Keys: up/down or j/k to move, enter to open, esc to go back, n/p for the next
+and previous page of keys, d to delete, r to reload and q to quit.`,
+		Example: `  # Browse KV
+  cfpurge tui
+
+  # Browse without being able to delete anything
+  cfpurge tui --read-only`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := api.ValidateAuth(); err != nil {
+				return err
+			}
+
+			if err := api.ValidateAccountID(); err != nil {
+				return err
+			}
+
+			if !util.IsTerminal(cmd.OutOrStdout()) {
+				return fmt.Errorf("tui needs an interactive terminal; use the kv commands in scripts")
+			}
+
+			access := api.WriteAccess
+			if readOnly {
+				access = api.ReadAccess
+			}
+			client, err := api.GetClient(access)
+			if err != nil {
+				return err
+			}
+
+			model := newBrowser(cmd.Context(), client, readOnly)
+			_, err = tea.NewProgram(model, tea.WithAltScreen(), tea.WithContext(cmd.Context())).Run()
+			if err != nil && cmd.Context().Err() == nil {
+				return fmt.Errorf("error running the terminal UI: %w", err)
+			}
+			return util.Interrupted(cmd.Context())
+		},
+	}
+
+	cmd.Flags().BoolVar(&readOnly, "read-only", false, "Browse without allowing deletes")
+
+	return cmd
+}
+
+// Screens of the browser
+const (
+	screenNamespaces = iota
+	screenKeys
+	screenValue
+)
+
+// browser is the bubbletea model of the tui command
+type browser struct {
+	ctx      context.Context
+	client   *cloudflare.API
+	readOnly bool
+
+	screen  int
+	loading bool
+	status  string
+	height  int
+
+	namespaces []cloudflare.WorkersKVNamespace
+	nsCursor   int
+
+	namespace cloudflare.WorkersKVNamespace
+	keys      []cloudflare.StorageKey
+	keyCursor int
+	// pageCursors holds the cursor of each page shown so far, for going back;
+	// the last one is the current page
+	pageCursors []string
+	nextCursor  string
+
+	key   cloudflare.StorageKey
+	value []byte
+
+	// confirming is the key awaiting confirmation of its deletion
+	confirming string
+}
+
+// Messages delivering the results of API calls to the browser
+type (
+	namespacesMsg struct {
+		namespaces []cloudflare.WorkersKVNamespace
+		err        error
+	}
+	keysMsg struct {
+		keys []cloudflare.StorageKey
+		next string
+		err  error
+	}
+	valueMsg struct {
+		value []byte
+		err   error
+	}
+	deletedMsg struct {
+		key string
+		err error
+	}
+)
+
+func newBrowser(ctx context.Context, client *cloudflare.API, readOnly bool) *browser {
+	return &browser{ctx: ctx, client: client, readOnly: readOnly, loading: true, height: 24}
+}
+
+func (b *browser) Init() tea.Cmd {
+	return b.loadNamespaces()
+}
+
+func (b *browser) loadNamespaces() tea.Cmd {
+	return func() tea.Msg {
+		namespaces, err := listAllNamespaces(b.ctx, b.client)
+		return namespacesMsg{namespaces: namespaces, err: err}
+	}
+}
+
+func (b *browser) loadKeys(cursor string) tea.Cmd {
+	namespace := b.namespace.ID
+	return func() tea.Msg {
+		limit := b.pageSize()
+
+		var msg keysMsg
+		msg.err = api.WithRetry(b.ctx, func(ctx context.Context) error {
+			var err error
+			msg.keys, msg.next, err = api.ListKVKeys(ctx, b.client, namespace, "", limit, cursor)
+			return err
+		})
+		return msg
+	}
+}
+
+func (b *browser) loadValue() tea.Cmd {
+	namespace, key := b.namespace.ID, b.key.Name
+	return func() tea.Msg {
+		var msg valueMsg
+		msg.err = api.WithRetry(b.ctx, func(ctx context.Context) error {
+			var err error
+			msg.value, err = api.ReadKV(ctx, b.client, namespace, key)
+			return err
+		})
+		return msg
+	}
+}
+
+func (b *browser) deleteKey(key string) tea.Cmd {
+	namespace := b.namespace.ID
+	return func() tea.Msg {
+		err := api.WithRetry(b.ctx, func(ctx context.Context) error {
+			return api.DeleteKV(ctx, b.client, namespace, key)
+		})
+		util.Audit(namespace, []string{key}, err)
+		return deletedMsg{key: key, err: err}
+	}
+}
+
+// pageSize is the number of keys listed per page, so that a page fits the
+// terminal below the header and above the status line
+func (b *browser) pageSize() int {
+	size := b.height - 6
+	if size < 1 {
+		size = 1
+	}
+	if size > kvListPageSize {
+		size = kvListPageSize
+	}
+	return size
+}
+
+func (b *browser) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		b.height = msg.Height
+		return b, nil
+
+	case namespacesMsg:
+		b.loading = false
+		if msg.err != nil {
+			b.status = fmt.Sprintf("Error listing KV namespaces: %v", msg.err)
+			return b, nil
+		}
+		b.namespaces = msg.namespaces
+		b.nsCursor = clampCursor(b.nsCursor, len(b.namespaces))
+		b.status = fmt.Sprintf("%d namespaces", len(b.namespaces))
+		return b, nil
+
+	case keysMsg:
+		b.loading = false
+		if msg.err != nil {
+			b.status = fmt.Sprintf("Error listing KV keys: %v", msg.err)
+			return b, nil
+		}
+		b.keys, b.nextCursor = msg.keys, msg.next
+		b.keyCursor = clampCursor(b.keyCursor, len(b.keys))
+		b.status = fmt.Sprintf("Page %d, %d keys", len(b.pageCursors), len(b.keys))
+		if b.nextCursor != "" {
+			b.status += "; n for the next page"
+		}
+		return b, nil
+
+	case valueMsg:
+		b.loading = false
+		if msg.err != nil {
+			b.status = fmt.Sprintf("Error reading value: %v", msg.err)
+			return b, nil
+		}
+		b.value = msg.value
+		b.status = fmt.Sprintf("%d bytes", len(b.value))
+		return b, nil
+
+	case deletedMsg:
+		b.loading = false
+		if msg.err != nil {
+			b.status = fmt.Sprintf("Error deleting KV key %s: %v", msg.key, msg.err)
+			return b, nil
+		}
+		b.status = fmt.Sprintf("Deleted KV key %s", msg.key)
+		b.screen = screenKeys
+		b.loading = true
+		return b, b.loadKeys(b.pageCursors[len(b.pageCursors)-1])
+
+	case tea.KeyMsg:
+		return b.handleKey(msg)
+	}
+
+	return b, nil
+}
+
+// handleKey acts on a key press for the current screen
+func (b *browser) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	key := msg.String()
+
+	if key == "ctrl+c" {
+		return b, tea.Quit
+	}
+
+	if b.confirming != "" {
+		target := b.confirming
+		b.confirming = ""
+		if key != "y" && key != "Y" {
+			b.status = "Delete cancelled"
+			return b, nil
+		}
+		b.loading = true
+		b.status = fmt.Sprintf("Deleting %s...", target)
+		return b, b.deleteKey(target)
+	}
+
+	// Ignore further input until the pending request completes
+	if b.loading {
+		return b, nil
+	}
+
+	switch key {
+	case "q":
+		return b, tea.Quit
+	case "esc", "backspace", "left", "h":
+		return b.back()
+	case "r":
+		return b.reload()
+	}
+
+	switch b.screen {
+	case screenNamespaces:
+		switch key {
+		case "up", "k":
+			b.nsCursor = clampCursor(b.nsCursor-1, len(b.namespaces))
+		case "down", "j":
+			b.nsCursor = clampCursor(b.nsCursor+1, len(b.namespaces))
+		case "enter", "right", "l":
+			if len(b.namespaces) == 0 {
+				return b, nil
+			}
+			b.namespace = b.namespaces[b.nsCursor]
+			b.screen, b.keyCursor, b.keys = screenKeys, 0, nil
+			b.pageCursors = []string{""}
+			b.loading = true
+			return b, b.loadKeys("")
+		}
+
+	case screenKeys:
+		switch key {
+		case "up", "k":
+			b.keyCursor = clampCursor(b.keyCursor-1, len(b.keys))
+		case "down", "j":
+			b.keyCursor = clampCursor(b.keyCursor+1, len(b.keys))
+		case "n":
+			if b.nextCursor == "" {
+				b.status = "This is the last page"
+				return b, nil
+			}
+			b.pageCursors = append(b.pageCursors, b.nextCursor)
+			b.keyCursor, b.loading = 0, true
+			return b, b.loadKeys(b.nextCursor)
+		case "p":
+			if len(b.pageCursors) <= 1 {
+				b.status = "This is the first page"
+				return b, nil
+			}
+			b.pageCursors = b.pageCursors[:len(b.pageCursors)-1]
+			b.keyCursor, b.loading = 0, true
+			return b, b.loadKeys(b.pageCursors[len(b.pageCursors)-1])
+		case "enter", "right", "l":
+			if len(b.keys) == 0 {
+				return b, nil
+			}
+			b.key, b.value = b.keys[b.keyCursor], nil
+			b.screen, b.loading = screenValue, true
+			return b, b.loadValue()
+		case "d":
+			if len(b.keys) > 0 {
+				return b.confirmDelete(b.keys[b.keyCursor].Name)
+			}
+		}
+
+	case screenValue:
+		if key == "d" {
+			return b.confirmDelete(b.key.Name)
+		}
+	}
+
+	return b, nil
+}
+
+// back returns to the previous screen
+func (b *browser) back() (tea.Model, tea.Cmd) {
+	switch b.screen {
+	case screenKeys:
+		b.screen = screenNamespaces
+		b.status = fmt.Sprintf("%d namespaces", len(b.namespaces))
+	case screenValue:
+		b.screen = screenKeys
+		b.status = ""
+	}
+	return b, nil
+}
+
+// reload fetches the current screen again
+func (b *browser) reload() (tea.Model, tea.Cmd) {
+	b.loading = true
+	switch b.screen {
+	case screenKeys:
+		return b, b.loadKeys(b.pageCursors[len(b.pageCursors)-1])
+	case screenValue:
+		return b, b.loadValue()
+	}
+	return b, b.loadNamespaces()
+}
+
+// confirmDelete asks for confirmation before deleting key
+func (b *browser) confirmDelete(key string) (tea.Model, tea.Cmd) {
+	if b.readOnly {
+		b.status = "Read-only mode: deleting is disabled"
+		return b, nil
+	}
+	b.confirming = key
+	return b, nil
+}
+
+func (b *browser) View() string {
+	var s strings.Builder
+
+	switch b.screen {
+	case screenNamespaces:
+		s.WriteString("KV namespaces\n\n")
+		start, end := visibleRange(b.nsCursor, len(b.namespaces), b.pageSize())
+		for i := start; i < end; i++ {
+			ns := b.namespaces[i]
+			s.WriteString(listRow(i == b.nsCursor, fmt.Sprintf("%-40s %s", ns.Title, ns.ID)))
+		}
+
+	case screenKeys:
+		fmt.Fprintf(&s, "Keys in %s (%s)\n\n", b.namespace.Title, b.namespace.ID)
+		for i, key := range b.keys {
+			s.WriteString(listRow(i == b.keyCursor, key.Name))
+		}
+		if !b.loading && len(b.keys) == 0 {
+			s.WriteString("  (no keys)\n")
+		}
+
+	case screenValue:
+		fmt.Fprintf(&s, "%s in %s\n\n", b.key.Name, b.namespace.Title)
+		fmt.Fprintf(&s, "Expiration: %s\n", displayExpiration(b.key.Expiration))
+		fmt.Fprintf(&s, "Metadata:   %s\n\n", displayMetadata(b.key.Metadata))
+		if b.value != nil {
+			s.WriteString(b.displayValue())
+		}
+	}
+
+	s.WriteString("\n")
+	switch {
+	case b.confirming != "":
+		fmt.Fprintf(&s, "Delete KV key %s from %s? (y/N)", b.confirming, b.namespace.Title)
+	case b.loading:
+		s.WriteString("Loading...")
+	default:
+		s.WriteString(b.status)
+	}
+	s.WriteString("\n")
+	s.WriteString(b.help())
+
+	return s.String()
+}
+
+// displayValue renders the value for the value screen, cut to the lines that
+// fit the terminal. Binary values are described rather than printed.
+func (b *browser) displayValue() string {
+	mimeType, binary := util.DetectContentType(b.value)
+	if binary {
+		return fmt.Sprintf("(%s, %d bytes; use kv get --output-file to save it)\n", mimeType, len(b.value))
+	}
+
+	lines := strings.Split(strings.TrimRight(string(b.value), "\n"), "\n")
+	maxLines := b.height - 10
+	if maxLines < 1 {
+		maxLines = 1
+	}
+	if len(lines) > maxLines {
+		lines = append(lines[:maxLines], fmt.Sprintf("... %d more lines", len(lines)-maxLines))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// help lists the keys available on the current screen
+func (b *browser) help() string {
+	switch b.screen {
+	case screenKeys:
+		if b.readOnly {
+			return "enter: view  n/p: next/previous page  esc: back  r: reload  q: quit"
+		}
+		return "enter: view  n/p: next/previous page  d: delete  esc: back  r: reload  q: quit"
+	case screenValue:
+		if b.readOnly {
+			return "esc: back  r: reload  q: quit"
+		}
+		return "d: delete  esc: back  r: reload  q: quit"
+	}
+	return "enter: open  r: reload  q: quit"
+}
+
+// listRow renders one row of a list, marking the selected row
+func listRow(selected bool, text string) string {
+	if selected {
+		return "> " + text + "\n"
+	}
+	return "  " + text + "\n"
+}
+
+// visibleRange returns the rows of a list of n items that fit size rows and
+// include the cursor
+func visibleRange(cursor, n, size int) (start, end int) {
+	if cursor >= size {
+		start = cursor - size + 1
+	}
+	end = start + size
+	if end > n {
+		end = n
+	}
+	return start, end
+}
+
+// clampCursor keeps a cursor within a list of n items
+func clampCursor(cursor, n int) int {
+	if cursor >= n {
+		cursor = n - 1
+	}
+	if cursor < 0 {
+		cursor = 0
+	}
+	return cursor
+}
@@ -1,9 +1,8 @@
 package cmd
 
 import (
-	"context"
 	"fmt"
-	"strings"
+	"os"
 
 	"cfpurge/internal/api"
 	"cfpurge/internal/util"
@@ -11,28 +10,67 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var (
+	listZoneTag string
+	listOutput  string
+)
+
+// zoneInfo is a zone as shown by list
+type zoneInfo struct {
+	Name   string `json:"name" yaml:"name"`
+	ID     string `json:"id" yaml:"id"`
+	Status string `json:"status" yaml:"status"`
+}
+
 // listCmd represents the list command
 var listCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List available Cloudflare zones",
 	Long:  `List all zones in your Cloudflare account.`,
+	Example: `  # List zones as a table
+  cfpurge list
+
+  # List zones as YAML for config-as-code diffs
+  cfpurge list --output=yaml`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if err := api.ValidateAuth(); err != nil {
 			return err
 		}
 
-		zones, err := api.ListZones(context.Background())
+		if err := util.ValidateOutputFormat(listOutput); err != nil {
+			return err
+		}
+
+		zones, err := api.ListZones(cmd.Context())
 		if err != nil {
 			return fmt.Errorf("error listing zones: %w", err)
 		}
 
+		zones, err = api.ApplyZoneTag(zones, listZoneTag)
+		if err != nil {
+			return err
+		}
+
+		if util.IsStructuredOutput(listOutput) {
+			infos := make([]zoneInfo, len(zones))
+			for i, zone := range zones {
+				infos[i] = zoneInfo{Name: zone.Name, ID: zone.ID, Status: zone.Status}
+			}
+			return util.WriteOutput(os.Stdout, listOutput, infos)
+		}
+
 		fmt.Println("\nAvailable zones:")
-		fmt.Printf("%-40s %-30s %s\n", "Domain", "Zone ID", "Status")
-		fmt.Println(strings.Repeat("-", 80))
+		table := util.NewTable("Domain", "Zone ID", "Status")
 		for _, zone := range zones {
-			fmt.Printf("%-40s %-30s %s\n", zone.Name, zone.ID, zone.Status)
+			table.AddRow(zone.Name, zone.ID, zone.Status)
 		}
+		table.Print()
 
 		return nil
 	},
 }
+
+func init() {
+	listCmd.Flags().StringVar(&listZoneTag, "zone-tag", "", "Only list zones whose account or owner matches this tag")
+	listCmd.Flags().StringVar(&listOutput, "output", util.OutputTable, "Output format (table, json, yaml)")
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"cfpurge/internal/api"
+	"cfpurge/internal/util"
+
+	"github.com/spf13/cobra"
+)
+
+var profilesOutput string
+
+// profileInfo is a credentials profile as shown by profiles list. Secrets are
+// never included.
+type profileInfo struct {
+	Name      string `json:"name" yaml:"name"`
+	Auth      string `json:"auth" yaml:"auth"`
+	AccountID string `json:"account_id,omitempty" yaml:"account_id,omitempty"`
+	Active    bool   `json:"active" yaml:"active"`
+}
+
+// profilesCmd groups the credentials profile commands
+var profilesCmd = &cobra.Command{
+	Use:   "profiles",
+	Short: "Manage credentials profiles",
+	Long: `Credentials profiles are named sets of credentials kept in a YAML
+credentials file (default ~/.config/cfpurge/credentials), selected with
+--profile. The file should only be readable by you:
+
+  agency-a:
+    token: <api-token>
+    account_id: <account-id>
+  agency-b:
+    key: <api-key>
+    email: ops@example.com
+    require_explicit_zones: true
+
+Flags given on the command line, such as --token, override the profile.
+With require_explicit_zones, purge --everything must name its zones rather
+than use --all or --account-all.`,
+}
+
+// profilesListCmd lists the profiles in the credentials file
+var profilesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List credentials profiles",
+	Example: `  # List profiles without showing their secrets
+  cfpurge profiles list
+
+  # Then purge with one of them
+  cfpurge purge --profile=agency-a --zone=example.com --everything`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := util.ValidateOutputFormat(profilesOutput); err != nil {
+			return err
+		}
+
+		path, err := credentialsPath()
+		if err != nil {
+			return err
+		}
+
+		profiles, err := api.LoadProfiles(path)
+		if err != nil {
+			return err
+		}
+		warnIfCredentialsExposed(path)
+
+		infos := make([]profileInfo, len(profiles))
+		for i, profile := range profiles {
+			auth := "token"
+			if profile.ReadToken != "" || profile.WriteToken != "" {
+				auth = "read/write tokens"
+			} else if profile.APIToken == "" {
+				auth = "key"
+			}
+			infos[i] = profileInfo{Name: profile.Name, Auth: auth, AccountID: profile.AccountID, Active: profile.Name == cfgProfile}
+		}
+
+		if util.IsStructuredOutput(profilesOutput) {
+			return util.WriteOutput(os.Stdout, profilesOutput, infos)
+		}
+
+		if len(infos) == 0 {
+			util.Warning("No profiles in %s", path)
+			return nil
+		}
+
+		fmt.Printf("\nProfiles in %s:\n", path)
+		table := util.NewTable("", "Profile", "Auth", "Account ID")
+		for _, info := range infos {
+			marker := ""
+			if info.Active {
+				marker = "*"
+			}
+			table.AddRow(marker, info.Name, info.Auth, info.AccountID)
+		}
+		table.Print()
+
+		return nil
+	},
+}
+
+func init() {
+	profilesListCmd.Flags().StringVar(&profilesOutput, "output", util.OutputTable, "Output format (table, json, yaml)")
+	profilesCmd.AddCommand(profilesListCmd)
+}
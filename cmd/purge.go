@@ -1,9 +1,16 @@
 package cmd
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"cfpurge/internal/api"
 	"cfpurge/internal/util"
@@ -13,14 +20,94 @@ import (
 )
 
 var (
-	purgeHosts      string
-	purgeURLs       string
-	purgeTags       string
-	purgeAll        bool
-	purgeEverything bool
-	purgeQuiet      bool
+	purgeHosts       string
+	purgeURLs        string
+	purgeURLsFile    string
+	purgeSitemap     string
+	purgeChangesFile string
+	purgeBaseURL     string
+	purgeGitDiff     string
+	purgeGitBaseURL  string
+	purgeGitRoot     string
+	purgeImages      string
+	purgeImgVariants string
+	purgeImgDomain   string
+	purgeImgAccount  string
+	purgeTags        string
+	purgeTagsFile    string
+	purgeTagsFormat  string
+	purgeTagsColumn  string
+	purgeTagPrefix   string
+	purgeAll         bool
+	purgeAccountAll  bool
+	purgeAllAccounts bool
+	purgeYes         bool
+	purgeIncludeZone string
+	purgeExcludeZone string
+	purgeEverything  bool
+	purgeDevices     string
+	purgeQuiet       bool
+	purgeFailFast    bool
+	purgeDryRun      bool
+	purgeDryRunOut   string
+	purgeShowReqs    bool
+	purgeIfChanged   bool
+	purgeHashFile    string
+	purgeFromPlan    string
+	purgeRetryFailed string
+	purgeFailuresOut string
+	purgeReport      string
+	purgeErrorEmpty  bool
+	purgeAt          string
+	purgeAfter       time.Duration
+	purgeConcurrency util.Concurrency
+	purgeZoneConc    int
+	purgeBatchSize   int
+	purgeCacheZones  bool
+	purgeQueryVars   string
+	purgeQueryFile   string
+	purgeRefreshZone bool
+	purgeVerbose     bool
+	purgeSort        string
+	purgeZoneTag     string
+	purgeStrict      bool
+	purgeOutput      string
+	purgeMethod      string
+	purgeVerifyWait  time.Duration
+	purgeVerifyHost  string
+	purgeVerifyAddrs string
+	purgeReserve     bool
+	purgeRepeat      time.Duration
+	purgeRepeatCount int
+	purgeStaleSince  time.Duration
+
+	purgeRequireExplicitZones bool
+)
+
+// Purge methods accepted by --method
+const (
+	purgeMethodAPI       = "api"
+	purgeMethodGetVerify = "get-verify"
 )
 
+// zoneSummary is the structured form of an api.ZoneResult
+type zoneSummary struct {
+	Zone   string `json:"zone" yaml:"zone"`
+	Purged string `json:"purged" yaml:"purged"`
+	Error  string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// purgeSummary is the structured output of a purge run
+type purgeSummary struct {
+	util.ResultSummary `yaml:",inline"`
+	Account            string                   `json:"account,omitempty" yaml:"account,omitempty"`
+	Deduplicated       int                      `json:"deduplicated,omitempty" yaml:"deduplicated,omitempty"`
+	Zones              []zoneSummary            `json:"zones" yaml:"zones"`
+	NotAttempted       []string                 `json:"not_attempted,omitempty" yaml:"not_attempted,omitempty"`
+	Verification       []util.VerifyResult      `json:"verification,omitempty" yaml:"verification,omitempty"`
+	CacheReserve       []api.CacheReserveResult `json:"cache_reserve,omitempty" yaml:"cache_reserve,omitempty"`
+}
+
 // purgeCmd represents the purge command
 var purgeCmd = &cobra.Command{
 	Use:   "purge",
@@ -33,181 +120,1270 @@ var purgeCmd = &cobra.Command{
   cfpurge purge --all --hosts="api.example.com,www.example.com"
   
   # Purge specific URLs from a zone
-  cfpurge purge --urls="https://example.com/page1" example.com`,
+  cfpurge purge --urls="https://example.com/page1" example.com
+  
+  # Purge the tags in the "tag" column of a CSV manifest
+  cfpurge purge --all --tags-file=tags.csv --tags-file-format=csv
+  
+  # Purge the same tags in different environments
+  cfpurge purge --all --tags="header,footer" --tag-prefix="staging:"
+  
+  # Purge everything from the zones belonging to one account
+  cfpurge purge --all --everything --zone-tag="Team X"
+  
+  # Purge everything from every zone in the selected account, and no other
+  cfpurge purge --account-all --everything --account=<account-id>
+  
+  # Purge a list of changed URLs, each sent to the zone it belongs to
+  cfpurge purge --urls-file=changed-urls.txt
+  
+  # Purge the assets listed in a build tool's JSON manifest of {path, zone} objects
+  cfpurge purge --changes-file=changes.json --base-url="https://{zone}/static/{path}" --dry-run
+  
+  # Purge the files changed since the previous commit, served from public/
+  cfpurge purge --git-diff=HEAD~1 --git-base-url="https://example.com/{path}" --git-root=public --dry-run
+  
+  # In CI, purge only the URLs whose content changed since the last run
+  cfpurge purge --urls-file=assets.txt --if-changed --hash-file=.cfpurge-hashes.json
+  
+  # Show the exact API request bodies a purge would send, batched as in a real run
+  cfpurge purge --urls-file=changed-urls.txt --dry-run --show-requests
+  
+  # Purge every page listed in a sitemap (indexes are followed)
+  cfpurge purge --sitemap=https://example.com/sitemap.xml --dry-run
+  
+  # Show a per-zone results table with failures first
+  cfpurge purge --all --everything --verbose
+  
+  # Purge only the URLs whose cached copy is older than a day, found in the
+  # zone's request logs (Enterprise zones with Logpull)
+  cfpurge purge --stale-since=24h example.com
+  
+  # Purge at a set time, e.g. alongside a coordinated release
+  cfpurge purge --everything example.com --at=2024-06-01T09:00:00Z
+  
+  # Purge a short-lived cache every 5 minutes, 12 times
+  cfpurge purge --tags=prices --all --repeat=5m --repeat-count=12
+  
+  # Record failed targets, then retry only those
+  cfpurge purge --urls-file=changed-urls.txt --failures-output=failed.json
+  cfpurge purge --retry-failed=failed.json
+  
+  # Purge everything, including what is stored in Cache Reserve
+  cfpurge purge --everything example.com --purge-cache-reserve
+  
+  # Purge URLs, then fetch them to confirm the cache serves fresh content
+  cfpurge purge --urls-file=changed-urls.txt --method=get-verify`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if err := api.ValidateAuth(); err != nil {
 			return err
 		}
 
-		client, err := api.GetClient()
+		if purgeSort != "status" && purgeSort != "name" {
+			return fmt.Errorf("invalid sort order '%s': must be 'status' or 'name'", purgeSort)
+		}
+
+		if err := purgeConcurrency.Validate(); err != nil {
+			return err
+		}
+
+		if purgeZoneConc < 1 {
+			return fmt.Errorf("--zone-concurrency must be at least 1")
+		}
+
+		if err := util.ValidateBatchSize(purgeBatchSize, api.PurgeBatchSize); err != nil {
+			return err
+		}
+
+		if err := util.ValidateOutputFormat(purgeOutput); err != nil {
+			return err
+		}
+
+		if purgeMethod != purgeMethodAPI && purgeMethod != purgeMethodGetVerify {
+			return fmt.Errorf("invalid purge method '%s': must be '%s' or '%s'", purgeMethod, purgeMethodAPI, purgeMethodGetVerify)
+		}
+
+		// Keep structured output parseable by dropping per-zone success messages
+		// and printing progress and warnings to stderr
+		if util.IsStructuredOutput(purgeOutput) {
+			purgeQuiet = true
+		}
+		util.SetOutputFor(purgeOutput)
+
+		// Writing a plan file implies a dry run
+		if purgeDryRunOut != "" {
+			purgeDryRun = true
+		}
+
+		if err := api.ValidateDeviceTypes(util.SplitCommaList(purgeDevices)); err != nil {
+			return fmt.Errorf("invalid --device-types: %w", err)
+		}
+
+		if purgeDevices != "" && purgeEverything {
+			return fmt.Errorf("--device-types cannot be combined with --everything")
+		}
+
+		if (purgeVerifyHost != "" || purgeVerifyAddrs != "") && purgeMethod != purgeMethodGetVerify {
+			return fmt.Errorf("--verify-host and --verify-resolve require --method=%s", purgeMethodGetVerify)
+		}
+
+		if err := util.SetVerifyOverrides(purgeVerifyHost, util.SplitCommaList(purgeVerifyAddrs)); err != nil {
+			return fmt.Errorf("invalid --verify-resolve: %w", err)
+		}
+
+		if purgeShowReqs && !purgeDryRun {
+			return fmt.Errorf("--show-requests requires --dry-run")
+		}
+
+		if purgeAccountAll {
+			if purgeAll {
+				return fmt.Errorf("--account-all cannot be combined with --all")
+			}
+			if err := api.ValidateAccountID(); err != nil {
+				return fmt.Errorf("--account-all needs an account: %w", err)
+			}
+		}
+
+		if (purgeIncludeZone != "" || purgeExcludeZone != "") && !purgeAll && !purgeAccountAll && !purgeAllAccounts {
+			return fmt.Errorf("--include-zones and --exclude-zones require --all, --account-all or --all-accounts")
+		}
+
+		if purgeAllAccounts {
+			if err := validateAllAccounts(cmd, args); err != nil {
+				return err
+			}
+		}
+
+		if cmd.Flags().Changed("tags-column") && purgeTagsFormat != util.TagsFileCSV {
+			return fmt.Errorf("--tags-column requires --tags-file-format=csv")
+		}
+
+		if cmd.Flags().Changed("base-url") && purgeChangesFile == "" {
+			return fmt.Errorf("--base-url requires --changes-file")
+		}
+
+		if (purgeGitDiff == "") != (purgeGitBaseURL == "") {
+			return fmt.Errorf("--git-diff and --git-base-url must be given together")
+		}
+
+		if purgeGitRoot != "" && purgeGitDiff == "" {
+			return fmt.Errorf("--git-root requires --git-diff")
+		}
+
+		if purgeGitBaseURL != "" {
+			if err := util.ValidateURL(util.URLForPath(purgeGitBaseURL, "index.html")); err != nil {
+				return fmt.Errorf("invalid --git-base-url: %w", err)
+			}
+		}
+
+		if purgeRepeat < 0 || purgeRepeatCount < 0 {
+			return fmt.Errorf("--repeat and --repeat-count must not be negative")
+		}
+
+		if purgeRepeatCount > 0 && purgeRepeat == 0 {
+			return fmt.Errorf("--repeat-count requires --repeat")
+		}
+
+		if purgeRepeat > 0 && purgeRepeat < time.Second {
+			return fmt.Errorf("--repeat must be at least 1s, got %s", purgeRepeat)
+		}
+
+		if purgeStaleSince < 0 {
+			return fmt.Errorf("--stale-since must not be negative")
+		}
+
+		if purgeStaleSince > 0 {
+			if len(args) == 0 {
+				return fmt.Errorf("--stale-since needs the zones to search named as arguments")
+			}
+			if purgeEverything || purgeAll || purgeAccountAll {
+				return fmt.Errorf("--stale-since cannot be combined with --everything, --all or --account-all")
+			}
+		}
+
+		if purgeEverything && (purgeAll || purgeAccountAll) && requireExplicitZones(cmd) {
+			return fmt.Errorf("--everything with --all or --account-all is disabled by the explicit zones policy; name the zones to purge, or for a deliberate purge of every zone pass --require-explicit-zones=false")
+		}
+
+		if purgeRetryFailed != "" && purgeFromPlan != "" {
+			return fmt.Errorf("--retry-failed cannot be combined with --from-plan")
+		}
+
+		if purgeIfChanged != (purgeHashFile != "") {
+			return fmt.Errorf("--if-changed and --hash-file must be given together")
+		}
+
+		if purgeIfChanged && (purgeFromPlan != "" || purgeRetryFailed != "" || purgeRepeat > 0) {
+			return fmt.Errorf("--if-changed cannot be combined with --from-plan, --retry-failed or --repeat")
+		}
+
+		startAt, err := util.ParseSchedule(purgeAt, purgeAfter, time.Now())
 		if err != nil {
 			return err
 		}
 
-		zoneArgs := args
-		if len(zoneArgs) == 0 && !purgeAll && purgeHosts == "" && purgeURLs == "" && purgeTags == "" {
-			return fmt.Errorf("must specify at least one zone, use --all flag, or provide hosts/urls/tags")
+		if purgeAllAccounts {
+			return purgeEveryAccount(cmd, startAt)
 		}
 
-		zones, err := api.ListZones(context.Background())
+		client, err := api.GetClient(api.WriteAccess)
 		if err != nil {
-			return fmt.Errorf("error getting zones: %w", err)
+			return err
 		}
 
-		zoneMap := make(map[string]cloudflare.Zone)
-		for _, zone := range zones {
-			zoneMap[zone.Name] = zone
-			zoneMap[zone.ID] = zone
+		// Ctrl-C cancels a scheduled wait or stops sending further requests
+		ctx := cmd.Context()
+
+		opts, err := purgeOptionsFromFlags(ctx, args)
+		if err != nil {
+			return err
 		}
 
-		var targetZones []cloudflare.Zone
-		if purgeAll {
-			targetZones = zones
-		} else if len(zoneArgs) > 0 {
-			for _, arg := range zoneArgs {
-				if zone, ok := zoneMap[arg]; ok {
-					targetZones = append(targetZones, zone)
-				} else {
-					util.Warning("Zone '%s' not found", arg)
-				}
+		if purgeStaleSince > 0 {
+			staleURLs, err := findStaleURLs(ctx, client, args, purgeStaleSince)
+			if err != nil {
+				return err
 			}
-		} else if purgeHosts != "" || purgeURLs != "" {
-			hostsList := util.SplitCommaList(purgeHosts)
-			urlsList := util.SplitCommaList(purgeURLs)
+			opts.URLs = append(opts.URLs, staleURLs...)
+		}
 
-			for _, zone := range zones {
-				shouldInclude := false
+		// A commit that changed no served files is not an error in a pipeline
+		if purgeGitDiff != "" && !hasPurgeTargets(opts) {
+			return util.NothingMatched(fmt.Sprintf("files changed since %s", purgeGitDiff), purgeErrorEmpty)
+		}
 
-				for _, host := range hostsList {
-					if strings.HasSuffix(host, zone.Name) {
-						shouldInclude = true
-						break
-					}
-				}
+		var hashes map[string]string
+		var manifest util.HashManifest
+		if purgeIfChanged {
+			opts.URLs, hashes, manifest, err = filterUnchangedURLs(ctx, opts.URLs)
+			if err != nil {
+				return err
+			}
+			if !hasPurgeTargets(opts) {
+				return util.NothingMatched("URLs with changed content", purgeErrorEmpty)
+			}
+		}
 
-				for _, url := range urlsList {
-					if strings.Contains(url, zone.Name) {
-						shouldInclude = true
-						break
-					}
-				}
+		var plan *util.Plan
+		if purgeFromPlan != "" {
+			// Execute exactly what was reviewed, without re-discovering zones
+			plan, err = util.ReadPlan(purgeFromPlan, "purge")
+			if err != nil {
+				return err
+			}
+			util.Info("Loaded plan from %s created at %s covering %d zones", purgeFromPlan, plan.CreatedAt.Format(time.RFC3339), len(plan.Zones))
+		} else if purgeRetryFailed != "" {
+			// A failures file is a plan holding only what failed last time
+			plan, err = util.ReadPlan(purgeRetryFailed, "purge")
+			if err != nil {
+				return err
+			}
+			util.Info("Retrying %d failed zones from %s", len(plan.Zones), purgeRetryFailed)
+		} else {
+			plan, err = api.PlanPurge(ctx, client, opts)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(plan.Zones) == 0 {
+			return util.NothingMatched("zones", purgeErrorEmpty)
+		}
+
+		if purgeDryRun {
+			if purgeReserve {
+				util.Info("Cache Reserve would also be cleared for each zone where it is supported and disabled")
+			}
+			if err := printPurgePlan(plan, purgeDryRunOut); err != nil {
+				return err
+			}
+			if purgeShowReqs {
+				return printPurgeRequests(plan, client.BaseURL, purgeBatchSize)
+			}
+			return nil
+		}
 
-				if shouldInclude {
-					targetZones = append(targetZones, zone)
-				}
+		var verifyURLs []string
+		if purgeMethod == purgeMethodGetVerify {
+			verifyURLs = planURLs(plan)
+			if len(verifyURLs) == 0 {
+				return fmt.Errorf("--method=%s needs URLs to fetch; host, tag and everything purges cannot be verified", purgeMethodGetVerify)
 			}
+		}
+
+		// The plan is built first so that mistakes surface before the wait
+		if !startAt.IsZero() {
+			if err := util.WaitUntil(ctx, startAt, os.Stderr); err != nil {
+				return fmt.Errorf("scheduled purge cancelled: %w", err)
+			}
+		}
+
+		if purgeRepeat > 0 {
+			return repeatPurge(ctx, client, plan, opts, verifyURLs)
+		}
+
+		results, err := executePurge(ctx, client, plan, opts, verifyURLs)
+		if purgeIfChanged {
+			if hashErr := recordPurgedHashes(results, hashes, manifest); hashErr != nil && err == nil {
+				err = hashErr
+			}
+		}
+		return err
+	},
+}
+
+// hasPurgeTargets reports whether opts still hold anything to purge once
+// filters such as --git-diff and --if-changed have been applied
+func hasPurgeTargets(opts api.PurgeOptions) bool {
+	return len(opts.URLs) > 0 || len(opts.Hosts) > 0 || len(opts.Tags) > 0 || opts.Everything
+}
+
+// filterUnchangedURLs fetches and hashes each URL and drops those whose
+// content matches the hash recorded in --hash-file. Wildcard URLs cannot be
+// fetched and are always kept, as are URLs that fail to fetch.
+func filterUnchangedURLs(ctx context.Context, urls []string) ([]string, map[string]string, util.HashManifest, error) {
+	manifest, err := util.ReadHashManifest(purgeHashFile)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var kept, fetchable []string
+	for _, rawURL := range urls {
+		normalized, err := util.NormalizeURL(rawURL)
+		if err != nil || strings.HasSuffix(rawURL, "/*") {
+			kept = append(kept, rawURL)
+			continue
+		}
+		fetchable = append(fetchable, normalized)
+	}
+	fetchable = util.FilterDuplicates(fetchable)
+
+	util.Info("Fetching %d URLs to compare their content with %s", len(fetchable), purgeHashFile)
+	hashes, errs := util.HashURLs(ctx, fetchable, verifyConcurrency())
+	if err := util.Interrupted(ctx); err != nil {
+		return nil, nil, nil, err
+	}
+	for u, err := range errs {
+		util.Warning("Purging %s without comparing its content: %v", u, err)
+	}
+
+	changed := util.ChangedURLs(fetchable, hashes, manifest)
+	util.Info("%d of %d URLs changed since they were last purged", len(changed), len(fetchable))
+	return append(kept, changed...), hashes, manifest, nil
+}
+
+// recordPurgedHashes stores the content hash of every URL whose purge was
+// accepted in --hash-file, so that the next run skips it until it changes
+func recordPurgedHashes(results api.Results, hashes map[string]string, manifest util.HashManifest) error {
+	recorded := 0
+	for _, u := range purgedURLs(results) {
+		if hash, ok := hashes[u]; ok {
+			manifest[u] = hash
+			recorded++
+		}
+	}
+	if recorded == 0 {
+		return nil
+	}
+
+	if err := util.WriteHashManifest(purgeHashFile, manifest); err != nil {
+		return err
+	}
+	util.Info("Recorded the content hashes of %d purged URLs in %s", recorded, purgeHashFile)
+	return nil
+}
+
+// executePurge runs a purge plan once, clearing Cache Reserve and verifying
+// URLs as requested, and reports the results
+func executePurge(ctx context.Context, client *cloudflare.API, plan *util.Plan, opts api.PurgeOptions, verifyURLs []string) (api.Results, error) {
+	var before map[string]util.CacheSnapshot
+	if len(verifyURLs) > 0 {
+		util.Info("Recording the cached state of %d URLs before purging", len(verifyURLs))
+		before = util.SnapshotURLs(ctx, verifyURLs, verifyConcurrency())
+	}
+
+	if plan.Account != "" {
+		util.Info("Purging %d zones in account %s", len(plan.Zones), plan.Account)
+	}
+
+	purgedAt := time.Now()
+	results := api.ExecutePurgePlan(ctx, client, plan, opts)
+	if results.Deduplicated > 0 {
+		util.Info("Skipped %d duplicate purge targets", results.Deduplicated)
+	}
+
+	if purgeFailuresOut != "" {
+		failures := api.FailuresPlan(results)
+		if err := util.WritePlan(purgeFailuresOut, failures); err != nil {
+			return results, err
+		}
+		util.Info("Recorded %d failed zones in %s", len(failures.Zones), purgeFailuresOut)
+	}
+
+	if purgeReport != "" {
+		if err := util.WriteHTMLReport(purgeReport, util.NewReport("Cache purge", purgedAt, results.Results)); err != nil {
+			return results, err
+		}
+		util.Info("Wrote report to %s", purgeReport)
+	}
 
-			if len(targetZones) == 0 {
-				return fmt.Errorf("no matching zones found for the specified hosts/URLs")
+	var reserve []api.CacheReserveResult
+	if purgeReserve && ctx.Err() == nil {
+		reserve = api.ClearCacheReserves(ctx, client, results)
+		for _, result := range reserve {
+			switch result.Status {
+			case api.CacheReserveCleared:
+				util.Audit(result.Zone, []string{"cache-reserve"}, nil)
+			case api.CacheReserveError:
+				util.Audit(result.Zone, []string{"cache-reserve"}, errors.New(result.Detail))
 			}
 		}
+	}
 
-		successCount := 0
-		failureCount := 0
+	var verification []util.VerifyResult
+	if len(verifyURLs) > 0 && ctx.Err() == nil {
+		// Only URLs whose purge was accepted can be expected to be fresh
+		purged := purgedURLs(results)
+		if len(purged) > 0 {
+			util.Info("Verifying %d purged URLs (waiting up to %s for the purge to propagate)", len(purged), purgeVerifyWait)
+			verification = util.VerifyPurge(ctx, purged, before, purgedAt, util.VerifyOptions{
+				Timeout:     purgeVerifyWait,
+				Interval:    2 * time.Second,
+				Concurrency: verifyConcurrency(),
+			})
+		}
+	}
+
+	if util.IsStructuredOutput(purgeOutput) {
+		summary := newPurgeSummary(results)
+		summary.Account = plan.Account
+		summary.Verification = verification
+		summary.CacheReserve = reserve
+		if err := util.WriteOutput(os.Stdout, purgeOutput, summary); err != nil {
+			return results, err
+		}
+	} else {
+		if purgeVerbose {
+			printZoneResults(results.Zones, purgeSort)
+		}
+		if len(reserve) > 0 {
+			printCacheReserveResults(reserve)
+		}
+		if len(verification) > 0 {
+			printVerifyResults(verification)
+		}
+		results.Print()
+		printUnpurgedZones(results)
+	}
+
+	if err := util.Interrupted(ctx); err != nil {
+		return results, err
+	}
+
+	if purgeFailFast && results.Err() != nil {
+		return results, fmt.Errorf("aborted due to --fail-fast after the first failed zone")
+	}
+
+	reserveFailed := 0
+	for _, result := range reserve {
+		if result.Status == api.CacheReserveError {
+			reserveFailed++
+		}
+	}
+	if reserveFailed > 0 {
+		return results, fmt.Errorf("%d zones failed to clear Cache Reserve", reserveFailed)
+	}
 
-		for _, zone := range targetZones {
-			if purgeEverything {
-				_, err := client.PurgeEverything(context.Background(), zone.ID)
-				if err != nil {
-					util.Error("Error purging everything from %s: %v", zone.Name, err)
-					failureCount++
-					continue
-				}
-				if !purgeQuiet {
-					util.Success("Successfully purged everything from %s", zone.Name)
-				}
-				successCount++
+	stale := 0
+	for _, result := range verification {
+		if result.Status == util.VerifyStale {
+			stale++
+		}
+	}
+	if stale > 0 {
+		return results, fmt.Errorf("%d URLs still served stale cached content after the purge", stale)
+	}
+	return results, nil
+}
+
+// purgeOptionsFromFlags collects the purge options given on the command line
+func purgeOptionsFromFlags(ctx context.Context, zoneArgs []string) (api.PurgeOptions, error) {
+	var accountID string
+	if purgeAccountAll {
+		accountID = api.GetAccountID()
+	}
+
+	opts := api.PurgeOptions{
+		Zones:           zoneArgs,
+		Hosts:           util.SplitCommaList(purgeHosts),
+		URLs:            util.SplitCommaList(purgeURLs),
+		Tags:            util.SplitCommaList(purgeTags),
+		All:             purgeAll || purgeAccountAll,
+		Everything:      purgeEverything,
+		DeviceTypes:     util.FilterDuplicates(util.SplitCommaList(purgeDevices)),
+		ZoneTag:         purgeZoneTag,
+		IncludeZones:    util.SplitCommaList(purgeIncludeZone),
+		ExcludeZones:    util.SplitCommaList(purgeExcludeZone),
+		CacheZones:      purgeCacheZones,
+		RefreshZones:    purgeRefreshZone,
+		AccountID:       accountID,
+		Strict:          purgeStrict,
+		BatchSize:       purgeBatchSize,
+		Concurrency:     purgeConcurrency.N,
+		AutoConcurrency: purgeConcurrency.Auto,
+		ZoneConcurrency: purgeZoneConc,
+		FailFast:        purgeFailFast,
+		Verbose:         purgeVerbose,
+		Infof:           util.Info,
+		Warnf:           util.Warning,
+		OnZoneDone: func(result api.ZoneResult) {
+			reportZoneResult(result, purgeQuiet)
+			auditZoneResult(result)
+		},
+	}
+
+	if purgeURLsFile != "" {
+		fileURLs, err := util.ReadLines(purgeURLsFile)
+		if err != nil {
+			return opts, fmt.Errorf("error reading URLs file: %w", err)
+		}
+		opts.URLs = append(opts.URLs, fileURLs...)
+	}
+
+	if purgeTagsFile != "" {
+		fileTags, err := util.ReadTagsFile(purgeTagsFile, purgeTagsFormat, purgeTagsColumn)
+		if err != nil {
+			return opts, err
+		}
+		util.Info("Loaded %d tags from %s", len(fileTags), purgeTagsFile)
+		opts.Tags = append(opts.Tags, fileTags...)
+	}
+	opts.Tags = util.PrefixCacheTags(opts.Tags, purgeTagPrefix)
+
+	if purgeChangesFile != "" {
+		changes, err := util.ReadChangesFile(purgeChangesFile, purgeBaseURL)
+		if err != nil {
+			return opts, err
+		}
+		for _, zone := range changes {
+			util.Info("Found %d changed assets for %s in %s", len(zone.URLs), zone.Zone, purgeChangesFile)
+			opts.URLs = append(opts.URLs, zone.URLs...)
+		}
+	}
+
+	if purgeGitDiff != "" {
+		files, err := util.GitDiffFiles(ctx, purgeGitDiff)
+		if err != nil {
+			return opts, err
+		}
+		gitURLs, skipped := util.GitDiffURLs(files, purgeGitRoot, purgeGitBaseURL)
+		for _, file := range skipped {
+			util.Warning("Skipping changed file %s: it does not map to a URL", file)
+		}
+		util.Info("Found %d changed files since %s, mapped to %d URLs", len(files), purgeGitDiff, len(gitURLs))
+		opts.URLs = append(opts.URLs, gitURLs...)
+	}
+
+	if purgeSitemap != "" {
+		sitemapURLs, err := util.ReadSitemap(ctx, purgeSitemap)
+		if err != nil {
+			return opts, err
+		}
+		util.Info("Found %d URLs in sitemap %s", len(sitemapURLs), purgeSitemap)
+		opts.URLs = append(opts.URLs, sitemapURLs...)
+	}
+
+	if purgeImages != "" {
+		imageURLs, err := util.ImageDeliveryURLs(purgeImgDomain, purgeImgAccount, util.SplitCommaList(purgeImages), util.SplitCommaList(purgeImgVariants))
+		if err != nil {
+			return opts, fmt.Errorf("error building image delivery URLs: %w", err)
+		}
+		opts.URLs = append(opts.URLs, imageURLs...)
+	}
+
+	queryVariants := util.SplitCommaList(purgeQueryVars)
+	if purgeQueryFile != "" {
+		fileVariants, err := util.ReadLines(purgeQueryFile)
+		if err != nil {
+			return opts, fmt.Errorf("error reading query variants file: %w", err)
+		}
+		queryVariants = append(queryVariants, fileVariants...)
+	}
+	if len(queryVariants) > 0 {
+		base := len(opts.URLs)
+		expanded, err := util.ExpandQueryVariants(opts.URLs, queryVariants)
+		if err != nil {
+			return opts, err
+		}
+		opts.URLs = expanded
+		util.Info("Expanded %d URLs into %d with query string variants", base, len(opts.URLs))
+	}
+
+	return opts, nil
+}
+
+// requireExplicitZones reports whether purge --everything must name its zones.
+// The policy comes from --require-explicit-zones, CFPURGE_REQUIRE_EXPLICIT_ZONES
+// or the active profile; giving the flag on the command line overrides the
+// profile.
+func requireExplicitZones(cmd *cobra.Command) bool {
+	if cmd.Flags().Changed("require-explicit-zones") {
+		return purgeRequireExplicitZones
+	}
+	return purgeRequireExplicitZones || activeProfile.RequireExplicitZones
+}
+
+// defaultPurgeConcurrency is how many purge requests are sent at once for a zone
+const defaultPurgeConcurrency = 5
+
+// verifyConcurrency is how many URLs are fetched at once to verify a purge.
+// These fetches go to the zone rather than the API, so with
+// --batch-concurrency=auto the default is used.
+func verifyConcurrency() int {
+	if purgeConcurrency.Auto {
+		return defaultPurgeConcurrency
+	}
+	return purgeConcurrency.N
+}
+
+// findStaleURLs searches the request logs of the named zones for URLs whose
+// cached copy is older than staleSince
+func findStaleURLs(ctx context.Context, client *cloudflare.API, zoneArgs []string, staleSince time.Duration) ([]string, error) {
+	zones, err := api.ListZones(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error listing zones: %w", err)
+	}
+
+	now := time.Now()
+	cutoff := now.Add(-staleSince)
+
+	var urls []string
+	for _, arg := range zoneArgs {
+		var zone *cloudflare.Zone
+		for i := range zones {
+			if zones[i].Name == arg || zones[i].ID == arg {
+				zone = &zones[i]
+				break
+			}
+		}
+		if zone == nil {
+			return nil, fmt.Errorf("zone '%s' not found", arg)
+		}
+
+		util.Info("Searching the request logs of %s since %s for URLs cached before then", zone.Name, cutoff.Format(time.RFC3339))
+		stale, err := api.StaleCachedURLs(ctx, client, zone.ID, cutoff, now)
+		if err != nil {
+			return nil, err
+		}
+		util.Info("Found %d URLs in %s cached before %s", len(stale), zone.Name, cutoff.Format(time.RFC3339))
+		urls = append(urls, stale...)
+	}
+
+	return urls, nil
+}
+
+// reportZoneResult prints the outcome of purging a single zone
+func reportZoneResult(result api.ZoneResult, quiet bool) {
+	zone := result.Zone
+	if result.Err != nil {
+		if zone.Everything {
+			util.Error("Error purging everything from %s: %v", zone.Name, result.Err)
+		} else {
+			util.Error("Error purging cache for %s: %v", zone.Name, result.Err)
+		}
+		return
+	}
+
+	if quiet {
+		return
+	}
+
+	if zone.Everything {
+		util.Success("Successfully purged everything from %s", zone.Name)
+		return
+	}
+	if len(zone.Hosts) > 0 {
+		util.Success("Purged hosts from %s: %s", zone.Name, strings.Join(zone.Hosts, ", "))
+	}
+	if len(zone.URLs) > 0 {
+		util.Success("Purged URLs from %s: %s", zone.Name, strings.Join(zone.URLs, ", "))
+	}
+	if len(zone.Prefixes) > 0 {
+		util.Success("Purged prefixes from %s: %s", zone.Name, strings.Join(zone.Prefixes, ", "))
+	}
+	if len(zone.Tags) > 0 {
+		util.Success("Purged tags from %s: %s", zone.Name, strings.Join(zone.Tags, ", "))
+	}
+}
+
+// auditZoneResult records what was purged from a zone in the audit log
+func auditZoneResult(result api.ZoneResult) {
+	zone := result.Zone
+	if zone.Everything {
+		util.Audit(zone.Name, []string{"everything"}, result.Err)
+		return
+	}
+
+	var targets []string
+	targets = append(targets, zone.Hosts...)
+	targets = append(targets, zone.URLs...)
+	for _, prefix := range zone.Prefixes {
+		targets = append(targets, "prefix:"+prefix)
+	}
+	for _, tag := range zone.Tags {
+		targets = append(targets, "tag:"+tag)
+	}
+	util.Audit(zone.Name, targets, result.Err)
+}
+
+// printPurgePlan shows what a dry run would purge and optionally saves the plan to a file
+func printPurgePlan(plan *util.Plan, output string) error {
+	if plan.Account != "" {
+		fmt.Printf("Dry run mode - would purge the following in account %s:\n", plan.Account)
+	} else {
+		fmt.Println("Dry run mode - would purge the following:")
+	}
+	for _, zone := range plan.Zones {
+		fmt.Printf("  %s: %s\n", zone.Name, api.DescribePurge(zone))
+	}
+
+	if output != "" {
+		if err := util.WritePlan(output, plan); err != nil {
+			return err
+		}
+		util.Success("Plan written to %s", output)
+	}
+
+	return nil
+}
+
+// printPurgeRequests prints, for each zone, the API requests a purge would send
+// with their JSON bodies, so they can be compared with the API documentation or
+// replayed with curl. Credentials are sent as headers and are not shown.
+func printPurgeRequests(plan *util.Plan, baseURL string, batchSize int) error {
+	fmt.Println("\nRequests that would be sent (authentication headers omitted):")
+	for _, zone := range plan.Zones {
+		requests, err := api.ZoneAPIRequests(baseURL, zone, batchSize)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("  %s: %d requests\n", zone.Name, len(requests))
+		for _, req := range requests {
+			fmt.Printf("    %s %s\n", req.Method, req.URL)
+			fmt.Printf("    %s\n", req.Body)
+		}
+	}
+	return nil
+}
+
+// printUnpurgedZones lists the zones that failed or were skipped, with how to
+// re-run only those
+func printUnpurgedZones(results api.Results) {
+	zones := api.UnpurgedZones(results)
+	if len(zones) == 0 {
+		return
+	}
+
+	util.Error("%d zones were not purged: %s", len(zones), strings.Join(zones, ", "))
+	if purgeFailuresOut != "" {
+		util.Printf("Re-run only these with: cfpurge purge --retry-failed=%s\n", purgeFailuresOut)
+	} else {
+		util.Printf("Re-run only these by passing them as zones, e.g. cfpurge purge %s <same options>, or use --failures-output and --retry-failed next time\n", strings.Join(zones, " "))
+	}
+}
+
+// printZoneResults prints a per-zone results table, either with failures first or by zone name
+func printZoneResults(results []api.ZoneResult, order string) {
+	sort.SliceStable(results, func(i, j int) bool {
+		if order == "status" && (results[i].Err != nil) != (results[j].Err != nil) {
+			return results[i].Err != nil
+		}
+		return results[i].Zone.Name < results[j].Zone.Name
+	})
+
+	util.Header("Per-zone results")
+	widths := []int{40, 25, 15}
+	util.TableHeader([]string{"Zone", "Purged", "Status"}, widths)
+	for _, result := range results {
+		status := "OK"
+		if result.Err != nil {
+			status = "FAILED"
+		}
+		util.TableRow([]string{result.Zone.Name, result.Purged, status}, widths)
+	}
+}
+
+// planURLs returns every URL the plan purges
+func planURLs(plan *util.Plan) []string {
+	var urls []string
+	for _, zone := range plan.Zones {
+		urls = append(urls, zone.URLs...)
+	}
+	return util.FilterDuplicates(urls)
+}
+
+// purgedURLs returns the URLs whose purge requests succeeded
+func purgedURLs(results api.Results) []string {
+	var urls []string
+	for _, result := range results.Zones {
+		failed := make(map[string]bool, len(result.Failed.URLs))
+		for _, url := range result.Failed.URLs {
+			failed[url] = true
+		}
+		for _, url := range result.Zone.URLs {
+			if !failed[url] {
+				urls = append(urls, url)
+			}
+		}
+	}
+	return util.FilterDuplicates(urls)
+}
+
+// printVerifyResults prints the outcome of fetching each purged URL, warning
+// about those still served from stale cache
+func printVerifyResults(results []util.VerifyResult) {
+	util.Header("Purge verification")
+	widths := []int{60, 12, 40}
+	util.TableHeader([]string{"URL", "Status", "Detail"}, widths)
+	for _, result := range results {
+		util.TableRow([]string{result.URL, result.Status, result.Detail}, widths)
+	}
+
+	for _, result := range results {
+		switch result.Status {
+		case util.VerifyStale:
+			util.Warning("%s is still served from stale cache", result.URL)
+		case util.VerifyNotCached:
+			util.Info("%s is not served from cache; nothing to verify", result.URL)
+		}
+	}
+}
+
+// repeatPurge executes the plan every purgeRepeat until purgeRepeatCount
+// cycles have run or ctx is cancelled, printing a timestamped summary of each
+// cycle. Failed cycles are reported and the next one still runs, unless
+// --fail-fast is given.
+func repeatPurge(ctx context.Context, client *cloudflare.API, plan *util.Plan, opts api.PurgeOptions, verifyURLs []string) error {
+	cycles, failedCycles := 0, 0
+	for purgeRepeatCount == 0 || cycles < purgeRepeatCount {
+		if cycles > 0 {
+			timer := time.NewTimer(purgeRepeat)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+			case <-timer.C:
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+
+		cycles++
+		util.Info("Cycle %d started at %s", cycles, time.Now().Format(time.RFC3339))
+		results, err := executePurge(ctx, client, plan, opts, verifyURLs)
+		summary := results.Summary()
+		util.Info("Cycle %d finished at %s: %d zones purged, %d failed", cycles, time.Now().Format(time.RFC3339), summary.Successful, summary.Failed)
+
+		if errors.Is(err, util.ErrInterrupted) {
+			break
+		}
+		if err != nil || results.Err() != nil {
+			failedCycles++
+			if err != nil {
+				util.Error("Cycle %d failed: %v", cycles, err)
+			}
+			if purgeFailFast {
+				return fmt.Errorf("aborted due to --fail-fast after failed cycle %d", cycles)
+			}
+		}
+
+		if purgeRepeatCount == 0 || cycles < purgeRepeatCount {
+			util.Info("Next cycle at %s", time.Now().Add(purgeRepeat).Format(time.RFC3339))
+		}
+	}
+
+	util.Info("Ran %d purge cycles, %d with failures", cycles, failedCycles)
+
+	if err := util.Interrupted(ctx); err != nil {
+		return err
+	}
+	if failedCycles > 0 {
+		return fmt.Errorf("%d of %d purge cycles failed", failedCycles, cycles)
+	}
+	return nil
+}
+
+// accountPurge is the plan and outcome for one profile of an --all-accounts purge
+type accountPurge struct {
+	Profile api.Profile
+	Plan    *util.Plan
+	Results api.Results
+}
+
+// accountSummary is the structured form of an accountPurge
+type accountSummary struct {
+	Profile      string `json:"profile" yaml:"profile"`
+	purgeSummary `yaml:",inline"`
+}
+
+// validateAllAccounts rejects the options that name zones, select a single
+// profile, or write a single account's plan or failures, none of which fit a
+// purge of several accounts
+func validateAllAccounts(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 || purgeAll || purgeAccountAll {
+		return fmt.Errorf("--all-accounts cannot be combined with zone arguments, --all or --account-all; narrow it with --include-zones and --exclude-zones")
+	}
+	if cfgProfile != "" {
+		return fmt.Errorf("--all-accounts uses every profile in the credentials file and cannot be combined with --profile")
+	}
+	if purgeFromPlan != "" || purgeRetryFailed != "" || purgeDryRunOut != "" || purgeFailuresOut != "" || purgeReport != "" {
+		return fmt.Errorf("--all-accounts cannot be combined with --from-plan, --retry-failed, --dry-run-output, --failures-output or --report")
+	}
+	if purgeRepeat > 0 || purgeStaleSince > 0 || purgeIfChanged || purgeShowReqs || purgeReserve || purgeMethod != purgeMethodAPI {
+		return fmt.Errorf("--all-accounts cannot be combined with --repeat, --stale-since, --if-changed, --show-requests, --purge-cache-reserve or --method=%s", purgeMethodGetVerify)
+	}
+	if purgeEverything && requireExplicitZones(cmd) {
+		return fmt.Errorf("--everything with --all-accounts is disabled by the explicit zones policy; name the zones to purge, or for a deliberate purge of every zone pass --require-explicit-zones=false")
+	}
+	return nil
+}
+
+// profileClient returns a client with write access using the profile's
+// credentials and the global --rate-limit and --max-retries
+func profileClient(profile api.Profile) (*cloudflare.API, error) {
+	cfg := profile.Config()
+	cfg.RateLimit = cfgRateLimit
+	cfg.MaxRetries = cfgMaxRetries
+	api.SetConfig(cfg)
+
+	client, err := api.GetClient(api.WriteAccess)
+	if err != nil {
+		return nil, fmt.Errorf("error creating client for profile %s: %w", profile.Name, err)
+	}
+	return client, nil
+}
+
+// purgeEveryAccount runs the purge for every profile in the credentials file,
+// one account after another. Every account is planned before any is purged,
+// so that a mistake in one profile stops the run before anything is purged,
+// and the purge only starts once confirmed.
+func purgeEveryAccount(cmd *cobra.Command, startAt time.Time) error {
+	ctx := cmd.Context()
+
+	path, err := credentialsPath()
+	if err != nil {
+		return err
+	}
+	profiles, err := api.LoadProfiles(path)
+	if err != nil {
+		return err
+	}
+	if len(profiles) == 0 {
+		return fmt.Errorf("--all-accounts found no profiles in %s", path)
+	}
+	warnIfCredentialsExposed(path)
+
+	opts, err := purgeOptionsFromFlags(ctx, nil)
+	if err != nil {
+		return err
+	}
+	opts.All = true
+
+	var accounts []accountPurge
+	covered := make(map[string]string)
+	planned := make(map[string]bool)
+	total := 0
+	for _, profile := range profiles {
+		if profile.AccountID != "" {
+			if other, ok := covered[profile.AccountID]; ok {
+				util.Warning("Skipping profile %s: account %s is already covered by profile %s", profile.Name, profile.AccountID, other)
+				continue
+			}
+			covered[profile.AccountID] = profile.Name
+		}
+		if purgeEverything && profile.RequireExplicitZones && !cmd.Flags().Changed("require-explicit-zones") {
+			return fmt.Errorf("--everything with --all-accounts is disabled by the explicit zones policy of profile %s; for a deliberate purge of every zone pass --require-explicit-zones=false", profile.Name)
+		}
+
+		client, err := profileClient(profile)
+		if err != nil {
+			return err
+		}
+
+		accountOpts := opts
+		accountOpts.AccountID = profile.AccountID
+
+		util.Info("Planning purge for profile %s", profile.Name)
+		plan, err := api.PlanPurge(ctx, client, accountOpts)
+		if err != nil {
+			return fmt.Errorf("error planning purge for profile %s: %w", profile.Name, err)
+		}
+
+		// Profiles without an account ID may see zones another profile covers
+		zones := plan.Zones[:0]
+		for _, zone := range plan.Zones {
+			if planned[zone.ID] {
+				util.Info("Skipping zone %s for profile %s: it is already planned for another profile", zone.Name, profile.Name)
 				continue
 			}
+			planned[zone.ID] = true
+			zones = append(zones, zone)
+		}
+		plan.Zones = zones
 
-			var purgeHostsList []string
-			var purgeURLsList []string
-
-			if purgeHosts != "" {
-				for _, host := range util.SplitCommaList(purgeHosts) {
-					if strings.HasSuffix(host, zone.Name) {
-						purgeHostsList = append(purgeHostsList, host)
-					}
-				}
-			}
-
-			if purgeURLs != "" {
-				for _, url := range util.SplitCommaList(purgeURLs) {
-					if strings.Contains(url, zone.Name) {
-						purgeURLsList = append(purgeURLsList, url)
-					}
-				}
-			}
-
-			if len(purgeHostsList) > 0 || len(purgeURLsList) > 0 || purgeTags != "" {
-				var err error
-
-				if len(purgeHostsList) > 0 {
-					purgeReq := cloudflare.PurgeCacheRequest{
-						Hosts: purgeHostsList,
-					}
-					_, err = client.PurgeCache(context.Background(), zone.ID, purgeReq)
-				}
-
-				if len(purgeURLsList) > 0 {
-					purgeReq := cloudflare.PurgeCacheRequest{
-						Files: purgeURLsList,
-					}
-					_, err = client.PurgeCache(context.Background(), zone.ID, purgeReq)
-				}
-
-				if purgeTags != "" {
-					// Split tags into batches of 30 (Cloudflare's limit)
-					tagsList := util.SplitCommaList(purgeTags)
-					for i := 0; i < len(tagsList); i += 30 {
-						end := i + 30
-						if end > len(tagsList) {
-							end = len(tagsList)
-						}
-
-						batchTags := tagsList[i:end]
-						purgeReq := cloudflare.PurgeCacheRequest{
-							Tags: batchTags,
-						}
-						_, err = client.PurgeCache(context.Background(), zone.ID, purgeReq)
-
-						if err != nil {
-							break
-						}
-					}
-				}
-
-				if err != nil {
-					util.Error("Error purging cache for %s: %v", zone.Name, err)
-					failureCount++
-					continue
-				}
-
-				if !purgeQuiet {
-					if len(purgeHostsList) > 0 {
-						util.Success("Purged hosts from %s: %s", zone.Name, strings.Join(purgeHostsList, ", "))
-					}
-					if len(purgeURLsList) > 0 {
-						util.Success("Purged URLs from %s: %s", zone.Name, strings.Join(purgeURLsList, ", "))
-					}
-					if purgeTags != "" {
-						util.Success("Purged tags from %s: %s", zone.Name, purgeTags)
-					}
-				}
-				successCount++
-			}
-		}
-
-		util.PrettyPrintResults(successCount, failureCount)
+		accounts = append(accounts, accountPurge{Profile: profile, Plan: plan})
+		total += len(plan.Zones)
+	}
+
+	if total == 0 {
+		return util.NothingMatched("zones", purgeErrorEmpty)
+	}
+
+	if purgeDryRun {
+		for _, account := range accounts {
+			if len(account.Plan.Zones) == 0 {
+				continue
+			}
+			util.Header(fmt.Sprintf("Profile %s", account.Profile.Name))
+			if err := printPurgePlan(account.Plan, ""); err != nil {
+				return err
+			}
+		}
 		return nil
-	},
+	}
+
+	printAccountPlans(accounts)
+	if err := confirmAllAccounts(len(accounts), total); err != nil {
+		return err
+	}
+
+	if !startAt.IsZero() {
+		if err := util.WaitUntil(ctx, startAt, os.Stderr); err != nil {
+			return fmt.Errorf("scheduled purge cancelled: %w", err)
+		}
+	}
+
+	failedFast := false
+	for i := range accounts {
+		account := &accounts[i]
+		if len(account.Plan.Zones) == 0 || ctx.Err() != nil {
+			continue
+		}
+
+		client, err := profileClient(account.Profile)
+		if err != nil {
+			return err
+		}
+
+		util.Header(fmt.Sprintf("Profile %s", account.Profile.Name))
+		account.Results = api.ExecutePurgePlan(ctx, client, account.Plan, opts)
+		if purgeFailFast && account.Results.Err() != nil {
+			failedFast = true
+			break
+		}
+	}
+
+	if err := printAccountResults(accounts); err != nil {
+		return err
+	}
+
+	if err := util.Interrupted(ctx); err != nil {
+		return err
+	}
+
+	if failedFast {
+		return fmt.Errorf("aborted due to --fail-fast after the first failed zone")
+	}
+	return nil
+}
+
+// printAccountPlans lists how many zones each profile of an --all-accounts
+// purge would purge
+func printAccountPlans(accounts []accountPurge) {
+	util.Header("Zones to purge by account")
+	widths := []int{25, 40, 10}
+	util.TableHeader([]string{"Profile", "Account", "Zones"}, widths)
+	for _, account := range accounts {
+		util.TableRow([]string{account.Profile.Name, accountLabel(account), strconv.Itoa(len(account.Plan.Zones))}, widths)
+	}
+}
+
+// confirmAllAccounts asks for "yes" to be typed before an --all-accounts
+// purge, unless --yes was given. Without a terminal to ask on, --yes is
+// required.
+func confirmAllAccounts(accounts, zones int) error {
+	if purgeYes {
+		return nil
+	}
+	if !util.IsTerminal(os.Stdin) {
+		return fmt.Errorf("--all-accounts needs confirmation; pass --yes to purge without a terminal")
+	}
+
+	fmt.Fprintf(os.Stderr, "Purge %d zones across %d accounts? Type yes to continue: ", zones, accounts)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("error reading confirmation: %w", err)
+	}
+	if strings.TrimSpace(answer) != "yes" {
+		return fmt.Errorf("purge cancelled")
+	}
+	return nil
+}
+
+// printAccountResults reports the outcome of an --all-accounts purge grouped
+// by account, noting the accounts not attempted after --fail-fast or an
+// interrupt
+func printAccountResults(accounts []accountPurge) error {
+	if util.IsStructuredOutput(purgeOutput) {
+		summaries := make([]accountSummary, 0, len(accounts))
+		for _, account := range accounts {
+			if account.Results.Results == nil {
+				continue
+			}
+			summary := accountSummary{Profile: account.Profile.Name, purgeSummary: newPurgeSummary(account.Results)}
+			summary.Account = account.Plan.Account
+			summaries = append(summaries, summary)
+		}
+		return util.WriteOutput(os.Stdout, purgeOutput, summaries)
+	}
+
+	util.Header("Results by account")
+	widths := []int{25, 40, 12, 10}
+	util.TableHeader([]string{"Profile", "Account", "Successful", "Failed"}, widths)
+	var unpurged []string
+	for _, account := range accounts {
+		if len(account.Plan.Zones) == 0 {
+			continue
+		}
+		if account.Results.Results == nil {
+			util.TableRow([]string{account.Profile.Name, accountLabel(account), "-", "not attempted"}, widths)
+			continue
+		}
+		summary := account.Results.Summary()
+		util.TableRow([]string{account.Profile.Name, accountLabel(account), strconv.Itoa(summary.Successful), strconv.Itoa(summary.Failed)}, widths)
+		if zones := api.UnpurgedZones(account.Results); len(zones) > 0 {
+			unpurged = append(unpurged, fmt.Sprintf("--profile=%s %s", account.Profile.Name, strings.Join(zones, " ")))
+		}
+	}
+
+	for _, zones := range unpurged {
+		util.Error("Not purged, re-run without --all-accounts with: cfpurge purge %s <same options>", zones)
+	}
+	return nil
+}
+
+// accountLabel names the account of an --all-accounts purge, falling back to
+// the profile's account ID
+func accountLabel(account accountPurge) string {
+	if account.Plan.Account != "" {
+		return account.Plan.Account
+	}
+	if account.Profile.AccountID != "" {
+		return account.Profile.AccountID
+	}
+	return "all visible zones"
+}
+
+// printCacheReserveResults reports the Cache Reserve clear of each zone,
+// warning about zones where it could not be cleared
+func printCacheReserveResults(results []api.CacheReserveResult) {
+	for _, result := range results {
+		switch result.Status {
+		case api.CacheReserveCleared:
+			util.Success("Started clearing Cache Reserve for %s", result.Zone)
+		case api.CacheReserveUnsupported:
+			util.Warning("Cache Reserve is not available for %s; only the standard purge was applied (%s)", result.Zone, result.Detail)
+		case api.CacheReserveEnabled:
+			util.Warning("Cache Reserve is enabled for %s and was not cleared: %s", result.Zone, result.Detail)
+		default:
+			util.Error("Error clearing Cache Reserve for %s: %s", result.Zone, result.Detail)
+		}
+	}
+}
+
+// newPurgeSummary converts per-zone results into their structured form
+func newPurgeSummary(results api.Results) purgeSummary {
+	summary := purgeSummary{
+		ResultSummary: results.Summary(),
+		Deduplicated:  results.Deduplicated,
+		Zones:         make([]zoneSummary, len(results.Zones)),
+	}
+	for i, result := range results.Zones {
+		summary.Zones[i] = zoneSummary{Zone: result.Zone.Name, Purged: result.Purged}
+		if result.Err != nil {
+			summary.Zones[i].Error = result.Err.Error()
+		}
+	}
+	for _, zone := range results.NotAttempted {
+		summary.NotAttempted = append(summary.NotAttempted, zone.Name)
+	}
+	return summary
 }
 
 func init() {
 	purgeCmd.Flags().StringVar(&purgeHosts, "hosts", "", "Comma-separated list of hosts to purge")
 	purgeCmd.Flags().StringVar(&purgeURLs, "urls", "", "Comma-separated list of URLs to purge")
+	purgeCmd.Flags().StringVar(&purgeURLsFile, "urls-file", "", "File with one URL to purge per line")
+	purgeCmd.Flags().StringVar(&purgeQueryVars, "with-query-variants", "", "Comma-separated query strings, e.g. utm_source=mail,lang=de; each URL is also purged with each one appended")
+	purgeCmd.Flags().StringVar(&purgeQueryFile, "query-variants-file", "", "File with one query string per line to append to each URL, like --with-query-variants")
+	purgeCmd.Flags().StringVar(&purgeGitDiff, "git-diff", "", "Purge the URLs of the files changed since this git ref, as listed by git diff --name-only in the current repository")
+	purgeCmd.Flags().StringVar(&purgeGitBaseURL, "git-base-url", "", "URL template for --git-diff; {path} is replaced with each changed file's path, otherwise the path is appended")
+	purgeCmd.Flags().StringVar(&purgeGitRoot, "git-root", "", "Only purge changed files under this directory of the repository, with paths relative to it, e.g. the site's output directory")
+	purgeCmd.Flags().StringVar(&purgeSitemap, "sitemap", "", "Purge the URLs listed in a sitemap.xml URL or file, following sitemap indexes")
+	purgeCmd.Flags().StringVar(&purgeChangesFile, "changes-file", "", "Purge the assets in a JSON array of {\"path\", \"zone\"} objects, e.g. a build manifest")
+	purgeCmd.Flags().StringVar(&purgeBaseURL, "base-url", util.DefaultChangesBaseURL, "URL template for --changes-file; {zone} and {path} are replaced with each asset's zone and path")
+	purgeCmd.Flags().StringVar(&purgeImages, "images", "", "Comma-separated list of Cloudflare Images IDs to purge from --image-domain")
+	purgeCmd.Flags().StringVar(&purgeImgVariants, "image-variants", "public", "Comma-separated list of image variants to purge for each image")
+	purgeCmd.Flags().StringVar(&purgeImgDomain, "image-domain", "", "Custom domain serving the images under /cdn-cgi/imagedelivery/")
+	purgeCmd.Flags().StringVar(&purgeImgAccount, "image-account-hash", "", "Cloudflare Images account hash from the delivery URL")
+	purgeCmd.Flags().BoolVar(&purgeStrict, "strict", false, "Abort instead of skipping malformed URLs or cache tags")
 	purgeCmd.Flags().StringVar(&purgeTags, "tags", "", "Comma-separated list of cache tags to purge (Enterprise only)")
+	purgeCmd.Flags().StringVar(&purgeTagsFile, "tags-file", "", "File of cache tags to purge, in the --tags-file-format format")
+	purgeCmd.Flags().StringVar(&purgeTagsFormat, "tags-file-format", util.TagsFileLines, "Format of --tags-file: lines (one tag per line), csv or json (an array of strings)")
+	purgeCmd.Flags().StringVar(&purgeTagsColumn, "tags-column", util.DefaultTagsColumn, "CSV column holding the tags, for --tags-file-format=csv")
+	purgeCmd.Flags().StringVar(&purgeTagPrefix, "tag-prefix", "", "Prefix prepended to every tag in --tags and --tags-file, e.g. prod: to target one environment")
 	purgeCmd.Flags().BoolVar(&purgeAll, "all", false, "Apply to all zones")
+	purgeCmd.Flags().BoolVar(&purgeAccountAll, "account-all", false, "Apply to every zone in the account selected with --account, and no zones of other accounts")
+	purgeCmd.Flags().BoolVar(&purgeAllAccounts, "all-accounts", false, "Apply to every zone of every profile in the credentials file, account by account, after confirmation")
+	purgeCmd.Flags().BoolVar(&purgeYes, "yes", false, "Skip the confirmation prompt of --all-accounts, e.g. in scripts")
+	purgeCmd.Flags().StringVar(&purgeIncludeZone, "include-zones", "", "Comma-separated zone name patterns, e.g. staging.*,*-dev.com; only matching zones are purged")
+	purgeCmd.Flags().StringVar(&purgeExcludeZone, "exclude-zones", "", "Comma-separated zone name patterns of zones never to purge")
+	purgeCmd.Flags().StringVar(&purgeZoneTag, "zone-tag", "", "Only apply to zones whose account or owner matches this tag")
 	purgeCmd.Flags().BoolVar(&purgeEverything, "everything", false, "Purge everything from cache")
+	purgeCmd.Flags().StringVar(&purgeDevices, "device-types", "", fmt.Sprintf("Comma-separated device types (%s) to purge each URL for, on zones that cache a copy per device type", strings.Join(api.DeviceTypes, ", ")))
+	purgeCmd.Flags().BoolVar(&purgeRequireExplicitZones, "require-explicit-zones", envFlag("CFPURGE_REQUIRE_EXPLICIT_ZONES"), "Refuse --everything with --all or --account-all, so every zone purged must be named")
+	bindEnv(purgeCmd.Flags(), "require-explicit-zones", "CFPURGE_REQUIRE_EXPLICIT_ZONES")
 	purgeCmd.Flags().BoolVar(&purgeQuiet, "quiet", false, "Suppress success messages")
+	purgeCmd.Flags().BoolVar(&purgeDryRun, "dry-run", false, "Show what would be purged without actually purging")
+	purgeCmd.Flags().BoolVar(&purgeIfChanged, "if-changed", false, "Fetch each URL from the origin and purge only those whose content differs from the hash recorded in --hash-file")
+	purgeCmd.Flags().StringVar(&purgeHashFile, "hash-file", "", "JSON file of content hashes for --if-changed, updated with the URLs purged; created if missing")
+	purgeCmd.Flags().BoolVar(&purgeShowReqs, "show-requests", false, "With --dry-run, print the JSON body of every API request each zone would be sent, batched as in a real run")
+	purgeCmd.Flags().StringVar(&purgeDryRunOut, "dry-run-output", "", "Write the dry-run plan as JSON to this file (implies --dry-run)")
+	purgeCmd.Flags().StringVar(&purgeFromPlan, "from-plan", "", "Execute a plan previously written with --dry-run-output")
+	purgeCmd.Flags().StringVar(&purgeRetryFailed, "retry-failed", "", "Retry only the targets recorded in a file written with --failures-output")
+	purgeCmd.Flags().StringVar(&purgeReport, "report", "", "Also write the zones purged and their outcome as a self-contained HTML report to this file")
+	purgeCmd.Flags().StringVar(&purgeFailuresOut, "failures-output", "", "Write the targets that failed, with their errors, to this file for --retry-failed")
+	purgeConcurrency = util.Concurrency{N: defaultPurgeConcurrency}
+	purgeCmd.Flags().Var(&purgeConcurrency, "batch-concurrency", "Maximum number of purge requests sent concurrently for each zone, or auto to adapt to --rate-limit and rate limiting")
+	purgeCmd.Flags().Var(&purgeConcurrency, "concurrency", "Alias for --batch-concurrency")
+	purgeCmd.Flags().IntVar(&purgeZoneConc, "zone-concurrency", 1, "Maximum number of zones purged concurrently, each sending up to --batch-concurrency requests")
+	purgeCmd.Flags().BoolVar(&purgeCacheZones, "cache-zones", envFlag("CFPURGE_CACHE_ZONES"), fmt.Sprintf("Cache the zone list on disk for %s, so repeated purges skip listing zones", api.ZoneCacheTTL))
+	bindEnv(purgeCmd.Flags(), "cache-zones", "CFPURGE_CACHE_ZONES")
+	purgeCmd.Flags().BoolVar(&purgeRefreshZone, "refresh-zones", false, "Ignore and replace the cached zone list")
+	purgeCmd.Flags().IntVar(&purgeBatchSize, "batch-size", api.PurgeBatchSize, fmt.Sprintf("Number of URLs or tags sent per purge request (at most %d)", api.PurgeBatchSize))
+	purgeCmd.Flags().StringVar(&purgeAt, "at", "", "Wait until this RFC 3339 time before purging")
+	purgeCmd.Flags().DurationVar(&purgeAfter, "after", 0, "Wait this long before purging, e.g. 10m")
+	purgeCmd.Flags().BoolVar(&purgeErrorEmpty, "error-on-empty", false, fmt.Sprintf("Exit with status %d when no zones match", util.ExitNothingMatched))
+	purgeCmd.Flags().BoolVar(&purgeFailFast, "fail-fast", false, "Stop on the first error; work already completed is not rolled back")
+	purgeCmd.Flags().BoolVar(&purgeVerbose, "verbose", false, "Print request idempotency keys and a per-zone results table at the end")
+	purgeCmd.Flags().StringVar(&purgeOutput, "output", util.OutputTable, "Format for the results summary (table, json, yaml); json and yaml imply --quiet")
+	purgeCmd.Flags().StringVar(&purgeMethod, "method", purgeMethodAPI, "How to purge: api, or get-verify to also fetch each URL before and after and confirm the cache serves fresh content")
+	purgeCmd.Flags().StringVar(&purgeVerifyHost, "verify-host", "", "With --method=get-verify, send this Host header when fetching URLs; purge API calls are unaffected")
+	purgeCmd.Flags().StringVar(&purgeVerifyAddrs, "verify-resolve", "", "With --method=get-verify, comma-separated host:ip entries to connect to instead of DNS when fetching URLs, like curl --resolve")
+	purgeCmd.Flags().DurationVar(&purgeVerifyWait, "verify-timeout", time.Minute, "With --method=get-verify, how long to wait for a purge to propagate before reporting a URL as stale")
+	purgeCmd.Flags().BoolVar(&purgeReserve, "purge-cache-reserve", false, "Also clear the entire Cache Reserve of each purged zone, where the plan supports it and Cache Reserve is disabled")
+	purgeCmd.Flags().DurationVar(&purgeRepeat, "repeat", 0, "Re-run the purge at this interval, e.g. 5m, until interrupted or --repeat-count cycles have run")
+	purgeCmd.Flags().IntVar(&purgeRepeatCount, "repeat-count", 0, "With --repeat, stop after this many cycles (0 for no limit)")
+	purgeCmd.Flags().DurationVar(&purgeStaleSince, "stale-since", 0, "Purge only URLs whose cached copy is older than this, found in the zones' request logs (needs Logpull, at most 168h)")
+	purgeCmd.Flags().StringVar(&purgeSort, "sort", "status", "Sort order for the per-zone results table (status, name)")
 }
@@ -1,23 +1,50 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 
 	"cfpurge/cmd/kv"
 	"cfpurge/internal/api"
+	"cfpurge/internal/util"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var (
-	cfgAPIToken  string
-	cfgAPIKey    string
-	cfgEmail     string
-	cfgAccountID string
+	cfgAPIToken   string
+	cfgReadToken  string
+	cfgWriteToken string
+	cfgAPIKey     string
+	cfgEmail      string
+	cfgAccountID  string
+
+	cfgProfile         string
+	cfgCredentialsFile string
+	cfgEnvFile         string
+
+	// activeProfile is the profile selected with --profile, if any
+	activeProfile api.Profile
+
+	cfgNoEmoji     bool
+	cfgSummaryOnly bool
+
+	cfgRateLimit  float64
+	cfgMaxRetries int
+
+	cfgAuditLog    string
+	cfgAuditSyslog bool
 
 	version   string
 	buildTime string
+
+	// executedCmd is the command Execute ran, for OutputFormat
+	executedCmd *cobra.Command
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -28,43 +55,277 @@ var rootCmd = &cobra.Command{
 Supports purging by hosts, URLs, tags, and everything across zones,
 as well as complete management of Workers KV namespaces and entries.`,
 	Version: version,
+
+	// Errors are printed by main, as JSON when the command's output is JSON
+	SilenceErrors: true,
+
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if util.IsStructuredOutput(commandOutputFormat(cmd)) {
+			cmd.SilenceUsage = true
+		}
+		if err := initConfig(cmd); err != nil {
+			return err
+		}
+		if cfgAuditLog != "" || cfgAuditSyslog {
+			if err := util.OpenAuditLog(cfgAuditLog, cfgAuditSyslog, cmd.CommandPath()); err != nil {
+				return err
+			}
+		}
+		return startTracing(cmd, args)
+	},
 }
 
 // SetVersionInfo sets the version information for the root command
 func SetVersionInfo(v, bt string) {
 	version = v
 	buildTime = bt
+	api.SetVersion(v)
 	rootCmd.Version = fmt.Sprintf("%s (built at %s)", version, buildTime)
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() error {
-	return rootCmd.Execute()
+	// The first Ctrl-C cancels the command's context so that it can stop
+	// starting new work and report what completed; a second one exits at once
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	executed, err := rootCmd.ExecuteContextC(ctx)
+	executedCmd = executed
+	err = api.ClassifyError(err)
+	if closeErr := util.CloseAuditLog(); closeErr != nil {
+		util.Warning("%v", closeErr)
+	}
+	finishTracing(err)
+	return err
 }
 
-func init() {
-	cobra.OnInitialize(initConfig)
+// OutputFormat returns the --output format of the command Execute ran, so that
+// main can print a failure in the same format
+func OutputFormat() string {
+	if executedCmd == nil {
+		return util.OutputTable
+	}
+	return commandOutputFormat(executedCmd)
+}
+
+// commandOutputFormat returns the value of a command's --output flag, or the
+// table format when it has none
+func commandOutputFormat(cmd *cobra.Command) string {
+	format, err := cmd.Flags().GetString("output")
+	if err != nil || util.ValidateOutputFormat(format) != nil {
+		return util.OutputTable
+	}
+	return format
+}
 
+func init() {
 	// Global flags
 	rootCmd.PersistentFlags().StringVar(&cfgAPIToken, "token", os.Getenv("CLOUDFLARE_API_TOKEN"), "Cloudflare API Token")
+	rootCmd.PersistentFlags().StringVar(&cfgReadToken, "read-token", os.Getenv("CLOUDFLARE_API_READ_TOKEN"), "Cloudflare API Token used by commands that only read, instead of --token")
+	rootCmd.PersistentFlags().StringVar(&cfgWriteToken, "write-token", os.Getenv("CLOUDFLARE_API_WRITE_TOKEN"), "Cloudflare API Token used by commands that purge or change data, instead of --token")
 	rootCmd.PersistentFlags().StringVar(&cfgAPIKey, "key", os.Getenv("CLOUDFLARE_API_KEY"), "Cloudflare API Key")
 	rootCmd.PersistentFlags().StringVar(&cfgEmail, "email", os.Getenv("CLOUDFLARE_EMAIL"), "Cloudflare Email Address")
 	rootCmd.PersistentFlags().StringVar(&cfgAccountID, "account", os.Getenv("CLOUDFLARE_ACCOUNT_ID"), "Cloudflare Account ID")
+	rootCmd.PersistentFlags().StringVar(&cfgProfile, "profile", os.Getenv("CFPURGE_PROFILE"), "Use the named credentials profile from the credentials file")
+	rootCmd.PersistentFlags().StringVar(&cfgCredentialsFile, "credentials-file", os.Getenv("CFPURGE_CREDENTIALS_FILE"), "Credentials file holding named profiles (default ~/.config/cfpurge/credentials)")
+	rootCmd.PersistentFlags().StringVar(&cfgEnvFile, "env-file", "", "Load environment variables, such as CLOUDFLARE_API_TOKEN, from this dotenv file; variables already set in the environment win")
+	rootCmd.PersistentFlags().Float64Var(&cfgRateLimit, "rate-limit", api.DefaultRateLimit, "Maximum API requests per second, shared by all concurrent requests")
+	rootCmd.PersistentFlags().IntVar(&cfgMaxRetries, "max-retries", api.DefaultMaxRetries, "Times the Cloudflare client re-sends a request that failed with a network error, a 5xx response or rate limiting (429)")
+	rootCmd.PersistentFlags().BoolVar(&cfgNoEmoji, "no-emoji", envFlag("CFPURGE_NO_EMOJI"), "Print ASCII tags such as [OK] and [ERR] instead of emoji")
+	rootCmd.PersistentFlags().BoolVar(&cfgSummaryOnly, "summary-only", envFlag("CFPURGE_SUMMARY_ONLY"), "Print only warnings, errors and final summaries, without per-item success or progress messages")
+	rootCmd.PersistentFlags().StringVar(&cfgAuditLog, "audit-log", os.Getenv("CFPURGE_AUDIT_LOG"), "Append a JSON line for every purge and delete to this file")
+	rootCmd.PersistentFlags().BoolVar(&cfgAuditSyslog, "audit-syslog", false, "Also send audit log entries to the local syslog")
+	rootCmd.PersistentFlags().BoolVar(&cfgOTel, "otel", false, "Export OpenTelemetry traces over OTLP/HTTP (on by default when OTEL_EXPORTER_OTLP_ENDPOINT is set)")
+
+	for name, env := range map[string]string{
+		"token":            "CLOUDFLARE_API_TOKEN",
+		"read-token":       "CLOUDFLARE_API_READ_TOKEN",
+		"write-token":      "CLOUDFLARE_API_WRITE_TOKEN",
+		"key":              "CLOUDFLARE_API_KEY",
+		"email":            "CLOUDFLARE_EMAIL",
+		"account":          "CLOUDFLARE_ACCOUNT_ID",
+		"profile":          "CFPURGE_PROFILE",
+		"credentials-file": "CFPURGE_CREDENTIALS_FILE",
+		"no-emoji":         "CFPURGE_NO_EMOJI",
+		"summary-only":     "CFPURGE_SUMMARY_ONLY",
+		"audit-log":        "CFPURGE_AUDIT_LOG",
+	} {
+		bindEnv(rootCmd.PersistentFlags(), name, env)
+	}
 
 	// Add commands
 	rootCmd.AddCommand(listCmd)
 	rootCmd.AddCommand(purgeCmd)
+	rootCmd.AddCommand(doctorCmd)
+	rootCmd.AddCommand(watchCmd)
+	rootCmd.AddCommand(serveCmd)
+	rootCmd.AddCommand(profilesCmd)
 	rootCmd.AddCommand(kv.NewKVCmd())
+	rootCmd.AddCommand(kv.NewTUICmd())
+	rootCmd.AddCommand(commandsCmd)
 }
 
-// initConfig sets up the config based on flags and environment variables
-func initConfig() {
-	// Set up API client configuration
-	api.SetConfig(api.Config{
-		APIToken:  cfgAPIToken,
-		APIKey:    cfgAPIKey,
-		Email:     cfgEmail,
-		AccountID: cfgAccountID,
+// initConfig sets up the config based on flags, environment variables and the
+// selected credentials profile. Flags given on the command line override the
+// profile, which overrides environment variables, which override --env-file.
+func initConfig(cmd *cobra.Command) error {
+	if cfgEnvFile != "" {
+		if err := applyEnvFile(cmd, cfgEnvFile); err != nil {
+			return err
+		}
+	}
+
+	if cfgNoEmoji {
+		util.SetPrefixes(util.PlainPrefixes)
+	}
+	if cfgSummaryOnly {
+		util.SetLevel(util.LevelSummary)
+	}
+
+	cfg := api.Config{
+		APIToken:   cfgAPIToken,
+		ReadToken:  cfgReadToken,
+		WriteToken: cfgWriteToken,
+		APIKey:     cfgAPIKey,
+		Email:      cfgEmail,
+		AccountID:  cfgAccountID,
+	}
+
+	if cfgProfile != "" {
+		profile, err := loadProfile(cfgProfile)
+		if err != nil {
+			return err
+		}
+		activeProfile = profile
+
+		flags := cmd.Flags()
+		profileCfg := profile.Config()
+		if flags.Changed("token") {
+			profileCfg.APIToken = cfgAPIToken
+		}
+		if flags.Changed("read-token") {
+			profileCfg.ReadToken = cfgReadToken
+		}
+		if flags.Changed("write-token") {
+			profileCfg.WriteToken = cfgWriteToken
+		}
+		if flags.Changed("key") {
+			profileCfg.APIKey = cfgAPIKey
+		}
+		if flags.Changed("email") {
+			profileCfg.Email = cfgEmail
+		}
+		if flags.Changed("account") || profileCfg.AccountID == "" {
+			profileCfg.AccountID = cfgAccountID
+		}
+		cfg = profileCfg
+	}
+
+	if cfgRateLimit <= 0 {
+		return fmt.Errorf("--rate-limit must be greater than 0")
+	}
+	cfg.RateLimit = cfgRateLimit
+
+	if cfgMaxRetries < 1 {
+		return fmt.Errorf("--max-retries must be at least 1")
+	}
+	cfg.MaxRetries = cfgMaxRetries
+
+	api.SetConfig(cfg)
+	return nil
+}
+
+// envAnnotation marks a flag whose default is read from an environment
+// variable, so that --env-file can supply it after flags are parsed
+const envAnnotation = "cfpurge_env"
+
+// bindEnv records that a flag's default comes from the environment variable env
+func bindEnv(flags *pflag.FlagSet, name, env string) {
+	flags.SetAnnotation(name, envAnnotation, []string{env})
+}
+
+// applyEnvFile loads the variables of a dotenv file into the environment,
+// except those already set, and re-reads the defaults of flags bound to them
+// that were not given on the command line
+func applyEnvFile(cmd *cobra.Command, path string) error {
+	vars, err := util.ReadEnvFile(path)
+	if err != nil {
+		return err
+	}
+
+	loaded := make(map[string]bool)
+	for name, value := range vars {
+		if _, set := os.LookupEnv(name); set {
+			continue
+		}
+		if err := os.Setenv(name, value); err != nil {
+			return fmt.Errorf("error setting %s from %s: %w", name, path, err)
+		}
+		loaded[name] = true
+	}
+
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		env := flag.Annotations[envAnnotation]
+		if err != nil || len(env) == 0 || flag.Changed || !loaded[env[0]] {
+			return
+		}
+		value := os.Getenv(env[0])
+		if flag.Value.Type() == "bool" {
+			value = strconv.FormatBool(envFlag(env[0]))
+		}
+		if setErr := flag.Value.Set(value); setErr != nil {
+			err = fmt.Errorf("invalid %s in %s: %w", env[0], path, setErr)
+		}
 	})
+	return err
+}
+
+// envFlag reports whether a boolean environment variable is set to anything
+// but an empty or false value
+func envFlag(name string) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return false
+	}
+	enabled, err := strconv.ParseBool(value)
+	return err != nil || enabled
+}
+
+// credentialsPath returns the credentials file to read profiles from
+func credentialsPath() (string, error) {
+	if cfgCredentialsFile != "" {
+		return cfgCredentialsFile, nil
+	}
+	return api.DefaultCredentialsPath()
+}
+
+// loadProfile reads the named profile, warning when the credentials file can
+// be read by other users
+func loadProfile(name string) (api.Profile, error) {
+	path, err := credentialsPath()
+	if err != nil {
+		return api.Profile{}, err
+	}
+
+	profile, err := api.LoadProfile(path, name)
+	if err != nil {
+		return api.Profile{}, err
+	}
+
+	warnIfCredentialsExposed(path)
+	return profile, nil
+}
+
+// warnIfCredentialsExposed warns when the credentials file is group or world
+// readable
+func warnIfCredentialsExposed(path string) {
+	if exposed, err := api.CredentialsFileExposed(path); err == nil && exposed {
+		util.Warning("Credentials file %s is readable by other users; restrict it with: chmod 600 %s", path, path)
+	}
 }
@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"cfpurge/internal/api"
+	"cfpurge/internal/util"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/spf13/cobra"
+)
+
+// serveSecretHeader carries the shared secret on every purge request
+const serveSecretHeader = "X-Cfpurge-Secret"
+
+// serveMaxBodyBytes bounds the size of a purge request body
+const serveMaxBodyBytes = 1 << 20
+
+// serveShutdownTimeout is how long purges in flight may take to finish once
+// the server is asked to stop
+const serveShutdownTimeout = 30 * time.Second
+
+var (
+	serveBind        string
+	serveSecret      string
+	serveConcurrency int
+)
+
+// purgeRequest is the JSON body accepted by POST /purge
+type purgeRequest struct {
+	Zones      []string `json:"zones"`
+	Hosts      []string `json:"hosts"`
+	URLs       []string `json:"urls"`
+	Tags       []string `json:"tags"`
+	Everything bool     `json:"everything"`
+}
+
+// serveError is the JSON body returned when a request cannot be processed
+type serveError struct {
+	Error string `json:"error"`
+}
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run an HTTP server that purges cache on request",
+	Long: `Run a small HTTP server exposing POST /purge, so that CI webhooks can trigger
+purges without installing cfpurge. Requests must carry the shared secret in the
+` + serveSecretHeader + ` header.`,
+	Example: `  # Serve on localhost with the secret taken from the environment
+  CFPURGE_SERVE_SECRET=s3cret cfpurge serve --bind=127.0.0.1:8080
+
+  # Trigger a purge
+  curl -X POST -H "` + serveSecretHeader + `: s3cret" \
+    -d '{"urls":["https://example.com/app.js"]}' http://127.0.0.1:8080/purge`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := api.ValidateAuth(); err != nil {
+			return err
+		}
+
+		if serveSecret == "" {
+			return fmt.Errorf("a shared secret is required: use --secret or CFPURGE_SERVE_SECRET")
+		}
+
+		if serveConcurrency < 1 {
+			return fmt.Errorf("concurrency must be at least 1")
+		}
+
+		client, err := api.GetClient(api.WriteAccess)
+		if err != nil {
+			return err
+		}
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/purge", func(w http.ResponseWriter, r *http.Request) {
+			handlePurgeRequest(client, w, r)
+		})
+
+		server := &http.Server{
+			Addr:              serveBind,
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+
+		// The first Ctrl-C or SIGTERM cancels the command's context: stop
+		// accepting requests and let the purges in flight finish
+		shutdown := make(chan error, 1)
+		go func() {
+			<-cmd.Context().Done()
+			util.Info("Shutting down")
+			ctx, cancel := context.WithTimeout(context.Background(), serveShutdownTimeout)
+			defer cancel()
+			shutdown <- server.Shutdown(ctx)
+		}()
+
+		util.Info("Listening on %s", serveBind)
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		if err := <-shutdown; err != nil {
+			return fmt.Errorf("error shutting down: %w", err)
+		}
+		return nil
+	},
+}
+
+// handlePurgeRequest authenticates and executes a single POST /purge request
+func handlePurgeRequest(client *cloudflare.API, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeServeJSON(w, http.StatusMethodNotAllowed, serveError{Error: "method not allowed"})
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(serveSecretHeader)), []byte(serveSecret)) != 1 {
+		writeServeJSON(w, http.StatusUnauthorized, serveError{Error: "invalid or missing " + serveSecretHeader + " header"})
+		return
+	}
+
+	var req purgeRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, serveMaxBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeServeJSON(w, http.StatusBadRequest, serveError{Error: fmt.Sprintf("invalid request body: %v", err)})
+		return
+	}
+
+	opts := api.PurgeOptions{
+		Zones:       req.Zones,
+		Hosts:       req.Hosts,
+		URLs:        req.URLs,
+		Tags:        req.Tags,
+		Everything:  req.Everything,
+		Concurrency: serveConcurrency,
+		// Reject bad input outright; a webhook caller should hear about it rather
+		// than have items silently skipped
+		Strict:     true,
+		Infof:      util.Info,
+		Warnf:      util.Warning,
+		OnZoneDone: func(result api.ZoneResult) { reportZoneResult(result, false) },
+	}
+
+	plan, err := api.PlanPurge(r.Context(), client, opts)
+	if err != nil {
+		writeServeJSON(w, http.StatusBadRequest, serveError{Error: err.Error()})
+		return
+	}
+
+	if len(plan.Zones) == 0 {
+		writeServeJSON(w, http.StatusBadRequest, serveError{Error: "no matching zones found"})
+		return
+	}
+
+	summary := newPurgeSummary(api.ExecutePurgePlan(r.Context(), client, plan, opts))
+
+	status := http.StatusOK
+	if summary.Failed > 0 {
+		status = http.StatusBadGateway
+	}
+	writeServeJSON(w, status, summary)
+}
+
+// writeServeJSON writes a JSON response with the given status code
+func writeServeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := util.WriteOutput(w, util.OutputJSON, body); err != nil {
+		util.Error("Error writing response: %v", err)
+	}
+}
+
+func init() {
+	serveCmd.Flags().StringVar(&serveBind, "bind", "127.0.0.1:8080", "Address to listen on")
+	serveCmd.Flags().StringVar(&serveSecret, "secret", os.Getenv("CFPURGE_SERVE_SECRET"), "Shared secret required in the "+serveSecretHeader+" header")
+	bindEnv(serveCmd.Flags(), "secret", "CFPURGE_SERVE_SECRET")
+	serveCmd.Flags().IntVar(&serveConcurrency, "concurrency", 5, "Maximum number of purge requests sent concurrently for a zone")
+}
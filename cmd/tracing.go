@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"cfpurge/internal/api"
+	"cfpurge/internal/util"
+
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracingShutdownTimeout bounds how long exporting the remaining spans may delay exit
+const tracingShutdownTimeout = 5 * time.Second
+
+var (
+	cfgOTel bool
+
+	shutdownTracing func(context.Context) error
+	commandSpan     trace.Span
+)
+
+// startTracing sets up OpenTelemetry when enabled and starts a span covering the
+// whole command. The span's context is handed to the command through
+// cmd.Context(), so the spans of the operations it runs nest beneath it.
+func startTracing(cmd *cobra.Command, args []string) error {
+	if !api.TracingEnabled(cfgOTel) {
+		return nil
+	}
+
+	shutdown, err := api.InitTracing(cmd.Context(), version)
+	if err != nil {
+		return err
+	}
+	shutdownTracing = shutdown
+
+	ctx, span := api.StartSpan(cmd.Context(), cmd.CommandPath())
+	commandSpan = span
+	cmd.SetContext(ctx)
+	return nil
+}
+
+// finishTracing ends the command span and flushes spans to the collector
+func finishTracing(err error) {
+	if commandSpan != nil {
+		api.EndSpan(commandSpan, err)
+	}
+
+	if shutdownTracing == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		util.Warning("Error exporting traces: %v", err)
+	}
+}
@@ -0,0 +1,228 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"cfpurge/internal/api"
+	"cfpurge/internal/util"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/cobra"
+)
+
+var (
+	watchBaseURL     string
+	watchDebounce    time.Duration
+	watchDryRun      bool
+	watchConcurrency int
+)
+
+// watchCmd represents the watch command
+var watchCmd = &cobra.Command{
+	Use:   "watch <directory>",
+	Short: "Purge URLs when files in a directory change",
+	Long: `Watch a directory and purge the URLs of files that change.
+Each changed file is mapped to a URL using the base-URL template, and changes
+arriving in quick succession are batched into a single purge.`,
+	Example: `  # Purge built assets as they are rewritten
+  cfpurge watch ./dist --base-url="https://example.com/{path}"
+
+  # Log what would be purged without purging
+  cfpurge watch ./dist --base-url="https://example.com/static/" --dry-run`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := api.ValidateAuth(); err != nil {
+			return err
+		}
+
+		if watchBaseURL == "" {
+			return fmt.Errorf("--base-url is required")
+		}
+
+		if watchConcurrency < 1 {
+			return fmt.Errorf("concurrency must be at least 1")
+		}
+
+		root, err := filepath.Abs(args[0])
+		if err != nil {
+			return fmt.Errorf("error resolving directory: %w", err)
+		}
+
+		// Catch an unusable template up front rather than on the first change
+		if err := util.ValidateURL(util.URLForPath(watchBaseURL, "index.html")); err != nil {
+			return fmt.Errorf("invalid base URL template: %w", err)
+		}
+
+		client, err := api.GetClient(api.WriteAccess)
+		if err != nil {
+			return err
+		}
+
+		// The root command cancels this on Ctrl-C or SIGTERM
+		ctx := cmd.Context()
+
+		// Zones are resolved once; restart the watcher after adding a zone
+		zones, err := api.ListZones(ctx)
+		if err != nil {
+			return fmt.Errorf("error getting zones: %w", err)
+		}
+
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			return fmt.Errorf("error creating watcher: %w", err)
+		}
+		defer watcher.Close()
+
+		if err := addWatchDirs(watcher, root); err != nil {
+			return err
+		}
+
+		util.Info("Watching %s for changes (press Ctrl+C to stop)", root)
+
+		pending := make(map[string]bool)
+		var flush <-chan time.Time
+
+		for {
+			select {
+			case <-ctx.Done():
+				warnUnpurged(changedURLs(root, pending))
+				return nil
+
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return nil
+				}
+
+				// Permission changes do not alter what is served
+				if event.Op == fsnotify.Chmod {
+					continue
+				}
+
+				// fsnotify is not recursive, so new directories have to be added explicitly
+				if event.Has(fsnotify.Create) {
+					if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+						if err := addWatchDirs(watcher, event.Name); err != nil {
+							util.Warning("%v", err)
+						}
+						continue
+					}
+				}
+
+				pending[event.Name] = true
+				flush = time.After(watchDebounce)
+
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return nil
+				}
+				util.Warning("Watcher error: %v", err)
+
+			case <-flush:
+				flush = nil
+				urls := changedURLs(root, pending)
+				pending = make(map[string]bool)
+				purgeChangedURLs(ctx, client, zones, urls)
+			}
+		}
+	},
+}
+
+// addWatchDirs watches a directory and all of its subdirectories
+func addWatchDirs(watcher *fsnotify.Watcher, dir string) error {
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		if err := watcher.Add(path); err != nil {
+			return fmt.Errorf("error watching %s: %w", path, err)
+		}
+		return nil
+	})
+}
+
+// changedURLs maps changed file paths to sorted, normalised URLs
+func changedURLs(root string, paths map[string]bool) []string {
+	var urls []string
+	for path := range paths {
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			util.Warning("Skipping %s: %v", path, err)
+			continue
+		}
+
+		normalized, err := util.NormalizeURL(util.URLForPath(watchBaseURL, filepath.ToSlash(relPath)))
+		if err != nil {
+			util.Warning("Skipping %v", err)
+			continue
+		}
+		urls = append(urls, normalized)
+	}
+
+	sort.Strings(urls)
+	return urls
+}
+
+// warnUnpurged lists the changed URLs still waiting for the debounce when the
+// watcher stops, so they can be purged by hand
+func warnUnpurged(urls []string) {
+	if len(urls) == 0 {
+		return
+	}
+
+	util.Warning("Stopped before purging %d changed URLs:", len(urls))
+	for _, url := range urls {
+		fmt.Printf("  %s\n", url)
+	}
+}
+
+// purgeChangedURLs purges a batch of URLs from the zones they belong to.
+// Failures are reported but do not stop the watcher.
+func purgeChangedURLs(ctx context.Context, client *cloudflare.API, zones []cloudflare.Zone, urls []string) {
+	if len(urls) == 0 {
+		return
+	}
+
+	if watchDryRun {
+		util.Info("Dry run mode - would purge %d URLs:", len(urls))
+		for _, url := range urls {
+			fmt.Printf("  %s\n", url)
+		}
+		return
+	}
+
+	plan, err := api.PlanPurgeForZones(zones, api.PurgeOptions{URLs: urls, Warnf: util.Warning})
+	if err != nil {
+		util.Error("%v", err)
+		return
+	}
+
+	results := api.ExecutePurgePlan(ctx, client, plan, api.PurgeOptions{
+		Concurrency: watchConcurrency,
+		OnZoneDone: func(result api.ZoneResult) {
+			if result.Err != nil {
+				util.Error("Error purging cache for %s: %v", result.Zone.Name, result.Err)
+				return
+			}
+			util.Success("Purged %d URLs from %s", len(result.Zone.URLs), result.Zone.Name)
+		},
+	})
+
+	results.Print()
+}
+
+func init() {
+	watchCmd.Flags().StringVar(&watchBaseURL, "base-url", "", "URL template for changed files; {path} is replaced with the file's relative path, otherwise the path is appended")
+	watchCmd.Flags().DurationVar(&watchDebounce, "debounce", 500*time.Millisecond, "Wait this long after the last change before purging")
+	watchCmd.Flags().BoolVar(&watchDryRun, "dry-run", false, "Log what would be purged without actually purging")
+	watchCmd.Flags().IntVar(&watchConcurrency, "concurrency", 5, "Maximum number of purge requests sent concurrently for a zone")
+}
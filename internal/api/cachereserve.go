@@ -0,0 +1,97 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// Outcomes of clearing a zone's Cache Reserve
+const (
+	CacheReserveCleared     = "cleared"
+	CacheReserveUnsupported = "unsupported"
+	CacheReserveEnabled     = "enabled"
+	CacheReserveError       = "error"
+)
+
+// CacheReserveResult is the outcome of clearing one zone's Cache Reserve
+type CacheReserveResult struct {
+	Zone   string `json:"zone" yaml:"zone"`
+	Status string `json:"status" yaml:"status"`
+	Detail string `json:"detail,omitempty" yaml:"detail,omitempty"`
+}
+
+// ClearCacheReserve starts clearing everything stored in a zone's Cache
+// Reserve. Cloudflare only clears Cache Reserve while it is switched off, so a
+// zone with it enabled is reported rather than cleared, as is a zone whose
+// plan does not include Cache Reserve.
+func ClearCacheReserve(ctx context.Context, client *cloudflare.API, zoneID, zoneName string) CacheReserveResult {
+	result := CacheReserveResult{Zone: zoneName}
+	rc := cloudflare.ZoneIdentifier(zoneID)
+
+	var setting cloudflare.CacheReserve
+	err := WithRetry(ctx, func(ctx context.Context) error {
+		var err error
+		setting, err = client.GetCacheReserve(ctx, rc, cloudflare.GetCacheReserveParams{})
+		return err
+	})
+	if err != nil {
+		result.Status, result.Detail = CacheReserveError, err.Error()
+		if cacheReserveUnsupported(err) {
+			result.Status = CacheReserveUnsupported
+		}
+		return result
+	}
+
+	if setting.Value == "on" {
+		result.Status = CacheReserveEnabled
+		result.Detail = "Cache Reserve can only be cleared while it is disabled"
+		return result
+	}
+
+	err = WithRetry(ctx, func(ctx context.Context) error {
+		_, err := client.Raw(ctx, http.MethodPost, fmt.Sprintf("/zones/%s/cache/cache_reserve_clear", zoneID), nil, nil)
+		return err
+	})
+	if err != nil {
+		result.Status, result.Detail = CacheReserveError, err.Error()
+		if cacheReserveUnsupported(err) {
+			result.Status = CacheReserveUnsupported
+		}
+		return result
+	}
+
+	result.Status = CacheReserveCleared
+	return result
+}
+
+// ClearCacheReserves clears the Cache Reserve of every zone whose purge
+// succeeded, one zone at a time, stopping once ctx is cancelled
+func ClearCacheReserves(ctx context.Context, client *cloudflare.API, results Results) []CacheReserveResult {
+	var cleared []CacheReserveResult
+	seen := make(map[string]bool)
+	for _, result := range results.Zones {
+		if ctx.Err() != nil {
+			break
+		}
+		if result.Err != nil || seen[result.Zone.ID] {
+			continue
+		}
+		seen[result.Zone.ID] = true
+		cleared = append(cleared, ClearCacheReserve(ctx, client, result.Zone.ID, result.Zone.Name))
+	}
+	return cleared
+}
+
+// cacheReserveUnsupported reports whether err means the zone's plan or the
+// credentials do not allow Cache Reserve, rather than a transient failure.
+// cloudflare-go reports HTTP 403 as an AuthenticationError.
+func cacheReserveUnsupported(err error) bool {
+	var forbiddenErr *cloudflare.AuthenticationError
+	var notFoundErr *cloudflare.NotFoundError
+	var requestErr *cloudflare.RequestError
+	return errors.As(err, &forbiddenErr) || errors.As(err, &notFoundErr) || errors.As(err, &requestErr)
+}
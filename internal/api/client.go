@@ -3,6 +3,11 @@ package api
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"cfpurge/internal/util"
 
 	"github.com/cloudflare/cloudflare-go"
 )
@@ -13,24 +18,189 @@ type Config struct {
 	APIKey    string
 	Email     string
 	AccountID string
+
+	// ReadToken and WriteToken optionally split APIToken by privilege: commands
+	// that only read use ReadToken, and commands that purge or change anything
+	// use WriteToken. Either falls back to APIToken when unset.
+	ReadToken  string
+	WriteToken string
+
+	// RateLimit is the most requests per second sent to the API, or 0 for
+	// cloudflare-go's default of DefaultRateLimit
+	RateLimit float64
+
+	// MaxRetries is how many times cloudflare-go re-sends a request that failed
+	// with a network error, a 5xx or a 429 response, or 0 for DefaultMaxRetries.
+	// It backs off 1s, 2s, 4s and so on between attempts, and after a 429 the
+	// transport also holds the next attempt back until Retry-After has passed.
+	// Once these retries run out, a 429 is returned as a *RateLimitError, which
+	// WithRetry re-attempts up to maxRetries more times, each again retried by
+	// cloudflare-go.
+	MaxRetries int
+
+	// UserAgent identifies the tool's traffic to Cloudflare, or empty for
+	// UserAgent()
+	UserAgent string
 }
 
+// version is the cfpurge version reported in the user agent
+var version = "dev"
+
+// SetVersion records the cfpurge version reported to Cloudflare
+func SetVersion(v string) {
+	if v != "" {
+		version = v
+	}
+}
+
+// UserAgent identifies cfpurge and its version in API requests, so that
+// Cloudflare support and rate-limit diagnostics can tell its traffic apart
+func UserAgent() string {
+	return "cfpurge/" + version
+}
+
+// ClientOptions assembles the cloudflare-go options for the configuration:
+// the rate limit, the retry policy and the user agent
+func (c Config) ClientOptions() []cloudflare.Option {
+	rateLimit := c.RateLimit
+	if rateLimit <= 0 {
+		rateLimit = DefaultRateLimit
+	}
+	maxRetries := c.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = DefaultMaxRetries
+	}
+
+	userAgent := c.UserAgent
+	if userAgent == "" {
+		userAgent = UserAgent()
+	}
+
+	return []cloudflare.Option{
+		cloudflare.UsingRateLimit(rateLimit),
+		cloudflare.UsingRetryPolicy(maxRetries, sdkMinRetryDelaySecs, sdkMaxRetryDelaySecs),
+		cloudflare.UserAgent(userAgent),
+	}
+}
+
+// DefaultRateLimit is cloudflare-go's request rate limit, which matches the
+// API's limit of 1200 requests per five minutes
+const DefaultRateLimit = 4.0
+
+// DefaultMaxRetries is cloudflare-go's default number of retries for requests
+// that fail with a network error, a 5xx or a 429 response
+const DefaultMaxRetries = 3
+
+// The delays cloudflare-go backs off between retries, in seconds
+const (
+	sdkMinRetryDelaySecs = 1
+	sdkMaxRetryDelaySecs = 30
+)
+
+// Access is the privilege a command needs from its credentials
+type Access int
+
+const (
+	// ReadAccess is for commands that only list or read
+	ReadAccess Access = iota
+
+	// WriteAccess is for commands that purge, write or delete
+	WriteAccess
+)
+
 var config Config
 
-// SetConfig updates the global API configuration
+// Clients are built once per invocation so that every caller shares their
+// HTTP transport and the state kept there, such as Retry-After hints. They
+// are keyed by the credentials they use, so read and write access share a
+// client when both resolve to the same token.
+var (
+	clientMu      sync.Mutex
+	sharedClients map[string]*cloudflare.API
+)
+
+// SetConfig updates the global API configuration. Clients built with the
+// previous configuration are discarded.
 func SetConfig(cfg Config) {
+	clientMu.Lock()
+	defer clientMu.Unlock()
+
 	config = cfg
+	sharedClients = nil
 }
 
-// GetClient creates a new Cloudflare API client
-func GetClient() (*cloudflare.API, error) {
+// GetClient returns the Cloudflare API client with the given access for the
+// current configuration, creating it on first use. The client applies the
+// configuration's ClientOptions followed by any extra options; clients built
+// with extra options are not shared.
+func GetClient(access Access, extra ...cloudflare.Option) (*cloudflare.API, error) {
+	clientMu.Lock()
+	defer clientMu.Unlock()
+
+	token, err := tokenFor(access)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheKey := "token:" + token
+	if token == "" {
+		cacheKey = "key:" + config.Email
+	}
+	if len(extra) > 0 {
+		return newClient(token, extra...)
+	}
+	if client, ok := sharedClients[cacheKey]; ok {
+		return client, nil
+	}
+
+	api, err := newClient(token)
+	if err != nil {
+		return nil, err
+	}
+	if sharedClients == nil {
+		sharedClients = make(map[string]*cloudflare.API)
+	}
+	sharedClients[cacheKey] = api
+	return api, nil
+}
+
+// tokenFor picks the API token for the given access. Reads prefer the read
+// token and may use the write token, which can read too; writes never use the
+// read token. An empty token means the API key and email are used.
+func tokenFor(access Access) (string, error) {
+	candidates := []string{config.WriteToken, config.APIToken}
+	if access == ReadAccess {
+		candidates = []string{config.ReadToken, config.APIToken, config.WriteToken}
+	}
+
+	for _, token := range candidates {
+		if token != "" {
+			return token, nil
+		}
+	}
+
+	if access == WriteAccess && config.ReadToken != "" && (config.APIKey == "" || config.Email == "") {
+		return "", fmt.Errorf("this command makes changes and needs a write token; only a read token is configured")
+	}
+	return "", nil
+}
+
+// newClient creates a Cloudflare API client from the configuration, using
+// token when set and the API key and email otherwise
+func newClient(token string, extra ...cloudflare.Option) (*cloudflare.API, error) {
 	var api *cloudflare.API
 	var err error
 
-	if config.APIToken != "" {
-		api, err = cloudflare.NewWithAPIToken(config.APIToken)
+	// Turn 429 responses into a *RateLimitError that WithRetry re-attempts after
+	// Retry-After, send idempotency keys for calls that set one, and trace each request
+	transport := NewTracingTransport(NewIdempotencyTransport(NewRetryAfterTransport(nil)))
+	opts := append([]cloudflare.Option{cloudflare.HTTPClient(&http.Client{Transport: transport})}, config.ClientOptions()...)
+	opts = append(opts, extra...)
+
+	if token != "" {
+		api, err = cloudflare.NewWithAPIToken(token, opts...)
 	} else if config.APIKey != "" && config.Email != "" {
-		api, err = cloudflare.New(config.APIKey, config.Email)
+		api, err = cloudflare.New(config.APIKey, config.Email, opts...)
 	} else {
 		return nil, fmt.Errorf("either API Token or both API Key and Email are required")
 	}
@@ -44,8 +214,8 @@ func GetClient() (*cloudflare.API, error) {
 
 // ValidateAuth checks if authentication credentials are valid
 func ValidateAuth() error {
-	if config.APIToken == "" && (config.APIKey == "" || config.Email == "") {
-		return fmt.Errorf("either API Token or both API Key and Email are required")
+	if !UsesAPIToken() && (config.APIKey == "" || config.Email == "") {
+		return fmt.Errorf("either API Token or both API Key and Email are required: %w", util.ErrAuthFailed)
 	}
 	return nil
 }
@@ -58,6 +228,25 @@ func ValidateAccountID() error {
 	return nil
 }
 
+// UsesAPIToken reports whether any API token is configured, rather than only
+// an API key and email
+func UsesAPIToken() bool {
+	return config.APIToken != "" || config.ReadToken != "" || config.WriteToken != ""
+}
+
+// UsesSplitTokens reports whether separate read and write tokens are configured
+func UsesSplitTokens() bool {
+	return config.ReadToken != "" || config.WriteToken != ""
+}
+
+// GetRateLimit returns the configured request rate limit per second
+func GetRateLimit() float64 {
+	if config.RateLimit > 0 {
+		return config.RateLimit
+	}
+	return DefaultRateLimit
+}
+
 // GetAccountID returns the configured account ID
 func GetAccountID() string {
 	return config.AccountID
@@ -65,15 +254,164 @@ func GetAccountID() string {
 
 // ListZones gets all zones for the account
 func ListZones(ctx context.Context) ([]cloudflare.Zone, error) {
-	client, err := GetClient()
+	client, err := GetClient(ReadAccess)
 	if err != nil {
 		return nil, err
 	}
 
-	zones, err := client.ListZones(ctx)
+	var zones []cloudflare.Zone
+	err = WithRetry(ctx, func(ctx context.Context) error {
+		var err error
+		zones, err = client.ListZones(ctx)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
 	return zones, nil
 }
+
+// FilterZonesByTag returns the zones labelled with the given tag. Cloudflare does
+// not expose free-form zone tags through cloudflare-go, so a zone's labels are
+// its account name, owner name and owner email, compared case-insensitively.
+func FilterZonesByTag(zones []cloudflare.Zone, tag string) []cloudflare.Zone {
+	var filtered []cloudflare.Zone
+	for _, zone := range zones {
+		for _, label := range []string{zone.Account.Name, zone.Owner.Name, zone.Owner.Email} {
+			if label != "" && strings.EqualFold(label, tag) {
+				filtered = append(filtered, zone)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// FilterZonesByAccount returns the zones that belong to the given account ID
+func FilterZonesByAccount(zones []cloudflare.Zone, accountID string) []cloudflare.Zone {
+	var filtered []cloudflare.Zone
+	for _, zone := range zones {
+		if zone.Account.ID == accountID {
+			filtered = append(filtered, zone)
+		}
+	}
+	return filtered
+}
+
+// FilterZonesByPattern returns the zones whose name matches any of the
+// include patterns, or every zone when there are none, and none of the
+// exclude patterns. Patterns are globs, see util.CompileGlob, and are matched
+// case-insensitively.
+func FilterZonesByPattern(zones []cloudflare.Zone, include, exclude []string) ([]cloudflare.Zone, error) {
+	compile := func(patterns []string) ([]*util.Glob, error) {
+		globs := make([]*util.Glob, 0, len(patterns))
+		for _, pattern := range patterns {
+			glob, err := util.CompileGlob(strings.ToLower(pattern))
+			if err != nil {
+				return nil, err
+			}
+			globs = append(globs, glob)
+		}
+		return globs, nil
+	}
+	matchAny := func(globs []*util.Glob, name string) bool {
+		for _, glob := range globs {
+			if glob.Match(name) {
+				return true
+			}
+		}
+		return false
+	}
+
+	includes, err := compile(include)
+	if err != nil {
+		return nil, err
+	}
+	excludes, err := compile(exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	var filtered []cloudflare.Zone
+	for _, zone := range zones {
+		name := strings.ToLower(zone.Name)
+		if (len(includes) == 0 || matchAny(includes, name)) && !matchAny(excludes, name) {
+			filtered = append(filtered, zone)
+		}
+	}
+	return filtered, nil
+}
+
+// SelectZones returns the zones named by args, each a zone name or ID, along
+// with the args that match none of the zones
+func SelectZones(zones []cloudflare.Zone, args []string) ([]cloudflare.Zone, []string) {
+	zoneMap := make(map[string]cloudflare.Zone)
+	for _, zone := range zones {
+		zoneMap[zone.Name] = zone
+		zoneMap[zone.ID] = zone
+	}
+
+	var selected []cloudflare.Zone
+	var missing []string
+	seen := make(map[string]bool)
+	for _, arg := range args {
+		zone, ok := zoneMap[arg]
+		if !ok {
+			missing = append(missing, arg)
+			continue
+		}
+		if !seen[zone.ID] {
+			seen[zone.ID] = true
+			selected = append(selected, zone)
+		}
+	}
+	return selected, missing
+}
+
+// FilterTagPurgeZones splits zones into those whose plan can purge by cache
+// tag, which is an Enterprise feature, and those that cannot. Zones listed
+// without plan details are kept, since their plan is unknown.
+func FilterTagPurgeZones(zones []cloudflare.Zone) ([]cloudflare.Zone, []cloudflare.Zone) {
+	var supported, unsupported []cloudflare.Zone
+	for _, zone := range zones {
+		if enterprisePlan(zone) {
+			supported = append(supported, zone)
+		} else {
+			unsupported = append(unsupported, zone)
+		}
+	}
+	return supported, unsupported
+}
+
+// enterprisePlan reports whether a zone is on the Enterprise plan, or was
+// listed without plan details so that its plan is unknown
+func enterprisePlan(zone cloudflare.Zone) bool {
+	return zone.Plan.LegacyID == "" || zone.Plan.LegacyID == "enterprise"
+}
+
+// AccountLabel describes an account by name and ID, using the account details
+// carried by its zones
+func AccountLabel(zones []cloudflare.Zone, accountID string) string {
+	for _, zone := range zones {
+		if zone.Account.ID == accountID && zone.Account.Name != "" {
+			return fmt.Sprintf("%s (%s)", zone.Account.Name, accountID)
+		}
+	}
+	return accountID
+}
+
+// ApplyZoneTag narrows zones to those carrying the tag, returning a descriptive
+// error when nothing matches
+func ApplyZoneTag(zones []cloudflare.Zone, tag string) ([]cloudflare.Zone, error) {
+	if tag == "" {
+		return zones, nil
+	}
+
+	filtered := FilterZonesByTag(zones, tag)
+	if len(filtered) == 0 {
+		return nil, fmt.Errorf("no zones carry tag '%s'; zone tags are not exposed by the Cloudflare API, so only account names and owner names/emails can be matched", tag)
+	}
+
+	return filtered, nil
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Profile is a named set of credentials from the credentials file, along with
+// policies for commands run with it
+type Profile struct {
+	Name       string `yaml:"-"`
+	APIToken   string `yaml:"token"`
+	ReadToken  string `yaml:"read_token"`
+	WriteToken string `yaml:"write_token"`
+	APIKey     string `yaml:"key"`
+	Email      string `yaml:"email"`
+	AccountID  string `yaml:"account_id"`
+
+	// RequireExplicitZones makes purge --everything refuse --all and
+	// --account-all, see the purge --require-explicit-zones flag
+	RequireExplicitZones bool `yaml:"require_explicit_zones"`
+}
+
+// Config returns the API configuration for the profile
+func (p Profile) Config() Config {
+	return Config{
+		APIToken:   p.APIToken,
+		ReadToken:  p.ReadToken,
+		WriteToken: p.WriteToken,
+		APIKey:     p.APIKey,
+		Email:      p.Email,
+		AccountID:  p.AccountID,
+	}
+}
+
+// DefaultCredentialsPath returns the credentials file in the user's config
+// directory, e.g. ~/.config/cfpurge/credentials on Linux
+func DefaultCredentialsPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("error locating config directory: %w", err)
+	}
+	return filepath.Join(dir, "cfpurge", "credentials"), nil
+}
+
+// LoadProfiles parses a YAML credentials file mapping profile names to
+// credentials and returns the profiles sorted by name
+func LoadProfiles(path string) ([]Profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading credentials file: %w", err)
+	}
+
+	var byName map[string]Profile
+	if err := yaml.Unmarshal(data, &byName); err != nil {
+		return nil, fmt.Errorf("error parsing credentials file %s: %w", path, err)
+	}
+
+	profiles := make([]Profile, 0, len(byName))
+	for name, profile := range byName {
+		profile.Name = name
+		if profile.APIToken == "" && profile.ReadToken == "" && profile.WriteToken == "" && (profile.APIKey == "" || profile.Email == "") {
+			return nil, fmt.Errorf("profile '%s' in %s needs a token, read and write tokens, or a key and email", name, path)
+		}
+		profiles = append(profiles, profile)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+
+	return profiles, nil
+}
+
+// LoadProfile returns the named profile from a credentials file
+func LoadProfile(path, name string) (Profile, error) {
+	profiles, err := LoadProfiles(path)
+	if err != nil {
+		return Profile{}, err
+	}
+
+	for _, profile := range profiles {
+		if profile.Name == name {
+			return profile, nil
+		}
+	}
+	return Profile{}, fmt.Errorf("profile '%s' not found in %s", name, path)
+}
+
+// CredentialsFileExposed reports whether other users can read the credentials
+// file. Permission bits are not meaningful on Windows, so it is never exposed
+// there.
+func CredentialsFileExposed(path string) (bool, error) {
+	if runtime.GOOS == "windows" {
+		return false, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, fmt.Errorf("error reading credentials file: %w", err)
+	}
+	return info.Mode().Perm()&0o044 != 0, nil
+}
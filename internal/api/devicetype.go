@@ -0,0 +1,62 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// DeviceTypeHeader is the request header that Cloudflare's device type
+// caching varies cached responses on
+const DeviceTypeHeader = "CF-Device-Type"
+
+// DeviceTypes are the device types Cloudflare caches separately
+var DeviceTypes = []string{"desktop", "mobile", "tablet"}
+
+// ValidateDeviceTypes checks that every entry names a device type
+func ValidateDeviceTypes(types []string) error {
+	for _, deviceType := range types {
+		valid := false
+		for _, known := range DeviceTypes {
+			if deviceType == known {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return fmt.Errorf("invalid device type '%s': must be one of %s", deviceType, strings.Join(DeviceTypes, ", "))
+		}
+	}
+	return nil
+}
+
+// PurgeRequest is a single purge API request. With DeviceType set, the URLs in
+// Files are purged only as cached for that device type.
+type PurgeRequest struct {
+	cloudflare.PurgeCacheRequest
+	DeviceType string
+}
+
+// purgeFile is a URL in a purge request body, along with the request headers
+// its cached copy varies on
+type purgeFile struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// MarshalJSON encodes the request body sent to the purge API
+func (r PurgeRequest) MarshalJSON() ([]byte, error) {
+	if r.DeviceType == "" {
+		return json.Marshal(r.PurgeCacheRequest)
+	}
+
+	files := make([]purgeFile, len(r.Files))
+	for i, u := range r.Files {
+		files[i] = purgeFile{URL: u, Headers: map[string]string{DeviceTypeHeader: r.DeviceType}}
+	}
+	return json.Marshal(struct {
+		Files []purgeFile `json:"files"`
+	}{files})
+}
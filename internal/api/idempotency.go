@@ -0,0 +1,58 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+)
+
+// IdempotencyHeader is the header carrying the idempotency key of a request
+const IdempotencyHeader = "Idempotency-Key"
+
+type idempotencyKey struct{}
+
+// WithIdempotencyKey attaches a new idempotency key to the context. Every HTTP
+// request made with the context, including retries, carries the same key so the
+// API can recognise a repeated request after an ambiguous failure.
+func WithIdempotencyKey(ctx context.Context) (context.Context, string) {
+	key := newUUID()
+	return context.WithValue(ctx, idempotencyKey{}, key), key
+}
+
+// newUUID generates a random version 4 UUID
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("error generating idempotency key: %v", err))
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// idempotencyTransport sets the idempotency header on requests whose context carries a key
+type idempotencyTransport struct {
+	base http.RoundTripper
+}
+
+// NewIdempotencyTransport wraps an HTTP transport so that requests made with a
+// context from WithIdempotencyKey send the key as a header
+func NewIdempotencyTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &idempotencyTransport{base: base}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *idempotencyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key, ok := req.Context().Value(idempotencyKey{}).(string)
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set(IdempotencyHeader, key)
+	return t.base.RoundTrip(req)
+}
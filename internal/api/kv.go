@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// KVWrite is a single KV entry written with WriteKV
+type KVWrite struct {
+	Key      string
+	Value    []byte
+	Metadata interface{}
+
+	// Expiration is a Unix time and ExpirationTTL a number of seconds from
+	// now; zero leaves either unset
+	Expiration    int64
+	ExpirationTTL int
+}
+
+// WriteKV writes an entry to a namespace of the configured account. The
+// single-key endpoint takes a bare value, so the entry is sent as a bulk write
+// of one, which also carries its metadata and expiry. The value is sent base64
+// encoded, so binary values arrive intact.
+func WriteKV(ctx context.Context, client *cloudflare.API, namespaceID string, entry KVWrite) error {
+	_, err := client.WriteWorkersKVEntries(ctx, cloudflare.AccountIdentifier(GetAccountID()), cloudflare.WriteWorkersKVEntriesParams{
+		NamespaceID: namespaceID,
+		KVs: []*cloudflare.WorkersKVPair{{
+			Key:           entry.Key,
+			Value:         base64.StdEncoding.EncodeToString(entry.Value),
+			Base64:        true,
+			Metadata:      entry.Metadata,
+			Expiration:    int(entry.Expiration),
+			ExpirationTTL: entry.ExpirationTTL,
+		}},
+	})
+	return err
+}
+
+// ReadKV reads the value of a key in a namespace of the configured account
+func ReadKV(ctx context.Context, client *cloudflare.API, namespaceID, key string) ([]byte, error) {
+	return client.GetWorkersKV(ctx, cloudflare.AccountIdentifier(GetAccountID()), cloudflare.GetWorkersKVParams{
+		NamespaceID: namespaceID,
+		Key:         key,
+	})
+}
+
+// DeleteKV deletes a key from a namespace of the configured account
+func DeleteKV(ctx context.Context, client *cloudflare.API, namespaceID, key string) error {
+	_, err := client.DeleteWorkersKVEntry(ctx, cloudflare.AccountIdentifier(GetAccountID()), cloudflare.DeleteWorkersKVEntryParams{
+		NamespaceID: namespaceID,
+		Key:         key,
+	})
+	return err
+}
+
+// kvKeyLookupLimit is how many keys ReadKVKey lists, the smallest page the
+// list endpoint accepts
+const kvKeyLookupLimit = 10
+
+// ListKVKeys lists one page of at most limit keys starting with prefix in a
+// namespace of the configured account, starting at cursor. A limit of zero
+// leaves the page size to the API. The returned cursor is empty after the
+// last page.
+func ListKVKeys(ctx context.Context, client *cloudflare.API, namespaceID, prefix string, limit int, cursor string) ([]cloudflare.StorageKey, string, error) {
+	res, err := client.ListWorkersKVKeys(ctx, cloudflare.AccountIdentifier(GetAccountID()), cloudflare.ListWorkersKVsParams{
+		NamespaceID: namespaceID,
+		Prefix:      prefix,
+		Limit:       limit,
+		Cursor:      cursor,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	next := res.ResultInfo.Cursor
+	if next == "null" {
+		next = ""
+	}
+	return res.Result, next, nil
+}
+
+// ReadKVKey reads a key's listing, with its metadata and expiration, from a
+// namespace of the configured account. Only listing returns these, so the
+// keys starting with key are listed; the key itself sorts first among them.
+// A key that does not exist returns a *cloudflare.NotFoundError.
+func ReadKVKey(ctx context.Context, client *cloudflare.API, namespaceID, key string) (cloudflare.StorageKey, error) {
+	keys, _, err := ListKVKeys(ctx, client, namespaceID, key, kvKeyLookupLimit, "")
+	if err != nil {
+		return cloudflare.StorageKey{}, err
+	}
+
+	for _, k := range keys {
+		if k.Name == key {
+			return k, nil
+		}
+	}
+
+	notFound := cloudflare.NewNotFoundError(&cloudflare.Error{
+		StatusCode: http.StatusNotFound,
+		Errors:     []cloudflare.ResponseInfo{{Code: 10009, Message: fmt.Sprintf("key not found: %s", key)}},
+		ErrorCodes: []int{10009},
+	})
+	return cloudflare.StorageKey{}, &notFound
+}
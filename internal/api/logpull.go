@@ -0,0 +1,202 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+const (
+	// LogpullRetention is how far back Cloudflare keeps request logs for Logpull
+	LogpullRetention = 7 * 24 * time.Hour
+
+	// logpullWindow is the longest time range one Logpull request may cover
+	logpullWindow = time.Hour
+
+	// logpullDelay is how long Cloudflare takes to make a request's log available
+	logpullDelay = time.Minute
+
+	// logpullFields are the request log fields needed to find stale URLs
+	logpullFields = "ClientRequestScheme,ClientRequestHost,ClientRequestURI,CacheCacheStatus"
+)
+
+// logpullRecord is one request from Logpull
+type logpullRecord struct {
+	Scheme      string `json:"ClientRequestScheme"`
+	Host        string `json:"ClientRequestHost"`
+	URI         string `json:"ClientRequestURI"`
+	CacheStatus string `json:"CacheCacheStatus"`
+}
+
+// urlState tracks how a URL was served within the log window
+type urlState struct {
+	servedFromCache bool
+	refreshed       bool
+}
+
+// StaleCachedURLs reads a zone's request logs from cutoff until now and
+// returns the URLs whose cached copy predates cutoff: those served from cache
+// in that time without ever being fetched from the origin again. It needs
+// Logpull, which is only available on Enterprise zones with log retention
+// enabled, and cutoff must be within LogpullRetention.
+func StaleCachedURLs(ctx context.Context, client *cloudflare.API, zoneID string, cutoff, now time.Time) ([]string, error) {
+	if now.Sub(cutoff) > LogpullRetention {
+		return nil, fmt.Errorf("Cloudflare keeps request logs for %s; cannot look back to %s", LogpullRetention, cutoff.Format(time.RFC3339))
+	}
+
+	end := now.Add(-logpullDelay)
+	states := make(map[string]*urlState)
+
+	for start := cutoff; start.Before(end); start = start.Add(logpullWindow) {
+		windowEnd := start.Add(logpullWindow)
+		if windowEnd.After(end) {
+			windowEnd = end
+		}
+
+		err := WithRetry(ctx, func(ctx context.Context) error {
+			return pullLogs(ctx, client, zoneID, start, windowEnd, func(record logpullRecord) {
+				recordCacheStatus(states, record)
+			})
+		})
+		if err != nil {
+			if logsUnavailable(err) {
+				return nil, fmt.Errorf("request logs are not available for zone %s; finding stale URLs needs Logpull, which requires an Enterprise plan with log retention enabled: %w", zoneID, err)
+			}
+			return nil, fmt.Errorf("error reading request logs for zone %s: %w", zoneID, err)
+		}
+	}
+
+	var stale []string
+	for u, state := range states {
+		if state.servedFromCache && !state.refreshed {
+			stale = append(stale, u)
+		}
+	}
+	sort.Strings(stale)
+	return stale, nil
+}
+
+// recordCacheStatus notes whether a request was served from a cached copy or
+// refreshed that copy from the origin
+func recordCacheStatus(states map[string]*urlState, record logpullRecord) {
+	if record.Host == "" || record.URI == "" {
+		return
+	}
+
+	scheme := record.Scheme
+	if scheme == "" {
+		scheme = "https"
+	}
+	u := scheme + "://" + record.Host + record.URI
+
+	state := states[u]
+	switch record.CacheStatus {
+	case "hit", "stale", "updating":
+		if state == nil {
+			state = &urlState{}
+			states[u] = state
+		}
+		state.servedFromCache = true
+	case "miss", "expired", "revalidated":
+		if state == nil {
+			state = &urlState{}
+			states[u] = state
+		}
+		state.refreshed = true
+	}
+}
+
+// pullLogs streams the request logs of one time window to fn. Errors are
+// returned as *cloudflare.Error so that rate limiting is retried.
+func pullLogs(ctx context.Context, client *cloudflare.API, zoneID string, start, end time.Time, fn func(logpullRecord)) error {
+	query := url.Values{}
+	query.Set("start", start.UTC().Format(time.RFC3339))
+	query.Set("end", end.UTC().Format(time.RFC3339))
+	query.Set("fields", logpullFields)
+	endpoint := fmt.Sprintf("%s/zones/%s/logs/received?%s", client.BaseURL, url.PathEscape(zoneID), query.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	if client.APIToken != "" {
+		req.Header.Set("Authorization", "Bearer "+client.APIToken)
+	} else {
+		req.Header.Set("X-Auth-Key", client.APIKey)
+		req.Header.Set("X-Auth-Email", client.APIEmail)
+	}
+	req.Header.Set("User-Agent", client.UserAgent)
+
+	// Logpull answers with newline-delimited JSON rather than the usual API
+	// envelope, so it is requested directly
+	httpClient := &http.Client{Transport: NewTracingTransport(NewRetryAfterTransport(nil))}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return logpullError(resp)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record logpullRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return fmt.Errorf("error parsing request log line: %w", err)
+		}
+		fn(record)
+	}
+	return scanner.Err()
+}
+
+// logpullError converts a failed Logpull response into a *cloudflare.Error,
+// keeping the messages of a JSON error body
+func logpullError(resp *http.Response) error {
+	cfErr := &cloudflare.Error{StatusCode: resp.StatusCode}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	var envelope cloudflare.Response
+	if json.Unmarshal(body, &envelope) == nil && len(envelope.Errors) > 0 {
+		cfErr.Errors = envelope.Errors
+		for _, e := range envelope.Errors {
+			cfErr.ErrorCodes = append(cfErr.ErrorCodes, e.Code)
+			cfErr.ErrorMessages = append(cfErr.ErrorMessages, e.Message)
+		}
+	} else {
+		cfErr.ErrorMessages = []string{http.StatusText(resp.StatusCode)}
+		cfErr.Errors = []cloudflare.ResponseInfo{{Message: http.StatusText(resp.StatusCode)}}
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		cfErr.Type = cloudflare.ErrorTypeRateLimit
+	}
+	return cfErr
+}
+
+// logsUnavailable reports whether a Logpull error means the zone cannot use
+// Logpull at all, rather than a transient failure
+func logsUnavailable(err error) bool {
+	var cfErr *cloudflare.Error
+	if !errors.As(err, &cfErr) {
+		return false
+	}
+	switch cfErr.StatusCode {
+	case http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,772 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cfpurge/internal/util"
+
+	"github.com/cloudflare/cloudflare-go"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// PurgeBatchSize is the maximum number of URLs, tags or prefixes Cloudflare accepts per purge request
+const PurgeBatchSize = 30
+
+// PurgeOptions describes what a purge should cover and how it is sent
+type PurgeOptions struct {
+	// Zones are zone names or IDs to purge; with All, every visible zone is used
+	Zones []string
+	Hosts []string
+
+	// URLs ending in /* are purged by prefix, see util.WildcardPrefix, which
+	// is only available on Enterprise zones
+	URLs       []string
+	Tags       []string
+	All        bool
+	Everything bool
+
+	// ZoneTag narrows the visible zones, see FilterZonesByTag
+	ZoneTag string
+
+	// IncludeZones and ExcludeZones narrow the zones of an account by name,
+	// see FilterZonesByPattern
+	IncludeZones []string
+	ExcludeZones []string
+
+	// CacheZones reuses a zone listing cached on disk for ZoneCacheTTL, and
+	// RefreshZones replaces it with a fresh listing
+	CacheZones   bool
+	RefreshZones bool
+
+	// AccountID narrows the visible zones to a single account, so that All
+	// never reaches zones of other accounts the credentials can see
+	AccountID string
+
+	// Strict fails on malformed URLs or tags instead of skipping them
+	Strict bool
+
+	// BatchSize defaults to PurgeBatchSize and Concurrency to 1
+	BatchSize   int
+	Concurrency int
+
+	// DeviceTypes, when set, purges each URL once per device type, for zones
+	// that cache a separate copy per device type, see DeviceTypes
+	DeviceTypes []string
+
+	// ZoneConcurrency is how many zones are purged at once, default 1. Each
+	// zone sends up to Concurrency requests of its own, and every request
+	// still waits for the client's shared rate limit.
+	ZoneConcurrency int
+
+	// AutoConcurrency replaces Concurrency with a limit that adapts to rate
+	// limiting and latency, see util.NewAdaptiveSemaphore
+	AutoConcurrency bool
+
+	// FailFast skips the remaining zones after the first failure
+	FailFast bool
+
+	// Verbose reports each request's idempotency key through Infof
+	Verbose bool
+
+	// Infof and Warnf receive progress messages; nil discards them
+	Infof func(format string, args ...interface{})
+	Warnf func(format string, args ...interface{})
+
+	// OnZoneDone, if set, is called as each zone finishes
+	OnZoneDone func(result ZoneResult)
+}
+
+// ZoneResult is the outcome of purging a single zone
+type ZoneResult struct {
+	Zone   util.PlanZone
+	Purged string
+	Err    error
+
+	// Failed holds the targets of the requests that failed, so that they can
+	// be retried without repeating the rest of the zone
+	Failed util.PlanZone
+}
+
+// Results collects the outcome of a purge. The embedded util.Results holds the
+// per-zone success and failure counts.
+type Results struct {
+	*util.Results
+	Zones []ZoneResult
+
+	// Deduplicated counts targets skipped because the same zone and target
+	// had already been submitted earlier in the run
+	Deduplicated int
+
+	// NotAttempted holds the zones left unpurged after a fail-fast abort or
+	// an interrupt, so that a later run can resume with them
+	NotAttempted []util.PlanZone
+}
+
+func (o PurgeOptions) infof(format string, args ...interface{}) {
+	if o.Infof != nil {
+		o.Infof(format, args...)
+	}
+}
+
+func (o PurgeOptions) warnf(format string, args ...interface{}) {
+	if o.Warnf != nil {
+		o.Warnf(format, args...)
+	}
+}
+
+func (o PurgeOptions) batchSize() int {
+	if o.BatchSize > 0 {
+		return o.BatchSize
+	}
+	return PurgeBatchSize
+}
+
+func (o PurgeOptions) concurrency() int {
+	if o.Concurrency > 0 {
+		return o.Concurrency
+	}
+	return 1
+}
+
+func (o PurgeOptions) zoneConcurrency() int {
+	if o.ZoneConcurrency > 0 {
+		return o.ZoneConcurrency
+	}
+	return 1
+}
+
+func (o PurgeOptions) semaphore() *util.Semaphore {
+	if o.AutoConcurrency {
+		return util.NewAdaptiveSemaphore(GetRateLimit())
+	}
+	return util.NewSemaphore(o.concurrency())
+}
+
+// Purge plans and executes a purge. Per-zone failures are reported in the
+// results, and also as an error so callers that only check err notice them.
+func Purge(ctx context.Context, client *cloudflare.API, opts PurgeOptions) (Results, error) {
+	plan, err := PlanPurge(ctx, client, opts)
+	if err != nil {
+		return Results{Results: util.NewResults()}, err
+	}
+
+	results := ExecutePurgePlan(ctx, client, plan, opts)
+	if failed := results.Summary().Failed; failed > 0 {
+		return results, fmt.Errorf("%d of %d zones failed to purge", failed, len(results.Zones))
+	}
+
+	return results, nil
+}
+
+// PlanPurge lists the zones visible to client and works out what should be
+// purged from each of them
+func PlanPurge(ctx context.Context, client *cloudflare.API, opts PurgeOptions) (*util.Plan, error) {
+	// Fail before listing zones when nothing was asked for
+	if len(opts.Zones) == 0 && !opts.All && len(opts.Hosts) == 0 && len(opts.URLs) == 0 && len(opts.Tags) == 0 {
+		return nil, fmt.Errorf("must specify at least one zone, use --all flag, or provide hosts/urls/tags")
+	}
+
+	zones, err := listPurgeZones(ctx, client, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.ZoneTag != "" {
+		totalZones := len(zones)
+		zones, err = ApplyZoneTag(zones, opts.ZoneTag)
+		if err != nil {
+			return nil, err
+		}
+		opts.infof("%d of %d zones carry tag '%s'", len(zones), totalZones, opts.ZoneTag)
+	}
+
+	return PlanPurgeForZones(zones, opts)
+}
+
+// PlanPurgeForZones works out what should be purged from each of the given
+// zones, sending every host and URL to the most specific zone it belongs to
+func PlanPurgeForZones(zones []cloudflare.Zone, opts PurgeOptions) (*util.Plan, error) {
+	// Reject malformed tags individually rather than letting a whole batch fail
+	tags, invalidTags := util.SplitValidCacheTags(opts.Tags)
+	if len(invalidTags) > 0 {
+		names := make([]string, 0, len(invalidTags))
+		for tag, err := range invalidTags {
+			names = append(names, fmt.Sprintf("'%s' (%v)", tag, err))
+		}
+		sort.Strings(names)
+		if opts.Strict {
+			return nil, fmt.Errorf("%d invalid cache tags: %s", len(invalidTags), strings.Join(names, ", "))
+		}
+		opts.warnf("Rejected %d invalid cache tags: %s", len(invalidTags), strings.Join(names, ", "))
+	}
+
+	// Normalise URLs so that typos are caught here rather than silently purging nothing
+	var urls, prefixes []string
+	for _, rawURL := range opts.URLs {
+		prefix, wildcard, err := util.WildcardPrefix(rawURL)
+		if wildcard {
+			if err != nil {
+				if opts.Strict {
+					return nil, err
+				}
+				opts.warnf("Skipping %v", err)
+				continue
+			}
+			prefixes = append(prefixes, prefix)
+			continue
+		}
+
+		normalized, err := util.NormalizeURL(rawURL)
+		if err != nil {
+			if opts.Strict {
+				return nil, err
+			}
+			opts.warnf("Skipping %v", err)
+			continue
+		}
+		urls = append(urls, normalized)
+	}
+
+	// Host purges silently ignore entries that are not bare hostnames
+	var hosts []string
+	for _, rawHost := range opts.Hosts {
+		host, err := util.NormalizeHost(rawHost)
+		if err != nil {
+			if opts.Strict {
+				return nil, err
+			}
+			opts.warnf("Skipping %v", err)
+			continue
+		}
+		if host != strings.TrimSpace(rawHost) {
+			opts.warnf("Rewrote host '%s' to '%s'", strings.TrimSpace(rawHost), host)
+		}
+		hosts = append(hosts, host)
+	}
+
+	if len(opts.Zones) == 0 && !opts.All && len(hosts) == 0 && len(urls) == 0 && len(prefixes) == 0 && len(tags) == 0 {
+		return nil, fmt.Errorf("must specify at least one zone, use --all flag, or provide hosts/urls/tags")
+	}
+
+	var account string
+	if opts.AccountID != "" {
+		account = AccountLabel(zones, opts.AccountID)
+		accountZones := FilterZonesByAccount(zones, opts.AccountID)
+		if len(accountZones) == 0 {
+			return nil, fmt.Errorf("none of the %d zones visible to the current credentials belong to account %s", len(zones), opts.AccountID)
+		}
+		opts.infof("Limited to account %s: %d of %d visible zones", account, len(accountZones), len(zones))
+		zones = accountZones
+	}
+
+	if len(opts.IncludeZones) > 0 || len(opts.ExcludeZones) > 0 {
+		matched, err := FilterZonesByPattern(zones, opts.IncludeZones, opts.ExcludeZones)
+		if err != nil {
+			return nil, err
+		}
+		opts.infof("%d of %d zones match the zone name patterns", len(matched), len(zones))
+		zones = matched
+	}
+
+	zoneMap := make(map[string]cloudflare.Zone)
+	for _, zone := range zones {
+		zoneMap[zone.Name] = zone
+		zoneMap[zone.ID] = zone
+	}
+
+	// Map each host and URL to the single zone it belongs to
+	hostsByZone := groupByZone(hosts, zones, func(host string) (string, error) { return host, nil }, opts)
+	urlsByZone := groupByZone(urls, zones, util.HostFromURL, opts)
+	prefixesByZone := groupByZone(prefixes, zones, hostOfPrefix, opts)
+
+	// Prefix purges are an Enterprise feature, so other zones would reject them
+	for _, zone := range zones {
+		if len(prefixesByZone[zone.ID]) == 0 || enterprisePlan(zone) {
+			continue
+		}
+		err := fmt.Errorf("zone %s is on the %s plan; wildcard URLs can only be purged on Enterprise zones", zone.Name, zone.Plan.Name)
+		if opts.Strict {
+			return nil, err
+		}
+		opts.warnf("Skipping %d wildcard URLs: %v", len(prefixesByZone[zone.ID]), err)
+		delete(prefixesByZone, zone.ID)
+	}
+
+	var targetZones []cloudflare.Zone
+	if opts.All {
+		// Tokens scoped to specific zones only see those zones, so make the scope explicit
+		if account != "" {
+			opts.infof("Applying to all %d zones in account %s", len(zones), account)
+		} else {
+			opts.infof("Applying to all %d zones visible to the current credentials", len(zones))
+		}
+		targetZones = zones
+	} else if len(opts.Zones) > 0 {
+		for _, arg := range opts.Zones {
+			if zone, ok := zoneMap[arg]; ok {
+				targetZones = append(targetZones, zone)
+			} else {
+				opts.warnf("Zone '%s' not found among the %d zones visible to the current credentials; check the name, or whether your API token has access to it", arg, len(zones))
+			}
+		}
+	} else if len(hosts) > 0 || len(urls) > 0 || len(prefixes) > 0 {
+		for _, zone := range zones {
+			if len(hostsByZone[zone.ID]) > 0 || len(urlsByZone[zone.ID]) > 0 || len(prefixesByZone[zone.ID]) > 0 {
+				targetZones = append(targetZones, zone)
+			}
+		}
+
+		if len(targetZones) == 0 {
+			return nil, fmt.Errorf("no matching zones found for the specified hosts/URLs")
+		}
+	}
+
+	plan := util.NewPlan("purge")
+	plan.Account = account
+	for _, zone := range targetZones {
+		planZone := util.PlanZone{ID: zone.ID, Name: zone.Name, Everything: opts.Everything}
+		if !opts.Everything {
+			planZone.Hosts = hostsByZone[zone.ID]
+			planZone.URLs = urlsByZone[zone.ID]
+			if len(planZone.URLs) > 0 {
+				planZone.DeviceTypes = opts.DeviceTypes
+			}
+			planZone.Prefixes = prefixesByZone[zone.ID]
+			planZone.Tags = tags
+			if len(planZone.Hosts) == 0 && len(planZone.URLs) == 0 && len(planZone.Prefixes) == 0 && len(planZone.Tags) == 0 {
+				continue
+			}
+		}
+		plan.Zones = append(plan.Zones, planZone)
+	}
+
+	return plan, nil
+}
+
+// ExecutePurgePlan purges the zones in the plan, opts.ZoneConcurrency at a
+// time and in plan order. The requests within a zone are sent with
+// opts.Concurrency; an adaptive concurrency is shared by all zones and carries
+// what it learns from one zone to the next. Overlapping entries are only
+// submitted once per run. Once ctx is cancelled, or a zone fails with
+// opts.FailFast, no further zones are started, and the results cover the zones
+// attempted. A fail-fast failure also cancels the requests of the zones still
+// running, which report what they did not send as failed.
+func ExecutePurgePlan(ctx context.Context, client *cloudflare.API, plan *util.Plan, opts PurgeOptions) Results {
+	purgeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := Results{Results: util.NewResults()}
+	dedup := newPurgeDeduper()
+	sem := opts.semaphore()
+	zoneSem := util.NewSemaphore(opts.zoneConcurrency())
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var failed bool
+	zoneResults := make([]*ZoneResult, len(plan.Zones))
+
+	for i, zone := range plan.Zones {
+		zoneSem.Acquire()
+
+		mu.Lock()
+		stop := failed && opts.FailFast
+		mu.Unlock()
+		if stop {
+			zoneSem.Release(time.Now(), nil)
+			results.NotAttempted = append(results.NotAttempted, plan.Zones[i:]...)
+			break
+		}
+		if ctx.Err() != nil {
+			zoneSem.Release(time.Now(), nil)
+			opts.warnf("Interrupted; skipping the remaining %d zones", len(plan.Zones)-i)
+			results.NotAttempted = append(results.NotAttempted, plan.Zones[i:]...)
+			break
+		}
+
+		zone, deduplicated, ok := dedup.filter(zone)
+		results.Deduplicated += deduplicated
+		if !ok {
+			zoneSem.Release(time.Now(), nil)
+			continue
+		}
+
+		zoneSemaphore := sem
+		if !opts.AutoConcurrency {
+			zoneSemaphore = util.NewSemaphore(opts.concurrency())
+		}
+
+		wg.Add(1)
+		go func(i int, zone util.PlanZone) {
+			defer wg.Done()
+
+			result := purgeZone(purgeCtx, client, zone, zoneSemaphore, opts)
+
+			mu.Lock()
+			zoneResults[i] = &result
+			failed = failed || result.Err != nil
+			if result.Err != nil && opts.FailFast {
+				cancel()
+			}
+			if opts.OnZoneDone != nil {
+				opts.OnZoneDone(result)
+			}
+			mu.Unlock()
+
+			zoneSem.Release(time.Now(), nil)
+		}(i, zone)
+	}
+	wg.Wait()
+
+	for _, result := range zoneResults {
+		if result == nil {
+			continue
+		}
+		results.Zones = append(results.Zones, *result)
+		results.Record(result.Zone.Name, result.Purged, result.Err)
+	}
+
+	return results
+}
+
+// purgeZone sends the purge requests of a single zone, holding sem while
+// sending each request
+func purgeZone(ctx context.Context, client *cloudflare.API, zone util.PlanZone, sem *util.Semaphore, opts PurgeOptions) ZoneResult {
+	result := ZoneResult{Zone: zone, Purged: DescribePurge(zone)}
+
+	operation := "purge"
+	if zone.Everything {
+		operation = "purge_everything"
+	}
+	zoneCtx, span := StartSpan(ctx, "purge zone",
+		attribute.String("cloudflare.zone.id", zone.ID),
+		attribute.String("cloudflare.zone.name", zone.Name),
+		attribute.String("cfpurge.operation", operation),
+		attribute.Int("cfpurge.count", len(zone.Hosts)+len(zone.URLs)+len(zone.Prefixes)+len(zone.Tags)),
+	)
+
+	if zone.Everything {
+		reqCtx, idempotencyKey := WithIdempotencyKey(zoneCtx)
+		if opts.Verbose {
+			opts.infof("Sending purge everything request for zone %s with idempotency key %s", zone.ID, idempotencyKey)
+		}
+		result.Err = WithRetry(reqCtx, func(ctx context.Context) error {
+			_, err := client.PurgeEverything(ctx, zone.ID)
+			return err
+		})
+		if result.Err != nil {
+			result.Failed = zone
+		}
+	} else {
+		var failed []PurgeRequest
+		failed, result.Err = purgeBatches(zoneCtx, client, zone.ID, PurgeRequests(zone, opts.batchSize()), sem, opts)
+		result.Failed = util.PlanZone{ID: zone.ID, Name: zone.Name}
+		for _, req := range failed {
+			result.Failed.Hosts = append(result.Failed.Hosts, req.Hosts...)
+			result.Failed.URLs = append(result.Failed.URLs, req.Files...)
+			result.Failed.Prefixes = append(result.Failed.Prefixes, req.Prefixes...)
+			result.Failed.Tags = append(result.Failed.Tags, req.Tags...)
+			if req.DeviceType != "" {
+				result.Failed.DeviceTypes = append(result.Failed.DeviceTypes, req.DeviceType)
+			}
+		}
+		// A URL batch fails once per device type, but is retried for each
+		result.Failed.URLs = util.FilterDuplicates(result.Failed.URLs)
+		result.Failed.DeviceTypes = util.FilterDuplicates(result.Failed.DeviceTypes)
+	}
+
+	EndSpan(span, result.Err)
+	return result
+}
+
+// FailuresPlan returns a plan holding only the targets that failed to purge,
+// with the error each zone failed with, followed by the zones never attempted.
+// It can be executed like any other plan to retry just those targets.
+func FailuresPlan(results Results) *util.Plan {
+	plan := util.NewPlan("purge")
+	for _, result := range results.Zones {
+		if result.Err == nil {
+			continue
+		}
+		failed := result.Failed
+		failed.Error = result.Err.Error()
+		plan.Zones = append(plan.Zones, failed)
+	}
+	for _, zone := range results.NotAttempted {
+		zone.Error = "not attempted"
+		plan.Zones = append(plan.Zones, zone)
+	}
+	return plan
+}
+
+// UnpurgedZones returns the names of the zones that failed or were never
+// attempted, in plan order
+func UnpurgedZones(results Results) []string {
+	var names []string
+	for _, result := range results.Zones {
+		if result.Err != nil {
+			names = append(names, result.Zone.Name)
+		}
+	}
+	for _, zone := range results.NotAttempted {
+		names = append(names, zone.Name)
+	}
+	return util.FilterDuplicates(names)
+}
+
+// purgeDeduper tracks the (zone, target) pairs already submitted in a run
+type purgeDeduper struct {
+	seen map[string]bool
+}
+
+func newPurgeDeduper() *purgeDeduper {
+	return &purgeDeduper{seen: make(map[string]bool)}
+}
+
+// filter removes targets that were already submitted for the zone and marks the
+// rest as submitted. It returns the remaining zone, how many targets were
+// dropped, and whether anything is left to purge. Once a zone has been purged
+// entirely, any later targets in it are duplicates too.
+func (d *purgeDeduper) filter(zone util.PlanZone) (util.PlanZone, int, bool) {
+	everythingKey := zone.ID + "|everything"
+	if d.seen[everythingKey] {
+		if zone.Everything {
+			return zone, 1, false
+		}
+		return zone, len(zone.Hosts) + len(zone.URLs) + len(zone.Prefixes) + len(zone.Tags), false
+	}
+
+	if zone.Everything {
+		d.seen[everythingKey] = true
+		return zone, 0, true
+	}
+
+	deduplicated := 0
+	unique := func(kind string, targets []string) []string {
+		var kept []string
+		for _, target := range targets {
+			key := zone.ID + "|" + kind + "|" + target
+			if d.seen[key] {
+				deduplicated++
+				continue
+			}
+			d.seen[key] = true
+			kept = append(kept, target)
+		}
+		return kept
+	}
+
+	zone.Hosts = unique("host", zone.Hosts)
+	zone.URLs = unique("url", zone.URLs)
+	zone.Prefixes = unique("prefix", zone.Prefixes)
+	zone.Tags = unique("tag", zone.Tags)
+
+	return zone, deduplicated, len(zone.Hosts) > 0 || len(zone.URLs) > 0 || len(zone.Prefixes) > 0 || len(zone.Tags) > 0
+}
+
+// DescribePurge summarises what a plan zone purges, e.g. "2 hosts, 40 URLs"
+func DescribePurge(zone util.PlanZone) string {
+	if zone.Everything {
+		return "everything"
+	}
+
+	var parts []string
+	if len(zone.Hosts) > 0 {
+		parts = append(parts, fmt.Sprintf("%d hosts", len(zone.Hosts)))
+	}
+	if len(zone.URLs) > 0 && len(zone.DeviceTypes) > 0 {
+		parts = append(parts, fmt.Sprintf("%d URLs for %s", len(zone.URLs), strings.Join(zone.DeviceTypes, ", ")))
+	} else if len(zone.URLs) > 0 {
+		parts = append(parts, fmt.Sprintf("%d URLs", len(zone.URLs)))
+	}
+	if len(zone.Prefixes) > 0 {
+		parts = append(parts, fmt.Sprintf("%d prefixes", len(zone.Prefixes)))
+	}
+	if len(zone.Tags) > 0 {
+		parts = append(parts, fmt.Sprintf("%d tags", len(zone.Tags)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// PurgeRequests splits the hosts, URLs, prefixes and tags for a zone into purge
+// requests, batching all but hosts in groups of batchSize. A zone with device
+// types has its URLs purged once per device type instead of once.
+func PurgeRequests(zone util.PlanZone, batchSize int) []PurgeRequest {
+	var requests []PurgeRequest
+
+	if len(zone.Hosts) > 0 {
+		requests = append(requests, PurgeRequest{PurgeCacheRequest: cloudflare.PurgeCacheRequest{Hosts: zone.Hosts}})
+	}
+
+	if len(zone.DeviceTypes) == 0 {
+		for _, batch := range util.Chunk(zone.URLs, batchSize) {
+			requests = append(requests, PurgeRequest{PurgeCacheRequest: cloudflare.PurgeCacheRequest{Files: batch}})
+		}
+	}
+	for _, deviceType := range zone.DeviceTypes {
+		for _, batch := range util.Chunk(zone.URLs, batchSize) {
+			requests = append(requests, PurgeRequest{PurgeCacheRequest: cloudflare.PurgeCacheRequest{Files: batch}, DeviceType: deviceType})
+		}
+	}
+
+	for _, batch := range util.Chunk(zone.Prefixes, batchSize) {
+		requests = append(requests, PurgeRequest{PurgeCacheRequest: cloudflare.PurgeCacheRequest{Prefixes: batch}})
+	}
+
+	for _, batch := range util.Chunk(zone.Tags, batchSize) {
+		requests = append(requests, PurgeRequest{PurgeCacheRequest: cloudflare.PurgeCacheRequest{Tags: batch}})
+	}
+
+	return requests
+}
+
+// APIRequest is a purge request as it would be sent to the Cloudflare API,
+// without the credential headers
+type APIRequest struct {
+	Method string
+	URL    string
+	Body   []byte
+}
+
+// ZoneAPIRequests returns the requests a purge of zone sends, with the same
+// batching as a real run; a batchSize of zero or less means PurgeBatchSize
+func ZoneAPIRequests(baseURL string, zone util.PlanZone, batchSize int) ([]APIRequest, error) {
+	if batchSize <= 0 {
+		batchSize = PurgeBatchSize
+	}
+
+	purgeReqs := []PurgeRequest{{PurgeCacheRequest: cloudflare.PurgeCacheRequest{Everything: true}}}
+	if !zone.Everything {
+		purgeReqs = PurgeRequests(zone, batchSize)
+	}
+
+	requests := make([]APIRequest, 0, len(purgeReqs))
+	for _, purgeReq := range purgeReqs {
+		body, err := json.Marshal(purgeReq)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding purge request for zone %s: %w", zone.Name, err)
+		}
+		requests = append(requests, APIRequest{
+			Method: "POST",
+			URL:    fmt.Sprintf("%s/zones/%s/purge_cache", strings.TrimSuffix(baseURL, "/"), zone.ID),
+			Body:   body,
+		})
+	}
+	return requests, nil
+}
+
+// groupByZone assigns each item to the most specific zone its hostname belongs to,
+// keyed by zone ID. Items that cannot be parsed or match no zone are reported.
+func groupByZone(items []string, zones []cloudflare.Zone, hostOf func(string) (string, error), opts PurgeOptions) map[string][]string {
+	zoneNames := make([]string, 0, len(zones))
+	zoneIDs := make(map[string]string)
+	for _, zone := range zones {
+		zoneNames = append(zoneNames, zone.Name)
+		zoneIDs[zone.Name] = zone.ID
+	}
+
+	grouped := make(map[string][]string)
+	for _, item := range items {
+		host, err := hostOf(item)
+		if err != nil {
+			opts.warnf("Skipping '%s': %v", item, err)
+			continue
+		}
+
+		zoneName, ok := util.BestZoneMatch(host, zoneNames)
+		if !ok {
+			opts.warnf("Skipping '%s': no visible zone matches host '%s'", item, host)
+			continue
+		}
+
+		zoneID := zoneIDs[zoneName]
+		grouped[zoneID] = append(grouped[zoneID], item)
+	}
+
+	return grouped
+}
+
+// hostOfPrefix returns the hostname a purge prefix starts with
+func hostOfPrefix(prefix string) (string, error) {
+	host, _, _ := strings.Cut(prefix, "/")
+	return util.NormalizeHost(host)
+}
+
+// purgeBatches sends a zone's purge requests with bounded concurrency and returns
+// the requests that failed along with the first error encountered. Requests still
+// pass through the client's rate limiter, so raising concurrency does not bypass it.
+// Once ctx is cancelled, or a request fails with opts.FailFast, no further
+// requests are sent; they are returned as failed.
+func purgeBatches(ctx context.Context, client *cloudflare.API, zoneID string, requests []PurgeRequest, sem *util.Semaphore, opts PurgeOptions) ([]PurgeRequest, error) {
+	var wg sync.WaitGroup
+	var errMutex sync.Mutex
+	var firstErr error
+	var failed, unsent []PurgeRequest
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ctx = WithSemaphore(ctx, sem)
+
+	for i, purgeReq := range requests {
+		if ctx.Err() != nil {
+			unsent = requests[i:]
+			break
+		}
+		wg.Add(1)
+		sem.Acquire()
+
+		go func(purgeReq PurgeRequest) {
+			defer wg.Done()
+
+			start := time.Now()
+			err := purgeCacheWithRetry(ctx, client, zoneID, purgeReq, opts)
+			sem.Release(start, err)
+			if err != nil {
+				errMutex.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				failed = append(failed, purgeReq)
+				if opts.FailFast {
+					cancel()
+				}
+				errMutex.Unlock()
+			}
+		}(purgeReq)
+	}
+
+	wg.Wait()
+	if len(unsent) > 0 {
+		failed = append(failed, unsent...)
+		if firstErr == nil {
+			firstErr = fmt.Errorf("%d purge requests not sent: %w", len(unsent), ctx.Err())
+		}
+	}
+	return failed, firstErr
+}
+
+// purgeCacheWithRetry issues a purge request, retrying if Cloudflare rate limits it.
+// All attempts share one idempotency key so a retry after an ambiguous failure is
+// recognisable as the same purge.
+func purgeCacheWithRetry(ctx context.Context, client *cloudflare.API, zoneID string, purgeReq PurgeRequest, opts PurgeOptions) error {
+	ctx, idempotencyKey := WithIdempotencyKey(ctx)
+	if opts.Verbose {
+		opts.infof("Sending purge request for zone %s with idempotency key %s", zoneID, idempotencyKey)
+	}
+
+	return WithRetry(ctx, func(ctx context.Context) error {
+		// The SDK only sends plain URLs, so device type purges are sent raw
+		if purgeReq.DeviceType != "" {
+			_, err := client.Raw(ctx, http.MethodPost, fmt.Sprintf("/zones/%s/purge_cache", zoneID), purgeReq, nil)
+			return err
+		}
+		_, err := client.PurgeCache(ctx, zoneID, purgeReq.PurgeCacheRequest)
+		return err
+	})
+}
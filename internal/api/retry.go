@@ -0,0 +1,254 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cfpurge/internal/util"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+const (
+	// maxRetries is the number of times a rate-limited call is re-attempted
+	maxRetries = 5
+
+	// baseRetryDelay is the backoff used when Cloudflare does not send Retry-After
+	baseRetryDelay = time.Second
+
+	// maxRetryDelay caps how long a single wait may be
+	maxRetryDelay = 60 * time.Second
+)
+
+type semaphoreKey struct{}
+
+// WithSemaphore returns a context whose rate-limited requests are reported to
+// sem, so that an adaptive semaphore backs off
+func WithSemaphore(ctx context.Context, sem *util.Semaphore) context.Context {
+	return context.WithValue(ctx, semaphoreKey{}, sem)
+}
+
+// RateLimitError is a 429 response from the API. cloudflare-go reduces a 429
+// to an untyped error once its own retries run out, so the transport returns
+// this in place of the response for rate limiting to be told apart.
+type RateLimitError struct {
+	// RetryAfter is the wait Cloudflare asked for, or -1 when it sent no
+	// Retry-After header
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	if e.RetryAfter >= 0 {
+		return fmt.Sprintf("rate limited by the Cloudflare API (HTTP 429), retry after %s", e.RetryAfter)
+	}
+	return "rate limited by the Cloudflare API (HTTP 429)"
+}
+
+// retryAfterTransport turns rate-limited responses into a *RateLimitError and
+// holds back further requests until the Retry-After time has passed, so that
+// cloudflare-go's own retries wait as long as Cloudflare asks too. Every 429 is
+// reported to the semaphore of the request's context, see WithSemaphore,
+// including those cloudflare-go retries without returning.
+type retryAfterTransport struct {
+	base http.RoundTripper
+
+	mu    sync.Mutex
+	until time.Time
+}
+
+// NewRetryAfterTransport wraps an HTTP transport so that rate-limited responses
+// become a *RateLimitError and their Retry-After header is honoured
+func NewRetryAfterTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &retryAfterTransport{base: base}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *retryAfterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.wait(req.Context()); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusTooManyRequests {
+		return resp, err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+
+	if sem, ok := req.Context().Value(semaphoreKey{}).(*util.Semaphore); ok {
+		sem.RateLimited()
+	}
+
+	rateErr := &RateLimitError{RetryAfter: -1}
+	if delay, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+		rateErr.RetryAfter = delay
+
+		t.mu.Lock()
+		if until := time.Now().Add(delay); until.After(t.until) {
+			t.until = until
+		}
+		t.mu.Unlock()
+	}
+
+	return nil, rateErr
+}
+
+// wait blocks until the last Retry-After time has passed or ctx is done
+func (t *retryAfterTransport) wait(ctx context.Context) error {
+	t.mu.Lock()
+	delay := time.Until(t.until)
+	t.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// parseRetryAfter understands both the delay-seconds and HTTP-date forms of Retry-After
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		delay := time.Until(date)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+
+	return 0, false
+}
+
+// sdkRateLimitMessage is the untyped error cloudflare-go returns once its own
+// retries of a 429 run out, for clients not built with NewRetryAfterTransport
+const sdkRateLimitMessage = "exceeded available rate limit retries"
+
+// IsRateLimited reports whether an error is a Cloudflare 429 response
+func IsRateLimited(err error) bool {
+	var transportErr *RateLimitError
+	if errors.As(err, &transportErr) {
+		return true
+	}
+	if err != nil && strings.Contains(err.Error(), sdkRateLimitMessage) {
+		return true
+	}
+
+	var rateLimitErr *cloudflare.RatelimitError
+	if errors.As(err, &rateLimitErr) {
+		return true
+	}
+
+	var cfErr *cloudflare.Error
+	if errors.As(err, &cfErr) {
+		return cfErr.StatusCode == http.StatusTooManyRequests || cfErr.Type == cloudflare.ErrorTypeRateLimit
+	}
+
+	return false
+}
+
+// classifiedError keeps the message of an error while also matching kind
+// with errors.Is
+type classifiedError struct {
+	err  error
+	kind error
+}
+
+func (e *classifiedError) Error() string   { return e.err.Error() }
+func (e *classifiedError) Unwrap() []error { return []error{e.err, e.kind} }
+
+// ClassifyError marks a Cloudflare API error with the matching util sentinel,
+// so that its exit status and error code can be told apart from other failures
+func ClassifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var authnErr *cloudflare.AuthenticationError
+	var authzErr *cloudflare.AuthorizationError
+	var cfErr *cloudflare.Error
+	switch {
+	case errors.Is(err, util.ErrAuthFailed), errors.Is(err, util.ErrRateLimited), errors.Is(err, util.ErrAPI):
+		return err
+	case errors.As(err, &authnErr), errors.As(err, &authzErr):
+		return &classifiedError{err: err, kind: util.ErrAuthFailed}
+	case IsRateLimited(err):
+		return &classifiedError{err: err, kind: util.ErrRateLimited}
+	case errors.As(err, &cfErr):
+		if cfErr.StatusCode == http.StatusUnauthorized || cfErr.StatusCode == http.StatusForbidden {
+			return &classifiedError{err: err, kind: util.ErrAuthFailed}
+		}
+		return &classifiedError{err: err, kind: util.ErrAPI}
+	}
+
+	var notFoundErr *cloudflare.NotFoundError
+	var requestErr *cloudflare.RequestError
+	var serviceErr *cloudflare.ServiceError
+	if errors.As(err, &notFoundErr) || errors.As(err, &requestErr) || errors.As(err, &serviceErr) {
+		return &classifiedError{err: err, kind: util.ErrAPI}
+	}
+	return err
+}
+
+// WithRetry runs op, re-attempting it when Cloudflare responds with 429. The wait
+// between attempts follows the Retry-After header when present and falls back to
+// exponential backoff otherwise. The context passed to op must be used for the
+// API call so that it is cancelled with ctx.
+func WithRetry(ctx context.Context, op func(ctx context.Context) error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = op(ctx)
+		if err == nil || !IsRateLimited(err) || attempt >= maxRetries {
+			return err
+		}
+
+		delay := time.Duration(-1)
+		var rateErr *RateLimitError
+		if errors.As(err, &rateErr) {
+			delay = rateErr.RetryAfter
+		}
+		if delay < 0 {
+			delay = baseRetryDelay << attempt
+		}
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
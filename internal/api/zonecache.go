@@ -0,0 +1,153 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cfpurge/internal/util"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// ZoneCacheTTL is how long an on-disk zone listing stays valid
+const ZoneCacheTTL = 10 * time.Minute
+
+// zoneCache is the on-disk form of a zone listing for one account
+type zoneCache struct {
+	AccountID string            `json:"account_id"`
+	FetchedAt time.Time         `json:"fetched_at"`
+	Zones     []cloudflare.Zone `json:"zones"`
+}
+
+// listPurgeZones lists the zones visible to client. With opts.CacheZones the
+// listing is shared between invocations through a cache file keyed by the
+// client's credentials and the account ID; a cached listing that lacks a zone
+// the purge refers to is treated as stale and replaced.
+func listPurgeZones(ctx context.Context, client *cloudflare.API, opts PurgeOptions) ([]cloudflare.Zone, error) {
+	if opts.CacheZones && !opts.RefreshZones {
+		if cached, ok := readZoneCache(client); ok {
+			missing := missingZoneReference(cached, opts)
+			if missing == "" {
+				opts.infof("Using %d zones cached within the last %s", len(cached), ZoneCacheTTL)
+				return cached, nil
+			}
+			opts.infof("Cached zone list has no zone for '%s'; listing zones again", missing)
+		}
+	}
+
+	var zones []cloudflare.Zone
+	err := WithRetry(ctx, func(ctx context.Context) error {
+		var err error
+		zones, err = client.ListZones(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting zones: %w", err)
+	}
+
+	if opts.CacheZones {
+		// A failed cache write only costs a re-list next time
+		_ = writeZoneCache(client, zones)
+	}
+
+	return zones, nil
+}
+
+// missingZoneReference returns the first zone, host or URL in opts that none
+// of the zones covers, or "" when every reference is covered
+func missingZoneReference(zones []cloudflare.Zone, opts PurgeOptions) string {
+	if _, missing := SelectZones(zones, opts.Zones); len(missing) > 0 {
+		return missing[0]
+	}
+
+	zoneNames := make([]string, len(zones))
+	for i, zone := range zones {
+		zoneNames[i] = zone.Name
+	}
+
+	for _, host := range opts.Hosts {
+		normalized, err := util.NormalizeHost(host)
+		if err == nil {
+			if _, ok := util.BestZoneMatch(normalized, zoneNames); !ok {
+				return host
+			}
+		}
+	}
+
+	for _, rawURL := range opts.URLs {
+		host, err := util.HostFromURL(rawURL)
+		if err == nil {
+			if _, ok := util.BestZoneMatch(host, zoneNames); !ok {
+				return rawURL
+			}
+		}
+	}
+
+	return ""
+}
+
+// zoneCachePath returns the cache file for the client's credentials and the
+// configured account. Credentials see different zones, so each has its own
+// file, named by a hash so that the credentials are not written to disk.
+func zoneCachePath(client *cloudflare.API) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	key := sha256.Sum256([]byte(strings.Join([]string{client.APIToken, client.APIKey, client.APIEmail, GetAccountID()}, "\x00")))
+	return filepath.Join(dir, "cfpurge", fmt.Sprintf("zones-%x.json", key[:8])), nil
+}
+
+// readZoneCache loads the cached zone listing if it is still fresh
+func readZoneCache(client *cloudflare.API) ([]cloudflare.Zone, bool) {
+	path, err := zoneCachePath(client)
+	if err != nil {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+
+	var cache zoneCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, false
+	}
+
+	if cache.AccountID != GetAccountID() || time.Since(cache.FetchedAt) > ZoneCacheTTL {
+		return nil, false
+	}
+
+	return cache.Zones, true
+}
+
+// writeZoneCache saves a zone listing for later invocations
+func writeZoneCache(client *cloudflare.API, zones []cloudflare.Zone) error {
+	path, err := zoneCachePath(client)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(zoneCache{
+		AccountID: GetAccountID(),
+		FetchedAt: time.Now().UTC(),
+		Zones:     zones,
+	})
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0o600)
+}
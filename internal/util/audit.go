@@ -0,0 +1,150 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Audit outcomes
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+// AuditEntry is one line of the audit log, recording a single destructive
+// operation such as purging a zone or deleting keys from a namespace
+type AuditEntry struct {
+	Time    time.Time `json:"time"`
+	User    string    `json:"user,omitempty"`
+	Command string    `json:"command"`
+	Scope   string    `json:"scope"`
+	Targets []string  `json:"targets,omitempty"`
+	Outcome string    `json:"outcome"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// auditLog appends entries to a JSONL file and/or syslog. Each entry is
+// written as soon as it is recorded, so that an abrupt exit loses nothing.
+type auditLog struct {
+	mu      sync.Mutex
+	file    *os.File
+	syslog  io.WriteCloser
+	command string
+	user    string
+	warned  bool
+}
+
+// audit is the log opened by OpenAuditLog, or nil when auditing is off
+var audit *auditLog
+
+// OpenAuditLog starts recording destructive operations of command to a JSONL
+// file appended at path, to syslog, or both. Entries record the user from
+// $USER.
+func OpenAuditLog(path string, useSyslog bool, command string) error {
+	log := &auditLog{command: command, user: os.Getenv("USER")}
+	if log.user == "" {
+		log.user = os.Getenv("USERNAME")
+	}
+
+	if path != "" {
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return fmt.Errorf("error opening audit log: %w", err)
+		}
+		log.file = file
+	}
+
+	if useSyslog {
+		writer, err := openSyslog()
+		if err != nil {
+			if log.file != nil {
+				log.file.Close()
+			}
+			return fmt.Errorf("error connecting to syslog: %w", err)
+		}
+		log.syslog = writer
+	}
+
+	audit = log
+	return nil
+}
+
+// CloseAuditLog flushes the audit log to disk and closes it
+func CloseAuditLog() error {
+	log := audit
+	if log == nil {
+		return nil
+	}
+	audit = nil
+
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
+	var firstErr error
+	if log.file != nil {
+		if err := log.file.Sync(); err != nil {
+			firstErr = err
+		}
+		if err := log.file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if log.syslog != nil {
+		if err := log.syslog.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return fmt.Errorf("error closing audit log: %w", firstErr)
+	}
+	return nil
+}
+
+// Audit records the outcome of an operation on targets within scope, e.g. a
+// zone or namespace. It does nothing unless an audit log is open, and is safe
+// for concurrent use. A failure to write is reported once as a warning.
+func Audit(scope string, targets []string, err error) {
+	log := audit
+	if log == nil {
+		return
+	}
+
+	entry := AuditEntry{
+		Time:    time.Now().UTC(),
+		User:    log.user,
+		Command: log.command,
+		Scope:   scope,
+		Targets: targets,
+		Outcome: AuditSuccess,
+	}
+	if err != nil {
+		entry.Outcome = AuditFailure
+		entry.Error = err.Error()
+	}
+
+	line, marshalErr := json.Marshal(entry)
+	if marshalErr != nil {
+		return
+	}
+
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
+	var writeErr error
+	if log.file != nil {
+		_, writeErr = log.file.Write(append(line, '\n'))
+	}
+	if log.syslog != nil {
+		if _, err := log.syslog.Write(line); err != nil && writeErr == nil {
+			writeErr = err
+		}
+	}
+	if writeErr != nil && !log.warned {
+		log.warned = true
+		Warning("Error writing audit log: %v", writeErr)
+	}
+}
@@ -0,0 +1,73 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// DefaultChangesBaseURL builds asset URLs from the zone name and asset path
+const DefaultChangesBaseURL = "https://{zone}/{path}"
+
+// ChangedAsset is one entry of a build tool's changes file
+type ChangedAsset struct {
+	Path string `json:"path"`
+	Zone string `json:"zone"`
+}
+
+// ZoneChanges holds the URLs of the changed assets of one zone
+type ZoneChanges struct {
+	Zone string
+	URLs []string
+}
+
+// ReadChangesFile parses a JSON array of {"path", "zone"} objects, as emitted
+// by build tools, and builds a URL for each asset from the base-URL template.
+// {zone} in the template is replaced with the asset's zone and {path} with its
+// path; without {path} the path is appended. Results are sorted by zone.
+func ReadChangesFile(path, template string) ([]ZoneChanges, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading changes file: %w", err)
+	}
+
+	var assets []ChangedAsset
+	if err := json.Unmarshal(data, &assets); err != nil {
+		return nil, fmt.Errorf("error parsing changes file %s: expected a JSON array of {\"path\", \"zone\"} objects: %w", path, err)
+	}
+
+	return ChangedAssetURLs(assets, template)
+}
+
+// ChangedAssetURLs builds the URL of each changed asset and groups them by zone,
+// rejecting entries without a path or zone
+func ChangedAssetURLs(assets []ChangedAsset, template string) ([]ZoneChanges, error) {
+	if template == "" {
+		template = DefaultChangesBaseURL
+	}
+
+	byZone := make(map[string][]string)
+	for i, asset := range assets {
+		zone := strings.ToLower(strings.TrimSpace(asset.Zone))
+		assetPath := strings.TrimSpace(asset.Path)
+		if zone == "" {
+			return nil, fmt.Errorf("changes entry %d has no zone", i+1)
+		}
+		if assetPath == "" {
+			return nil, fmt.Errorf("changes entry %d has no path", i+1)
+		}
+
+		url := URLForPath(strings.ReplaceAll(template, "{zone}", zone), assetPath)
+		byZone[zone] = append(byZone[zone], url)
+	}
+
+	changes := make([]ZoneChanges, 0, len(byZone))
+	for zone, urls := range byZone {
+		changes = append(changes, ZoneChanges{Zone: zone, URLs: FilterDuplicates(urls)})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Zone < changes[j].Zone })
+
+	return changes, nil
+}
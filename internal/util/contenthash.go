@@ -0,0 +1,128 @@
+package util
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// hashBustParam is added to URLs fetched for hashing so that Cloudflare
+// misses its cache and the origin's current content is hashed
+const hashBustParam = "cfpurge-hash"
+
+// hashClient fetches URLs to hash their content
+var hashClient = &http.Client{Timeout: 30 * time.Second}
+
+// HashManifest maps each URL to the SHA-256 of its content when last purged
+type HashManifest map[string]string
+
+// ReadHashManifest loads a manifest written by WriteHashManifest. A missing
+// file is not an error and returns an empty manifest, so that the first run
+// treats every URL as changed.
+func ReadHashManifest(path string) (HashManifest, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return HashManifest{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading hash file: %w", err)
+	}
+
+	manifest := HashManifest{}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("error parsing hash file %s: %w", path, err)
+	}
+	return manifest, nil
+}
+
+// WriteHashManifest saves a manifest as JSON, replacing any earlier one
+func WriteHashManifest(path string, manifest HashManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding hash file: %w", err)
+	}
+
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("error writing hash file: %w", err)
+	}
+	return nil
+}
+
+// HashURLs fetches every URL from the origin, bypassing Cloudflare's cache with
+// a unique query parameter, and returns the SHA-256 of each response body.
+// URLs that cannot be fetched, or answer with an error status, are returned in
+// errs instead.
+func HashURLs(ctx context.Context, urls []string, concurrency int) (hashes map[string]string, errs map[string]error) {
+	var mu sync.Mutex
+	hashes = make(map[string]string)
+	errs = make(map[string]error)
+
+	forEachURL(urls, concurrency, func(rawURL string) {
+		hash, err := hashURL(ctx, rawURL)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			errs[rawURL] = err
+			return
+		}
+		hashes[rawURL] = hash
+	})
+	return hashes, errs
+}
+
+// hashURL fetches one URL, bypassing the cache, and hashes its body
+func hashURL(ctx context.Context, rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("error parsing %s: %w", rawURL, err)
+	}
+	query := u.Query()
+	query.Set(hashBustParam, strconv.FormatInt(time.Now().UnixNano(), 36))
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", fmt.Errorf("error fetching %s: %w", rawURL, err)
+	}
+
+	resp, err := hashClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error fetching %s: %w", rawURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return "", fmt.Errorf("error fetching %s: %s", rawURL, resp.Status)
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, resp.Body); err != nil {
+		return "", fmt.Errorf("error reading %s: %w", rawURL, err)
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// ChangedURLs returns the URLs whose hash differs from the manifest or that
+// are not in it yet. URLs without a hash, because they could not be fetched,
+// are treated as changed.
+func ChangedURLs(urls []string, hashes map[string]string, manifest HashManifest) []string {
+	var changed []string
+	for _, u := range urls {
+		hash, ok := hashes[u]
+		if !ok || manifest[u] != hash {
+			changed = append(changed, u)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,29 @@
+package util
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// DetectContentType guesses the MIME type of a stored value from its first
+// bytes, as http.DetectContentType does, but labels JSON, which it reports as
+// plain text. binary is true for anything that is not text and would print as
+// garbage on a terminal.
+func DetectContentType(value []byte) (mimeType string, binary bool) {
+	mimeType = http.DetectContentType(value)
+	if strings.HasPrefix(mimeType, "text/plain") && json.Valid(value) {
+		return "application/json", false
+	}
+	return mimeType, !strings.HasPrefix(mimeType, "text/")
+}
+
+// IsTerminal reports whether w is a terminal rather than a file or pipe
+func IsTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	return ok && term.IsTerminal(int(f.Fd()))
+}
@@ -0,0 +1,64 @@
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// SavedCursor is where a paginated kv list stopped, recorded so a later run
+// can continue from the next page
+type SavedCursor struct {
+	Namespace string `json:"namespace"`
+	Prefix    string `json:"prefix,omitempty"`
+	Cursor    string `json:"cursor"`
+}
+
+// WriteCursor saves a cursor as JSON, replacing any earlier one
+func WriteCursor(path string, cursor SavedCursor) error {
+	data, err := json.MarshalIndent(cursor, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding cursor: %w", err)
+	}
+
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("error writing cursor: %w", err)
+	}
+
+	return nil
+}
+
+// ReadCursor loads a cursor written by WriteCursor. A missing file is not an
+// error and returns nil, so the first run of a session starts from the
+// beginning. A cursor saved for a different namespace or prefix is rejected,
+// since it would resume somewhere unrelated.
+func ReadCursor(path, namespace, prefix string) (*SavedCursor, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading cursor: %w", err)
+	}
+
+	var cursor SavedCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, fmt.Errorf("error parsing cursor %s: %w", path, err)
+	}
+
+	if cursor.Namespace != namespace || cursor.Prefix != prefix {
+		return nil, fmt.Errorf("cursor %s was saved for namespace %s with prefix %q, not namespace %s with prefix %q", path, cursor.Namespace, cursor.Prefix, namespace, prefix)
+	}
+
+	return &cursor, nil
+}
+
+// ClearCursor removes a saved cursor once pagination is complete
+func ClearCursor(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("error removing cursor: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package util
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+)
+
+// Kinds of difference reported by DiffKeys
+const (
+	DiffOnlySource = "only_source"
+	DiffOnlyDest   = "only_dest"
+	DiffChanged    = "changed"
+)
+
+// DiffEntry is a key's state in one namespace. Value is nil unless values are
+// being compared.
+type DiffEntry struct {
+	Metadata interface{}
+	Value    []byte
+}
+
+// KeyDiff describes how one key differs between a source and destination
+// namespace. Fields lists what changed for keys present in both.
+type KeyDiff struct {
+	Key            string      `json:"key" yaml:"key"`
+	Status         string      `json:"status" yaml:"status"`
+	Fields         []string    `json:"fields,omitempty" yaml:"fields,omitempty"`
+	SourceMetadata interface{} `json:"source_metadata,omitempty" yaml:"source_metadata,omitempty"`
+	DestMetadata   interface{} `json:"dest_metadata,omitempty" yaml:"dest_metadata,omitempty"`
+}
+
+// DiffSummary counts the keys in each state
+type DiffSummary struct {
+	SourceKeys int `json:"source_keys" yaml:"source_keys"`
+	DestKeys   int `json:"dest_keys" yaml:"dest_keys"`
+	OnlySource int `json:"only_source" yaml:"only_source"`
+	OnlyDest   int `json:"only_dest" yaml:"only_dest"`
+	Changed    int `json:"changed" yaml:"changed"`
+	Identical  int `json:"identical" yaml:"identical"`
+}
+
+// DiffKeys compares the keys of two namespaces and returns the differing keys
+// sorted by name. Metadata is compared structurally; values are compared byte
+// for byte when both entries have one.
+func DiffKeys(source, dest map[string]DiffEntry) ([]KeyDiff, DiffSummary) {
+	summary := DiffSummary{SourceKeys: len(source), DestKeys: len(dest)}
+	var diffs []KeyDiff
+
+	for key, src := range source {
+		dst, ok := dest[key]
+		if !ok {
+			diffs = append(diffs, KeyDiff{Key: key, Status: DiffOnlySource, SourceMetadata: src.Metadata})
+			summary.OnlySource++
+			continue
+		}
+
+		var fields []string
+		if !reflect.DeepEqual(src.Metadata, dst.Metadata) {
+			fields = append(fields, "metadata")
+		}
+		if src.Value != nil && dst.Value != nil && !bytes.Equal(src.Value, dst.Value) {
+			fields = append(fields, "value")
+		}
+		if len(fields) == 0 {
+			summary.Identical++
+			continue
+		}
+
+		diffs = append(diffs, KeyDiff{Key: key, Status: DiffChanged, Fields: fields, SourceMetadata: src.Metadata, DestMetadata: dst.Metadata})
+		summary.Changed++
+	}
+
+	for key, dst := range dest {
+		if _, ok := source[key]; !ok {
+			diffs = append(diffs, KeyDiff{Key: key, Status: DiffOnlyDest, DestMetadata: dst.Metadata})
+			summary.OnlyDest++
+		}
+	}
+
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Key < diffs[j].Key })
+	return diffs, summary
+}
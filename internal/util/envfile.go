@@ -0,0 +1,103 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ReadEnvFile parses a dotenv file of KEY=value lines. Blank lines and lines
+// starting with # are skipped, and an "export " prefix is allowed. Values may
+// be double quoted, where \n, \", \\ and \$ are unescaped, or single quoted,
+// where they are taken literally; an unquoted value ends at " #".
+func ReadEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening env file: %w", err)
+	}
+	defer f.Close()
+
+	vars := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		name = strings.TrimSpace(name)
+		if !ok || !validEnvName(name) {
+			return nil, fmt.Errorf("%s line %d: expected KEY=value", path, lineNum)
+		}
+
+		value, err := parseEnvValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", path, lineNum, err)
+		}
+		vars[name] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading env file: %w", err)
+	}
+
+	return vars, nil
+}
+
+// parseEnvValue unquotes a dotenv value
+func parseEnvValue(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	switch quote := value[0]; quote {
+	case '\'', '"':
+		end := closingQuote(value, quote)
+		if end < 0 {
+			return "", fmt.Errorf("unterminated %c quote", quote)
+		}
+		if rest := strings.TrimSpace(value[end+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+			return "", fmt.Errorf("unexpected text after quoted value: %s", rest)
+		}
+		inner := value[1:end]
+		if quote == '\'' {
+			return inner, nil
+		}
+		return strings.NewReplacer(`\n`, "\n", `\"`, `"`, `\\`, `\`, `\$`, "$").Replace(inner), nil
+	}
+
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(value), nil
+}
+
+// closingQuote returns the index of the quote closing value[0], skipping
+// backslash escapes inside double quotes, or -1 when there is none
+func closingQuote(value string, quote byte) int {
+	for i := 1; i < len(value); i++ {
+		switch {
+		case quote == '"' && value[i] == '\\':
+			i++
+		case value[i] == quote:
+			return i
+		}
+	}
+	return -1
+}
+
+// validEnvName reports whether name can be an environment variable name
+func validEnvName(name string) bool {
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		return false
+	}
+	for _, r := range name {
+		if !(r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')) {
+			return false
+		}
+	}
+	return true
+}
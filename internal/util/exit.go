@@ -0,0 +1,111 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ExitNothingMatched is the exit status used under --error-on-empty when an
+// operation matched no targets, so CI can tell it apart from an API failure
+const ExitNothingMatched = 3
+
+// ExitAuthFailed is the exit status when Cloudflare rejected the credentials,
+// or none were given, so scripts can stop instead of retrying
+const ExitAuthFailed = 4
+
+// ExitNotModified is the exit status of kv get --if-metadata-version when the
+// value has not changed, so polling scripts can skip it without parsing output
+const ExitNotModified = 5
+
+// ExitInterrupted is the exit status after Ctrl-C, following the shell
+// convention of 128 plus the signal number
+const ExitInterrupted = 130
+
+// ErrInterrupted is returned when a command stopped early because it was interrupted
+var ErrInterrupted = errors.New("interrupted")
+
+// ErrNothingMatched is returned under --error-on-empty when filters matched nothing
+var ErrNothingMatched = errors.New("nothing matched")
+
+// ErrNotModified is returned by a conditional read whose value has not changed
+var ErrNotModified = errors.New("not modified")
+
+// ErrAuthFailed is wrapped by errors caused by missing or rejected credentials
+var ErrAuthFailed = errors.New("authentication failed")
+
+// ErrRateLimited is wrapped by errors caused by Cloudflare rate limiting
+var ErrRateLimited = errors.New("rate limited")
+
+// ErrAPI is wrapped by other errors returned by the Cloudflare API
+var ErrAPI = errors.New("Cloudflare API error")
+
+// Stable error codes for structured error output, which scripts may match on
+const (
+	ErrorCodeAuthFailed     = "auth_failed"
+	ErrorCodeRateLimited    = "rate_limited"
+	ErrorCodeAPI            = "api_error"
+	ErrorCodeNothingMatched = "nothing_matched"
+	ErrorCodeNotModified    = "not_modified"
+	ErrorCodeInterrupted    = "interrupted"
+	ErrorCodeGeneral        = "error"
+)
+
+// NothingMatched warns that an operation matched no targets. It returns an error
+// wrapping ErrNothingMatched when errorOnEmpty is set, and nil otherwise.
+func NothingMatched(what string, errorOnEmpty bool) error {
+	Warning("No %s matched; nothing to do", what)
+	if errorOnEmpty {
+		return fmt.Errorf("no %s matched: %w", what, ErrNothingMatched)
+	}
+	return nil
+}
+
+// Interrupted returns an error wrapping ErrInterrupted if ctx has been
+// cancelled, e.g. by Ctrl-C, and nil otherwise. Commands return it after
+// printing a summary of the work that did complete.
+func Interrupted(ctx context.Context) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+	return fmt.Errorf("interrupted before all work completed: %w", ErrInterrupted)
+}
+
+// ExitCode returns the process exit status for an error returned by a command
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if errors.Is(err, ErrNothingMatched) {
+		return ExitNothingMatched
+	}
+	if errors.Is(err, ErrAuthFailed) {
+		return ExitAuthFailed
+	}
+	if errors.Is(err, ErrNotModified) {
+		return ExitNotModified
+	}
+	if errors.Is(err, ErrInterrupted) || errors.Is(err, context.Canceled) {
+		return ExitInterrupted
+	}
+	return 1
+}
+
+// ErrorCode returns the machine-readable code for an error returned by a command
+func ErrorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrNothingMatched):
+		return ErrorCodeNothingMatched
+	case errors.Is(err, ErrNotModified):
+		return ErrorCodeNotModified
+	case errors.Is(err, ErrInterrupted), errors.Is(err, context.Canceled):
+		return ErrorCodeInterrupted
+	case errors.Is(err, ErrAuthFailed):
+		return ErrorCodeAuthFailed
+	case errors.Is(err, ErrRateLimited):
+		return ErrorCodeRateLimited
+	case errors.Is(err, ErrAPI):
+		return ErrorCodeAPI
+	}
+	return ErrorCodeGeneral
+}
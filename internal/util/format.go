@@ -3,40 +3,161 @@ package util
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
+// out receives all human-readable messages printed by this package
+var out io.Writer = os.Stdout
+
+// SetOutput redirects human-readable messages, e.g. to stderr so that
+// structured output on stdout stays parseable
+func SetOutput(w io.Writer) {
+	out = w
+}
+
+// Prefixes are the markers printed before status messages
+type Prefixes struct {
+	Success string
+	Error   string
+	Warning string
+	Info    string
+	Wait    string
+}
+
+// EmojiPrefixes are the default markers, for interactive terminals
+var EmojiPrefixes = Prefixes{Success: "✅", Error: "❌", Warning: "⚠️", Info: "ℹ️", Wait: "⏳"}
+
+// PlainPrefixes are ASCII markers for log viewers and parsers that mangle emoji
+var PlainPrefixes = Prefixes{Success: "[OK]", Error: "[ERR]", Warning: "[WARN]", Info: "[INFO]", Wait: "[WAIT]"}
+
+// prefixes are the markers currently in use
+var prefixes = EmojiPrefixes
+
+// SetPrefixes changes the markers printed before status messages
+func SetPrefixes(p Prefixes) {
+	prefixes = p
+}
+
+// Level selects which status messages are printed
+type Level int
+
+const (
+	// LevelNormal prints every message
+	LevelNormal Level = iota
+
+	// LevelSummary drops success and progress messages, keeping warnings,
+	// errors and the final summaries, for runs too large to read item by item
+	LevelSummary
+)
+
+// level is the current message level
+var level = LevelNormal
+
+// SetLevel changes which status messages are printed
+func SetLevel(l Level) {
+	level = l
+}
+
+// Printf prints a plain message to the message output
+func Printf(format string, args ...interface{}) {
+	fmt.Fprintf(out, format, args...)
+}
+
+// Println prints a plain line to the message output
+func Println(args ...interface{}) {
+	fmt.Fprintln(out, args...)
+}
+
 // Success prints a success message with a checkmark
 func Success(message string, args ...interface{}) {
-	fmt.Printf("✅ "+message+"\n", args...)
+	if level >= LevelSummary {
+		return
+	}
+	fmt.Fprintf(out, prefixes.Success+" "+message+"\n", args...)
 }
 
 // Error prints an error message with a cross
 func Error(message string, args ...interface{}) {
-	fmt.Printf("❌ "+message+"\n", args...)
+	fmt.Fprintf(out, prefixes.Error+" "+message+"\n", args...)
 }
 
 // Warning prints a warning message
 func Warning(message string, args ...interface{}) {
-	fmt.Printf("⚠️ "+message+"\n", args...)
+	fmt.Fprintf(out, prefixes.Warning+" "+message+"\n", args...)
 }
 
 // Info prints an info message
 func Info(message string, args ...interface{}) {
-	fmt.Printf("ℹ️ "+message+"\n", args...)
+	if level >= LevelSummary {
+		return
+	}
+	fmt.Fprintf(out, prefixes.Info+" "+message+"\n", args...)
 }
 
 // Separator prints a horizontal line
 func Separator() {
-	fmt.Println(strings.Repeat("-", 80))
+	fmt.Fprintln(out, strings.Repeat("-", 80))
 }
 
 // Header prints a header with a separator
 func Header(title string) {
-	fmt.Println("\n" + title)
+	fmt.Fprintln(out, "\n"+title)
 	Separator()
 }
 
+// FormatCount formats a count with thousands separators, e.g. 49970 as "49,970"
+func FormatCount(n int) string {
+	digits := strconv.Itoa(n)
+	sign := ""
+	if n < 0 {
+		sign, digits = "-", digits[1:]
+	}
+
+	var b strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(d)
+	}
+	return sign + b.String()
+}
+
+// FormatBytes formats a size with decimal units, e.g. 4200000000 as "4.2 GB"
+func FormatBytes(n int64) string {
+	if n < 1000 {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	value := float64(n)
+	for _, unit := range []string{"kB", "MB", "GB", "TB"} {
+		value /= 1000
+		if value < 1000 || unit == "TB" {
+			return fmt.Sprintf("%.1f %s", value, unit)
+		}
+	}
+	return ""
+}
+
+// TruncateBytes shortens data to at most max bytes without splitting a UTF-8
+// character, reporting whether anything was cut. A max of zero or less means
+// no limit.
+func TruncateBytes(data []byte, max int) ([]byte, bool) {
+	if max <= 0 || len(data) <= max {
+		return data, false
+	}
+
+	cut := max
+	for cut > 0 && cut > max-utf8.UTFMax && !utf8.RuneStart(data[cut]) {
+		cut--
+	}
+	return data[:cut], true
+}
+
 // FormatJSON formats JSON data for display
 func FormatJSON(data interface{}) string {
 	jsonBytes, err := json.MarshalIndent(data, "", "  ")
@@ -48,32 +169,37 @@ func FormatJSON(data interface{}) string {
 
 // PrettyPrintResults formats operation results
 func PrettyPrintResults(success, failure int) {
-	fmt.Printf("\nSummary: %d successful, %d failed\n", success, failure)
-	if failure > 0 {
-		Error("Some operations failed")
+	writeSummary(out, ResultSummary{Successful: success, Failed: failure})
+}
+
+// writeSummary writes the summary line and overall status to w
+func writeSummary(w io.Writer, summary ResultSummary) {
+	fmt.Fprintf(w, "\nSummary: %d successful, %d failed\n", summary.Successful, summary.Failed)
+	if summary.Failed > 0 {
+		fmt.Fprintln(w, prefixes.Error+" Some operations failed")
 	} else {
-		Success("All operations completed successfully")
+		fmt.Fprintln(w, prefixes.Success+" All operations completed successfully")
 	}
 }
 
 // TableHeader prints a formatted table header
 func TableHeader(columns []string, widths []int) {
 	for i, col := range columns {
-		fmt.Printf("%-*s", widths[i], col)
+		fmt.Fprintf(out, "%-*s", widths[i], col)
 	}
-	fmt.Println()
+	fmt.Fprintln(out)
 
 	// Print separator line
 	for _, width := range widths {
-		fmt.Print(strings.Repeat("-", width))
+		fmt.Fprint(out, strings.Repeat("-", width))
 	}
-	fmt.Println()
+	fmt.Fprintln(out)
 }
 
 // TableRow prints a formatted table row
 func TableRow(values []string, widths []int) {
 	for i, val := range values {
-		fmt.Printf("%-*s", widths[i], val)
+		fmt.Fprintf(out, "%-*s", widths[i], val)
 	}
-	fmt.Println()
+	fmt.Fprintln(out)
 }
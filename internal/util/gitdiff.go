@@ -0,0 +1,68 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// GitDiffFiles returns the paths, relative to the top of the repository, of
+// the files changed between ref and the working tree, as listed by
+// git diff --name-only. Renames are listed as a deletion and an addition, so
+// both the old and the new path are returned.
+func GitDiffFiles(ctx context.Context, ref string) ([]string, error) {
+	if strings.HasPrefix(ref, "-") {
+		return nil, fmt.Errorf("invalid git ref '%s'", ref)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", "diff", "--name-only", "--no-renames", "-z", ref, "--")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("error running git diff against '%s': %s: %w", ref, msg, err)
+		}
+		return nil, fmt.Errorf("error running git diff against '%s': %w", ref, err)
+	}
+
+	var files []string
+	for _, file := range strings.Split(stdout.String(), "\x00") {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// GitDiffURLs maps changed file paths to URLs with a base-URL template, as
+// URLForPath does. With root, only files under that directory of the
+// repository are mapped, relative to it. Files outside root, and files that
+// do not form a valid URL, are returned as skipped.
+func GitDiffURLs(files []string, root, template string) (urls, skipped []string) {
+	root = strings.Trim(path.Clean("/"+strings.TrimSpace(root)), "/")
+
+	for _, file := range files {
+		relPath := file
+		if root != "" {
+			var ok bool
+			relPath, ok = strings.CutPrefix(file, root+"/")
+			if !ok {
+				skipped = append(skipped, file)
+				continue
+			}
+		}
+
+		normalized, err := NormalizeURL(URLForPath(template, relPath))
+		if err != nil {
+			skipped = append(skipped, file)
+			continue
+		}
+		urls = append(urls, normalized)
+	}
+
+	return FilterDuplicates(urls), skipped
+}
@@ -0,0 +1,101 @@
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Glob matches strings against a shell-style pattern. Unlike path.Match, '*'
+// also matches '/', since KV keys often use slashes without being paths.
+type Glob struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+// CompileGlob parses a pattern where '*' matches any run of characters, '?'
+// matches one character, '[...]' matches a character class ('[!...]' negates
+// it) and '\' escapes the next character
+func CompileGlob(pattern string) (*Glob, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; r {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		case '\\':
+			if i+1 == len(runes) {
+				return nil, fmt.Errorf("invalid pattern '%s': trailing backslash", pattern)
+			}
+			i++
+			expr.WriteString(regexp.QuoteMeta(string(runes[i])))
+		case '[':
+			end := i + 1
+			if end < len(runes) && (runes[end] == '!' || runes[end] == '^') {
+				end++
+			}
+			// A ']' straight after the opening bracket is part of the class
+			if end < len(runes) && runes[end] == ']' {
+				end++
+			}
+			for end < len(runes) && runes[end] != ']' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("invalid pattern '%s': unterminated character class", pattern)
+			}
+
+			class := runes[i+1 : end]
+			expr.WriteString("[")
+			if class[0] == '!' || class[0] == '^' {
+				expr.WriteString("^")
+				class = class[1:]
+			}
+			expr.WriteString(strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`).Replace(string(class)))
+			expr.WriteString("]")
+			i = end
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expr.WriteString("$")
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern '%s': %w", pattern, err)
+	}
+	return &Glob{pattern: pattern, re: re}, nil
+}
+
+// Match reports whether s matches the whole pattern
+func (g *Glob) Match(s string) bool {
+	return g.re.MatchString(s)
+}
+
+// Prefix returns the literal text before the first wildcard, which every
+// match starts with. It can narrow a listing before matching.
+func (g *Glob) Prefix() string {
+	var prefix strings.Builder
+	runes := []rune(g.pattern)
+	for i := 0; i < len(runes); i++ {
+		switch runes[i] {
+		case '*', '?', '[':
+			return prefix.String()
+		case '\\':
+			i++
+		}
+		if i < len(runes) {
+			prefix.WriteRune(runes[i])
+		}
+	}
+	return prefix.String()
+}
+
+// String returns the pattern the glob was compiled from
+func (g *Glob) String() string {
+	return g.pattern
+}
@@ -1,7 +1,12 @@
 package util
 
 import (
+	"fmt"
+	"net/url"
+	"os"
 	"strings"
+
+	"golang.org/x/net/idna"
 )
 
 // SplitCommaList splits a comma-separated string into a slice
@@ -69,3 +74,275 @@ func FilterDuplicates(slice []string) []string {
 
 	return result
 }
+
+// Chunk splits items into consecutive batches of at most size items, the
+// last holding the remainder. The batches share the backing array of items.
+// A size below 1 puts everything in one batch.
+func Chunk[T any](items []T, size int) [][]T {
+	if len(items) == 0 {
+		return nil
+	}
+	if size < 1 {
+		size = len(items)
+	}
+
+	chunks := make([][]T, 0, (len(items)+size-1)/size)
+	for size < len(items) {
+		chunks = append(chunks, items[:size:size])
+		items = items[size:]
+	}
+	return append(chunks, items)
+}
+
+// ValidateBatchSize checks a --batch-size value against the most items
+// Cloudflare accepts in one request of that kind
+func ValidateBatchSize(size, max int) error {
+	if size < 1 || size > max {
+		return fmt.Errorf("--batch-size must be between 1 and %d, got %d", max, size)
+	}
+	return nil
+}
+
+// HostMatchesZone checks if a hostname is the zone apex or a subdomain of the zone
+func HostMatchesZone(host, zone string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	zone = strings.ToLower(strings.TrimSuffix(zone, "."))
+	return host == zone || strings.HasSuffix(host, "."+zone)
+}
+
+// BestZoneMatch returns the most specific zone name the host belongs to
+func BestZoneMatch(host string, zoneNames []string) (string, bool) {
+	best := ""
+	for _, zone := range zoneNames {
+		if HostMatchesZone(host, zone) && len(zone) > len(best) {
+			best = zone
+		}
+	}
+	return best, best != ""
+}
+
+// HostFromURL extracts the hostname from a URL, tolerating a missing scheme
+func HostFromURL(rawURL string) (string, error) {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	if parsed.Hostname() == "" {
+		return "", fmt.Errorf("no hostname in URL")
+	}
+
+	return parsed.Hostname(), nil
+}
+
+// ReadLines reads non-empty lines from a file, skipping # comments
+func ReadLines(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	return lines, nil
+}
+
+// LookupPath resolves a dotted path such as "a.b.c" through nested JSON maps
+func LookupPath(data interface{}, path string) (interface{}, bool) {
+	current := data
+	for _, part := range strings.Split(path, ".") {
+		obj, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = obj[part]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// NormalizeURL trims the URL, adds an https scheme when missing and lowercases the
+// host, converting internationalised domain names to their ASCII form. It returns
+// an error for URLs that could never match anything in the cache.
+func NormalizeURL(rawURL string) (string, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	if rawURL == "" {
+		return "", fmt.Errorf("empty URL")
+	}
+
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL '%s': %w", rawURL, err)
+	}
+
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return "", fmt.Errorf("invalid URL '%s': scheme must be http or https", rawURL)
+	}
+
+	host := parsed.Hostname()
+	if host == "" {
+		return "", fmt.Errorf("invalid URL '%s': missing host", rawURL)
+	}
+
+	asciiHost, err := idna.Lookup.ToASCII(strings.ToLower(host))
+	if err != nil {
+		return "", fmt.Errorf("invalid URL '%s': bad host: %w", rawURL, err)
+	}
+
+	if port := parsed.Port(); port != "" {
+		parsed.Host = asciiHost + ":" + port
+	} else {
+		parsed.Host = asciiHost
+	}
+
+	return parsed.String(), nil
+}
+
+// WildcardPrefix converts a URL ending in a /* wildcard, such as
+// https://example.com/images/*, into the prefix Cloudflare purges everything
+// under: example.com/images, covering both http and https. ok is false for a
+// URL without a wildcard. Cloudflare matches prefixes by whole path segments
+// from the start of the path, so a wildcard anywhere else is rejected.
+func WildcardPrefix(rawURL string) (prefix string, ok bool, err error) {
+	rawURL = strings.TrimSpace(rawURL)
+	if !strings.Contains(rawURL, "*") {
+		return "", false, nil
+	}
+
+	if !strings.HasSuffix(rawURL, "/*") || strings.Count(rawURL, "*") != 1 {
+		return "", true, fmt.Errorf("invalid wildcard URL '%s': only a trailing /* is supported, e.g. https://example.com/images/*", rawURL)
+	}
+
+	normalized, err := NormalizeURL(strings.TrimSuffix(rawURL, "/*"))
+	if err != nil {
+		return "", true, err
+	}
+
+	parsed, err := url.Parse(normalized)
+	if err != nil {
+		return "", true, fmt.Errorf("invalid wildcard URL '%s': %w", rawURL, err)
+	}
+	if parsed.RawQuery != "" || parsed.Fragment != "" {
+		return "", true, fmt.Errorf("invalid wildcard URL '%s': a wildcard cannot follow a query string", rawURL)
+	}
+
+	path := strings.TrimSuffix(parsed.EscapedPath(), "/")
+	if path == "" {
+		return "", true, fmt.Errorf("invalid wildcard URL '%s': the wildcard covers the whole host, purge it with --hosts instead", rawURL)
+	}
+
+	return parsed.Host + path, true, nil
+}
+
+// ExpandQueryVariants adds, for each URL, a copy with each query string
+// appended, since Cloudflare caches every query string as a separate entry
+// that a purge of the plain URL misses. Variants may start with '?'. A URL
+// that already has a query string gets the variant appended with '&';
+// wildcard URLs are kept as they are.
+func ExpandQueryVariants(urls, variants []string) ([]string, error) {
+	var queries []string
+	for _, variant := range variants {
+		query := strings.TrimPrefix(strings.TrimSpace(variant), "?")
+		if query == "" {
+			continue
+		}
+		if _, err := url.ParseQuery(query); err != nil {
+			return nil, fmt.Errorf("invalid query variant '%s': %w", variant, err)
+		}
+		queries = append(queries, query)
+	}
+
+	expanded := make([]string, 0, len(urls)*(len(queries)+1))
+	for _, rawURL := range urls {
+		expanded = append(expanded, rawURL)
+		if strings.Contains(rawURL, "*") {
+			continue
+		}
+
+		base, fragment, _ := strings.Cut(rawURL, "#")
+		separator := "?"
+		if strings.Contains(base, "?") {
+			separator = "&"
+		}
+		for _, query := range queries {
+			variantURL := base + separator + query
+			if fragment != "" {
+				variantURL += "#" + fragment
+			}
+			expanded = append(expanded, variantURL)
+		}
+	}
+
+	return FilterDuplicates(expanded), nil
+}
+
+// NormalizeHost reduces a host purge entry to the bare lowercase hostname that
+// Cloudflare expects, stripping any scheme, port, path, query and trailing dot.
+// Entries that are still not valid hostnames, such as wildcards, are rejected.
+func NormalizeHost(rawHost string) (string, error) {
+	trimmed := strings.TrimSpace(rawHost)
+	if trimmed == "" {
+		return "", fmt.Errorf("empty host")
+	}
+
+	host, err := HostFromURL(trimmed)
+	if err != nil {
+		return "", fmt.Errorf("invalid host '%s': %w", trimmed, err)
+	}
+
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	asciiHost, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		return "", fmt.Errorf("invalid host '%s': %w", trimmed, err)
+	}
+
+	if len(asciiHost) > 253 {
+		return "", fmt.Errorf("invalid host '%s': longer than 253 characters", trimmed)
+	}
+	for _, label := range strings.Split(asciiHost, ".") {
+		if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return "", fmt.Errorf("invalid host '%s': bad label '%s'", trimmed, label)
+		}
+		for _, r := range label {
+			if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+				return "", fmt.Errorf("invalid host '%s': invalid character %q", trimmed, r)
+			}
+		}
+	}
+
+	return asciiHost, nil
+}
+
+// ValidateURL checks that a URL can be normalised for purging
+func ValidateURL(rawURL string) error {
+	_, err := NormalizeURL(rawURL)
+	return err
+}
+
+// URLForPath expands a base-URL template for a slash-separated relative file path.
+// A {path} placeholder is replaced with the path; otherwise the path is appended.
+func URLForPath(template, relPath string) string {
+	relPath = strings.TrimPrefix(relPath, "/")
+	if strings.Contains(template, "{path}") {
+		return strings.ReplaceAll(template, "{path}", relPath)
+	}
+	return strings.TrimSuffix(template, "/") + "/" + relPath
+}
@@ -0,0 +1,57 @@
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// imagePathSegment matches account hashes, image IDs and variant names, which
+// Cloudflare Images limits to URL-safe characters without slashes
+var imagePathSegment = regexp.MustCompile(`^[A-Za-z0-9._~-]+$`)
+
+// ImageDeliveryURLs builds the Cloudflare Images delivery URLs for every image
+// and variant served from a custom domain, i.e.
+// https://<domain>/cdn-cgi/imagedelivery/<account hash>/<image id>/<variant>.
+// The imagedelivery.net host is not a zone of yours, so only custom-domain URLs
+// can be purged.
+func ImageDeliveryURLs(domain, accountHash string, imageIDs, variants []string) ([]string, error) {
+	domain = strings.TrimSuffix(strings.TrimSpace(domain), "/")
+	if domain == "" {
+		return nil, fmt.Errorf("an image delivery domain is required")
+	}
+	if strings.Contains(domain, "/") && !strings.Contains(domain, "://") {
+		return nil, fmt.Errorf("invalid image delivery domain '%s'", domain)
+	}
+	if !strings.Contains(domain, "://") {
+		domain = "https://" + domain
+	}
+
+	if !imagePathSegment.MatchString(accountHash) {
+		return nil, fmt.Errorf("invalid images account hash '%s'", accountHash)
+	}
+	if len(imageIDs) == 0 {
+		return nil, fmt.Errorf("at least one image ID is required")
+	}
+	if len(variants) == 0 {
+		return nil, fmt.Errorf("at least one image variant is required")
+	}
+
+	for _, variant := range variants {
+		if !imagePathSegment.MatchString(variant) {
+			return nil, fmt.Errorf("invalid image variant '%s'", variant)
+		}
+	}
+
+	var urls []string
+	for _, imageID := range imageIDs {
+		if !imagePathSegment.MatchString(imageID) {
+			return nil, fmt.Errorf("invalid image ID '%s'", imageID)
+		}
+		for _, variant := range variants {
+			urls = append(urls, fmt.Sprintf("%s/cdn-cgi/imagedelivery/%s/%s/%s", domain, accountHash, imageID, variant))
+		}
+	}
+
+	return urls, nil
+}
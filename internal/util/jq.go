@@ -0,0 +1,64 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/itchyny/gojq"
+)
+
+// JQ is a compiled jq expression for extracting parts of JSON values
+type JQ struct {
+	expr string
+	code *gojq.Code
+}
+
+// ParseJQ compiles a jq expression, so that a mistake in it is reported
+// before any value is fetched
+func ParseJQ(expr string) (*JQ, error) {
+	query, err := gojq.Parse(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid jq expression '%s': %w", expr, err)
+	}
+
+	code, err := gojq.Compile(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid jq expression '%s': %w", expr, err)
+	}
+
+	return &JQ{expr: expr, code: code}, nil
+}
+
+// Apply parses value as JSON and returns every result of the expression. It
+// fails when value is not JSON or the expression raises an error.
+func (q *JQ) Apply(value []byte) ([]interface{}, error) {
+	var input interface{}
+	if err := json.Unmarshal(value, &input); err != nil {
+		return nil, fmt.Errorf("value is not JSON, so a jq expression cannot be applied: %w", err)
+	}
+
+	var results []interface{}
+	iter := q.code.Run(input)
+	for {
+		result, ok := iter.Next()
+		if !ok {
+			break
+		}
+		if err, ok := result.(error); ok {
+			return nil, fmt.Errorf("error evaluating jq expression '%s': %w", q.expr, err)
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// FormatJQResult renders one result for the terminal: strings as plain text so
+// they can be captured by scripts, anything else as indented JSON
+func FormatJQResult(result interface{}) string {
+	if str, ok := result.(string); ok {
+		return str
+	}
+	data, _ := json.MarshalIndent(result, "", "  ")
+	return string(data)
+}
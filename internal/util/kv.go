@@ -0,0 +1,174 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// NamespaceIDLength is the length of a Workers KV namespace ID in hex
+	NamespaceIDLength = 32
+
+	// MaxKVKeyLength is the longest key Workers KV accepts, in bytes
+	MaxKVKeyLength = 512
+
+	// MaxKVValueSize is the largest value Workers KV accepts, in bytes
+	MaxKVValueSize = 25 * 1024 * 1024
+
+	// ChunkCountMetadata is the metadata field recording how many chunks a
+	// value written with kv put --chunk was split into
+	ChunkCountMetadata = "cfpurge-chunks"
+
+	// VersionMetadata is the metadata field holding a value's version number,
+	// which kv get --if-metadata-version compares before reading the value
+	VersionMetadata = "version"
+)
+
+// ValidateNamespaceID checks that id looks like a KV namespace ID, so that a
+// title or truncated ID is reported before the API answers with a bare 400
+func ValidateNamespaceID(id string) error {
+	if len(id) != NamespaceIDLength {
+		return fmt.Errorf("namespace ID '%s' must be %d hex characters, got %d", Truncate(id, 64), NamespaceIDLength, len(id))
+	}
+
+	for _, r := range id {
+		if !isHexDigit(r) {
+			return fmt.Errorf("namespace ID '%s' must be %d hex characters, found %q", id, NamespaceIDLength, r)
+		}
+	}
+
+	return nil
+}
+
+// ValidateKVKey checks a key name against Workers KV's limits: non-empty, at
+// most 512 bytes, and not "." or ".."
+func ValidateKVKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("key is empty")
+	}
+
+	if len(key) > MaxKVKeyLength {
+		return fmt.Errorf("key '%s' is %d bytes, the maximum is %d", Truncate(key, 64), len(key), MaxKVKeyLength)
+	}
+
+	if key == "." || key == ".." {
+		return fmt.Errorf("key cannot be '%s'", key)
+	}
+
+	return nil
+}
+
+// Formats accepted by ReadKeysFile
+const (
+	KeysFileLines = "lines"
+	KeysFileRaw   = "raw"
+)
+
+// ReadKeysFile reads KV key names, one per line. Unlike ReadLines, a line is
+// kept exactly as written apart from its line ending, since spaces are valid
+// in key names. In the lines format, blank lines and lines starting with # are
+// skipped; in the raw format only empty lines are, so keys starting with # or
+// made only of whitespace can be listed too. Keys are passed to the API
+// unescaped: the client escapes them in request paths, so escaping them here
+// too would address a different key.
+func ReadKeysFile(path, format string) ([]string, error) {
+	if format != "" && format != KeysFileLines && format != KeysFileRaw {
+		return nil, fmt.Errorf("invalid keys file format '%s': must be %s or %s", format, KeysFileLines, KeysFileRaw)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" {
+			continue
+		}
+		if format != KeysFileRaw && (strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#")) {
+			continue
+		}
+		if err := ValidateKVKey(line); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		keys = append(keys, line)
+	}
+
+	return keys, nil
+}
+
+// ValidateKVValueSize checks that a value of size bytes fits in a single KV
+// entry, so that an oversized value fails before it is uploaded
+func ValidateKVValueSize(size int) error {
+	if size > MaxKVValueSize {
+		return fmt.Errorf("value is %s bytes; Workers KV values are limited to 25 MiB (%s bytes)", FormatCount(size), FormatCount(MaxKVValueSize))
+	}
+	return nil
+}
+
+// ChunkKey returns the key holding chunk index of a chunked value. Chunk 0 is
+// stored under the key itself, with the chunk count in its metadata.
+func ChunkKey(key string, index int) string {
+	if index == 0 {
+		return key
+	}
+	return fmt.Sprintf("%s#chunk-%d", key, index)
+}
+
+// SplitChunks splits value into chunks of at most size bytes
+func SplitChunks(value []byte, size int) [][]byte {
+	var chunks [][]byte
+	for len(value) > size {
+		chunks = append(chunks, value[:size])
+		value = value[size:]
+	}
+	return append(chunks, value)
+}
+
+// ChunkCount returns the chunk count recorded in a key's metadata, and false
+// when the value was not chunked
+func ChunkCount(metadata interface{}) (int, bool) {
+	fields, ok := metadata.(map[string]interface{})
+	if !ok {
+		return 0, false
+	}
+
+	switch count := fields[ChunkCountMetadata].(type) {
+	case float64:
+		return int(count), count > 1
+	case int:
+		return count, count > 1
+	}
+	return 0, false
+}
+
+// MetadataVersion returns the version number recorded in a key's metadata, a
+// whole number or a string holding one, and false when there is none
+func MetadataVersion(metadata interface{}) (int64, bool) {
+	fields, ok := metadata.(map[string]interface{})
+	if !ok {
+		return 0, false
+	}
+
+	switch version := fields[VersionMetadata].(type) {
+	case float64:
+		return int64(version), version == float64(int64(version))
+	case int:
+		return int64(version), true
+	case int64:
+		return version, true
+	case string:
+		n, err := strconv.ParseInt(strings.TrimSpace(version), 10, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// isHexDigit reports whether r is 0-9, a-f or A-F
+func isHexDigit(r rune) bool {
+	return (r >= '0' && r <= '9') || (r >= 'a' && r <= 'f') || (r >= 'A' && r <= 'F')
+}
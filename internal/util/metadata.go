@@ -0,0 +1,79 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MetadataFilter is a predicate over a KV key's metadata, parsed from an
+// expression such as "env=staging", "owner.team~=web" or "expires"
+type MetadataFilter struct {
+	Path  string
+	Op    string
+	Value string
+}
+
+// Operators supported by MetadataFilter; an empty Op checks that the path exists
+const (
+	FilterEquals   = "="
+	FilterContains = "~="
+)
+
+// ParseMetadataFilter parses "path=value" (equality), "path~=value" (substring)
+// or "path" (existence). Paths are dotted and may start with "$." as in JSONPath.
+func ParseMetadataFilter(expr string) (*MetadataFilter, error) {
+	filter := &MetadataFilter{}
+
+	path := strings.TrimSpace(expr)
+	if i := strings.Index(path, FilterContains); i >= 0 {
+		filter.Op = FilterContains
+		filter.Value = path[i+len(FilterContains):]
+		path = path[:i]
+	} else if i := strings.Index(path, FilterEquals); i >= 0 {
+		filter.Op = FilterEquals
+		filter.Value = path[i+len(FilterEquals):]
+		path = path[:i]
+	}
+
+	path = strings.TrimPrefix(strings.TrimSpace(path), "$")
+	path = strings.TrimPrefix(path, ".")
+	if path == "" {
+		return nil, fmt.Errorf("invalid metadata filter '%s': missing field path", expr)
+	}
+	for _, part := range strings.Split(path, ".") {
+		if part == "" {
+			return nil, fmt.Errorf("invalid metadata filter '%s': empty path segment", expr)
+		}
+	}
+
+	filter.Path = path
+	return filter, nil
+}
+
+// Match reports whether the metadata satisfies the filter. Non-string values
+// are compared using their printed form, so "count=3" matches a numeric 3.
+func (f *MetadataFilter) Match(metadata interface{}) bool {
+	value, ok := LookupPath(metadata, f.Path)
+	if !ok {
+		return false
+	}
+
+	actual, isString := value.(string)
+	if !isString {
+		actual = fmt.Sprint(value)
+	}
+
+	switch f.Op {
+	case FilterEquals:
+		return actual == f.Value
+	case FilterContains:
+		return strings.Contains(actual, f.Value)
+	default:
+		return true
+	}
+}
+
+// String returns the filter in expression form
+func (f *MetadataFilter) String() string {
+	return f.Path + f.Op + f.Value
+}
@@ -0,0 +1,88 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Output formats accepted by --output and --format flags
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+	OutputYAML  = "yaml"
+)
+
+// ResultSummary is the structured form of PrettyPrintResults
+type ResultSummary struct {
+	Successful int `json:"successful" yaml:"successful"`
+	Failed     int `json:"failed" yaml:"failed"`
+}
+
+// ErrorOutput is the structured form of an error that stopped a command
+type ErrorOutput struct {
+	Error string `json:"error" yaml:"error"`
+	Code  string `json:"code" yaml:"code"`
+}
+
+// ValidateOutputFormat checks that format is one of the supported output formats
+func ValidateOutputFormat(format string) error {
+	switch format {
+	case OutputTable, OutputJSON, OutputYAML:
+		return nil
+	}
+	return fmt.Errorf("invalid output format '%s': must be '%s', '%s' or '%s'", format, OutputTable, OutputJSON, OutputYAML)
+}
+
+// IsStructuredOutput reports whether format is machine-readable rather than a table
+func IsStructuredOutput(format string) bool {
+	return format == OutputJSON || format == OutputYAML
+}
+
+// SetOutputFor moves human-readable messages to stderr when format is
+// structured, so that stdout holds only the JSON or YAML result
+func SetOutputFor(format string) {
+	if IsStructuredOutput(format) {
+		SetOutput(os.Stderr)
+	}
+}
+
+// WriteOutput marshals data to w in the given structured format. The same
+// values feed both formats, so types need matching json and yaml tags.
+func WriteOutput(w io.Writer, format string, data interface{}) error {
+	switch format {
+	case OutputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(data); err != nil {
+			return fmt.Errorf("error encoding JSON: %w", err)
+		}
+	case OutputYAML:
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(data); err != nil {
+			return fmt.Errorf("error encoding YAML: %w", err)
+		}
+		if err := encoder.Close(); err != nil {
+			return fmt.Errorf("error encoding YAML: %w", err)
+		}
+	default:
+		return fmt.Errorf("output format '%s' is not structured", format)
+	}
+	return nil
+}
+
+// WriteError prints the error that stopped a command to w: as an ErrorOutput
+// in a structured format, so that pipelines parsing the output can read it,
+// and as plain text otherwise
+func WriteError(w io.Writer, format string, err error) {
+	if IsStructuredOutput(format) {
+		if WriteOutput(w, format, ErrorOutput{Error: err.Error(), Code: ErrorCode(err)}) == nil {
+			return
+		}
+	}
+	fmt.Fprintf(w, "Error: %v\n", err)
+}
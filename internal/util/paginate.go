@@ -0,0 +1,196 @@
+package util
+
+import (
+	"fmt"
+	"sync"
+)
+
+// PageFetcher fetches one page of at most pageLimit items starting at cursor,
+// returning the cursor of the next page or an empty cursor after the last page
+type PageFetcher[T any] func(cursor string, pageLimit int) ([]T, string, error)
+
+// ShardFetcher fetches one page of the items whose key starts with shard, like
+// PageFetcher
+type ShardFetcher[T any] func(shard, cursor string, pageLimit int) ([]T, string, error)
+
+// Paginate collects items page by page, starting at cursor, until limit items
+// have been collected, maxPages pages have been fetched, or no pages remain.
+// A limit or maxPages of zero means no cap. Each page asks for no more than
+// pageSize items, and no more than are still needed to reach limit, so the
+// returned cursor resumes right after the last item returned. It is empty
+// once every item has been returned.
+func Paginate[T any](limit, pageSize, maxPages int, cursor string, fetch PageFetcher[T]) ([]T, string, error) {
+	var items []T
+
+	for pages := 0; maxPages == 0 || pages < maxPages; pages++ {
+		pageLimit := pageSize
+		if limit > 0 && limit-len(items) < pageLimit {
+			pageLimit = limit - len(items)
+		}
+
+		page, next, err := fetch(cursor, pageLimit)
+		if err != nil {
+			return items, cursor, err
+		}
+
+		// Guard against a page larger than requested overshooting the limit
+		if limit > 0 && len(items)+len(page) > limit {
+			page = page[:limit-len(items)]
+		}
+		items = append(items, page...)
+		cursor = next
+
+		if cursor == "" || (limit > 0 && len(items) >= limit) {
+			break
+		}
+	}
+
+	return items, cursor, nil
+}
+
+// PrefixShards splits the keys starting with prefix by the character that
+// follows it: one shard per printable ASCII character and DEL, and one per
+// UTF-8 lead byte, which covers every non-ASCII character. The key equal to
+// prefix and keys whose next character is a control character fall in no
+// shard; they sort first, and ScanPrefix lists them separately.
+func PrefixShards(prefix string) []string {
+	shards := make([]string, 0, 0x7f-' '+1+0xf4-0xc2+1)
+	for c := byte(' '); c <= 0x7f; c++ {
+		shards = append(shards, prefix+string([]byte{c}))
+	}
+	for b := byte(0xc2); b <= 0xf4; b++ {
+		shards = append(shards, prefix+string([]byte{b}))
+	}
+	return shards
+}
+
+// prefixHeadPageSize is how many keys are asked for at a time while listing
+// the keys that sort before every shard, of which there are rarely any
+const prefixHeadPageSize = 10
+
+// ScanPrefix lists every item whose key starts with prefix: the key equal to
+// prefix and those continuing with a control character, which sort before all
+// other keys and are read from the start of the listing, and then the shards
+// of PrefixShards, concurrently as in PaginateShards. name returns the key of
+// an item. Items are not sorted, and limit caps their number (zero means no
+// cap).
+func ScanPrefix[T any](prefix string, limit, pageSize, concurrency int, name func(T) string, fetch ShardFetcher[T]) ([]T, error) {
+	var head []T
+	cursor := ""
+	for {
+		page, next, err := fetch(prefix, cursor, prefixHeadPageSize)
+		if err != nil {
+			return nil, fmt.Errorf("error listing keys before the shards: %w", err)
+		}
+
+		done := next == ""
+		for _, item := range page {
+			key := name(item)
+			if key != prefix && (len(key) <= len(prefix) || key[len(prefix)] >= ' ') {
+				done = true
+				break
+			}
+			head = append(head, item)
+		}
+		if done {
+			break
+		}
+		cursor = next
+	}
+
+	if limit > 0 && len(head) >= limit {
+		return head[:limit], nil
+	}
+	shardLimit := 0
+	if limit > 0 {
+		shardLimit = limit - len(head)
+	}
+
+	items, err := PaginateShards(PrefixShards(prefix), shardLimit, pageSize, concurrency, fetch)
+	if err != nil {
+		return nil, err
+	}
+	return append(head, items...), nil
+}
+
+// PaginateShards walks the pages of up to concurrency shards at once and
+// merges their items in the order they arrive, so items are not sorted across
+// shards. It stops once limit items have been collected (zero means no cap),
+// and at the first error.
+func PaginateShards[T any](shards []string, limit, pageSize, concurrency int, fetch ShardFetcher[T]) ([]T, error) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var items []T
+	var firstErr error
+	sem := make(chan struct{}, concurrency)
+
+	// remaining reports how many items are still wanted, or -1 to stop; mu must be held
+	remaining := func() int {
+		if firstErr != nil || (limit > 0 && len(items) >= limit) {
+			return -1
+		}
+		if limit > 0 {
+			return limit - len(items)
+		}
+		return 0
+	}
+
+	for _, shard := range shards {
+		mu.Lock()
+		stop := remaining() < 0
+		mu.Unlock()
+		if stop {
+			break
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(shard string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			cursor := ""
+			for {
+				mu.Lock()
+				wanted := remaining()
+				mu.Unlock()
+				if wanted < 0 {
+					return
+				}
+
+				pageLimit := pageSize
+				if wanted > 0 && wanted < pageLimit {
+					pageLimit = wanted
+				}
+
+				page, next, err := fetch(shard, cursor, pageLimit)
+
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = fmt.Errorf("error listing shard '%s': %w", shard, err)
+					}
+					mu.Unlock()
+					return
+				}
+				items = append(items, page...)
+				mu.Unlock()
+
+				if next == "" {
+					return
+				}
+				cursor = next
+			}
+		}(shard)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if limit > 0 && len(items) > limit {
+		items = items[:limit]
+	}
+	return items, nil
+}
@@ -0,0 +1,140 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Plan is a reviewable record of the operations a dry run would perform
+type Plan struct {
+	Command    string          `json:"command"`
+	CreatedAt  time.Time       `json:"created_at"`
+	Account    string          `json:"account,omitempty"`
+	Zones      []PlanZone      `json:"zones,omitempty"`
+	Namespaces []PlanNamespace `json:"namespaces,omitempty"`
+	CacheTags  []string        `json:"cache_tags,omitempty"`
+}
+
+// PlanZone describes what would be purged from a single zone
+type PlanZone struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Everything bool     `json:"everything,omitempty"`
+	Hosts      []string `json:"hosts,omitempty"`
+	URLs       []string `json:"urls,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+
+	// Prefixes come from wildcard URLs, see WildcardPrefix
+	Prefixes []string `json:"prefixes,omitempty"`
+
+	// DeviceTypes, when set, has the URLs purged once per device type
+	DeviceTypes []string `json:"device_types,omitempty"`
+
+	// Error is set in a failures file to the error the zone last failed with
+	Error string `json:"error,omitempty"`
+}
+
+// PlanNamespace describes which keys would be deleted from a KV namespace
+type PlanNamespace struct {
+	ID   string   `json:"id"`
+	Keys []string `json:"keys"`
+
+	// Errors is set in a failures file to the error each key last failed with
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// NewPlan creates an empty plan for the given command
+func NewPlan(command string) *Plan {
+	return &Plan{
+		Command:   command,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// AddFailedKey records a key that could not be deleted, so that a failures file
+// can be retried with the same code that executes plans
+func (p *Plan) AddFailedKey(namespaceID, key, errMessage string) {
+	for i := range p.Namespaces {
+		if p.Namespaces[i].ID == namespaceID {
+			if p.Namespaces[i].Errors == nil {
+				p.Namespaces[i].Errors = make(map[string]string)
+			}
+			p.Namespaces[i].Keys = append(p.Namespaces[i].Keys, key)
+			p.Namespaces[i].Errors[key] = errMessage
+			return
+		}
+	}
+
+	p.Namespaces = append(p.Namespaces, PlanNamespace{
+		ID:     namespaceID,
+		Keys:   []string{key},
+		Errors: map[string]string{key: errMessage},
+	})
+}
+
+// WritePlan saves a plan as indented JSON
+func WritePlan(path string, plan *Plan) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding plan: %w", err)
+	}
+
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("error writing plan: %w", err)
+	}
+
+	return nil
+}
+
+// ReadPlan loads a plan written by WritePlan, rejecting unknown fields and
+// plans that were generated for a different command
+func ReadPlan(path, command string) (*Plan, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening plan: %w", err)
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+
+	var plan Plan
+	if err := decoder.Decode(&plan); err != nil {
+		return nil, fmt.Errorf("error parsing plan %s: %w", path, err)
+	}
+
+	if err := plan.Validate(command); err != nil {
+		return nil, fmt.Errorf("invalid plan %s: %w", path, err)
+	}
+
+	return &plan, nil
+}
+
+// Validate checks that the plan is complete and belongs to the given command
+func (p *Plan) Validate(command string) error {
+	if p.Command != command {
+		return fmt.Errorf("plan was generated for '%s', not '%s'", p.Command, command)
+	}
+
+	for i, zone := range p.Zones {
+		if zone.ID == "" {
+			return fmt.Errorf("zone %d is missing an id", i)
+		}
+		if !zone.Everything && len(zone.Hosts) == 0 && len(zone.URLs) == 0 && len(zone.Tags) == 0 && len(zone.Prefixes) == 0 {
+			return fmt.Errorf("zone %s has nothing to purge", zone.ID)
+		}
+	}
+
+	for i, ns := range p.Namespaces {
+		if ns.ID == "" {
+			return fmt.Errorf("namespace %d is missing an id", i)
+		}
+		if len(ns.Keys) == 0 {
+			return fmt.Errorf("namespace %s has no keys", ns.ID)
+		}
+	}
+
+	return nil
+}
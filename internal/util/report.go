@@ -0,0 +1,96 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"time"
+)
+
+// Report is the outcome of an operation, written as HTML by WriteHTMLReport
+// for readers who don't use the CLI
+type Report struct {
+	Title    string
+	DryRun   bool
+	Started  time.Time
+	Finished time.Time
+	Summary  ResultSummary
+	Items    []ItemResult
+}
+
+// NewReport builds a report of the outcomes recorded in results by an
+// operation started at started and finishing now
+func NewReport(title string, started time.Time, results *Results) Report {
+	return Report{
+		Title:    title,
+		Started:  started,
+		Finished: time.Now(),
+		Summary:  results.Summary(),
+		Items:    results.Items(),
+	}
+}
+
+// reportTemplate renders a Report as a single page with no external assets,
+// so that it can be attached to a ticket or email as is
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"timestamp": func(t time.Time) string { return t.UTC().Format(time.RFC3339) },
+	"duration":  func(r Report) string { return r.Finished.Sub(r.Started).Round(time.Millisecond).String() },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2rem; color: #222; }
+table { border-collapse: collapse; margin-top: 1rem; }
+th, td { border: 1px solid #ddd; padding: 0.4rem 0.8rem; text-align: left; vertical-align: top; }
+th { background: #f4f4f4; }
+.ok { color: #1a7f37; }
+.failed { color: #cf222e; }
+.note { background: #fff8c5; padding: 0.5rem 0.8rem; display: inline-block; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{if .DryRun}}<p class="note">Dry run: nothing was changed. The items below are what the operation would affect.</p>{{end}}
+<table>
+<tr><th>Started</th><td>{{timestamp .Started}}</td></tr>
+<tr><th>Finished</th><td>{{timestamp .Finished}} ({{duration .}})</td></tr>
+<tr><th>Successful</th><td class="ok">{{.Summary.Successful}}</td></tr>
+<tr><th>Failed</th><td class="failed">{{.Summary.Failed}}</td></tr>
+</table>
+{{if .Items}}
+<table>
+<tr><th>Scope</th><th>Item</th><th>Result</th></tr>
+{{range .Items}}<tr><td>{{.Scope}}</td><td>{{.Item}}</td>{{if .Error}}<td class="failed">Failed: {{.Error}}</td>{{else}}<td class="ok">OK</td>{{end}}</tr>
+{{end}}</table>
+{{else}}
+<p>Nothing was affected.</p>
+{{end}}
+</body>
+</html>
+`))
+
+// RenderHTMLReport writes report to w as a self-contained HTML page
+func RenderHTMLReport(w io.Writer, report Report) error {
+	if err := reportTemplate.Execute(w, report); err != nil {
+		return fmt.Errorf("error rendering report: %w", err)
+	}
+	return nil
+}
+
+// WriteHTMLReport writes report to path as a self-contained HTML page
+func WriteHTMLReport(path string, report Report) error {
+	var buf bytes.Buffer
+	if err := RenderHTMLReport(&buf, report); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("error writing report: %w", err)
+	}
+
+	return nil
+}
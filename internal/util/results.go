@@ -0,0 +1,118 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ItemResult is the outcome of one operation, such as deleting a key from a
+// namespace or purging a zone. Scope names the container the item belongs to.
+type ItemResult struct {
+	Scope string `json:"scope" yaml:"scope"`
+	Item  string `json:"item,omitempty" yaml:"item,omitempty"`
+	Error string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// Results accumulates operation outcomes. It is safe for concurrent use, so
+// worker goroutines can record into it without their own locking.
+type Results struct {
+	mu      sync.Mutex
+	summary ResultSummary
+	items   []ItemResult
+}
+
+// resultsDocument is the structured form of Results
+type resultsDocument struct {
+	ResultSummary `yaml:",inline"`
+	Items         []ItemResult `json:"items" yaml:"items"`
+}
+
+// NewResults returns an empty result set
+func NewResults() *Results {
+	return &Results{}
+}
+
+// Record adds the outcome of one operation; a nil err counts as a success
+func (r *Results) Record(scope, item string, err error) {
+	result := ItemResult{Scope: scope, Item: item}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err != nil {
+		result.Error = err.Error()
+		r.summary.Failed++
+	} else {
+		r.summary.Successful++
+	}
+	r.items = append(r.items, result)
+}
+
+// Merge adds all outcomes recorded in other to r
+func (r *Results) Merge(other *Results) {
+	if other == nil || other == r {
+		return
+	}
+
+	// Snapshot first so the two locks are never held together
+	summary := other.Summary()
+	items := other.Items()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.summary.Successful += summary.Successful
+	r.summary.Failed += summary.Failed
+	r.items = append(r.items, items...)
+}
+
+// Summary returns the success and failure counts
+func (r *Results) Summary() ResultSummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.summary
+}
+
+// Items returns a copy of every recorded outcome in the order recorded
+func (r *Results) Items() []ItemResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]ItemResult(nil), r.items...)
+}
+
+// Failures returns the recorded outcomes that have an error
+func (r *Results) Failures() []ItemResult {
+	var failures []ItemResult
+	for _, item := range r.Items() {
+		if item.Error != "" {
+			failures = append(failures, item)
+		}
+	}
+	return failures
+}
+
+// Err returns an error describing the failures, or nil if there were none
+func (r *Results) Err() error {
+	summary := r.Summary()
+	if summary.Failed == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d of %d operations failed", summary.Failed, summary.Successful+summary.Failed)
+}
+
+// Render writes the results to w. The table format is the summary printed by
+// PrettyPrintResults; json and yaml include every recorded outcome.
+func (r *Results) Render(w io.Writer, format string) error {
+	if IsStructuredOutput(format) {
+		return WriteOutput(w, format, resultsDocument{ResultSummary: r.Summary(), Items: r.Items()})
+	}
+
+	writeSummary(w, r.Summary())
+	return nil
+}
+
+// Print writes the table summary to the message output
+func (r *Results) Print() {
+	_ = r.Render(out, OutputTable)
+}
@@ -0,0 +1,96 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// dayUnit matches a day count such as "7d" or "1.5d" in a duration string
+var dayUnit = regexp.MustCompile(`(\d+(?:\.\d+)?)d`)
+
+// ParseSchedule works out when a scheduled operation should run from an
+// RFC 3339 time (--at) or a delay from now (--after). The zero time means
+// run immediately.
+func ParseSchedule(at string, after time.Duration, now time.Time) (time.Time, error) {
+	if at != "" && after != 0 {
+		return time.Time{}, fmt.Errorf("--at cannot be combined with --after")
+	}
+
+	if after < 0 {
+		return time.Time{}, fmt.Errorf("--after must not be negative")
+	}
+	if after > 0 {
+		return now.Add(after), nil
+	}
+
+	if at == "" {
+		return time.Time{}, nil
+	}
+
+	when, err := time.Parse(time.RFC3339, at)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --at time '%s': must be RFC 3339, e.g. 2006-01-02T15:04:05Z: %w", at, err)
+	}
+	if !when.After(now) {
+		return time.Time{}, fmt.Errorf("--at time %s is in the past", when.Format(time.RFC3339))
+	}
+
+	return when, nil
+}
+
+// WaitUntil blocks until the given time, printing a countdown to w once per
+// second. It returns the context's error if the wait is cancelled first. The
+// countdown is progress, so LevelSummary silences it.
+func WaitUntil(ctx context.Context, when time.Time, w io.Writer) error {
+	if level >= LevelSummary {
+		w = io.Discard
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	timer := time.NewTimer(time.Until(when))
+	defer timer.Stop()
+
+	for {
+		remaining := time.Until(when).Round(time.Second)
+		fmt.Fprintf(w, "\r%s Starting at %s, in %s   ", prefixes.Wait, when.Local().Format(time.RFC3339), remaining)
+
+		select {
+		case <-ctx.Done():
+			fmt.Fprintln(w)
+			return ctx.Err()
+		case <-timer.C:
+			fmt.Fprintln(w)
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// ParseDuration parses a Go duration such as "90m" or "24h", also accepting a
+// "d" unit for days, e.g. "7d" or "1d12h"
+func ParseDuration(value string) (time.Duration, error) {
+	var convErr error
+	expanded := dayUnit.ReplaceAllStringFunc(value, func(match string) string {
+		days, err := strconv.ParseFloat(match[:len(match)-1], 64)
+		if err != nil {
+			convErr = err
+			return match
+		}
+		return strconv.FormatFloat(days*24, 'f', -1, 64) + "h"
+	})
+	if convErr != nil {
+		return 0, fmt.Errorf("invalid duration '%s': %w", value, convErr)
+	}
+
+	d, err := time.ParseDuration(expanded)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration '%s': %w", value, err)
+	}
+	return d, nil
+}
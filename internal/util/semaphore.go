@@ -0,0 +1,202 @@
+package util
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxAutoConcurrency caps the concurrency chosen by --concurrency=auto
+	MaxAutoConcurrency = 50
+
+	// latencyWeight is how much each new observation moves the average latency
+	latencyWeight = 0.2
+)
+
+// Concurrency is the value of a --concurrency flag: a fixed number of
+// concurrent requests, or "auto" to adapt to the rate limit and observed
+// latency. It implements pflag.Value.
+type Concurrency struct {
+	N    int
+	Auto bool
+}
+
+// String implements pflag.Value
+func (c *Concurrency) String() string {
+	if c.Auto {
+		return "auto"
+	}
+	return strconv.Itoa(c.N)
+}
+
+// Set implements pflag.Value, accepting a positive number or "auto"
+func (c *Concurrency) Set(value string) error {
+	if value == "auto" {
+		c.Auto, c.N = true, 0
+		return nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("must be a number or auto")
+	}
+	c.Auto, c.N = false, n
+	return nil
+}
+
+// Type implements pflag.Value
+func (c *Concurrency) Type() string {
+	return "int|auto"
+}
+
+// Validate checks that a fixed concurrency is at least 1
+func (c Concurrency) Validate() error {
+	if !c.Auto && c.N < 1 {
+		return fmt.Errorf("concurrency must be at least 1")
+	}
+	return nil
+}
+
+// Semaphore creates the semaphore for this concurrency. rate is the client's
+// request rate limit per second, or 0 when it is unlimited.
+func (c Concurrency) Semaphore(rate float64) *Semaphore {
+	if c.Auto {
+		return NewAdaptiveSemaphore(rate)
+	}
+	return NewSemaphore(c.N)
+}
+
+// Semaphore bounds how many requests run at once. A fixed semaphore always
+// allows the same number; an adaptive one starts at one and adjusts its limit
+// AIMD-style, adding a slot after a full round of successes and halving on rate
+// limiting. Its limit never exceeds what the rate limit can serve at the
+// observed latency, since further requests would only queue in the client.
+type Semaphore struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  int
+	active int
+
+	adaptive     bool
+	rate         float64
+	latency      time.Duration
+	successes    int
+	lastDecrease time.Time
+}
+
+// NewSemaphore creates a semaphore allowing a fixed n holders
+func NewSemaphore(n int) *Semaphore {
+	if n < 1 {
+		n = 1
+	}
+	s := &Semaphore{limit: n}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// NewAdaptiveSemaphore creates a semaphore whose limit adapts to rate limiting
+// and latency. rate is the client's request rate limit per second, or 0 when
+// it is unlimited.
+func NewAdaptiveSemaphore(rate float64) *Semaphore {
+	s := NewSemaphore(1)
+	s.adaptive, s.rate = true, rate
+	return s
+}
+
+// Acquire blocks until a slot is free and takes it
+func (s *Semaphore) Acquire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for s.active >= s.limit {
+		s.cond.Wait()
+	}
+	s.active++
+}
+
+// Release frees a slot taken at start. A call that failed passes its error so
+// that only successful calls count towards raising the limit.
+func (s *Semaphore) Release(start time.Time, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.active--
+	if s.adaptive && err == nil {
+		s.observe(time.Since(start))
+	}
+	s.cond.Broadcast()
+}
+
+// observe records a successful call and raises the limit after a full round
+// of successes, up to the ceiling
+func (s *Semaphore) observe(latency time.Duration) {
+	if s.latency == 0 {
+		s.latency = latency
+	} else {
+		s.latency += time.Duration(latencyWeight * float64(latency-s.latency))
+	}
+
+	s.successes++
+	ceiling := s.ceiling()
+	if s.successes >= s.limit && s.limit < ceiling {
+		s.limit++
+		s.successes = 0
+	}
+	if s.limit > ceiling {
+		s.limit = ceiling
+	}
+}
+
+// ceiling is the most concurrent requests the rate limit can serve at the
+// average latency, following Little's law
+func (s *Semaphore) ceiling() int {
+	if s.rate <= 0 || s.latency <= 0 {
+		return MaxAutoConcurrency
+	}
+
+	n := int(math.Round(s.rate * s.latency.Seconds()))
+	if n < 1 {
+		return 1
+	}
+	if n > MaxAutoConcurrency {
+		return MaxAutoConcurrency
+	}
+	return n
+}
+
+// RateLimited halves the limit of an adaptive semaphore. Requests already in
+// flight when the limit drops tend to be rate limited together, so further
+// reports within one average latency of the last decrease are ignored.
+func (s *Semaphore) RateLimited() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.adaptive {
+		return
+	}
+
+	cooldown := s.latency
+	if cooldown < time.Second {
+		cooldown = time.Second
+	}
+	if !s.lastDecrease.IsZero() && time.Since(s.lastDecrease) < cooldown {
+		return
+	}
+
+	s.limit /= 2
+	if s.limit < 1 {
+		s.limit = 1
+	}
+	s.successes = 0
+	s.lastDecrease = time.Now()
+}
+
+// Limit returns the current number of holders allowed
+func (s *Semaphore) Limit() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.limit
+}
@@ -0,0 +1,117 @@
+package util
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// maxSitemapDepth bounds how deeply nested sitemap indexes are followed
+const maxSitemapDepth = 5
+
+// sitemapClient fetches remote sitemaps
+var sitemapClient = &http.Client{Timeout: 30 * time.Second}
+
+// sitemapDocument covers both <urlset> sitemaps and <sitemapindex> files
+type sitemapDocument struct {
+	URLs []struct {
+		Loc string `xml:"loc"`
+	} `xml:"url"`
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+}
+
+// ReadSitemap returns the page URLs listed in a sitemap, given as a URL or a
+// local file. Sitemap index files are followed recursively, and sources
+// ending in .gz are decompressed.
+func ReadSitemap(ctx context.Context, source string) ([]string, error) {
+	visited := make(map[string]bool)
+	return readSitemap(ctx, source, visited, 0)
+}
+
+func readSitemap(ctx context.Context, source string, visited map[string]bool, depth int) ([]string, error) {
+	if depth > maxSitemapDepth {
+		return nil, fmt.Errorf("sitemap %s is nested more than %d levels deep", source, maxSitemapDepth)
+	}
+	if visited[source] {
+		return nil, nil
+	}
+	visited[source] = true
+
+	body, err := openSitemap(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var reader io.Reader = body
+	if strings.HasSuffix(strings.ToLower(source), ".gz") {
+		gzipReader, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing sitemap %s: %w", source, err)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	var doc sitemapDocument
+	if err := xml.NewDecoder(reader).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("error parsing sitemap %s: %w", source, err)
+	}
+
+	var urls []string
+	for _, entry := range doc.URLs {
+		if loc := strings.TrimSpace(entry.Loc); loc != "" {
+			urls = append(urls, loc)
+		}
+	}
+
+	for _, child := range doc.Sitemaps {
+		loc := strings.TrimSpace(child.Loc)
+		if loc == "" {
+			continue
+		}
+		childURLs, err := readSitemap(ctx, loc, visited, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, childURLs...)
+	}
+
+	return urls, nil
+}
+
+// openSitemap opens a local sitemap file or fetches a remote one
+func openSitemap(ctx context.Context, source string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		file, err := os.Open(source)
+		if err != nil {
+			return nil, fmt.Errorf("error opening sitemap: %w", err)
+		}
+		return file, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching sitemap %s: %w", source, err)
+	}
+
+	resp, err := sitemapClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching sitemap %s: %w", source, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("error fetching sitemap %s: %s", source, resp.Status)
+	}
+
+	return resp.Body, nil
+}
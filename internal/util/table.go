@@ -0,0 +1,157 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// tableGap separates adjacent table columns
+const tableGap = "  "
+
+// minColumnWidth is the narrowest a column is shrunk to when fitting a terminal
+const minColumnWidth = 8
+
+// Table renders rows as aligned columns sized to their contents. Cells longer
+// than their column's maximum are truncated with an ellipsis.
+type Table struct {
+	headers []string
+	rows    [][]string
+
+	// MaxWidths caps the width of each column; zero or a missing entry means
+	// no cap
+	MaxWidths []int
+
+	// Width is the total width to fit the table in. Zero uses the terminal
+	// width when rendering to a terminal, and no limit otherwise.
+	Width int
+}
+
+// NewTable creates a table with the given column headers
+func NewTable(headers ...string) *Table {
+	return &Table{headers: headers}
+}
+
+// AddRow appends a row; missing cells are left blank and extra cells dropped
+func (t *Table) AddRow(values ...string) {
+	row := make([]string, len(t.headers))
+	for i := range row {
+		if i < len(values) {
+			// Keep every row on one line
+			row[i] = strings.Join(strings.Fields(values[i]), " ")
+		}
+	}
+	t.rows = append(t.rows, row)
+}
+
+// Render writes the header, a separator and the rows to w
+func (t *Table) Render(w io.Writer) {
+	widths := t.columnWidths(t.totalWidth(w))
+
+	t.writeRow(w, t.headers, widths)
+	total := len(tableGap) * (len(widths) - 1)
+	for _, width := range widths {
+		total += width
+	}
+	fmt.Fprintln(w, strings.Repeat("-", total))
+	for _, row := range t.rows {
+		t.writeRow(w, row, widths)
+	}
+}
+
+// Print writes the table to stdout
+func (t *Table) Print() {
+	t.Render(os.Stdout)
+}
+
+// totalWidth is the width the table must fit in, or zero for no limit
+func (t *Table) totalWidth(w io.Writer) int {
+	if t.Width > 0 {
+		return t.Width
+	}
+	if IsTerminal(w) {
+		f := w.(*os.File)
+		if width, _, err := term.GetSize(int(f.Fd())); err == nil {
+			return width
+		}
+	}
+	return 0
+}
+
+// columnWidths measures each column, applies MaxWidths, and then narrows the
+// widest columns until the table fits in total, if given
+func (t *Table) columnWidths(total int) []int {
+	widths := make([]int, len(t.headers))
+	for i, header := range t.headers {
+		widths[i] = utf8.RuneCountInString(header)
+	}
+	for _, row := range t.rows {
+		for i, cell := range row {
+			if n := utf8.RuneCountInString(cell); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+
+	for i := range widths {
+		if i < len(t.MaxWidths) && t.MaxWidths[i] > 0 && widths[i] > t.MaxWidths[i] {
+			widths[i] = t.MaxWidths[i]
+		}
+	}
+
+	if total <= 0 {
+		return widths
+	}
+
+	excess := len(tableGap)*(len(widths)-1) - total
+	for _, width := range widths {
+		excess += width
+	}
+	for excess > 0 {
+		widest := 0
+		for i := range widths {
+			if widths[i] > widths[widest] {
+				widest = i
+			}
+		}
+		if widths[widest] <= minColumnWidth {
+			break
+		}
+		widths[widest]--
+		excess--
+	}
+
+	return widths
+}
+
+// writeRow writes one line, padding every column but the last
+func (t *Table) writeRow(w io.Writer, row []string, widths []int) {
+	var line strings.Builder
+	for i, cell := range row {
+		cell = Truncate(cell, widths[i])
+		if i == len(row)-1 {
+			line.WriteString(cell)
+			break
+		}
+		line.WriteString(cell)
+		line.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)))
+		line.WriteString(tableGap)
+	}
+	fmt.Fprintln(w, strings.TrimRight(line.String(), " "))
+}
+
+// Truncate shortens s to at most width characters, ending in an ellipsis when
+// anything was cut
+func Truncate(s string, width int) string {
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	if width <= 1 {
+		return string([]rune(s)[:width])
+	}
+	return string([]rune(s)[:width-1]) + "…"
+}
@@ -0,0 +1,236 @@
+package util
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// MaxCacheTagLength is the longest cache tag Cloudflare accepts
+const MaxCacheTagLength = 1024
+
+// MaxCacheTagHeaderLength is the most bytes of cache tags Cloudflare reads from
+// a response's Cache-Tag header; tags beyond it cannot be purged
+const MaxCacheTagHeaderLength = 16 * 1024
+
+// ValidateCacheTag checks a single cache tag against Cloudflare's tag syntax:
+// non-empty, at most 1024 characters, printable ASCII without spaces or commas
+func ValidateCacheTag(tag string) error {
+	if tag == "" {
+		return fmt.Errorf("tag is empty")
+	}
+
+	if len(tag) > MaxCacheTagLength {
+		return fmt.Errorf("tag is %d characters, the maximum is %d", len(tag), MaxCacheTagLength)
+	}
+
+	for _, r := range tag {
+		if r == ',' {
+			return fmt.Errorf("tag contains a comma")
+		}
+		if r <= ' ' || r > '~' {
+			return fmt.Errorf("tag contains invalid character %q", r)
+		}
+	}
+
+	return nil
+}
+
+// ValidateCacheTagList checks cache-tag metadata before it is stored, so that
+// every tag in it can later be purged. The value may hold a single tag or a
+// comma or space separated list; the error names the first offending tag.
+func ValidateCacheTagList(value string) error {
+	tags := SplitTagList(value)
+	if len(tags) == 0 {
+		return fmt.Errorf("cache tag is empty")
+	}
+
+	for _, tag := range tags {
+		if err := ValidateCacheTag(tag); err != nil {
+			return fmt.Errorf("invalid cache tag '%s': %w", Truncate(tag, 64), err)
+		}
+	}
+
+	if total := len(strings.Join(tags, ",")); total > MaxCacheTagHeaderLength {
+		return fmt.Errorf("%d cache tags take %d bytes, the maximum is %d", len(tags), total, MaxCacheTagHeaderLength)
+	}
+
+	return nil
+}
+
+// PrefixCacheTags prepends prefix to every tag, dropping tags that become
+// duplicates. Validation happens afterwards, so the prefix counts toward the
+// tag length limit.
+func PrefixCacheTags(tags []string, prefix string) []string {
+	if prefix == "" {
+		return tags
+	}
+
+	prefixed := make([]string, len(tags))
+	for i, tag := range tags {
+		prefixed[i] = prefix + tag
+	}
+	return FilterDuplicates(prefixed)
+}
+
+// SplitValidCacheTags separates valid tags from invalid ones, returning the
+// reason each invalid tag was rejected
+func SplitValidCacheTags(tags []string) ([]string, map[string]error) {
+	var valid []string
+	invalid := make(map[string]error)
+
+	for _, tag := range tags {
+		if err := ValidateCacheTag(tag); err != nil {
+			invalid[tag] = err
+			continue
+		}
+		valid = append(valid, tag)
+	}
+
+	return valid, invalid
+}
+
+// SplitTagList splits list-style cache-tag metadata such as
+// "product-1, sale homepage" into its individual tags
+func SplitTagList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
+
+// TagListContains reports whether tag is exactly one of the tags in list-style
+// metadata, so that "sale" does not match "wholesale"
+func TagListContains(value, tag string) bool {
+	for _, item := range SplitTagList(value) {
+		if item == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// ReportInvalidCacheTags prints which tags were rejected and why
+func ReportInvalidCacheTags(invalid map[string]error) {
+	if len(invalid) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(invalid))
+	for tag := range invalid {
+		names = append(names, fmt.Sprintf("'%s'", tag))
+	}
+	Warning("Rejected %d invalid cache tags: %s", len(invalid), strings.Join(names, ", "))
+	for tag, err := range invalid {
+		Printf("   %s: %v\n", tag, err)
+	}
+}
+
+// Formats accepted by ReadTagsFile
+const (
+	TagsFileLines = "lines"
+	TagsFileCSV   = "csv"
+	TagsFileJSON  = "json"
+)
+
+// DefaultTagsColumn is the CSV column read by ReadTagsFile unless told otherwise
+const DefaultTagsColumn = "tag"
+
+// ReadTagsFile reads cache tags from a file in one of three formats: lines
+// (one tag per line, blank lines and # comments skipped), csv (the named
+// column of a file with a header row) or json (an array of strings). Empty
+// tags are skipped; validation is left to the caller.
+func ReadTagsFile(path, format, column string) ([]string, error) {
+	switch format {
+	case "", TagsFileLines:
+		tags, err := ReadLines(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading tags file: %w", err)
+		}
+		return tags, nil
+	case TagsFileCSV:
+		return readTagsCSV(path, column)
+	case TagsFileJSON:
+		return readTagsJSON(path)
+	default:
+		return nil, fmt.Errorf("invalid tags file format '%s': must be %s, %s or %s", format, TagsFileLines, TagsFileCSV, TagsFileJSON)
+	}
+}
+
+// readTagsCSV reads one column of a CSV file, found by its header
+func readTagsCSV(path, column string) ([]string, error) {
+	if column == "" {
+		column = DefaultTagsColumn
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading tags file: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("tags file %s is empty", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing tags file %s: %w", path, err)
+	}
+
+	index := -1
+	for i, name := range header {
+		if strings.EqualFold(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")), column) {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("tags file %s has no '%s' column (columns: %s)", path, column, strings.Join(header, ", "))
+	}
+
+	var tags []string
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error parsing tags file %s: %w", path, err)
+		}
+		if index < len(record) {
+			if tag := strings.TrimSpace(record[index]); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+
+	return tags, nil
+}
+
+// readTagsJSON reads a JSON array of tag strings
+func readTagsJSON(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading tags file: %w", err)
+	}
+
+	var raw []string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("error parsing tags file %s: expected a JSON array of strings: %w", path, err)
+	}
+
+	var tags []string
+	for _, tag := range raw {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
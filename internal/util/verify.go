@@ -0,0 +1,267 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Outcomes of verifying that a purged URL is no longer served from stale cache
+const (
+	VerifyFresh     = "fresh"
+	VerifyStale     = "stale"
+	VerifyNotCached = "not-cached"
+	VerifyError     = "error"
+)
+
+// verifyClient fetches purged URLs to check what the cache serves
+var verifyClient = &http.Client{Timeout: 30 * time.Second}
+
+// verifyHost, when set, replaces the Host header of verification requests
+var verifyHost string
+
+// SetVerifyOverrides makes verification requests send host as their Host
+// header and connect to the addresses in resolve, given as host:ip entries
+// like curl's --resolve, instead of looking the hosts up in DNS. It only
+// affects the requests made to check purged URLs, never the Cloudflare API.
+func SetVerifyOverrides(host string, resolve []string) error {
+	addrs := make(map[string]string, len(resolve))
+	for _, entry := range resolve {
+		name, ip, ok := strings.Cut(entry, ":")
+		ip = strings.TrimSuffix(strings.TrimPrefix(ip, "["), "]")
+		if !ok || name == "" || net.ParseIP(ip) == nil {
+			return fmt.Errorf("invalid resolve entry '%s': expected host:ip, e.g. example.com:203.0.113.10", entry)
+		}
+		addrs[strings.ToLower(name)] = ip
+	}
+
+	verifyHost = host
+	if len(addrs) == 0 {
+		verifyClient = &http.Client{Timeout: 30 * time.Second}
+		return nil
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		name, port, err := net.SplitHostPort(addr)
+		if err == nil {
+			if ip, ok := addrs[strings.ToLower(name)]; ok {
+				addr = net.JoinHostPort(ip, port)
+			}
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+	verifyClient = &http.Client{Timeout: 30 * time.Second, Transport: transport}
+	return nil
+}
+
+// CacheSnapshot records what Cloudflare served for a URL at one moment
+type CacheSnapshot struct {
+	StatusCode   int       `json:"status_code" yaml:"status_code"`
+	CacheStatus  string    `json:"cache_status" yaml:"cache_status"`
+	Age          int       `json:"age" yaml:"age"`
+	ETag         string    `json:"etag,omitempty" yaml:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty" yaml:"last_modified,omitempty"`
+	FetchedAt    time.Time `json:"fetched_at" yaml:"fetched_at"`
+}
+
+// VerifyResult is the outcome of checking one URL after a purge
+type VerifyResult struct {
+	URL            string        `json:"url" yaml:"url"`
+	Status         string        `json:"status" yaml:"status"`
+	Detail         string        `json:"detail,omitempty" yaml:"detail,omitempty"`
+	ContentChanged bool          `json:"content_changed" yaml:"content_changed"`
+	Before         CacheSnapshot `json:"before" yaml:"before"`
+	After          CacheSnapshot `json:"after" yaml:"after"`
+}
+
+// VerifyOptions controls how long purged URLs are polled for fresh content
+type VerifyOptions struct {
+	// Timeout is how long a URL may keep serving stale content while the
+	// purge propagates before it is reported as stale
+	Timeout     time.Duration
+	Interval    time.Duration
+	Concurrency int
+}
+
+// FetchCacheSnapshot requests a URL and records the cache headers of the response
+func FetchCacheSnapshot(ctx context.Context, url string) (CacheSnapshot, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return CacheSnapshot{}, fmt.Errorf("error fetching %s: %w", url, err)
+	}
+	if verifyHost != "" {
+		req.Host = verifyHost
+	}
+
+	resp, err := verifyClient.Do(req)
+	if err != nil {
+		return CacheSnapshot{}, fmt.Errorf("error fetching %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	// Read the body so that the cache stores the response
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	snapshot := CacheSnapshot{
+		StatusCode:   resp.StatusCode,
+		CacheStatus:  strings.ToUpper(resp.Header.Get("CF-Cache-Status")),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		FetchedAt:    time.Now(),
+	}
+	if age, err := strconv.Atoi(resp.Header.Get("Age")); err == nil {
+		snapshot.Age = age
+	}
+	return snapshot, nil
+}
+
+// SnapshotURLs fetches each URL concurrently and returns the snapshots that
+// succeeded, keyed by URL. URLs that could not be fetched are left out.
+func SnapshotURLs(ctx context.Context, urls []string, concurrency int) map[string]CacheSnapshot {
+	snapshots := make(map[string]CacheSnapshot, len(urls))
+	var mu sync.Mutex
+
+	forEachURL(urls, concurrency, func(url string) {
+		snapshot, err := FetchCacheSnapshot(ctx, url)
+		if err != nil {
+			Warning("Could not record the cached state of %s: %v", url, err)
+			return
+		}
+		mu.Lock()
+		snapshots[url] = snapshot
+		mu.Unlock()
+	})
+
+	return snapshots
+}
+
+// ClassifyCacheState decides whether a response fetched after a purge started
+// came from content cached before the purge. The Age header tells how long the
+// response has been in cache; one second of slack covers its rounding.
+func ClassifyCacheState(snapshot CacheSnapshot, purgedAt time.Time) string {
+	switch snapshot.CacheStatus {
+	case "MISS", "EXPIRED":
+		return VerifyFresh
+	case "STALE", "UPDATING":
+		return VerifyStale
+	case "HIT", "REVALIDATED":
+		cachedAt := snapshot.FetchedAt.Add(-time.Duration(snapshot.Age) * time.Second)
+		if cachedAt.Before(purgedAt.Add(-time.Second)) {
+			return VerifyStale
+		}
+		return VerifyFresh
+	default:
+		// DYNAMIC, BYPASS or no header: the response is not served from cache
+		return VerifyNotCached
+	}
+}
+
+// VerifyPurge checks that each purged URL is no longer served from content
+// cached before purgedAt. A URL that still looks stale is polled until the
+// timeout, since purges take a moment to propagate. A fresh miss is followed
+// by one more request to confirm the cache is repopulated with a new age.
+func VerifyPurge(ctx context.Context, urls []string, before map[string]CacheSnapshot, purgedAt time.Time, opts VerifyOptions) []VerifyResult {
+	results := make([]VerifyResult, len(urls))
+	index := make(map[string]int, len(urls))
+	for i, url := range urls {
+		index[url] = i
+	}
+
+	forEachURL(urls, opts.Concurrency, func(url string) {
+		result := verifyURL(ctx, url, purgedAt, opts)
+		result.Before = before[url]
+		result.ContentChanged = contentChanged(result.Before, result.After)
+		results[index[url]] = result
+	})
+
+	return results
+}
+
+// verifyURL polls one URL until it is served fresh or the timeout passes
+func verifyURL(ctx context.Context, url string, purgedAt time.Time, opts VerifyOptions) VerifyResult {
+	result := VerifyResult{URL: url}
+	deadline := time.Now().Add(opts.Timeout)
+
+	for {
+		snapshot, err := FetchCacheSnapshot(ctx, url)
+		if err == nil {
+			result.After = snapshot
+			result.Status = ClassifyCacheState(snapshot, purgedAt)
+			result.Detail = ""
+		} else {
+			result.Status = VerifyError
+			result.Detail = err.Error()
+		}
+
+		if result.Status != VerifyStale && result.Status != VerifyError {
+			break
+		}
+		if result.Status == VerifyStale {
+			result.Detail = fmt.Sprintf("still served from cache with age %ds", snapshot.Age)
+		}
+		if !time.Now().Add(opts.Interval).Before(deadline) {
+			return result
+		}
+
+		select {
+		case <-ctx.Done():
+			return result
+		case <-time.After(opts.Interval):
+		}
+	}
+
+	if result.Status != VerifyFresh || result.After.CacheStatus == "HIT" {
+		return result
+	}
+
+	// The purge let the request through to the origin; the next request
+	// should be a hit on the newly cached copy
+	first := result.After.CacheStatus
+	snapshot, err := FetchCacheSnapshot(ctx, url)
+	if err != nil {
+		result.Detail = fmt.Sprintf("%s, then %v", first, err)
+		return result
+	}
+	result.After = snapshot
+	result.Detail = fmt.Sprintf("%s, then %s with age %ds", first, snapshot.CacheStatus, snapshot.Age)
+	return result
+}
+
+// contentChanged reports whether the validators of a URL changed across the purge
+func contentChanged(before, after CacheSnapshot) bool {
+	if before.ETag != "" && after.ETag != "" {
+		return before.ETag != after.ETag
+	}
+	if before.LastModified != "" && after.LastModified != "" {
+		return before.LastModified != after.LastModified
+	}
+	return false
+}
+
+// forEachURL runs fn for every URL with at most concurrency running at once
+func forEachURL(urls []string, concurrency int, fn func(url string)) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	for _, url := range urls {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(url string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(url)
+		}(url)
+	}
+	wg.Wait()
+}
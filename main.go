@@ -1,10 +1,10 @@
 package main
 
 import (
-	"fmt"
 	"os"
 
 	"cfpurge/cmd"
+	"cfpurge/internal/util"
 )
 
 var (
@@ -16,7 +16,7 @@ func main() {
 	cmd.SetVersionInfo(version, buildTime)
 
 	if err := cmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		util.WriteError(os.Stderr, cmd.OutputFormat(), err)
+		os.Exit(util.ExitCode(err))
 	}
 }
@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"cfpurge/internal/util"
+)
+
+func TestAuditLogAppendsEntries(t *testing.T) {
+	t.Setenv("USER", "alice")
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	// Entries from an earlier run are kept
+	if err := os.WriteFile(path, []byte(`{"command":"earlier"}`+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := util.OpenAuditLog(path, false, "cfpurge kv delete"); err != nil {
+		t.Fatalf("OpenAuditLog returned error: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			util.Audit("namespace-1", []string{fmt.Sprintf("key-%d", i)}, nil)
+		}(i)
+	}
+	wg.Wait()
+	util.Audit("namespace-2", []string{"a", "b"}, errors.New("rate limited"))
+
+	if err := util.CloseAuditLog(); err != nil {
+		t.Fatalf("CloseAuditLog returned error: %v", err)
+	}
+
+	// Nothing is recorded once the log is closed
+	util.Audit("namespace-3", nil, nil)
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var entries []util.AuditEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry util.AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("invalid audit line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if len(entries) != 52 {
+		t.Fatalf("expected 52 audit lines, got %d", len(entries))
+	}
+	if entries[1].User != "alice" || entries[1].Command != "cfpurge kv delete" || entries[1].Outcome != util.AuditSuccess || entries[1].Time.IsZero() {
+		t.Errorf("unexpected entry %+v", entries[1])
+	}
+	last := entries[51]
+	if last.Scope != "namespace-2" || last.Outcome != util.AuditFailure || last.Error != "rate limited" || len(last.Targets) != 2 {
+		t.Errorf("unexpected failure entry %+v", last)
+	}
+}
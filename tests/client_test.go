@@ -0,0 +1,124 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"cfpurge/internal/api"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+func TestGetClientIsSharedUntilConfigChanges(t *testing.T) {
+	api.SetConfig(api.Config{APIToken: "token-a"})
+	defer api.SetConfig(api.Config{})
+
+	first, err := api.GetClient(api.ReadAccess)
+	if err != nil {
+		t.Fatalf("GetClient returned error: %v", err)
+	}
+	second, err := api.GetClient(api.WriteAccess)
+	if err != nil {
+		t.Fatalf("GetClient returned error: %v", err)
+	}
+	if first != second {
+		t.Error("expected GetClient to return the same client within an invocation")
+	}
+
+	api.SetConfig(api.Config{APIToken: "token-b"})
+	third, err := api.GetClient(api.ReadAccess)
+	if err != nil {
+		t.Fatalf("GetClient returned error: %v", err)
+	}
+	if third == first || third.APIToken != "token-b" {
+		t.Error("expected a new client after the configuration changed")
+	}
+
+	api.SetConfig(api.Config{})
+	if _, err := api.GetClient(api.ReadAccess); err == nil {
+		t.Error("expected an error without credentials")
+	}
+}
+
+func TestGetClientSelectsReadOrWriteToken(t *testing.T) {
+	defer api.SetConfig(api.Config{})
+
+	api.SetConfig(api.Config{APIToken: "token", ReadToken: "read", WriteToken: "write"})
+	read, err := api.GetClient(api.ReadAccess)
+	if err != nil || read.APIToken != "read" {
+		t.Fatalf("expected the read token for reads, got %v", err)
+	}
+	write, err := api.GetClient(api.WriteAccess)
+	if err != nil || write.APIToken != "write" {
+		t.Fatalf("expected the write token for writes, got %v", err)
+	}
+
+	// Unset split tokens fall back to the single token
+	api.SetConfig(api.Config{APIToken: "token", ReadToken: "read"})
+	if write, err := api.GetClient(api.WriteAccess); err != nil || write.APIToken != "token" {
+		t.Errorf("expected writes to fall back to the single token, got %v", err)
+	}
+
+	// A write token can also read, but a read token never writes
+	api.SetConfig(api.Config{WriteToken: "write"})
+	if read, err := api.GetClient(api.ReadAccess); err != nil || read.APIToken != "write" {
+		t.Errorf("expected reads to use the write token, got %v", err)
+	}
+	api.SetConfig(api.Config{ReadToken: "read"})
+	if err := api.ValidateAuth(); err != nil {
+		t.Errorf("expected a read token alone to be valid credentials, got %v", err)
+	}
+	if _, err := api.GetClient(api.WriteAccess); err == nil {
+		t.Error("expected writes to fail with only a read token")
+	}
+}
+
+func TestGetClientAppliesConfiguredOptions(t *testing.T) {
+	api.SetConfig(api.Config{APIToken: "token", UserAgent: "cfpurge/1.2.3"})
+	defer api.SetConfig(api.Config{})
+
+	client, err := api.GetClient(api.ReadAccess)
+	if err != nil {
+		t.Fatalf("GetClient returned error: %v", err)
+	}
+	if client.UserAgent != "cfpurge/1.2.3" {
+		t.Errorf("expected the configured user agent, got %q", client.UserAgent)
+	}
+
+	custom, err := api.GetClient(api.ReadAccess, cloudflare.BaseURL("http://127.0.0.1:1"))
+	if err != nil {
+		t.Fatalf("GetClient returned error: %v", err)
+	}
+	if custom == client || custom.BaseURL != "http://127.0.0.1:1" || custom.UserAgent != "cfpurge/1.2.3" {
+		t.Errorf("expected a separate client with both the configured and extra options, got %+v", custom)
+	}
+}
+
+func TestClientSendsVersionedUserAgent(t *testing.T) {
+	api.SetVersion("1.2.3")
+	defer api.SetVersion("dev")
+	api.SetConfig(api.Config{APIToken: "token"})
+	defer api.SetConfig(api.Config{})
+
+	var userAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.Header.Get("User-Agent")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"success":true,"errors":[],"messages":[],"result":[],"result_info":{"page":1,"per_page":50,"total_pages":1,"count":0,"total_count":0}}`)
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := api.GetClient(api.ReadAccess, cloudflare.BaseURL(server.URL))
+	if err != nil {
+		t.Fatalf("GetClient returned error: %v", err)
+	}
+	if _, err := client.ListZones(context.Background()); err != nil {
+		t.Fatalf("ListZones returned error: %v", err)
+	}
+	if userAgent != "cfpurge/1.2.3" {
+		t.Errorf("expected user agent cfpurge/1.2.3, got %q", userAgent)
+	}
+}
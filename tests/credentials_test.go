@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"cfpurge/internal/api"
+)
+
+func writeCredentials(t *testing.T, content string, perm os.FileMode) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "credentials")
+	if err := os.WriteFile(path, []byte(content), perm); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadProfiles(t *testing.T) {
+	path := writeCredentials(t, `
+agency-b:
+  key: key-b
+  email: ops@example.com
+agency-a:
+  token: token-a
+  account_id: account-a
+  require_explicit_zones: true
+`, 0o600)
+
+	profiles, err := api.LoadProfiles(path)
+	if err != nil {
+		t.Fatalf("LoadProfiles returned error: %v", err)
+	}
+	if len(profiles) != 2 || profiles[0].Name != "agency-a" || profiles[1].Name != "agency-b" {
+		t.Fatalf("expected profiles sorted by name, got %+v", profiles)
+	}
+
+	profile, err := api.LoadProfile(path, "agency-a")
+	if err != nil {
+		t.Fatalf("LoadProfile returned error: %v", err)
+	}
+	if cfg := profile.Config(); cfg.APIToken != "token-a" || cfg.AccountID != "account-a" {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+	if !profile.RequireExplicitZones || profiles[1].RequireExplicitZones {
+		t.Errorf("expected only agency-a to require explicit zones, got %+v", profiles)
+	}
+
+	if _, err := api.LoadProfile(path, "missing"); err == nil {
+		t.Error("expected an error for an unknown profile")
+	}
+}
+
+func TestLoadProfilesRejectsIncompleteProfile(t *testing.T) {
+	path := writeCredentials(t, "broken:\n  email: ops@example.com\n", 0o600)
+	if _, err := api.LoadProfiles(path); err == nil {
+		t.Error("expected an error for a profile without a token or key")
+	}
+}
+
+func TestCredentialsFileExposed(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permission bits are not checked on Windows")
+	}
+
+	private := writeCredentials(t, "a:\n  token: t\n", 0o600)
+	if exposed, err := api.CredentialsFileExposed(private); err != nil || exposed {
+		t.Errorf("expected a 0600 file not to be exposed, got %v, %v", exposed, err)
+	}
+
+	public := writeCredentials(t, "a:\n  token: t\n", 0o600)
+	if err := os.Chmod(public, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if exposed, err := api.CredentialsFileExposed(public); err != nil || !exposed {
+		t.Errorf("expected a 0644 file to be exposed, got %v, %v", exposed, err)
+	}
+}
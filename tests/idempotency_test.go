@@ -0,0 +1,49 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"cfpurge/internal/api"
+)
+
+func TestIdempotencyKeyHeader(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Get(api.IdempotencyHeader))
+	}))
+	defer server.Close()
+
+	httpClient := &http.Client{Transport: api.NewIdempotencyTransport(nil)}
+	ctx, key := api.WithIdempotencyKey(context.Background())
+
+	// Two requests with the same context, as a retry would make
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, server.URL, nil)
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	// A request without a key must not get the header
+	req, _ := http.NewRequest(http.MethodPost, server.URL, nil)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if len(key) != 36 {
+		t.Fatalf("expected a UUID, got %q", key)
+	}
+	if received[0] != key || received[1] != key {
+		t.Fatalf("expected both requests to carry %q, got %v", key, received[:2])
+	}
+	if received[2] != "" {
+		t.Fatalf("expected no key on plain request, got %q", received[2])
+	}
+}
@@ -0,0 +1,301 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"cfpurge/internal/api"
+	"cfpurge/internal/util"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// awkwardKeys are valid key names that are not safe to put in a URL as is
+var awkwardKeys = []string{
+	"with space",
+	"a/b/c",
+	"page#chunk-1",
+	"query?x=1&y=2",
+	"100%",
+	"ключ/été ✓",
+	" padded ",
+}
+
+// newKVTestClient returns a client from api.GetClient for a fake KV API. Bulk
+// writes store each pair under its key; reads and deletes use the key decoded
+// from the request path, and listing pages through the sorted keys with the
+// cursor holding the index of the next key.
+func newKVTestClient(t *testing.T) (*cloudflare.API, map[string]cloudflare.WorkersKVPair) {
+	var mu sync.Mutex
+	store := make(map[string]cloudflare.WorkersKVPair)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, "/namespaces/ns/bulk") {
+			var pairs []cloudflare.WorkersKVPair
+			if err := json.NewDecoder(r.Body).Decode(&pairs); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			for _, pair := range pairs {
+				store[pair.Key] = pair
+			}
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"success":true,"errors":[],"messages":[],"result":null}`)
+			return
+		}
+
+		if r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/namespaces/ns/keys") {
+			query := r.URL.Query()
+			var names []string
+			for name := range store {
+				if strings.HasPrefix(name, query.Get("prefix")) {
+					names = append(names, name)
+				}
+			}
+			sort.Strings(names)
+
+			start, _ := strconv.Atoi(query.Get("cursor"))
+			end := len(names)
+			if limit, _ := strconv.Atoi(query.Get("limit")); limit > 0 && start+limit < end {
+				end = start + limit
+			}
+			cursor := ""
+			if end < len(names) {
+				cursor = strconv.Itoa(end)
+			}
+
+			keys := make([]cloudflare.StorageKey, 0, end-start)
+			for _, name := range names[start:end] {
+				keys = append(keys, cloudflare.StorageKey{Name: name, Expiration: store[name].Expiration, Metadata: store[name].Metadata})
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": true, "errors": []string{}, "messages": []string{},
+				"result": keys, "result_info": map[string]interface{}{"count": len(keys), "cursor": cursor},
+			})
+			return
+		}
+
+		_, escaped, ok := strings.Cut(r.URL.EscapedPath(), "/namespaces/ns/values/")
+		key, err := url.PathUnescape(escaped)
+		if !ok || err != nil || strings.Contains(escaped, "/") {
+			http.Error(w, "bad key path "+r.URL.EscapedPath(), http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			pair, ok := store[key]
+			if !ok {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, `{"success":false,"errors":[{"code":10009,"message":"key not found"}],"messages":[],"result":null}`)
+				return
+			}
+			value, _ := base64.StdEncoding.DecodeString(pair.Value)
+			w.Write(value)
+			return
+		case http.MethodDelete:
+			delete(store, key)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"success":true,"errors":[],"messages":[],"result":null}`)
+	}))
+	t.Cleanup(server.Close)
+
+	api.SetConfig(api.Config{APIToken: "token", AccountID: "account"})
+	t.Cleanup(func() { api.SetConfig(api.Config{}) })
+
+	client, err := api.GetClient(api.WriteAccess, cloudflare.BaseURL(server.URL), cloudflare.UsingRateLimit(1000))
+	if err != nil {
+		t.Fatalf("GetClient returned error: %v", err)
+	}
+	return client, store
+}
+
+func TestKVKeysRoundTripUnescaped(t *testing.T) {
+	client, store := newKVTestClient(t)
+	ctx := context.Background()
+
+	keys := append(append([]string{}, awkwardKeys...), util.ChunkKey("page", 2), "#hashed", "   ")
+	for _, key := range keys {
+		if err := util.ValidateKVKey(key); err != nil {
+			t.Fatalf("expected %q to be a valid key: %v", key, err)
+		}
+
+		value := []byte("value of " + key + "\x00\xff")
+		err := api.WriteKV(ctx, client, "ns", api.KVWrite{Key: key, Value: value, Metadata: map[string]interface{}{"k": key}, ExpirationTTL: 60})
+		if err != nil {
+			t.Fatalf("put %q: %v", key, err)
+		}
+		pair, ok := store[key]
+		if !ok {
+			t.Fatalf("put %q stored under a different key: %v", key, store)
+		}
+		if pair.ExpirationTTL != 60 || !reflect.DeepEqual(pair.Metadata, map[string]interface{}{"k": key}) {
+			t.Errorf("put %q lost its metadata or expiry: %+v", key, pair)
+		}
+
+		got, err := api.ReadKV(ctx, client, "ns", key)
+		if err != nil || !bytes.Equal(got, value) {
+			t.Errorf("get %q returned %q, %v", key, got, err)
+		}
+
+		if err := api.DeleteKV(ctx, client, "ns", key); err != nil {
+			t.Fatalf("delete %q: %v", key, err)
+		}
+		if _, ok := store[key]; ok {
+			t.Errorf("delete %q left the key in place", key)
+		}
+	}
+}
+
+func TestReadKVKeyFindsExactKey(t *testing.T) {
+	client, _ := newKVTestClient(t)
+	ctx := context.Background()
+
+	for _, key := range []string{"page", "page/1", "page 2", "pager"} {
+		if err := api.WriteKV(ctx, client, "ns", api.KVWrite{Key: key, Metadata: map[string]interface{}{"name": key}}); err != nil {
+			t.Fatalf("put %q: %v", key, err)
+		}
+	}
+
+	listed, err := api.ReadKVKey(ctx, client, "ns", "page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if listed.Name != "page" || !reflect.DeepEqual(listed.Metadata, map[string]interface{}{"name": "page"}) {
+		t.Errorf("expected the metadata of page, got %+v", listed)
+	}
+
+	var notFound *cloudflare.NotFoundError
+	if _, err := api.ReadKVKey(ctx, client, "ns", "pag"); !errors.As(err, &notFound) {
+		t.Errorf("expected a not found error for a key that is only a prefix, got %v", err)
+	}
+}
+
+func TestListKVKeysFollowsCursor(t *testing.T) {
+	client, _ := newKVTestClient(t)
+	ctx := context.Background()
+
+	expected := []string{"a", "b", "c", "d", "e"}
+	for _, key := range append([]string{"other"}, expected...) {
+		if err := api.WriteKV(ctx, client, "ns", api.KVWrite{Key: key}); err != nil {
+			t.Fatalf("put %q: %v", key, err)
+		}
+	}
+
+	var names []string
+	cursor := ""
+	for pages := 1; ; pages++ {
+		keys, next, err := api.ListKVKeys(ctx, client, "ns", "", 2, cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range keys {
+			if key.Name != "other" {
+				names = append(names, key.Name)
+			}
+		}
+		if next == "" {
+			if pages != 3 {
+				t.Errorf("expected 3 pages, got %d", pages)
+			}
+			break
+		}
+		cursor = next
+	}
+
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %q, got %q", expected, names)
+	}
+}
+
+func TestReadKeysFileKeepsKeysVerbatim(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.txt")
+	content := "# keys to delete\r\n" + strings.Join(awkwardKeys, "\r\n") + "\n\n   \n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := util.ReadKeysFile(path, util.KeysFileLines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys, awkwardKeys) {
+		t.Errorf("expected %q, got %q", awkwardKeys, keys)
+	}
+
+	if err := os.WriteFile(path, []byte("ok\n..\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := util.ReadKeysFile(path, util.KeysFileLines); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected an invalid key on line 2 to be reported, got %v", err)
+	}
+}
+
+func TestReadKeysFileRawKeepsCommentsAndWhitespace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.txt")
+	if err := os.WriteFile(path, []byte("#hashed\r\n   \n\nplain\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := util.ReadKeysFile(path, util.KeysFileRaw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"#hashed", "   ", "plain"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected %q, got %q", expected, keys)
+	}
+
+	if _, err := util.ReadKeysFile(path, "csv"); err == nil {
+		t.Error("expected an unknown format to be rejected")
+	}
+}
+
+func TestMetadataVersion(t *testing.T) {
+	cases := []struct {
+		metadata interface{}
+		version  int64
+		ok       bool
+	}{
+		{map[string]interface{}{"version": float64(8)}, 8, true},
+		{map[string]interface{}{"version": "12"}, 12, true},
+		{map[string]interface{}{"version": 1.5}, 0, false},
+		{map[string]interface{}{"version": "v2"}, 0, false},
+		{map[string]interface{}{"env": "prod"}, 0, false},
+		{nil, 0, false},
+	}
+	for _, c := range cases {
+		version, ok := util.MetadataVersion(c.metadata)
+		if ok != c.ok || (ok && version != c.version) {
+			t.Errorf("MetadataVersion(%v) = %d, %v; want %d, %v", c.metadata, version, ok, c.version, c.ok)
+		}
+	}
+
+	err := fmt.Errorf("key config is not newer than version 8: %w", util.ErrNotModified)
+	if code := util.ExitCode(err); code != util.ExitNotModified {
+		t.Errorf("expected exit status %d for an unmodified value, got %d", util.ExitNotModified, code)
+	}
+	if code := util.ErrorCode(err); code != util.ErrorCodeNotModified {
+		t.Errorf("expected error code %s, got %s", util.ErrorCodeNotModified, code)
+	}
+}
@@ -0,0 +1,45 @@
+package tests
+
+import (
+	"testing"
+
+	"cfpurge/internal/util"
+)
+
+func TestMetadataFilterMatch(t *testing.T) {
+	metadata := map[string]interface{}{
+		"env":   "staging",
+		"count": float64(3),
+		"owner": map[string]interface{}{"team": "web-platform"},
+	}
+
+	cases := map[string]bool{
+		"env=staging":        true,
+		"env=production":     false,
+		"env~=stag":          true,
+		"$.owner.team~=web":  true,
+		"owner.team=web":     false,
+		"count=3":            true,
+		"owner":              true,
+		"expires":            false,
+		"owner.team.missing": false,
+	}
+
+	for expr, want := range cases {
+		filter, err := util.ParseMetadataFilter(expr)
+		if err != nil {
+			t.Fatalf("ParseMetadataFilter(%q) returned error: %v", expr, err)
+		}
+		if got := filter.Match(metadata); got != want {
+			t.Errorf("%q.Match = %v; want %v", expr, got, want)
+		}
+	}
+}
+
+func TestParseMetadataFilterRejectsInvalid(t *testing.T) {
+	for _, expr := range []string{"", "=value", "~=value", "a..b=c", "$."} {
+		if _, err := util.ParseMetadataFilter(expr); err == nil {
+			t.Errorf("ParseMetadataFilter(%q) expected error", expr)
+		}
+	}
+}
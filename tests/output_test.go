@@ -0,0 +1,194 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"cfpurge/internal/util"
+)
+
+func TestWriteOutputFormats(t *testing.T) {
+	summary := util.ResultSummary{Successful: 3, Failed: 1}
+
+	cases := map[string]string{
+		util.OutputJSON: "{\n  \"successful\": 3,\n  \"failed\": 1\n}\n",
+		util.OutputYAML: "successful: 3\nfailed: 1\n",
+	}
+
+	for format, want := range cases {
+		var buf bytes.Buffer
+		if err := util.WriteOutput(&buf, format, summary); err != nil {
+			t.Fatalf("WriteOutput(%s) returned error: %v", format, err)
+		}
+		if buf.String() != want {
+			t.Errorf("WriteOutput(%s) = %q; want %q", format, buf.String(), want)
+		}
+	}
+}
+
+func TestValidateOutputFormat(t *testing.T) {
+	for _, format := range []string{util.OutputTable, util.OutputJSON, util.OutputYAML} {
+		if err := util.ValidateOutputFormat(format); err != nil {
+			t.Errorf("ValidateOutputFormat(%q) returned error: %v", format, err)
+		}
+	}
+
+	if err := util.ValidateOutputFormat("csv"); err == nil {
+		t.Error("ValidateOutputFormat(\"csv\") expected error")
+	}
+
+	if err := util.WriteOutput(&bytes.Buffer{}, util.OutputTable, nil); err == nil {
+		t.Error("WriteOutput with table format expected error")
+	}
+}
+
+func TestSetOutputRedirectsMessages(t *testing.T) {
+	var buf bytes.Buffer
+	util.SetOutput(&buf)
+	defer util.SetOutput(os.Stdout)
+
+	util.Info("hello %s", "world")
+	util.Printf("plain\n")
+
+	if got := buf.String(); got != "ℹ️ hello world\nplain\n" {
+		t.Errorf("unexpected redirected output %q", got)
+	}
+}
+
+func TestPlainPrefixesReplaceEmoji(t *testing.T) {
+	var buf bytes.Buffer
+	util.SetOutput(&buf)
+	util.SetPrefixes(util.PlainPrefixes)
+	defer util.SetOutput(os.Stdout)
+	defer util.SetPrefixes(util.EmojiPrefixes)
+
+	util.Success("done")
+	util.Error("failed")
+	util.Warning("careful")
+	util.Info("note")
+	util.PrettyPrintResults(1, 0)
+
+	want := "[OK] done\n[ERR] failed\n[WARN] careful\n[INFO] note\n\nSummary: 1 successful, 0 failed\n[OK] All operations completed successfully\n"
+	if got := buf.String(); got != want {
+		t.Errorf("unexpected plain output %q", got)
+	}
+}
+
+func TestTableAlignsColumnsToContent(t *testing.T) {
+	table := util.NewTable("Title", "ID")
+	table.AddRow("a-rather-long-namespace-title-that-exceeds-forty-characters", "1")
+	table.AddRow("short", "0123456789abcdef")
+
+	var buf bytes.Buffer
+	table.Render(&buf)
+
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected header, separator and two rows, got %q", buf.String())
+	}
+	column := strings.Index(lines[0], "ID")
+	for _, line := range lines[2:] {
+		if idx := strings.LastIndex(line, " ") + 1; idx != column {
+			t.Errorf("expected the ID column at %d, got %d in %q", column, idx, line)
+		}
+	}
+	if len(lines[1]) != len(lines[3]) {
+		t.Errorf("expected the separator to span the table, got %q", lines[1])
+	}
+}
+
+func TestTableTruncatesToMaxWidthAndTotalWidth(t *testing.T) {
+	table := util.NewTable("Key", "Value")
+	table.MaxWidths = []int{0, 10}
+	table.AddRow("key", "a value that is much longer than ten characters")
+	table.AddRow("multi", "line\nvalue")
+
+	var buf bytes.Buffer
+	table.Render(&buf)
+	if !strings.Contains(buf.String(), "key    a value t…\n") {
+		t.Errorf("expected the value to be truncated to 10 characters, got %q", buf.String())
+	}
+	if !strings.Contains(buf.String(), "multi  line value\n") {
+		t.Errorf("expected multi-line cells on one line, got %q", buf.String())
+	}
+
+	wide := util.NewTable("Name", "Description")
+	wide.Width = 30
+	wide.AddRow(strings.Repeat("n", 20), strings.Repeat("d", 40))
+	buf.Reset()
+	wide.Render(&buf)
+	for _, line := range strings.Split(strings.TrimRight(buf.String(), "\n"), "\n") {
+		if n := utf8.RuneCountInString(line); n > 30 {
+			t.Errorf("line is %d characters, wider than 30: %q", n, line)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	cases := []struct {
+		in    string
+		width int
+		want  string
+	}{
+		{"short", 10, "short"},
+		{"exactly", 7, "exactly"},
+		{"truncated", 6, "trunc…"},
+		{"héllo wörld", 5, "héll…"},
+		{"ab", 1, "a"},
+	}
+	for _, c := range cases {
+		if got := util.Truncate(c.in, c.width); got != c.want {
+			t.Errorf("Truncate(%q, %d) = %q, want %q", c.in, c.width, got, c.want)
+		}
+	}
+}
+
+func TestSummaryLevelKeepsProblemsAndSummary(t *testing.T) {
+	var buf bytes.Buffer
+	util.SetOutput(&buf)
+	util.SetPrefixes(util.PlainPrefixes)
+	util.SetLevel(util.LevelSummary)
+	defer util.SetOutput(os.Stdout)
+	defer util.SetPrefixes(util.EmojiPrefixes)
+	defer util.SetLevel(util.LevelNormal)
+
+	util.Success("done")
+	util.Info("note")
+	util.Warning("careful")
+	util.Error("failed")
+	util.PrettyPrintResults(1, 1)
+
+	want := "[WARN] careful\n[ERR] failed\n\nSummary: 1 successful, 1 failed\n[ERR] Some operations failed\n"
+	if got := buf.String(); got != want {
+		t.Errorf("unexpected summary-only output %q", got)
+	}
+}
+
+func TestRenderHTMLReport(t *testing.T) {
+	results := util.NewResults()
+	results.Record("example.com", "2 URLs", nil)
+	results.Record("<script>alert(1)</script>", "everything", errors.New("forbidden"))
+
+	started := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	report := util.NewReport("Cache purge", started, results)
+
+	var buf bytes.Buffer
+	if err := util.RenderHTMLReport(&buf, report); err != nil {
+		t.Fatal(err)
+	}
+	html := buf.String()
+
+	for _, want := range []string{"<title>Cache purge</title>", "2024-05-01T12:00:00Z", "example.com", "Failed: forbidden", "&lt;script&gt;"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("expected the report to contain %q", want)
+		}
+	}
+	if strings.Contains(html, "<script>") {
+		t.Error("expected item text to be escaped")
+	}
+}
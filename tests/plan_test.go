@@ -0,0 +1,62 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cfpurge/internal/util"
+)
+
+func TestPlanRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plan.json")
+
+	plan := util.NewPlan("kv delete")
+	plan.Namespaces = []util.PlanNamespace{{ID: "ns1", Keys: []string{"a", "b"}}}
+
+	if err := util.WritePlan(path, plan); err != nil {
+		t.Fatalf("WritePlan: %v", err)
+	}
+
+	loaded, err := util.ReadPlan(path, "kv delete")
+	if err != nil {
+		t.Fatalf("ReadPlan: %v", err)
+	}
+	if len(loaded.Namespaces) != 1 || len(loaded.Namespaces[0].Keys) != 2 {
+		t.Fatalf("unexpected plan contents: %+v", loaded)
+	}
+
+	if _, err := util.ReadPlan(path, "purge"); err == nil {
+		t.Fatal("expected error reading plan for a different command")
+	}
+}
+
+func TestPlanRejectsUnknownFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plan.json")
+	data := `{"command": "purge", "zones": [{"id": "z1", "everything": true, "extra": 1}]}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := util.ReadPlan(path, "purge"); err == nil {
+		t.Fatal("expected error for unknown field")
+	}
+}
+
+func TestPlanAddFailedKey(t *testing.T) {
+	plan := util.NewPlan("kv delete")
+	plan.AddFailedKey("ns1", "a", "rate limited")
+	plan.AddFailedKey("ns2", "b", "not found")
+	plan.AddFailedKey("ns1", "c", "timeout")
+
+	if len(plan.Namespaces) != 2 {
+		t.Fatalf("expected 2 namespaces, got %+v", plan.Namespaces)
+	}
+	ns := plan.Namespaces[0]
+	if ns.ID != "ns1" || len(ns.Keys) != 2 || ns.Errors["c"] != "timeout" {
+		t.Errorf("unexpected failures for ns1: %+v", ns)
+	}
+	if err := plan.Validate("kv delete"); err != nil {
+		t.Errorf("failures plan should be valid: %v", err)
+	}
+}
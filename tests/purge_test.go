@@ -0,0 +1,817 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cfpurge/internal/api"
+	"cfpurge/internal/util"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+var testZones = []cloudflare.Zone{
+	{ID: "zone-a", Name: "example.com"},
+	{ID: "zone-b", Name: "shop.example.com"},
+	{ID: "zone-c", Name: "example.org"},
+}
+
+func TestPlanPurgeForZonesGroupsURLsByZone(t *testing.T) {
+	plan, err := api.PlanPurgeForZones(testZones, api.PurgeOptions{
+		URLs: []string{
+			"https://www.example.com/a",
+			"https://cdn.shop.example.com/b",
+			"https://example.net/unmatched",
+		},
+	})
+	if err != nil {
+		t.Fatalf("PlanPurgeForZones returned error: %v", err)
+	}
+
+	if len(plan.Zones) != 2 {
+		t.Fatalf("expected 2 zones, got %d", len(plan.Zones))
+	}
+	if plan.Zones[0].ID != "zone-a" || len(plan.Zones[0].URLs) != 1 {
+		t.Errorf("unexpected first zone: %+v", plan.Zones[0])
+	}
+	if plan.Zones[1].ID != "zone-b" || plan.Zones[1].URLs[0] != "https://cdn.shop.example.com/b" {
+		t.Errorf("unexpected second zone: %+v", plan.Zones[1])
+	}
+}
+
+func TestPlanPurgeForZonesStrict(t *testing.T) {
+	opts := api.PurgeOptions{Zones: []string{"example.com"}, Tags: []string{"ok", "bad tag"}}
+
+	plan, err := api.PlanPurgeForZones(testZones, opts)
+	if err != nil {
+		t.Fatalf("expected invalid tag to be skipped, got %v", err)
+	}
+	if tags := plan.Zones[0].Tags; len(tags) != 1 || tags[0] != "ok" {
+		t.Errorf("expected only the valid tag, got %v", tags)
+	}
+
+	opts.Strict = true
+	if _, err := api.PlanPurgeForZones(testZones, opts); err == nil {
+		t.Error("expected strict mode to reject the invalid tag")
+	}
+}
+
+func TestPlanPurgeForZonesNormalizesHosts(t *testing.T) {
+	var warnings []string
+	plan, err := api.PlanPurgeForZones(testZones, api.PurgeOptions{
+		Hosts: []string{"https://API.example.com/", "cdn.shop.example.com", "*.example.com"},
+		Warnf: func(format string, args ...interface{}) { warnings = append(warnings, fmt.Sprintf(format, args...)) },
+	})
+	if err != nil {
+		t.Fatalf("PlanPurgeForZones returned error: %v", err)
+	}
+
+	if len(plan.Zones) != 2 || plan.Zones[0].Hosts[0] != "api.example.com" || plan.Zones[1].Hosts[0] != "cdn.shop.example.com" {
+		t.Errorf("unexpected plan: %+v", plan.Zones)
+	}
+	if len(warnings) != 2 {
+		t.Errorf("expected a rewrite and a rejection warning, got %v", warnings)
+	}
+}
+
+func TestPlanPurgeForZonesAccountAll(t *testing.T) {
+	zones := []cloudflare.Zone{
+		{ID: "zone-a", Name: "example.com", Account: cloudflare.Account{ID: "acct-1", Name: "Team A"}},
+		{ID: "zone-b", Name: "example.org", Account: cloudflare.Account{ID: "acct-2", Name: "Team B"}},
+		{ID: "zone-c", Name: "example.net", Account: cloudflare.Account{ID: "acct-1", Name: "Team A"}},
+	}
+
+	plan, err := api.PlanPurgeForZones(zones, api.PurgeOptions{All: true, Everything: true, AccountID: "acct-1"})
+	if err != nil {
+		t.Fatalf("PlanPurgeForZones returned error: %v", err)
+	}
+	if len(plan.Zones) != 2 || plan.Zones[0].ID != "zone-a" || plan.Zones[1].ID != "zone-c" {
+		t.Errorf("expected only the zones of acct-1, got %+v", plan.Zones)
+	}
+	if plan.Account != "Team A (acct-1)" {
+		t.Errorf("expected the plan to name the account, got %q", plan.Account)
+	}
+
+	// Hosts in another account's zones must not be reached either
+	if _, err := api.PlanPurgeForZones(zones, api.PurgeOptions{Hosts: []string{"example.org"}, AccountID: "acct-1"}); err == nil {
+		t.Error("expected an error for a host only found in another account")
+	}
+
+	if _, err := api.PlanPurgeForZones(zones, api.PurgeOptions{All: true, AccountID: "acct-3"}); err == nil {
+		t.Error("expected an error for an account with no visible zones")
+	}
+}
+
+func TestPlanPurgeForZonesZonePatterns(t *testing.T) {
+	zones := []cloudflare.Zone{
+		{ID: "zone-a", Name: "staging.example.com", Account: cloudflare.Account{ID: "acct-1"}},
+		{ID: "zone-b", Name: "example.com", Account: cloudflare.Account{ID: "acct-1"}},
+		{ID: "zone-c", Name: "Staging.Example.org", Account: cloudflare.Account{ID: "acct-1"}},
+		{ID: "zone-d", Name: "staging.legacy.net", Account: cloudflare.Account{ID: "acct-1"}},
+		{ID: "zone-e", Name: "staging.example.net", Account: cloudflare.Account{ID: "acct-2"}},
+	}
+
+	plan, err := api.PlanPurgeForZones(zones, api.PurgeOptions{
+		All:          true,
+		Everything:   true,
+		AccountID:    "acct-1",
+		IncludeZones: []string{"staging.*"},
+		ExcludeZones: []string{"*.legacy.net"},
+	})
+	if err != nil {
+		t.Fatalf("PlanPurgeForZones returned error: %v", err)
+	}
+	var ids []string
+	for _, zone := range plan.Zones {
+		ids = append(ids, zone.ID)
+	}
+	if strings.Join(ids, ",") != "zone-a,zone-c" {
+		t.Errorf("expected the matching zones of acct-1, got %v", ids)
+	}
+
+	// An account with no matching zones plans nothing rather than failing
+	plan, err = api.PlanPurgeForZones(zones, api.PurgeOptions{All: true, Everything: true, AccountID: "acct-2", IncludeZones: []string{"*.com"}})
+	if err != nil || len(plan.Zones) != 0 {
+		t.Errorf("expected an empty plan, got %+v, %v", plan, err)
+	}
+
+	if _, err := api.FilterZonesByPattern(zones, []string{"staging.\\"}, nil); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
+
+func TestStructuredPurgeOutputKeepsStdoutParseable(t *testing.T) {
+	stdout, stderr := os.Stdout, os.Stderr
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages, err := os.CreateTemp(t.TempDir(), "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout, os.Stderr = w, messages
+	util.SetOutput(os.Stdout)
+	t.Cleanup(func() {
+		os.Stdout, os.Stderr = stdout, stderr
+		util.SetOutput(stdout)
+	})
+
+	util.SetOutputFor(util.OutputJSON)
+	plan, err := api.PlanPurgeForZones(testZones, api.PurgeOptions{All: true, Everything: true, Infof: util.Info, Warnf: util.Warning})
+	if err != nil {
+		t.Fatalf("PlanPurgeForZones returned error: %v", err)
+	}
+	util.Warning("Skipping nothing")
+	if err := util.WriteOutput(os.Stdout, util.OutputJSON, plan); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded util.Plan
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("stdout is not a single JSON document: %v\n%s", err, data)
+	}
+	if len(decoded.Zones) != len(testZones) {
+		t.Errorf("expected %d zones on stdout, got %+v", len(testZones), decoded)
+	}
+
+	logged, err := os.ReadFile(messages.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(logged), "Applying to all 3 zones") || !strings.Contains(string(logged), "Skipping nothing") {
+		t.Errorf("expected progress and warnings on stderr, got %q", logged)
+	}
+}
+
+func TestPlanPurgeForZonesWildcardURLs(t *testing.T) {
+	zones := []cloudflare.Zone{
+		{ID: "ent", Name: "example.com", Plan: cloudflare.ZonePlan{LegacyID: "enterprise"}},
+		{ID: "pro", Name: "example.org", Plan: cloudflare.ZonePlan{ZonePlanCommon: cloudflare.ZonePlanCommon{Name: "Pro"}, LegacyID: "pro"}},
+	}
+
+	plan, err := api.PlanPurgeForZones(zones, api.PurgeOptions{
+		URLs: []string{"https://www.example.com/images/*", "https://example.com/a", "https://example.org/css/*"},
+	})
+	if err != nil {
+		t.Fatalf("PlanPurgeForZones returned error: %v", err)
+	}
+	if len(plan.Zones) != 1 {
+		t.Fatalf("expected only the Enterprise zone, got %+v", plan.Zones)
+	}
+	zone := plan.Zones[0]
+	if len(zone.Prefixes) != 1 || zone.Prefixes[0] != "www.example.com/images" || len(zone.URLs) != 1 {
+		t.Errorf("unexpected zone: %+v", zone)
+	}
+
+	requests := api.PurgeRequests(zone, api.PurgeBatchSize)
+	if len(requests) != 2 || len(requests[1].Prefixes) != 1 {
+		t.Errorf("expected a files and a prefixes request, got %+v", requests)
+	}
+
+	_, err = api.PlanPurgeForZones(zones, api.PurgeOptions{URLs: []string{"https://example.org/css/*"}, Strict: true})
+	if err == nil {
+		t.Error("expected a strict plan to reject a wildcard on a non-Enterprise zone")
+	}
+}
+
+func TestWildcardPrefix(t *testing.T) {
+	prefix, ok, err := util.WildcardPrefix("https://Example.com/images/*")
+	if err != nil || !ok || prefix != "example.com/images" {
+		t.Errorf("WildcardPrefix = %q, %v, %v; want example.com/images", prefix, ok, err)
+	}
+
+	if _, ok, err := util.WildcardPrefix("https://example.com/a.png"); ok || err != nil {
+		t.Errorf("WildcardPrefix of a plain URL = %v, %v; want not a wildcard", ok, err)
+	}
+
+	for _, input := range []string{"https://example.com/*.jpg", "https://example.com/a/*/b/*", "https://example.com/*", "https://example.com/a?b=*"} {
+		if _, _, err := util.WildcardPrefix(input); err == nil {
+			t.Errorf("WildcardPrefix(%q) succeeded; want an error", input)
+		}
+	}
+}
+
+func TestPurgeRequestsBatching(t *testing.T) {
+	zone := util.PlanZone{Hosts: []string{"a.example.com"}, URLs: make([]string, 65), Tags: make([]string, 30)}
+
+	requests := api.PurgeRequests(zone, api.PurgeBatchSize)
+	// 1 hosts request, 3 URL batches (30+30+5) and 1 tag batch
+	if len(requests) != 5 {
+		t.Fatalf("expected 5 requests, got %d", len(requests))
+	}
+	if len(requests[3].Files) != 5 {
+		t.Errorf("expected last URL batch of 5, got %d", len(requests[3].Files))
+	}
+}
+
+// newPurgeTestClient returns a client for a fake API that counts purge requests
+// per zone and rejects purges for zone-c
+func newPurgeTestClient(t *testing.T) (*cloudflare.API, map[string]int) {
+	var mu sync.Mutex
+	purged := make(map[string]int)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		zoneID := strings.Split(strings.TrimPrefix(r.URL.Path, "/zones/"), "/")[0]
+		mu.Lock()
+		purged[zoneID]++
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if zoneID == "zone-c" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"success":false,"errors":[{"code":10000,"message":"forbidden"}],"messages":[],"result":null}`)
+			return
+		}
+		fmt.Fprint(w, `{"success":true,"errors":[],"messages":[],"result":{"id":"purge"}}`)
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := cloudflare.NewWithAPIToken("test-token", cloudflare.BaseURL(server.URL))
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+
+	return client, purged
+}
+
+func TestExecutePurgePlan(t *testing.T) {
+	client, purged := newPurgeTestClient(t)
+
+	urls := make([]string, 45)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("https://example.com/%d", i)
+	}
+
+	plan := util.NewPlan("purge")
+	plan.Zones = []util.PlanZone{
+		{ID: "zone-a", Name: "example.com", URLs: urls},
+		{ID: "zone-c", Name: "example.org", Everything: true},
+	}
+
+	results := api.ExecutePurgePlan(context.Background(), client, plan, api.PurgeOptions{Concurrency: 2})
+
+	if summary := results.Summary(); summary.Successful != 1 || summary.Failed != 1 {
+		t.Fatalf("expected 1 success and 1 failure, got %+v", summary)
+	}
+	if purged["zone-a"] != 2 {
+		t.Errorf("expected 2 batched requests for zone-a, got %d", purged["zone-a"])
+	}
+	if results.Zones[1].Err == nil {
+		t.Error("expected zone-c to report its error")
+	}
+}
+
+func TestExecutePurgePlanZoneConcurrency(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			max := maxInFlight.Load()
+			if n <= max || maxInFlight.CompareAndSwap(max, n) {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"success":true,"errors":[],"messages":[],"result":{"id":"purge"}}`)
+	}))
+	defer server.Close()
+
+	client, err := cloudflare.NewWithAPIToken("test-token", cloudflare.BaseURL(server.URL), cloudflare.UsingRateLimit(1000))
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+
+	plan := util.NewPlan("purge")
+	plan.Zones = []util.PlanZone{
+		{ID: "zone-a", Name: "a.example.com", URLs: []string{"https://a.example.com/1", "https://a.example.com/2"}},
+		{ID: "zone-b", Name: "b.example.com", URLs: []string{"https://b.example.com/1", "https://b.example.com/2"}},
+		{ID: "zone-c", Name: "c.example.com", URLs: []string{"https://c.example.com/1", "https://c.example.com/2"}},
+	}
+
+	results := api.ExecutePurgePlan(context.Background(), client, plan, api.PurgeOptions{BatchSize: 1, Concurrency: 1, ZoneConcurrency: 3})
+
+	if got := maxInFlight.Load(); got != 3 {
+		t.Errorf("expected one request per zone in flight at once, got at most %d", got)
+	}
+	if len(results.Zones) != 3 {
+		t.Fatalf("expected 3 zone results, got %d", len(results.Zones))
+	}
+	for i, result := range results.Zones {
+		if result.Zone.ID != plan.Zones[i].ID || result.Err != nil {
+			t.Errorf("result %d: expected %s to succeed in plan order, got %s: %v", i, plan.Zones[i].ID, result.Zone.ID, result.Err)
+		}
+	}
+}
+
+func TestExecutePurgePlanStopsWhenCancelled(t *testing.T) {
+	client, purged := newPurgeTestClient(t)
+
+	plan := util.NewPlan("purge")
+	plan.Zones = []util.PlanZone{
+		{ID: "zone-a", Name: "example.com", Everything: true},
+		{ID: "zone-b", Name: "shop.example.com", Everything: true},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results := api.ExecutePurgePlan(ctx, client, plan, api.PurgeOptions{
+		OnZoneDone: func(api.ZoneResult) { cancel() },
+	})
+
+	if len(results.Zones) != 1 || purged["zone-b"] != 0 {
+		t.Errorf("expected only the first zone to be attempted, got %d results and %v", len(results.Zones), purged)
+	}
+	if summary := results.Summary(); summary.Successful != 1 || summary.Failed != 0 {
+		t.Errorf("expected the completed zone in the summary, got %+v", summary)
+	}
+}
+
+func TestExecutePurgePlanDeduplicatesOverlappingSelectors(t *testing.T) {
+	client, purged := newPurgeTestClient(t)
+
+	// The same zone selected by name and by ID, plus repeated tags and URLs
+	plan, err := api.PlanPurgeForZones(testZones, api.PurgeOptions{
+		Zones: []string{"example.com", "zone-a"},
+		Tags:  []string{"product-1", "product-1"},
+	})
+	if err != nil {
+		t.Fatalf("PlanPurgeForZones returned error: %v", err)
+	}
+	plan.Zones = append(plan.Zones, util.PlanZone{ID: "zone-b", Name: "shop.example.com", URLs: []string{"https://shop.example.com/a", "https://shop.example.com/a"}})
+
+	results := api.ExecutePurgePlan(context.Background(), client, plan, api.PurgeOptions{})
+
+	// zone-a once with one tag, zone-b once with one URL. The duplicate tag is
+	// dropped once in the first zone-a entry and twice in the second.
+	if summary := results.Summary(); summary.Successful != 2 || summary.Failed != 0 {
+		t.Fatalf("expected 2 successful zones, got %+v", summary)
+	}
+	if results.Deduplicated != 4 {
+		t.Errorf("expected 4 deduplicated targets, got %d", results.Deduplicated)
+	}
+	if purged["zone-a"] != 1 || purged["zone-b"] != 1 {
+		t.Errorf("expected one request per zone, got %v", purged)
+	}
+	if tags := results.Zones[0].Zone.Tags; len(tags) != 1 {
+		t.Errorf("expected duplicate tag to be dropped, got %v", tags)
+	}
+}
+
+func TestExecutePurgePlanEverythingCoversLaterTargets(t *testing.T) {
+	client, purged := newPurgeTestClient(t)
+
+	plan := util.NewPlan("purge")
+	plan.Zones = []util.PlanZone{
+		{ID: "zone-a", Name: "example.com", Everything: true},
+		{ID: "zone-a", Name: "example.com", URLs: []string{"https://example.com/a"}},
+		{ID: "zone-a", Name: "example.com", Everything: true},
+	}
+
+	results := api.ExecutePurgePlan(context.Background(), client, plan, api.PurgeOptions{})
+
+	if results.Summary().Successful != 1 || results.Deduplicated != 2 || purged["zone-a"] != 1 {
+		t.Errorf("expected a single purge everything, got %+v with %d requests", results.Summary(), purged["zone-a"])
+	}
+}
+
+func TestFailuresPlanKeepsOnlyFailedTargets(t *testing.T) {
+	client, _ := newPurgeTestClient(t)
+
+	plan := util.NewPlan("purge")
+	plan.Zones = []util.PlanZone{
+		{ID: "zone-a", Name: "example.com", URLs: []string{"https://example.com/a"}},
+		{ID: "zone-c", Name: "example.org", URLs: []string{"https://example.org/a", "https://example.org/b"}, Tags: []string{"t1"}},
+	}
+
+	failures := api.FailuresPlan(api.ExecutePurgePlan(context.Background(), client, plan, api.PurgeOptions{}))
+
+	if len(failures.Zones) != 1 || failures.Zones[0].ID != "zone-c" {
+		t.Fatalf("expected only zone-c in the failures plan, got %+v", failures.Zones)
+	}
+	failed := failures.Zones[0]
+	if len(failed.URLs) != 2 || len(failed.Tags) != 1 || failed.Error == "" {
+		t.Errorf("expected both URLs, the tag and an error for zone-c, got %+v", failed)
+	}
+	if err := failures.Validate("purge"); err != nil {
+		t.Errorf("failures plan should be a valid purge plan: %v", err)
+	}
+}
+
+func TestFailuresPlanResumesAfterFailFast(t *testing.T) {
+	client, _ := newPurgeTestClient(t)
+
+	plan := util.NewPlan("purge")
+	plan.Zones = []util.PlanZone{
+		{ID: "zone-c", Name: "example.org", URLs: []string{"https://example.org/a"}},
+		{ID: "zone-a", Name: "example.com", URLs: []string{"https://example.com/a"}},
+		{ID: "zone-b", Name: "shop.example.com", URLs: []string{"https://shop.example.com/a"}},
+	}
+
+	results := api.ExecutePurgePlan(context.Background(), client, plan, api.PurgeOptions{FailFast: true})
+	if len(results.Zones) != 1 || len(results.NotAttempted) != 2 {
+		t.Fatalf("expected one attempted zone and two not attempted, got %d and %d", len(results.Zones), len(results.NotAttempted))
+	}
+
+	failures := api.FailuresPlan(results)
+	if len(failures.Zones) != 3 || failures.Zones[2].ID != "zone-b" || failures.Zones[2].Error != "not attempted" {
+		t.Errorf("expected the failed zone followed by the two skipped zones, got %+v", failures.Zones)
+	}
+
+	names := api.UnpurgedZones(results)
+	if strings.Join(names, ",") != "example.org,example.com,shop.example.com" {
+		t.Errorf("UnpurgedZones = %v", names)
+	}
+}
+
+func TestFailFastStopsSendingBatches(t *testing.T) {
+	client, purged := newPurgeTestClient(t)
+
+	urls := []string{"https://example.org/1", "https://example.org/2", "https://example.org/3", "https://example.org/4"}
+	plan := util.NewPlan("purge")
+	plan.Zones = []util.PlanZone{{ID: "zone-c", Name: "example.org", URLs: urls}}
+
+	results := api.ExecutePurgePlan(context.Background(), client, plan, api.PurgeOptions{BatchSize: 1, Concurrency: 1, FailFast: true})
+
+	if purged["zone-c"] != 1 {
+		t.Errorf("expected no requests after the first failure, got %d", purged["zone-c"])
+	}
+	if len(results.Zones) != 1 || !reflect.DeepEqual(results.Zones[0].Failed.URLs, urls) {
+		t.Fatalf("expected every URL to be reported as failed, got %+v", results.Zones)
+	}
+}
+
+func TestFailFastCancelsConcurrentZones(t *testing.T) {
+	var slowRequests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasPrefix(r.URL.Path, "/zones/zone-c/") {
+			time.Sleep(30 * time.Millisecond)
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"success":false,"errors":[{"code":10000,"message":"forbidden"}],"messages":[],"result":null}`)
+			return
+		}
+		slowRequests.Add(1)
+		time.Sleep(20 * time.Millisecond)
+		fmt.Fprint(w, `{"success":true,"errors":[],"messages":[],"result":{"id":"purge"}}`)
+	}))
+	defer server.Close()
+
+	client, err := cloudflare.NewWithAPIToken("test-token", cloudflare.BaseURL(server.URL), cloudflare.UsingRateLimit(1000))
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+
+	var urls []string
+	for i := 0; i < 20; i++ {
+		urls = append(urls, fmt.Sprintf("https://example.com/%d", i))
+	}
+	plan := util.NewPlan("purge")
+	plan.Zones = []util.PlanZone{
+		{ID: "zone-a", Name: "example.com", URLs: urls},
+		{ID: "zone-c", Name: "example.org", URLs: []string{"https://example.org/a"}},
+	}
+
+	results := api.ExecutePurgePlan(context.Background(), client, plan, api.PurgeOptions{BatchSize: 1, Concurrency: 1, ZoneConcurrency: 2, FailFast: true})
+
+	if n := slowRequests.Load(); n >= int32(len(urls)) {
+		t.Errorf("expected the running zone to stop sending after the other zone failed, got all %d requests", n)
+	}
+	if len(results.Zones) != 2 || results.Zones[0].Err == nil || len(results.Zones[0].Failed.URLs) == 0 {
+		t.Fatalf("expected the cancelled zone to report its unsent URLs as failed, got %+v", results.Zones)
+	}
+}
+
+func TestClearCacheReserves(t *testing.T) {
+	var mu sync.Mutex
+	cleared := make(map[string]bool)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		zoneID := strings.Split(strings.TrimPrefix(r.URL.Path, "/zones/"), "/")[0]
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/cache/cache_reserve_clear") {
+			mu.Lock()
+			cleared[zoneID] = true
+			mu.Unlock()
+			fmt.Fprint(w, `{"success":true,"errors":[],"messages":[],"result":{"state":"In-progress"}}`)
+			return
+		}
+
+		switch zoneID {
+		case "zone-a":
+			fmt.Fprint(w, `{"success":true,"errors":[],"messages":[],"result":{"id":"cache_reserve","value":"off"}}`)
+		case "zone-b":
+			fmt.Fprint(w, `{"success":true,"errors":[],"messages":[],"result":{"id":"cache_reserve","value":"on"}}`)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"success":false,"errors":[{"code":1142,"message":"not entitled"}],"messages":[],"result":null}`)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := cloudflare.NewWithAPIToken("test-token", cloudflare.BaseURL(server.URL))
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+
+	results := api.Results{Zones: []api.ZoneResult{
+		{Zone: util.PlanZone{ID: "zone-a", Name: "example.com"}},
+		{Zone: util.PlanZone{ID: "zone-b", Name: "shop.example.com"}},
+		{Zone: util.PlanZone{ID: "zone-c", Name: "example.org"}},
+		{Zone: util.PlanZone{ID: "zone-d", Name: "example.net"}, Err: fmt.Errorf("purge failed")},
+	}}
+
+	got := api.ClearCacheReserves(context.Background(), client, results)
+	if len(got) != 3 {
+		t.Fatalf("expected results for the 3 purged zones, got %+v", got)
+	}
+
+	want := []string{api.CacheReserveCleared, api.CacheReserveEnabled, api.CacheReserveUnsupported}
+	for i, status := range want {
+		if got[i].Status != status {
+			t.Errorf("%s: expected %s, got %s (%s)", got[i].Zone, status, got[i].Status, got[i].Detail)
+		}
+	}
+	if !cleared["zone-a"] || cleared["zone-b"] || cleared["zone-c"] {
+		t.Errorf("expected only zone-a to be cleared, got %v", cleared)
+	}
+}
+
+func TestStaleCachedURLs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/logs/received") {
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			t.Errorf("expected token auth, got %q", r.Header.Get("Authorization"))
+		}
+		fmt.Fprintln(w, `{"ClientRequestScheme":"https","ClientRequestHost":"example.com","ClientRequestURI":"/old.css","CacheCacheStatus":"hit"}`)
+		fmt.Fprintln(w, `{"ClientRequestScheme":"https","ClientRequestHost":"example.com","ClientRequestURI":"/fresh.css","CacheCacheStatus":"hit"}`)
+		fmt.Fprintln(w, `{"ClientRequestScheme":"https","ClientRequestHost":"example.com","ClientRequestURI":"/fresh.css","CacheCacheStatus":"expired"}`)
+		fmt.Fprintln(w, `{"ClientRequestScheme":"https","ClientRequestHost":"example.com","ClientRequestURI":"/api","CacheCacheStatus":"dynamic"}`)
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := cloudflare.NewWithAPIToken("test-token", cloudflare.BaseURL(server.URL))
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+
+	now := time.Now()
+	got, err := api.StaleCachedURLs(context.Background(), client, "zone-a", now.Add(-2*time.Hour), now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 1 || got[0] != "https://example.com/old.css" {
+		t.Errorf("expected only the never refreshed URL, got %v", got)
+	}
+
+	if _, err := api.StaleCachedURLs(context.Background(), client, "zone-a", now.Add(-8*24*time.Hour), now); err == nil {
+		t.Error("expected an error for a cutoff beyond log retention")
+	}
+}
+
+func TestStaleCachedURLsWithoutLogpull(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"success":false,"errors":[{"code":10000,"message":"Authentication error"}],"messages":[],"result":null}`)
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := cloudflare.NewWithAPIToken("test-token", cloudflare.BaseURL(server.URL))
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+
+	now := time.Now()
+	_, err = api.StaleCachedURLs(context.Background(), client, "zone-a", now.Add(-time.Hour), now)
+	if err == nil || !strings.Contains(err.Error(), "not available") {
+		t.Errorf("expected a Logpull availability error, got %v", err)
+	}
+}
+
+func TestSelectTagPurgeZones(t *testing.T) {
+	zones, missing := api.SelectZones(testZones, []string{"example.com", "zone-a", "missing.com"})
+	if len(zones) != 1 || zones[0].ID != "zone-a" {
+		t.Errorf("SelectZones = %v; want only zone-a once", zones)
+	}
+	if len(missing) != 1 || missing[0] != "missing.com" {
+		t.Errorf("missing = %v; want [missing.com]", missing)
+	}
+
+	planned := []cloudflare.Zone{
+		{ID: "ent", Name: "ent.com", Plan: cloudflare.ZonePlan{LegacyID: "enterprise"}},
+		{ID: "pro", Name: "pro.com", Plan: cloudflare.ZonePlan{LegacyID: "pro"}},
+		{ID: "unknown", Name: "unknown.com"},
+	}
+	supported, unsupported := api.FilterTagPurgeZones(planned)
+	if len(supported) != 2 || supported[0].ID != "ent" || supported[1].ID != "unknown" {
+		t.Errorf("supported = %v; want ent and unknown", supported)
+	}
+	if len(unsupported) != 1 || unsupported[0].ID != "pro" {
+		t.Errorf("unsupported = %v; want pro", unsupported)
+	}
+}
+
+func TestPlanPurgeReusesCachedZones(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	var listed int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		listed++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"success":true,"errors":[],"messages":[],"result":[{"id":"zone-a","name":"example.com"}],"result_info":{"page":1,"per_page":50,"total_pages":1,"count":1,"total_count":1}}`)
+	}))
+	defer server.Close()
+
+	client, err := cloudflare.NewWithAPIToken("test-token", cloudflare.BaseURL(server.URL))
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+
+	opts := api.PurgeOptions{Zones: []string{"example.com"}, Everything: true, CacheZones: true}
+	for i := 0; i < 2; i++ {
+		if _, err := api.PlanPurge(context.Background(), client, opts); err != nil {
+			t.Fatalf("PlanPurge returned error: %v", err)
+		}
+	}
+	if listed != 1 {
+		t.Errorf("expected the second purge to use the cached zones, listed %d times", listed)
+	}
+
+	// A zone missing from the cache means it may be stale
+	opts.Zones = []string{"new.example.net"}
+	api.PlanPurge(context.Background(), client, opts)
+	if listed != 2 {
+		t.Errorf("expected a zone missing from the cache to trigger a re-list, listed %d times", listed)
+	}
+
+	opts.Zones, opts.RefreshZones = []string{"example.com"}, true
+	api.PlanPurge(context.Background(), client, opts)
+	if listed != 3 {
+		t.Errorf("expected --refresh-zones to re-list, listed %d times", listed)
+	}
+
+	// Other credentials may see other zones, so they never share a listing
+	other, err := cloudflare.NewWithAPIToken("other-token", cloudflare.BaseURL(server.URL))
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	opts.RefreshZones = false
+	api.PlanPurge(context.Background(), other, opts)
+	if listed != 4 {
+		t.Errorf("expected another token to list its own zones, listed %d times", listed)
+	}
+	api.PlanPurge(context.Background(), client, opts)
+	if listed != 4 {
+		t.Errorf("expected the first token's listing to stay cached, listed %d times", listed)
+	}
+}
+
+func TestZoneAPIRequestsBatchesLikeARealRun(t *testing.T) {
+	zone := util.PlanZone{
+		ID:   "zone-1",
+		Name: "example.com",
+		URLs: []string{"https://example.com/a", "https://example.com/b", "https://example.com/c"},
+		Tags: []string{"footer"},
+	}
+
+	requests, err := api.ZoneAPIRequests("https://api.cloudflare.com/client/v4/", zone, 2)
+	if err != nil {
+		t.Fatalf("ZoneAPIRequests: %v", err)
+	}
+
+	want := []string{
+		`{"files":["https://example.com/a","https://example.com/b"]}`,
+		`{"files":["https://example.com/c"]}`,
+		`{"tags":["footer"]}`,
+	}
+	if len(requests) != len(want) {
+		t.Fatalf("expected %d requests, got %d", len(want), len(requests))
+	}
+	for i, req := range requests {
+		if req.Method != "POST" || req.URL != "https://api.cloudflare.com/client/v4/zones/zone-1/purge_cache" {
+			t.Errorf("request %d: unexpected %s %s", i, req.Method, req.URL)
+		}
+		if string(req.Body) != want[i] {
+			t.Errorf("request %d body = %s, want %s", i, req.Body, want[i])
+		}
+	}
+
+	requests, err = api.ZoneAPIRequests("https://api.cloudflare.com/client/v4", util.PlanZone{ID: "zone-1", Everything: true}, 0)
+	if err != nil || len(requests) != 1 || string(requests[0].Body) != `{"purge_everything":true}` {
+		t.Errorf("unexpected purge everything requests %v, %v", requests, err)
+	}
+}
+
+func TestPurgeByDeviceType(t *testing.T) {
+	if err := api.ValidateDeviceTypes([]string{"mobile", "desktop", "tablet"}); err != nil {
+		t.Errorf("expected the Cloudflare device types to be valid: %v", err)
+	}
+	if err := api.ValidateDeviceTypes([]string{"phone"}); err == nil {
+		t.Error("expected an unknown device type to be rejected")
+	}
+
+	var mu sync.Mutex
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"success":true,"errors":[],"messages":[],"result":{"id":"purge"}}`)
+	}))
+	defer server.Close()
+
+	client, err := cloudflare.NewWithAPIToken("test-token", cloudflare.BaseURL(server.URL), cloudflare.UsingRateLimit(1000))
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+
+	plan := util.NewPlan("purge")
+	plan.Zones = []util.PlanZone{{
+		ID:          "zone-a",
+		Name:        "example.com",
+		URLs:        []string{"https://example.com/a"},
+		Tags:        []string{"footer"},
+		DeviceTypes: []string{"mobile", "tablet"},
+	}}
+
+	results := api.ExecutePurgePlan(context.Background(), client, plan, api.PurgeOptions{})
+	if summary := results.Summary(); summary.Successful != 1 {
+		t.Fatalf("expected the zone to be purged, got %+v", summary)
+	}
+
+	sort.Strings(bodies)
+	want := []string{
+		`{"files":[{"url":"https://example.com/a","headers":{"CF-Device-Type":"mobile"}}]}`,
+		`{"files":[{"url":"https://example.com/a","headers":{"CF-Device-Type":"tablet"}}]}`,
+		`{"tags":["footer"]}`,
+	}
+	if !reflect.DeepEqual(bodies, want) {
+		t.Errorf("expected one URL request per device type and untouched tags, got %q", bodies)
+	}
+}
@@ -0,0 +1,69 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"cfpurge/internal/util"
+)
+
+func TestResultsConcurrentRecord(t *testing.T) {
+	results := util.NewResults()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			if i%4 == 0 {
+				err = errors.New("boom")
+			}
+			results.Record("ns", fmt.Sprintf("key-%d", i), err)
+		}(i)
+	}
+	wg.Wait()
+
+	summary := results.Summary()
+	if summary.Successful != 75 || summary.Failed != 25 {
+		t.Errorf("expected 75 successful and 25 failed, got %+v", summary)
+	}
+	if len(results.Items()) != 100 || len(results.Failures()) != 25 {
+		t.Errorf("expected 100 items and 25 failures, got %d and %d", len(results.Items()), len(results.Failures()))
+	}
+	if results.Err() == nil {
+		t.Error("expected Err to report the failures")
+	}
+}
+
+func TestResultsMergeAndRender(t *testing.T) {
+	first := util.NewResults()
+	first.Record("ns-a", "key-1", nil)
+
+	second := util.NewResults()
+	second.Record("ns-b", "key-2", errors.New("not found"))
+
+	first.Merge(second)
+
+	var buf bytes.Buffer
+	if err := first.Render(&buf, util.OutputJSON); err != nil {
+		t.Fatalf("Render returned error: %v", err)
+	}
+	for _, want := range []string{`"successful": 1`, `"failed": 1`, `"scope": "ns-b"`, `"error": "not found"`} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected rendered JSON to contain %s, got %s", want, buf.String())
+		}
+	}
+
+	buf.Reset()
+	if err := util.NewResults().Render(&buf, util.OutputTable); err != nil {
+		t.Fatalf("Render returned error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "Summary: 0 successful, 0 failed") {
+		t.Errorf("unexpected table output %q", buf.String())
+	}
+}
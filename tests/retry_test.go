@@ -0,0 +1,215 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cfpurge/internal/api"
+	"cfpurge/internal/util"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+func TestWithRetryRateLimitThenSuccess(t *testing.T) {
+	calls := 0
+	err := api.WithRetry(context.Background(), func(ctx context.Context) error {
+		calls++
+		if calls == 1 {
+			return &cloudflare.Error{StatusCode: http.StatusTooManyRequests}
+		}
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("expected success after retry, got %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected 2 calls, got %d", calls)
+	}
+}
+
+func TestWithRetryDoesNotRetryOtherErrors(t *testing.T) {
+	calls := 0
+	wantErr := errors.New("boom")
+	err := api.WithRetry(context.Background(), func(ctx context.Context) error {
+		calls++
+		return wantErr
+	})
+
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("expected %v, got %v", wantErr, err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected 1 call, got %d", calls)
+	}
+}
+
+// newRateLimitedClient returns a client from api.GetClient for a fake API that
+// answers the first limited requests with 429 and the given Retry-After header,
+// and later ones with a zone, along with the number of requests received
+func newRateLimitedClient(t *testing.T, limited int32, retryAfter string, opts ...cloudflare.Option) (*cloudflare.API, *int32) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if atomic.AddInt32(&requests, 1) <= limited {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"success":false,"errors":[{"code":971,"message":"Please wait and consider throttling your request speed"}],"messages":[],"result":null}`)
+			return
+		}
+		fmt.Fprint(w, `{"success":true,"errors":[],"messages":[],"result":{"id":"zone","name":"example.com"}}`)
+	}))
+	t.Cleanup(server.Close)
+
+	api.SetConfig(api.Config{APIToken: "token"})
+	t.Cleanup(func() { api.SetConfig(api.Config{}) })
+
+	client, err := api.GetClient(api.ReadAccess, append([]cloudflare.Option{cloudflare.BaseURL(server.URL), cloudflare.UsingRateLimit(1000)}, opts...)...)
+	if err != nil {
+		t.Fatalf("GetClient returned error: %v", err)
+	}
+	return client, &requests
+}
+
+func TestWithRetryRetriesRateLimitedResponse(t *testing.T) {
+	// Without cloudflare-go's own retries, the 429 reaches WithRetry
+	client, requests := newRateLimitedClient(t, 1, "0", cloudflare.UsingRetryPolicy(0, 0, 0))
+
+	start := time.Now()
+	err := api.WithRetry(context.Background(), func(ctx context.Context) error {
+		_, err := client.ZoneDetails(ctx, "zone")
+		return err
+	})
+
+	if err != nil {
+		t.Fatalf("expected success after retry, got %v", err)
+	}
+	if n := atomic.LoadInt32(requests); n != 2 {
+		t.Fatalf("expected 2 requests, got %d", n)
+	}
+	// Retry-After: 0 should skip the default backoff entirely
+	if elapsed := time.Since(start); elapsed >= 500*time.Millisecond {
+		t.Fatalf("expected Retry-After to be honoured, waited %v", elapsed)
+	}
+}
+
+func TestClientRetriesHonourRetryAfter(t *testing.T) {
+	// cloudflare-go retries the 429 itself without a backoff of its own, so
+	// only the transport holds the retry back for Retry-After
+	client, requests := newRateLimitedClient(t, 1, "1", cloudflare.UsingRetryPolicy(1, 0, 0))
+
+	start := time.Now()
+	if _, err := client.ZoneDetails(context.Background(), "zone"); err != nil {
+		t.Fatalf("expected success after retry, got %v", err)
+	}
+	if n := atomic.LoadInt32(requests); n != 2 {
+		t.Fatalf("expected 2 requests, got %d", n)
+	}
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Errorf("expected the retry to wait for Retry-After: 1, waited %v", elapsed)
+	}
+}
+
+func TestWithRetryGivesUpOnPersistentRateLimiting(t *testing.T) {
+	client, requests := newRateLimitedClient(t, 1000, "0", cloudflare.UsingRetryPolicy(1, 0, 0))
+
+	err := api.WithRetry(context.Background(), func(ctx context.Context) error {
+		_, err := client.ZoneDetails(ctx, "zone")
+		return err
+	})
+
+	if !api.IsRateLimited(err) {
+		t.Fatalf("expected a rate limit error, got %v", err)
+	}
+	// Each of WithRetry's 6 attempts is sent twice by cloudflare-go
+	if n := atomic.LoadInt32(requests); n != 12 {
+		t.Errorf("expected 12 requests, got %d", n)
+	}
+}
+
+func TestRateLimitedResponsesHalveAdaptiveSemaphore(t *testing.T) {
+	client, _ := newRateLimitedClient(t, 1, "0", cloudflare.UsingRetryPolicy(1, 0, 0))
+
+	sem := util.NewAdaptiveSemaphore(0)
+	for i := 0; i < 1+2+3; i++ {
+		sem.Acquire()
+		sem.Release(time.Now(), nil)
+	}
+	if sem.Limit() != 4 {
+		t.Fatalf("expected the limit to grow to 4, got %d", sem.Limit())
+	}
+
+	// cloudflare-go retries the 429 itself, and the semaphore still hears of it
+	ctx := api.WithSemaphore(context.Background(), sem)
+	sem.Acquire()
+	start := time.Now()
+	_, err := client.ZoneDetails(ctx, "zone")
+	sem.Release(start, err)
+
+	if err != nil {
+		t.Fatalf("expected success after retry, got %v", err)
+	}
+	if sem.Limit() != 2 {
+		t.Errorf("expected the 429 to halve the limit from 4 to 2, got %d", sem.Limit())
+	}
+}
+
+func TestClassifyErrorRateLimitedResponse(t *testing.T) {
+	client, _ := newRateLimitedClient(t, 1000, "0", cloudflare.UsingRetryPolicy(0, 0, 0))
+
+	_, err := client.ZoneDetails(context.Background(), "zone")
+	err = api.ClassifyError(fmt.Errorf("error getting zone: %w", err))
+	if !errors.Is(err, util.ErrRateLimited) {
+		t.Fatalf("expected a rate limit error, got %v", err)
+	}
+	if code := util.ErrorCode(err); code != util.ErrorCodeRateLimited {
+		t.Errorf("ErrorCode = %q, want %q", code, util.ErrorCodeRateLimited)
+	}
+
+	// Clients without the transport get cloudflare-go's untyped error
+	sdkErr := api.ClassifyError(errors.New("exceeded available rate limit retries"))
+	if !errors.Is(sdkErr, util.ErrRateLimited) {
+		t.Errorf("expected cloudflare-go's rate limit error to be classified, got %v", sdkErr)
+	}
+}
+
+func TestWriteErrorStructured(t *testing.T) {
+	authErr := api.ClassifyError(fmt.Errorf("error listing zones: %w", &cloudflare.AuthenticationError{}))
+	if code := util.ExitCode(authErr); code != util.ExitAuthFailed {
+		t.Errorf("ExitCode = %d, want %d", code, util.ExitAuthFailed)
+	}
+
+	var buf strings.Builder
+	util.WriteError(&buf, util.OutputJSON, authErr)
+	var out util.ErrorOutput
+	if err := json.Unmarshal([]byte(buf.String()), &out); err != nil {
+		t.Fatalf("error output is not JSON: %v\n%s", err, buf.String())
+	}
+	if out.Code != util.ErrorCodeAuthFailed || out.Error != authErr.Error() {
+		t.Errorf("error output = %+v", out)
+	}
+
+	buf.Reset()
+	util.WriteError(&buf, util.OutputTable, errors.New("boom"))
+	if buf.String() != "Error: boom\n" {
+		t.Errorf("table error output = %q", buf.String())
+	}
+
+	apiErr := api.ClassifyError(&cloudflare.Error{StatusCode: http.StatusBadRequest})
+	if code := util.ErrorCode(apiErr); code != util.ErrorCodeAPI {
+		t.Errorf("ErrorCode = %q, want %q", code, util.ErrorCodeAPI)
+	}
+	if code := util.ErrorCode(errors.New("bad flag")); code != util.ErrorCodeGeneral {
+		t.Errorf("ErrorCode = %q, want %q", code, util.ErrorCodeGeneral)
+	}
+}
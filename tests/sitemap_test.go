@@ -0,0 +1,62 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cfpurge/internal/util"
+)
+
+func TestReadSitemapFollowsIndex(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sitemap.xml":
+			fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>%[1]s/pages.xml</loc></sitemap>
+  <sitemap><loc>%[1]s/sitemap.xml</loc></sitemap>
+</sitemapindex>`, server.URL)
+		case "/pages.xml":
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>https://example.com/</loc></url>
+  <url><loc> https://example.com/about </loc></url>
+</urlset>`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	urls, err := util.ReadSitemap(context.Background(), server.URL+"/sitemap.xml")
+	if err != nil {
+		t.Fatalf("ReadSitemap returned error: %v", err)
+	}
+
+	if len(urls) != 2 || urls[0] != "https://example.com/" || urls[1] != "https://example.com/about" {
+		t.Errorf("unexpected URLs: %v", urls)
+	}
+}
+
+func TestReadSitemapFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sitemap.xml")
+	content := `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9"><url><loc>https://example.com/a</loc></url></urlset>`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	urls, err := util.ReadSitemap(context.Background(), path)
+	if err != nil || len(urls) != 1 || urls[0] != "https://example.com/a" {
+		t.Errorf("ReadSitemap = %v, %v", urls, err)
+	}
+
+	if _, err := util.ReadSitemap(context.Background(), filepath.Join(t.TempDir(), "missing.xml")); err == nil {
+		t.Error("expected error for missing sitemap file")
+	}
+}
@@ -0,0 +1,47 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"cfpurge/internal/api"
+	"cfpurge/internal/util"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestExecutePurgePlanRecordsZoneSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	client, _ := newPurgeTestClient(t)
+	plan := util.NewPlan("purge")
+	plan.Zones = []util.PlanZone{
+		{ID: "zone-a", Name: "example.com", URLs: []string{"https://example.com/a", "https://example.com/b"}},
+		{ID: "zone-c", Name: "example.org", Everything: true},
+	}
+
+	api.ExecutePurgePlan(context.Background(), client, plan, api.PurgeOptions{})
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 zone spans, got %d", len(spans))
+	}
+
+	attrs := make(map[string]string)
+	for _, attr := range spans[0].Attributes() {
+		attrs[string(attr.Key)] = attr.Value.Emit()
+	}
+	if attrs["cloudflare.zone.id"] != "zone-a" || attrs["cfpurge.count"] != "2" || attrs["cfpurge.success"] != "true" {
+		t.Errorf("unexpected attributes on the zone-a span: %v", attrs)
+	}
+
+	if spans[1].Status().Code != codes.Error {
+		t.Errorf("expected the failed zone-c span to have an error status, got %v", spans[1].Status())
+	}
+}
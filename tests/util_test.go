@@ -0,0 +1,1271 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cfpurge/internal/util"
+)
+
+func TestBestZoneMatch(t *testing.T) {
+	zones := []string{"example.com", "shop.example.com", "notexample.com"}
+
+	cases := map[string]string{
+		"example.com":          "example.com",
+		"www.example.com":      "example.com",
+		"cdn.shop.example.com": "shop.example.com",
+		"notexample.com":       "notexample.com",
+		"WWW.Example.COM":      "example.com",
+		"example.org":          "",
+	}
+
+	for host, want := range cases {
+		got, ok := util.BestZoneMatch(host, zones)
+		if got != want || ok != (want != "") {
+			t.Errorf("BestZoneMatch(%q) = %q, %v; want %q", host, got, ok, want)
+		}
+	}
+}
+
+func TestHostFromURL(t *testing.T) {
+	cases := map[string]string{
+		"https://www.example.com/page?x=1": "www.example.com",
+		"http://example.com:8080/":         "example.com",
+		"example.com/path":                 "example.com",
+	}
+
+	for rawURL, want := range cases {
+		got, err := util.HostFromURL(rawURL)
+		if err != nil || got != want {
+			t.Errorf("HostFromURL(%q) = %q, %v; want %q", rawURL, got, err, want)
+		}
+	}
+}
+
+func TestNormalizeURL(t *testing.T) {
+	cases := map[string]string{
+		"example.com/page":             "https://example.com/page",
+		"  https://Example.COM/Page  ": "https://example.com/Page",
+		"http://example.com:8080/a":    "http://example.com:8080/a",
+		"https://bücher.example/x":     "https://xn--bcher-kva.example/x",
+	}
+
+	for rawURL, want := range cases {
+		got, err := util.NormalizeURL(rawURL)
+		if err != nil || got != want {
+			t.Errorf("NormalizeURL(%q) = %q, %v; want %q", rawURL, got, err, want)
+		}
+	}
+
+	for _, bad := range []string{"", "   ", "ftp://example.com/x", "https:///path"} {
+		if err := util.ValidateURL(bad); err == nil {
+			t.Errorf("ValidateURL(%q) expected error", bad)
+		}
+	}
+}
+
+func TestSplitValidCacheTags(t *testing.T) {
+	tags := []string{"product-123", "has space", "", "ok_tag", "café", strings.Repeat("a", util.MaxCacheTagLength+1)}
+
+	valid, invalid := util.SplitValidCacheTags(tags)
+
+	if len(valid) != 2 || valid[0] != "product-123" || valid[1] != "ok_tag" {
+		t.Errorf("unexpected valid tags: %v", valid)
+	}
+	if len(invalid) != 4 {
+		t.Errorf("expected 4 invalid tags, got %d: %v", len(invalid), invalid)
+	}
+}
+
+func TestValidateCacheTagList(t *testing.T) {
+	for _, value := range []string{"product-123", "product-1,sale homepage"} {
+		if err := util.ValidateCacheTagList(value); err != nil {
+			t.Errorf("ValidateCacheTagList(%q) returned error: %v", value, err)
+		}
+	}
+
+	oversized := strings.Repeat("a", util.MaxCacheTagLength+1)
+	cases := []struct{ value, wantInError string }{
+		{"", "empty"},
+		{"product-1," + oversized, "maximum is 1024"},
+		{"ok café", "'café'"},
+		{"ok bad\x7ftag", "invalid character"},
+	}
+	for _, c := range cases {
+		err := util.ValidateCacheTagList(c.value)
+		if err == nil || !strings.Contains(err.Error(), c.wantInError) {
+			t.Errorf("ValidateCacheTagList(%q) = %v, want an error containing %q", util.Truncate(c.value, 40), err, c.wantInError)
+		}
+	}
+
+	many := make([]string, util.MaxCacheTagHeaderLength/9)
+	for i := range many {
+		many[i] = fmt.Sprintf("tag-%05d", i)
+	}
+	if err := util.ValidateCacheTagList(strings.Join(many, ",")); err == nil {
+		t.Error("expected an error for tags exceeding the Cache-Tag header limit")
+	}
+}
+
+func TestURLForPath(t *testing.T) {
+	cases := []struct{ template, path, want string }{
+		{"https://example.com/static/{path}", "css/app.css", "https://example.com/static/css/app.css"},
+		{"https://example.com/", "index.html", "https://example.com/index.html"},
+		{"https://example.com", "/js/app.js", "https://example.com/js/app.js"},
+	}
+
+	for _, c := range cases {
+		if got := util.URLForPath(c.template, c.path); got != c.want {
+			t.Errorf("URLForPath(%q, %q) = %q; want %q", c.template, c.path, got, c.want)
+		}
+	}
+}
+
+func TestImageDeliveryURLs(t *testing.T) {
+	urls, err := util.ImageDeliveryURLs("images.example.com", "Vi7wi5KSItxGFsWRG2Us6Q", []string{"logo", "hero-2"}, []string{"public", "thumb"})
+	if err != nil {
+		t.Fatalf("ImageDeliveryURLs returned error: %v", err)
+	}
+
+	want := []string{
+		"https://images.example.com/cdn-cgi/imagedelivery/Vi7wi5KSItxGFsWRG2Us6Q/logo/public",
+		"https://images.example.com/cdn-cgi/imagedelivery/Vi7wi5KSItxGFsWRG2Us6Q/logo/thumb",
+		"https://images.example.com/cdn-cgi/imagedelivery/Vi7wi5KSItxGFsWRG2Us6Q/hero-2/public",
+		"https://images.example.com/cdn-cgi/imagedelivery/Vi7wi5KSItxGFsWRG2Us6Q/hero-2/thumb",
+	}
+	if strings.Join(urls, " ") != strings.Join(want, " ") {
+		t.Errorf("ImageDeliveryURLs = %v; want %v", urls, want)
+	}
+
+	if _, err := util.ImageDeliveryURLs("images.example.com", "hash", []string{"a/b"}, []string{"public"}); err == nil {
+		t.Error("expected error for image ID containing a slash")
+	}
+	if _, err := util.ImageDeliveryURLs("", "hash", []string{"a"}, []string{"public"}); err == nil {
+		t.Error("expected error for missing domain")
+	}
+}
+
+func TestNothingMatched(t *testing.T) {
+	util.SetOutput(io.Discard)
+	defer util.SetOutput(os.Stdout)
+
+	if err := util.NothingMatched("zones", false); err != nil {
+		t.Errorf("expected no error without --error-on-empty, got %v", err)
+	}
+
+	err := util.NothingMatched("zones", true)
+	if !errors.Is(err, util.ErrNothingMatched) {
+		t.Fatalf("expected ErrNothingMatched, got %v", err)
+	}
+	if code := util.ExitCode(err); code != util.ExitNothingMatched {
+		t.Errorf("ExitCode = %d; want %d", code, util.ExitNothingMatched)
+	}
+	if code := util.ExitCode(errors.New("api failure")); code != 1 {
+		t.Errorf("ExitCode for other errors = %d; want 1", code)
+	}
+}
+
+func TestInterrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := util.Interrupted(ctx); err != nil {
+		t.Errorf("expected no error before cancellation, got %v", err)
+	}
+
+	cancel()
+	err := util.Interrupted(ctx)
+	if !errors.Is(err, util.ErrInterrupted) {
+		t.Fatalf("expected ErrInterrupted, got %v", err)
+	}
+	if code := util.ExitCode(err); code != util.ExitInterrupted {
+		t.Errorf("ExitCode = %d; want %d", code, util.ExitInterrupted)
+	}
+	if code := util.ExitCode(fmt.Errorf("scheduled purge cancelled: %w", context.Canceled)); code != util.ExitInterrupted {
+		t.Errorf("ExitCode for a cancelled wait = %d; want %d", code, util.ExitInterrupted)
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	now := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+
+	when, err := util.ParseSchedule("2024-06-01T10:30:00Z", 0, now)
+	if err != nil || !when.Equal(now.Add(90*time.Minute)) {
+		t.Errorf("ParseSchedule(--at) = %v, %v", when, err)
+	}
+
+	when, err = util.ParseSchedule("", 10*time.Minute, now)
+	if err != nil || !when.Equal(now.Add(10*time.Minute)) {
+		t.Errorf("ParseSchedule(--after) = %v, %v", when, err)
+	}
+
+	if when, err := util.ParseSchedule("", 0, now); err != nil || !when.IsZero() {
+		t.Errorf("expected an immediate run, got %v, %v", when, err)
+	}
+
+	for _, at := range []string{"2024-06-01T08:00:00Z", "tomorrow"} {
+		if _, err := util.ParseSchedule(at, 0, now); err == nil {
+			t.Errorf("expected error for --at=%s", at)
+		}
+	}
+	if _, err := util.ParseSchedule("2024-06-01T10:30:00Z", time.Minute, now); err == nil {
+		t.Error("expected error when combining --at and --after")
+	}
+}
+
+func TestWaitUntilCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := util.WaitUntil(ctx, time.Now().Add(time.Hour), io.Discard)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestFormatCount(t *testing.T) {
+	cases := map[int]string{
+		0:        "0",
+		999:      "999",
+		1000:     "1,000",
+		49970:    "49,970",
+		1234567:  "1,234,567",
+		-1234567: "-1,234,567",
+	}
+	for n, want := range cases {
+		if got := util.FormatCount(n); got != want {
+			t.Errorf("FormatCount(%d) = %q; want %q", n, got, want)
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	cases := map[int64]string{
+		0:             "0 B",
+		999:           "999 B",
+		1500:          "1.5 kB",
+		4200000000:    "4.2 GB",
+		3000000000000: "3.0 TB",
+	}
+	for n, want := range cases {
+		if got := util.FormatBytes(n); got != want {
+			t.Errorf("FormatBytes(%d) = %q; want %q", n, got, want)
+		}
+	}
+}
+
+func TestNormalizeHost(t *testing.T) {
+	cases := map[string]string{
+		"api.example.com":                 "api.example.com",
+		"https://API.Example.com/":        "api.example.com",
+		"http://api.example.com:8080/a?b": "api.example.com",
+		" www.example.com/ ":              "www.example.com",
+		"example.com.":                    "example.com",
+	}
+	for input, want := range cases {
+		got, err := util.NormalizeHost(input)
+		if err != nil || got != want {
+			t.Errorf("NormalizeHost(%q) = %q, %v; want %q", input, got, err, want)
+		}
+	}
+
+	for _, input := range []string{"", "*.example.com", "exa mple.com", "-bad.example.com", "ftp://"} {
+		if got, err := util.NormalizeHost(input); err == nil {
+			t.Errorf("NormalizeHost(%q) = %q; expected an error", input, got)
+		}
+	}
+}
+
+func TestTagListContains(t *testing.T) {
+	value := "product-1, sale homepage,featured"
+
+	if got := util.SplitTagList(value); strings.Join(got, "|") != "product-1|sale|homepage|featured" {
+		t.Errorf("SplitTagList(%q) = %v", value, got)
+	}
+
+	for _, tag := range []string{"product-1", "sale", "homepage", "featured"} {
+		if !util.TagListContains(value, tag) {
+			t.Errorf("expected %q to be found in %q", tag, value)
+		}
+	}
+
+	// Substrings of a listed tag, or spanning two tags, must not match
+	for _, tag := range []string{"product", "product-", "ale", "home", "sale homepage", "1, sale"} {
+		if util.TagListContains(value, tag) {
+			t.Errorf("expected %q not to match %q", tag, value)
+		}
+	}
+
+	if util.TagListContains("wholesale", "sale") {
+		t.Error("expected 'sale' not to match 'wholesale'")
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	cases := map[string]time.Duration{
+		"90m":   90 * time.Minute,
+		"24h":   24 * time.Hour,
+		"7d":    7 * 24 * time.Hour,
+		"1d12h": 36 * time.Hour,
+		"1.5d":  36 * time.Hour,
+	}
+	for input, want := range cases {
+		got, err := util.ParseDuration(input)
+		if err != nil || got != want {
+			t.Errorf("ParseDuration(%q) = %v, %v; want %v", input, got, err, want)
+		}
+	}
+
+	for _, input := range []string{"", "7", "week", "d"} {
+		if _, err := util.ParseDuration(input); err == nil {
+			t.Errorf("ParseDuration(%q) expected an error", input)
+		}
+	}
+}
+
+func TestClassifyCacheState(t *testing.T) {
+	purgedAt := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	fetchedAt := purgedAt.Add(10 * time.Second)
+
+	cases := []struct {
+		snapshot util.CacheSnapshot
+		want     string
+	}{
+		{util.CacheSnapshot{CacheStatus: "MISS", FetchedAt: fetchedAt}, util.VerifyFresh},
+		{util.CacheSnapshot{CacheStatus: "HIT", Age: 5, FetchedAt: fetchedAt}, util.VerifyFresh},
+		{util.CacheSnapshot{CacheStatus: "HIT", Age: 3600, FetchedAt: fetchedAt}, util.VerifyStale},
+		{util.CacheSnapshot{CacheStatus: "STALE", FetchedAt: fetchedAt}, util.VerifyStale},
+		{util.CacheSnapshot{CacheStatus: "DYNAMIC", FetchedAt: fetchedAt}, util.VerifyNotCached},
+		{util.CacheSnapshot{FetchedAt: fetchedAt}, util.VerifyNotCached},
+	}
+	for _, c := range cases {
+		if got := util.ClassifyCacheState(c.snapshot, purgedAt); got != c.want {
+			t.Errorf("ClassifyCacheState(%+v) = %s, want %s", c.snapshot, got, c.want)
+		}
+	}
+}
+
+func TestVerifyPurge(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/stale":
+			w.Header().Set("CF-Cache-Status", "HIT")
+			w.Header().Set("Age", "3600")
+			w.Header().Set("ETag", `"v1"`)
+		case "/fresh":
+			// Miss on the first request after the purge, then a hit
+			if atomic.AddInt32(&requests, 1) == 1 {
+				w.Header().Set("CF-Cache-Status", "MISS")
+			} else {
+				w.Header().Set("CF-Cache-Status", "HIT")
+				w.Header().Set("Age", "0")
+			}
+			w.Header().Set("ETag", `"v2"`)
+		}
+	}))
+	defer server.Close()
+
+	urls := []string{server.URL + "/stale", server.URL + "/fresh"}
+	before := map[string]util.CacheSnapshot{
+		urls[0]: {CacheStatus: "HIT", ETag: `"v1"`},
+		urls[1]: {CacheStatus: "HIT", ETag: `"v1"`},
+	}
+
+	results := util.VerifyPurge(context.Background(), urls, before, time.Now(), util.VerifyOptions{
+		Timeout:     50 * time.Millisecond,
+		Interval:    10 * time.Millisecond,
+		Concurrency: 2,
+	})
+
+	if results[0].Status != util.VerifyStale || results[0].ContentChanged {
+		t.Errorf("expected %s to be stale and unchanged, got %+v", urls[0], results[0])
+	}
+	if results[1].Status != util.VerifyFresh || !results[1].ContentChanged || results[1].After.CacheStatus != "HIT" {
+		t.Errorf("expected %s to be fresh, changed and cached again, got %+v", urls[1], results[1])
+	}
+}
+
+func TestChunk(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5, 6, 7}
+
+	cases := []struct {
+		name  string
+		items []int
+		size  int
+		want  [][]int
+	}{
+		{"empty", nil, 3, nil},
+		{"empty non-nil", []int{}, 3, nil},
+		{"exact multiple", items[:6], 3, [][]int{{1, 2, 3}, {4, 5, 6}}},
+		{"remainder", items, 3, [][]int{{1, 2, 3}, {4, 5, 6}, {7}}},
+		{"size larger than items", items[:2], 30, [][]int{{1, 2}}},
+		{"size one", items[:3], 1, [][]int{{1}, {2}, {3}}},
+		{"size zero", items[:3], 0, [][]int{{1, 2, 3}}},
+	}
+	for _, c := range cases {
+		got := util.Chunk(c.items, c.size)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: Chunk(%v, %d) = %v, want %v", c.name, c.items, c.size, got, c.want)
+		}
+	}
+}
+
+func TestChunkAppendDoesNotOverwriteNextChunk(t *testing.T) {
+	items := []string{"a", "b", "c", "d"}
+	chunks := util.Chunk(items, 2)
+
+	// Each chunk is capped so that appending to it copies instead of
+	// writing into the following chunk
+	_ = append(chunks[0], "x")
+	if chunks[1][0] != "c" {
+		t.Errorf("appending to the first chunk overwrote the second: %v", chunks[1])
+	}
+
+	total := 0
+	for _, chunk := range util.Chunk(make([]string, 10001), 30) {
+		if len(chunk) > 30 {
+			t.Fatalf("chunk of %d exceeds the batch size", len(chunk))
+		}
+		total += len(chunk)
+	}
+	if total != 10001 {
+		t.Errorf("chunks hold %d items, want 10001", total)
+	}
+}
+
+func TestPrefixCacheTags(t *testing.T) {
+	got := util.PrefixCacheTags([]string{"header", "footer", "header"}, "prod:")
+	want := []string{"prod:header", "prod:footer"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PrefixCacheTags = %v, want %v", got, want)
+	}
+
+	tags := []string{"a", "b"}
+	if got := util.PrefixCacheTags(tags, ""); !reflect.DeepEqual(got, tags) {
+		t.Errorf("expected tags unchanged without a prefix, got %v", got)
+	}
+
+	// The prefix counts toward the tag length limit
+	long := util.PrefixCacheTags([]string{strings.Repeat("t", util.MaxCacheTagLength)}, "prod:")
+	if _, invalid := util.SplitValidCacheTags(long); len(invalid) != 1 {
+		t.Errorf("expected the prefixed tag to exceed the length limit, got %v", invalid)
+	}
+}
+
+func TestTruncateBytes(t *testing.T) {
+	cases := []struct {
+		in        string
+		max       int
+		want      string
+		truncated bool
+	}{
+		{"hello", 10, "hello", false},
+		{"hello", 5, "hello", false},
+		{"hello", 3, "hel", true},
+		{"hello", 0, "hello", false},
+		// "é" is two bytes and must not be split
+		{"héllo", 2, "h", true},
+		{"héllo", 3, "hé", true},
+	}
+	for _, c := range cases {
+		got, truncated := util.TruncateBytes([]byte(c.in), c.max)
+		if string(got) != c.want || truncated != c.truncated {
+			t.Errorf("TruncateBytes(%q, %d) = %q, %v, want %q, %v", c.in, c.max, got, truncated, c.want, c.truncated)
+		}
+	}
+}
+
+func TestGlob(t *testing.T) {
+	cases := []struct {
+		pattern string
+		input   string
+		want    bool
+	}{
+		{"product-123*", "product-123", true},
+		{"product-123*", "product-1234/details", true},
+		{"product-123*", "x-product-123", false},
+		{"users/*/profile", "users/42/profile", true},
+		{"users/*/profile", "users/a/b/profile", true},
+		{"users/*/profile", "users/42/settings", false},
+		{"key-?", "key-1", true},
+		{"key-?", "key-12", false},
+		{"key-[0-9]", "key-7", true},
+		{"key-[!0-9]", "key-7", false},
+		{"key-[!0-9]", "key-x", true},
+		{"a.b", "a.b", true},
+		{"a.b", "axb", false},
+		{`star\*`, "star*", true},
+		{`star\*`, "starry", false},
+		{"[]]x", "]x", true},
+	}
+	for _, c := range cases {
+		glob, err := util.CompileGlob(c.pattern)
+		if err != nil {
+			t.Fatalf("CompileGlob(%q) returned error: %v", c.pattern, err)
+		}
+		if got := glob.Match(c.input); got != c.want {
+			t.Errorf("%q matching %q = %v, want %v", c.pattern, c.input, got, c.want)
+		}
+	}
+
+	for _, invalid := range []string{"key-[0-9", `trailing\`} {
+		if _, err := util.CompileGlob(invalid); err == nil {
+			t.Errorf("expected CompileGlob(%q) to fail", invalid)
+		}
+	}
+}
+
+func TestGlobPrefix(t *testing.T) {
+	cases := map[string]string{
+		"product-123*":    "product-123",
+		"users/*/profile": "users/",
+		"key-?":           "key-",
+		"key-[0-9]":       "key-",
+		"*suffix":         "",
+		`star\*x*`:        "star*x",
+		"exact":           "exact",
+	}
+	for pattern, want := range cases {
+		glob, err := util.CompileGlob(pattern)
+		if err != nil {
+			t.Fatalf("CompileGlob(%q) returned error: %v", pattern, err)
+		}
+		if got := glob.Prefix(); got != want {
+			t.Errorf("Prefix of %q = %q, want %q", pattern, got, want)
+		}
+	}
+}
+
+func TestReadChangesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "changes.json")
+	manifest := `[
+  {"path": "/css/site.css", "zone": "example.com"},
+  {"path": "js/app.js", "zone": "Example.org", "hash": "abc123"},
+  {"path": "css/site.css", "zone": "example.com"},
+  {"path": "index.html", "zone": "example.com"}
+]`
+	if err := os.WriteFile(path, []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := util.ReadChangesFile(path, "https://{zone}/static/{path}")
+	if err != nil {
+		t.Fatalf("ReadChangesFile returned error: %v", err)
+	}
+
+	want := []util.ZoneChanges{
+		{Zone: "example.com", URLs: []string{"https://example.com/static/css/site.css", "https://example.com/static/index.html"}},
+		{Zone: "example.org", URLs: []string{"https://example.org/static/js/app.js"}},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("ReadChangesFile = %+v, want %+v", changes, want)
+	}
+
+	// Without a template, URLs are built from the zone
+	changes, err = util.ChangedAssetURLs([]util.ChangedAsset{{Path: "a.png", Zone: "example.com"}}, "")
+	if err != nil || changes[0].URLs[0] != "https://example.com/a.png" {
+		t.Errorf("expected the default template to be used, got %+v, %v", changes, err)
+	}
+}
+
+func TestReadChangesFileRejectsInvalidEntries(t *testing.T) {
+	dir := t.TempDir()
+	cases := map[string]string{
+		"not-array.json":    `{"path": "a.css", "zone": "example.com"}`,
+		"missing-zone.json": `[{"path": "a.css"}]`,
+		"missing-path.json": `[{"zone": "example.com"}]`,
+		"malformed.json":    `[{"path": "a.css",`,
+	}
+	for name, content := range cases {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := util.ReadChangesFile(path, ""); err == nil {
+			t.Errorf("expected %s to be rejected", name)
+		}
+	}
+}
+
+func TestReadTagsFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	want := []string{"header", "footer"}
+
+	lines := write("tags.txt", "# release tags\nheader\n\nfooter\n")
+	if got, err := util.ReadTagsFile(lines, util.TagsFileLines, ""); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("lines: got %v, %v", got, err)
+	}
+
+	csvFile := write("tags.csv", "\ufeffpath,Tag\n/index.html,header\n/about.html,\n/footer.html, footer \n")
+	if got, err := util.ReadTagsFile(csvFile, util.TagsFileCSV, "tag"); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("csv: got %v, %v", got, err)
+	}
+	if got, err := util.ReadTagsFile(csvFile, util.TagsFileCSV, "path"); err != nil || len(got) != 3 {
+		t.Errorf("csv with another column: got %v, %v", got, err)
+	}
+	if _, err := util.ReadTagsFile(csvFile, util.TagsFileCSV, "missing"); err == nil {
+		t.Error("expected an error for a missing CSV column")
+	}
+
+	jsonFile := write("tags.json", `["header", " ", "footer"]`)
+	if got, err := util.ReadTagsFile(jsonFile, util.TagsFileJSON, ""); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("json: got %v, %v", got, err)
+	}
+	if _, err := util.ReadTagsFile(write("bad.json", `{"tags": ["a"]}`), util.TagsFileJSON, ""); err == nil {
+		t.Error("expected an error for a JSON object")
+	}
+
+	if _, err := util.ReadTagsFile(lines, "xml", ""); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+// fakeKeyPages serves total numbered keys in pages, using the index of the
+// next key as the cursor, and records the page limit of each request
+func fakeKeyPages(total int, pageLimits *[]int) util.PageFetcher[string] {
+	return func(cursor string, pageLimit int) ([]string, string, error) {
+		*pageLimits = append(*pageLimits, pageLimit)
+		start := 0
+		if cursor != "" {
+			fmt.Sscanf(cursor, "%d", &start)
+		}
+		end := start + pageLimit
+		if end > total {
+			end = total
+		}
+		var page []string
+		for i := start; i < end; i++ {
+			page = append(page, fmt.Sprintf("key-%d", i))
+		}
+		if end == total {
+			return page, "", nil
+		}
+		return page, fmt.Sprintf("%d", end), nil
+	}
+}
+
+func TestPaginateStopsAtLimit(t *testing.T) {
+	var pageLimits []int
+	keys, cursor, err := util.Paginate(100, 30, 0, "", fakeKeyPages(250, &pageLimits))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(keys) != 100 {
+		t.Fatalf("expected exactly 100 keys, got %d", len(keys))
+	}
+	if keys[99] != "key-99" {
+		t.Errorf("expected the last key to be key-99, got %s", keys[99])
+	}
+	if cursor != "100" {
+		t.Errorf("expected the cursor to resume after the last key, got %q", cursor)
+	}
+	if want := []int{30, 30, 30, 10}; !reflect.DeepEqual(pageLimits, want) {
+		t.Errorf("expected page limits %v, got %v", want, pageLimits)
+	}
+}
+
+func TestPaginateWithoutLimit(t *testing.T) {
+	var pageLimits []int
+	keys, cursor, err := util.Paginate(0, 100, 0, "", fakeKeyPages(250, &pageLimits))
+	if err != nil || len(keys) != 250 || cursor != "" {
+		t.Errorf("expected all 250 keys and no cursor, got %d, %q, %v", len(keys), cursor, err)
+	}
+
+	// A single page, as kv list fetches without --all
+	pageLimits = nil
+	keys, cursor, err = util.Paginate(0, 100, 1, "200", fakeKeyPages(250, &pageLimits))
+	if err != nil || len(keys) != 50 || keys[0] != "key-200" || cursor != "" {
+		t.Errorf("expected the last 50 keys, got %d, %q, %v", len(keys), cursor, err)
+	}
+}
+
+func TestPaginateTrimsOversizedPages(t *testing.T) {
+	fetch := func(cursor string, pageLimit int) ([]int, string, error) {
+		return make([]int, 1000), "more", nil
+	}
+	items, _, err := util.Paginate(100, 1000, 0, "", fetch)
+	if err != nil || len(items) != 100 {
+		t.Errorf("expected 100 items, got %d, %v", len(items), err)
+	}
+}
+
+func TestPaginateReturnsError(t *testing.T) {
+	calls := 0
+	fetch := func(cursor string, pageLimit int) ([]int, string, error) {
+		calls++
+		if calls == 2 {
+			return nil, "", errors.New("rate limited")
+		}
+		return []int{1, 2}, "next", nil
+	}
+	items, cursor, err := util.Paginate(0, 2, 0, "", fetch)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(items) != 2 || cursor != "next" {
+		t.Errorf("expected the keys and cursor before the error, got %v, %q", items, cursor)
+	}
+}
+
+func TestPaginateShardsMergesAllShards(t *testing.T) {
+	keys := []string{"a1", "a2", "a3", "b1", "c1", "c2", "~x"}
+	fetch := func(shard, cursor string, pageLimit int) ([]string, string, error) {
+		var matching []string
+		for _, key := range keys {
+			if strings.HasPrefix(key, shard) {
+				matching = append(matching, key)
+			}
+		}
+		start := 0
+		if cursor != "" {
+			fmt.Sscan(cursor, &start)
+		}
+		end := start + pageLimit
+		if end >= len(matching) {
+			return matching[start:], "", nil
+		}
+		return matching[start:end], fmt.Sprint(end), nil
+	}
+
+	shards := util.PrefixShards("")
+	if len(shards) != 147 || shards[0] != " " || shards[94] != "~" || shards[len(shards)-1] != "\xf4" {
+		t.Fatalf("unexpected shards %q", shards)
+	}
+
+	items, err := util.PaginateShards(shards, 0, 2, 4, fetch)
+	if err != nil {
+		t.Fatalf("PaginateShards: %v", err)
+	}
+	sort.Strings(items)
+	if !reflect.DeepEqual(items, keys) {
+		t.Errorf("expected %v, got %v", keys, items)
+	}
+
+	items, err = util.PaginateShards(shards, 4, 2, 4, fetch)
+	if err != nil || len(items) != 4 {
+		t.Errorf("expected 4 items with a limit, got %v, %v", items, err)
+	}
+}
+
+func TestScanPrefixListsEveryKey(t *testing.T) {
+	// Sorted by bytes, as KV lists keys
+	keys := []string{"p/", "p/\x00nul", "p/\ttab", "p/\x1fus", "p/ space", "p/a", "p/b/c", "p/~", "p/\x7fdel", "p/été", "p/ключ", "p/日本", "p/🙂"}
+	fetch := func(shard, cursor string, pageLimit int) ([]string, string, error) {
+		var matching []string
+		for _, key := range append([]string{"other"}, keys...) {
+			if strings.HasPrefix(key, shard) {
+				matching = append(matching, key)
+			}
+		}
+		start := 0
+		if cursor != "" {
+			fmt.Sscan(cursor, &start)
+		}
+		end := start + pageLimit
+		if end >= len(matching) {
+			return matching[start:], "", nil
+		}
+		return matching[start:end], fmt.Sprint(end), nil
+	}
+	name := func(key string) string { return key }
+
+	items, err := util.ScanPrefix("p/", 0, 2, 4, name, fetch)
+	if err != nil {
+		t.Fatalf("ScanPrefix: %v", err)
+	}
+	sort.Strings(items)
+	if !reflect.DeepEqual(items, keys) {
+		t.Errorf("expected %q, got %q", keys, items)
+	}
+
+	items, err = util.ScanPrefix("p/", 3, 2, 4, name, fetch)
+	if err != nil || !reflect.DeepEqual(items, keys[:3]) {
+		t.Errorf("expected the first keys within the limit, got %q, %v", items, err)
+	}
+}
+
+func TestPaginateShardsReturnsError(t *testing.T) {
+	fetch := func(shard, cursor string, pageLimit int) ([]string, string, error) {
+		if shard == "b" {
+			return nil, "", errors.New("rate limited")
+		}
+		return []string{shard + "1"}, "", nil
+	}
+	if _, err := util.PaginateShards([]string{"a", "b", "c"}, 0, 10, 2, fetch); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestDiffKeys(t *testing.T) {
+	source := map[string]util.DiffEntry{
+		"same":       {Metadata: map[string]interface{}{"cache-tag": "a"}, Value: []byte("1")},
+		"meta":       {Metadata: map[string]interface{}{"cache-tag": "a"}},
+		"value":      {Value: []byte("old")},
+		"unfetched":  {},
+		"old-only":   {Metadata: map[string]interface{}{"cache-tag": "x"}},
+		"empty-both": {Value: []byte{}},
+	}
+	dest := map[string]util.DiffEntry{
+		"same":       {Metadata: map[string]interface{}{"cache-tag": "a"}, Value: []byte("1")},
+		"meta":       {Metadata: map[string]interface{}{"cache-tag": "b"}},
+		"value":      {Value: []byte("new")},
+		"unfetched":  {Value: []byte("only one side read")},
+		"new-only":   {},
+		"empty-both": {Value: []byte{}},
+	}
+
+	diffs, summary := util.DiffKeys(source, dest)
+
+	want := []util.KeyDiff{
+		{Key: "meta", Status: util.DiffChanged, Fields: []string{"metadata"}},
+		{Key: "new-only", Status: util.DiffOnlyDest},
+		{Key: "old-only", Status: util.DiffOnlySource},
+		{Key: "value", Status: util.DiffChanged, Fields: []string{"value"}},
+	}
+	if len(diffs) != len(want) {
+		t.Fatalf("expected %d diffs, got %+v", len(want), diffs)
+	}
+	for i, w := range want {
+		if diffs[i].Key != w.Key || diffs[i].Status != w.Status || !reflect.DeepEqual(diffs[i].Fields, w.Fields) {
+			t.Errorf("diff %d: got %+v, want %+v", i, diffs[i], w)
+		}
+	}
+
+	wantSummary := util.DiffSummary{SourceKeys: 6, DestKeys: 6, OnlySource: 1, OnlyDest: 1, Changed: 2, Identical: 3}
+	if summary != wantSummary {
+		t.Errorf("summary = %+v, want %+v", summary, wantSummary)
+	}
+}
+
+func TestSavedCursorRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cursor.json")
+
+	saved, err := util.ReadCursor(path, "ns-1", "user-")
+	if err != nil || saved != nil {
+		t.Fatalf("expected no cursor before the first save, got %+v, %v", saved, err)
+	}
+
+	if err := util.WriteCursor(path, util.SavedCursor{Namespace: "ns-1", Prefix: "user-", Cursor: "abc"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	saved, err = util.ReadCursor(path, "ns-1", "user-")
+	if err != nil || saved == nil || saved.Cursor != "abc" {
+		t.Fatalf("expected cursor abc, got %+v, %v", saved, err)
+	}
+
+	if _, err := util.ReadCursor(path, "ns-2", "user-"); err == nil {
+		t.Error("expected a cursor saved for another namespace to be rejected")
+	}
+	if _, err := util.ReadCursor(path, "ns-1", ""); err == nil {
+		t.Error("expected a cursor saved for another prefix to be rejected")
+	}
+
+	if err := util.ClearCursor(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected the cursor file to be removed, got %v", err)
+	}
+	if err := util.ClearCursor(path); err != nil {
+		t.Errorf("expected clearing a missing cursor to succeed, got %v", err)
+	}
+}
+
+func TestConcurrencyFlag(t *testing.T) {
+	var c util.Concurrency
+	if err := c.Set("auto"); err != nil || !c.Auto {
+		t.Fatalf("expected auto, got %+v, %v", c, err)
+	}
+	if err := c.Set("8"); err != nil || c.Auto || c.N != 8 {
+		t.Fatalf("expected 8, got %+v, %v", c, err)
+	}
+	if err := c.Set("many"); err == nil {
+		t.Error("expected an error for a non-numeric concurrency")
+	}
+	if err := (util.Concurrency{N: 0}).Validate(); err == nil {
+		t.Error("expected a concurrency of 0 to be rejected")
+	}
+}
+
+func TestSemaphoreFixedLimit(t *testing.T) {
+	sem := util.NewSemaphore(3)
+	var active, peak int32
+
+	done := make(chan struct{})
+	for i := 0; i < 12; i++ {
+		sem.Acquire()
+		go func() {
+			defer func() { done <- struct{}{} }()
+			n := atomic.AddInt32(&active, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&active, -1)
+			sem.Release(time.Now(), nil)
+		}()
+	}
+	for i := 0; i < 12; i++ {
+		<-done
+	}
+
+	if peak > 3 {
+		t.Errorf("expected at most 3 concurrent holders, saw %d", peak)
+	}
+	sem.RateLimited()
+	if sem.Limit() != 3 {
+		t.Errorf("expected a fixed semaphore to ignore rate limiting, got limit %d", sem.Limit())
+	}
+}
+
+func TestAdaptiveSemaphoreIncreasesAndBacksOff(t *testing.T) {
+	sem := util.NewAdaptiveSemaphore(0)
+	release := func(n int) {
+		for i := 0; i < n; i++ {
+			sem.Acquire()
+			sem.Release(time.Now(), nil)
+		}
+	}
+
+	if sem.Limit() != 1 {
+		t.Fatalf("expected to start at 1, got %d", sem.Limit())
+	}
+	release(1 + 2 + 3)
+	if sem.Limit() != 4 {
+		t.Fatalf("expected one slot added per round of successes, got %d", sem.Limit())
+	}
+
+	sem.Acquire()
+	sem.Release(time.Now(), errors.New("failed"))
+	if sem.Limit() != 4 {
+		t.Errorf("expected failures not to raise the limit, got %d", sem.Limit())
+	}
+
+	sem.RateLimited()
+	if sem.Limit() != 2 {
+		t.Fatalf("expected rate limiting to halve the limit, got %d", sem.Limit())
+	}
+	sem.RateLimited()
+	if sem.Limit() != 2 {
+		t.Errorf("expected rate limiting reported together to back off once, got %d", sem.Limit())
+	}
+}
+
+func TestAdaptiveSemaphoreRespectsRateLimit(t *testing.T) {
+	// At 4 requests per second and 500ms per request, two requests in flight
+	// already use the whole rate limit
+	sem := util.NewAdaptiveSemaphore(4)
+	for i := 0; i < 50; i++ {
+		sem.Acquire()
+		sem.Release(time.Now().Add(-500*time.Millisecond), nil)
+	}
+
+	if sem.Limit() != 2 {
+		t.Errorf("expected the limit to stop at 2, got %d", sem.Limit())
+	}
+}
+
+func TestJQ(t *testing.T) {
+	jq, err := util.ParseJQ(".features.checkout, .regions[0]")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	results, err := jq.Apply([]byte(`{"features":{"checkout":{"enabled":true}},"regions":["eu","us"]}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %v", results)
+	}
+	if got := util.FormatJQResult(results[0]); got != "{\n  \"enabled\": true\n}" {
+		t.Errorf("expected an object as indented JSON, got %q", got)
+	}
+	if got := util.FormatJQResult(results[1]); got != "eu" {
+		t.Errorf("expected a string as plain text, got %q", got)
+	}
+
+	if _, err := jq.Apply([]byte("not json")); err == nil || !strings.Contains(err.Error(), "not JSON") {
+		t.Errorf("expected an error for a non-JSON value, got %v", err)
+	}
+	if _, err := util.ParseJQ(".features["); err == nil {
+		t.Error("expected an error for an invalid expression")
+	}
+
+	failing, err := util.ParseJQ(`error("boom")`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := failing.Apply([]byte(`{}`)); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("expected the expression's error, got %v", err)
+	}
+}
+
+func TestValidateNamespaceIDAndKey(t *testing.T) {
+	if err := util.ValidateNamespaceID("0123456789abcdef0123456789ABCDEF"); err != nil {
+		t.Errorf("ValidateNamespaceID rejected a valid ID: %v", err)
+	}
+	for _, id := range []string{"", "my-namespace", "0123456789abcdef0123456789abcde", "0123456789abcdef0123456789abcdeg"} {
+		if err := util.ValidateNamespaceID(id); err == nil {
+			t.Errorf("ValidateNamespaceID(%q) succeeded; want an error", id)
+		}
+	}
+
+	if err := util.ValidateKVKey(strings.Repeat("k", util.MaxKVKeyLength)); err != nil {
+		t.Errorf("ValidateKVKey rejected a %d byte key: %v", util.MaxKVKeyLength, err)
+	}
+	for _, key := range []string{"", ".", "..", strings.Repeat("é", util.MaxKVKeyLength/2+1)} {
+		if err := util.ValidateKVKey(key); err == nil {
+			t.Errorf("ValidateKVKey(%q) succeeded; want an error", util.Truncate(key, 20))
+		}
+	}
+}
+
+func TestValidateBatchSize(t *testing.T) {
+	for _, size := range []int{1, 30} {
+		if err := util.ValidateBatchSize(size, 30); err != nil {
+			t.Errorf("ValidateBatchSize(%d, 30) = %v; want nil", size, err)
+		}
+	}
+	for _, size := range []int{0, -1, 31} {
+		if err := util.ValidateBatchSize(size, 30); err == nil {
+			t.Errorf("ValidateBatchSize(%d, 30) succeeded; want an error", size)
+		}
+	}
+}
+
+func TestDetectContentType(t *testing.T) {
+	cases := []struct {
+		value  []byte
+		mime   string
+		binary bool
+	}{
+		{[]byte(`{"a": 1}`), "application/json", false},
+		{[]byte("hello world"), "text/plain; charset=utf-8", false},
+		{[]byte("<html><body>hi</body></html>"), "text/html; charset=utf-8", false},
+		{[]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), "image/png", true},
+		{[]byte{0x00, 0x01, 0x02, 0xff}, "application/octet-stream", true},
+	}
+	for _, c := range cases {
+		mime, binary := util.DetectContentType(c.value)
+		if mime != c.mime || binary != c.binary {
+			t.Errorf("DetectContentType(%q) = %q, %v; want %q, %v", c.value, mime, binary, c.mime, c.binary)
+		}
+	}
+}
+
+func TestExpandQueryVariants(t *testing.T) {
+	urls := []string{
+		"https://example.com/page",
+		"https://example.com/search?q=shoes#top",
+		"https://example.com/assets/*",
+	}
+	got, err := util.ExpandQueryVariants(urls, []string{"?lang=de", " utm_source=mail ", ""})
+	if err != nil {
+		t.Fatalf("ExpandQueryVariants: %v", err)
+	}
+
+	want := []string{
+		"https://example.com/page",
+		"https://example.com/page?lang=de",
+		"https://example.com/page?utm_source=mail",
+		"https://example.com/search?q=shoes#top",
+		"https://example.com/search?q=shoes&lang=de#top",
+		"https://example.com/search?q=shoes&utm_source=mail#top",
+		"https://example.com/assets/*",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExpandQueryVariants = %v, want %v", got, want)
+	}
+
+	if _, err := util.ExpandQueryVariants(urls, []string{"a=%zz"}); err == nil {
+		t.Error("expected an error for a malformed query variant")
+	}
+}
+
+func TestGitDiffURLs(t *testing.T) {
+	files := []string{"public/index.html", "public/css/site.css", "src/main.go", "public/a b.png"}
+
+	urls, skipped := util.GitDiffURLs(files, "./public/", "https://example.com/{path}")
+	want := []string{
+		"https://example.com/index.html",
+		"https://example.com/css/site.css",
+		"https://example.com/a%20b.png",
+	}
+	if !reflect.DeepEqual(urls, want) {
+		t.Errorf("urls = %v, want %v", urls, want)
+	}
+	if !reflect.DeepEqual(skipped, []string{"src/main.go"}) {
+		t.Errorf("skipped = %v, want [src/main.go]", skipped)
+	}
+
+	urls, skipped = util.GitDiffURLs([]string{"docs/page.html"}, "", "https://example.com/static")
+	if !reflect.DeepEqual(urls, []string{"https://example.com/static/docs/page.html"}) || len(skipped) != 0 {
+		t.Errorf("without root got %v, skipped %v", urls, skipped)
+	}
+}
+
+func TestChunkedValues(t *testing.T) {
+	if err := util.ValidateKVValueSize(util.MaxKVValueSize); err != nil {
+		t.Errorf("a value of exactly 25 MiB should fit: %v", err)
+	}
+	if err := util.ValidateKVValueSize(util.MaxKVValueSize + 1); err == nil {
+		t.Error("expected an error for a value over 25 MiB")
+	}
+
+	chunks := util.SplitChunks([]byte("abcdefgh"), 3)
+	if !reflect.DeepEqual(chunks, [][]byte{[]byte("abc"), []byte("def"), []byte("gh")}) {
+		t.Errorf("SplitChunks = %q", chunks)
+	}
+
+	if util.ChunkKey("data", 0) != "data" || util.ChunkKey("data", 2) != "data#chunk-2" {
+		t.Errorf("unexpected chunk keys %q, %q", util.ChunkKey("data", 0), util.ChunkKey("data", 2))
+	}
+
+	// Metadata read back from the API holds JSON numbers
+	if count, ok := util.ChunkCount(map[string]interface{}{util.ChunkCountMetadata: float64(3)}); !ok || count != 3 {
+		t.Errorf("ChunkCount = %d, %v, want 3, true", count, ok)
+	}
+	if _, ok := util.ChunkCount(map[string]interface{}{"cache-tag": "a"}); ok {
+		t.Error("expected no chunk count without the metadata field")
+	}
+}
+
+func TestReadEnvFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	content := `# Cloudflare credentials
+CLOUDFLARE_API_TOKEN=abc123 # read only
+export CLOUDFLARE_ACCOUNT_ID="0123 4567"
+
+CFPURGE_AUDIT_LOG='/var/log/$USER.log'
+GREETING="line1\nsay \"hi\""
+EMPTY=
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	vars, err := util.ReadEnvFile(path)
+	if err != nil {
+		t.Fatalf("ReadEnvFile: %v", err)
+	}
+	want := map[string]string{
+		"CLOUDFLARE_API_TOKEN":  "abc123",
+		"CLOUDFLARE_ACCOUNT_ID": "0123 4567",
+		"CFPURGE_AUDIT_LOG":     "/var/log/$USER.log",
+		"GREETING":              "line1\nsay \"hi\"",
+		"EMPTY":                 "",
+	}
+	if !reflect.DeepEqual(vars, want) {
+		t.Errorf("ReadEnvFile = %q, want %q", vars, want)
+	}
+
+	for _, bad := range []string{"NO_EQUALS\n", "1BAD=x\n", "TOKEN=\"unterminated\n"} {
+		if err := os.WriteFile(path, []byte(bad), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := util.ReadEnvFile(path); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestHashURLsFindsChangedContent(t *testing.T) {
+	var cacheBusted atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("cfpurge-hash") != "" {
+			cacheBusted.Add(1)
+		}
+		switch r.URL.Path {
+		case "/missing":
+			http.NotFound(w, r)
+		default:
+			fmt.Fprintf(w, "content of %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	same, changed, missing := server.URL+"/same", server.URL+"/changed", server.URL+"/missing"
+	hashes, errs := util.HashURLs(context.Background(), []string{same, changed, missing}, 2)
+	if len(hashes) != 2 || errs[missing] == nil {
+		t.Fatalf("expected two hashes and an error for %s, got %v, %v", missing, hashes, errs)
+	}
+	if cacheBusted.Load() != 3 {
+		t.Errorf("expected every fetch to bypass the cache, got %d of 3", cacheBusted.Load())
+	}
+
+	path := filepath.Join(t.TempDir(), "hashes.json")
+	manifest, err := util.ReadHashManifest(path)
+	if err != nil || len(manifest) != 0 {
+		t.Fatalf("a missing hash file should read as empty, got %v, %v", manifest, err)
+	}
+	manifest[same] = hashes[same]
+	manifest[changed] = "sha256:old"
+	if err := util.WriteHashManifest(path, manifest); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err = util.ReadHashManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := util.ChangedURLs([]string{same, changed, missing}, hashes, manifest)
+	if !reflect.DeepEqual(got, []string{changed, missing}) {
+		t.Errorf("ChangedURLs = %v, want [%s %s]", got, changed, missing)
+	}
+}
+
+func TestVerifyOverrides(t *testing.T) {
+	var gotHost string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHost = r.Host
+		w.Header().Set("CF-Cache-Status", "HIT")
+	}))
+	defer server.Close()
+	defer util.SetVerifyOverrides("", nil)
+
+	_, port, _ := strings.Cut(strings.TrimPrefix(server.URL, "http://"), ":")
+	if err := util.SetVerifyOverrides("origin.example.com", []string{"www.example.com:127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, err := util.FetchCacheSnapshot(context.Background(), "http://www.example.com:"+port+"/")
+	if err != nil {
+		t.Fatalf("expected www.example.com to resolve to the test server: %v", err)
+	}
+	if gotHost != "origin.example.com" {
+		t.Errorf("expected Host header origin.example.com, got %q", gotHost)
+	}
+	if snapshot.CacheStatus != "HIT" {
+		t.Errorf("expected the test server's response, got %+v", snapshot)
+	}
+
+	for _, entry := range []string{"www.example.com", "www.example.com:not-an-ip", ":127.0.0.1"} {
+		if err := util.SetVerifyOverrides("", []string{entry}); err == nil {
+			t.Errorf("expected resolve entry %q to be rejected", entry)
+		}
+	}
+}